@@ -0,0 +1,365 @@
+// Package adminserver 提供用于查询、管理时间轮任务的 HTTP 接口，返回 JSON.
+//
+//	GET    /tasks?from=&to=&limit=         查询执行时刻位于 [from, to) 范围内的待执行任务，时间为 RFC3339 格式
+//	GET    /tasks/{key}                    根据唯一键查询任务
+//	DELETE /tasks/{key}                    删除任务
+//	POST   /tasks/{key}/reschedule         迁移任务，请求体为 {"execute_at": "RFC3339"}
+//	GET    /deadletters?offset=&limit=     查询死信队列
+//	POST   /deadletters/requeue            将死信重新入队，请求体为 {"index": 0, "key": "任务唯一键", "execute_at": "RFC3339"}
+//	GET    /stats?horizon=10m              查询时间轮的统计信息
+//	GET    /healthz                        健康检查，异常时返回 503 以及故障原因：redis_unreachable、scan_stalled 或者 stopped
+//
+// 挂载到已有的 mux 上时，使用 http.StripPrefix 去除路由前缀：
+//
+//	mux.Handle("/timewheel/", http.StripPrefix("/timewheel", adminserver.New(rTimeWheel)))
+package adminserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+const (
+	// 查询任务以及死信时默认的数量上限
+	defaultLimit = 100
+	// 查询统计信息时默认的时间范围
+	defaultHorizon = time.Hour
+)
+
+// Wheel 管理接口依赖的时间轮能力，*timewheel.RTimeWheel 实现了该接口.
+type Wheel interface {
+	ListPendingTasks(ctx context.Context, from, to time.Time, limit int) ([]*timewheel.RTaskElement, error)
+	GetTask(ctx context.Context, key string) (*timewheel.RTaskElement, time.Time, error)
+	RemoveTaskByKey(ctx context.Context, key string) error
+	RescheduleTask(ctx context.Context, key string, newExecuteAt time.Time) error
+	ListDeadLetters(ctx context.Context, offset, limit int) ([]*timewheel.DeadLetter, error)
+	RequeueDeadLetter(ctx context.Context, deadLetter *timewheel.DeadLetter, executeAt time.Time) error
+	Stats(ctx context.Context, horizon time.Duration) (timewheel.WheelStats, error)
+	HealthCheck(ctx context.Context) error
+	State() timewheel.WheelState
+}
+
+var _ Wheel = (*timewheel.RTimeWheel)(nil)
+
+type Option func(s *Server)
+
+// WithMiddleware 设置包裹全部接口的中间件，用于接入鉴权等逻辑. 多次设置时，先设置的中间件位于外层.
+func WithMiddleware(middleware func(next http.Handler) http.Handler) Option {
+	return func(s *Server) {
+		s.middlewares = append(s.middlewares, middleware)
+	}
+}
+
+// Server 时间轮的管理接口，实现了 http.Handler.
+type Server struct {
+	wheel       Wheel
+	middlewares []func(next http.Handler) http.Handler
+	handler     http.Handler
+}
+
+func New(wheel Wheel, opts ...Option) *Server {
+	s := Server{wheel: wheel}
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	s.handler = http.HandlerFunc(s.route)
+	for i := len(s.middlewares) - 1; i >= 0; i-- {
+		s.handler = s.middlewares[i](s.handler)
+	}
+	return &s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	switch {
+	case path == "/tasks":
+		s.allow(w, r, http.MethodGet, s.listTasks)
+	case strings.HasPrefix(path, "/tasks/") && strings.HasSuffix(path, "/reschedule"):
+		key, ok := pathKey(w, strings.TrimSuffix(strings.TrimPrefix(path, "/tasks/"), "/reschedule"))
+		if ok {
+			s.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) { s.rescheduleTask(w, r, key) })
+		}
+	case strings.HasPrefix(path, "/tasks/"):
+		key, ok := pathKey(w, strings.TrimPrefix(path, "/tasks/"))
+		if !ok {
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			s.getTask(w, r, key)
+		case http.MethodDelete:
+			s.removeTask(w, r, key)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		}
+	case path == "/deadletters":
+		s.allow(w, r, http.MethodGet, s.listDeadLetters)
+	case path == "/deadletters/requeue":
+		s.allow(w, r, http.MethodPost, s.requeueDeadLetter)
+	case path == "/stats":
+		s.allow(w, r, http.MethodGet, s.stats)
+	case path == "/healthz":
+		s.allow(w, r, http.MethodGet, s.healthz)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("path %s not found", path))
+	}
+}
+
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, method string, handler http.HandlerFunc) {
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	handler(w, r)
+}
+
+// taskView 任务以及其执行时刻
+type taskView struct {
+	Task      *timewheel.RTaskElement `json:"task"`
+	ExecuteAt time.Time               `json:"execute_at"`
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, err := parseTime(query.Get("from"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTime(query.Get("to"), from.Add(time.Hour))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := parseInt(query.Get("limit"), defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	tasks, err := s.wheel.ListPendingTasks(r.Context(), from, to, limit)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	views := make([]taskView, 0, len(tasks))
+	for _, task := range tasks {
+		views = append(views, taskView{Task: task, ExecuteAt: task.ScheduledAt()})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getTask(w http.ResponseWriter, r *http.Request, key string) {
+	task, executeAt, err := s.wheel.GetTask(r.Context(), key)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, taskView{Task: task, ExecuteAt: executeAt})
+}
+
+func (s *Server) removeTask(w http.ResponseWriter, r *http.Request, key string) {
+	if err := s.wheel.RemoveTaskByKey(r.Context(), key); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"key": key})
+}
+
+type rescheduleReq struct {
+	ExecuteAt time.Time `json:"execute_at"`
+}
+
+func (s *Server) rescheduleTask(w http.ResponseWriter, r *http.Request, key string) {
+	var req rescheduleReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExecuteAt.IsZero() {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body, err: %v", err))
+		return
+	}
+	if err := s.wheel.RescheduleTask(r.Context(), key, req.ExecuteAt); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "execute_at": req.ExecuteAt})
+}
+
+// deadLetterView 死信记录以及其在死信队列中的下标
+type deadLetterView struct {
+	Index int `json:"index"`
+	*timewheel.DeadLetter
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, err := parseInt(query.Get("offset"), 0)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := parseInt(query.Get("limit"), defaultLimit)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	deadLetters, err := s.wheel.ListDeadLetters(r.Context(), offset, limit)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	views := make([]deadLetterView, 0, len(deadLetters))
+	for i, deadLetter := range deadLetters {
+		views = append(views, deadLetterView{Index: offset + i, DeadLetter: deadLetter})
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+type requeueReq struct {
+	Index     int       `json:"index"`
+	Key       string    `json:"key"` // 用于确认下标对应的记录没有发生变化
+	ExecuteAt time.Time `json:"execute_at"`
+}
+
+func (s *Server) requeueDeadLetter(w http.ResponseWriter, r *http.Request) {
+	var req requeueReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Index < 0 || req.Key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body, err: %v", err))
+		return
+	}
+	if req.ExecuteAt.IsZero() {
+		req.ExecuteAt = time.Now()
+	}
+
+	deadLetters, err := s.wheel.ListDeadLetters(r.Context(), req.Index, 1)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	// 死信队列从头部写入，下标会随着新记录的写入而变化
+	if len(deadLetters) == 0 || deadLetters[0].Task.Key != req.Key {
+		writeError(w, http.StatusConflict, fmt.Errorf("dead letter at index %d is not %s, list again", req.Index, req.Key))
+		return
+	}
+	if err := s.wheel.RequeueDeadLetter(r.Context(), deadLetters[0], req.ExecuteAt); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": req.Key, "execute_at": req.ExecuteAt})
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	horizon := defaultHorizon
+	if raw := r.URL.Query().Get("horizon"); raw != "" {
+		var err error
+		if horizon, err = time.ParseDuration(raw); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid horizon: %s", raw))
+			return
+		}
+	}
+
+	stats, err := s.wheel.Stats(r.Context(), horizon)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// healthView 健康检查的结果
+type healthView struct {
+	Status string               `json:"status"`
+	State  timewheel.WheelState `json:"state"`
+	Reason string               `json:"reason,omitempty"`
+	Error  string               `json:"error,omitempty"`
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	err := s.wheel.HealthCheck(r.Context())
+	if err == nil {
+		writeJSON(w, http.StatusOK, healthView{Status: "ok", State: s.wheel.State()})
+		return
+	}
+
+	view := healthView{Status: "unhealthy", State: s.wheel.State(), Error: err.Error()}
+	var healthErr *timewheel.HealthError
+	if errors.As(err, &healthErr) {
+		view.State = healthErr.State
+	}
+	switch {
+	case errors.Is(err, timewheel.ErrRedisUnreachable):
+		view.Reason = "redis_unreachable"
+	case errors.Is(err, timewheel.ErrScanStalled):
+		view.Reason = "scan_stalled"
+	case errors.Is(err, timewheel.ErrWheelStopped):
+		view.Reason = "stopped"
+	}
+	writeJSON(w, http.StatusServiceUnavailable, view)
+}
+
+func pathKey(w http.ResponseWriter, escaped string) (string, bool) {
+	key, err := url.PathUnescape(escaped)
+	if err != nil || key == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid key: %s", escaped))
+		return "", false
+	}
+	return key, true
+}
+
+func parseTime(raw string, defaultValue time.Time) (time.Time, error) {
+	if raw == "" {
+		return defaultValue, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", raw)
+	}
+	return t, nil
+}
+
+func parseInt(raw string, defaultValue int) (int, error) {
+	if raw == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", raw)
+	}
+	return v, nil
+}
+
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, timewheel.ErrTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, timewheel.ErrTaskAlreadyExecuted):
+		return http.StatusConflict
+	case errors.Is(err, timewheel.ErrExecuteAtInPast):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
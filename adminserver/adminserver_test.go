@@ -0,0 +1,209 @@
+package adminserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+type fakeWheel struct {
+	tasks       map[string]*timewheel.RTaskElement
+	deadLetters []*timewheel.DeadLetter
+	requeued    []string
+	healthErr   error
+}
+
+func (f *fakeWheel) ListPendingTasks(ctx context.Context, from, to time.Time, limit int) ([]*timewheel.RTaskElement, error) {
+	tasks := make([]*timewheel.RTaskElement, 0, len(f.tasks))
+	for _, task := range f.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+func (f *fakeWheel) GetTask(ctx context.Context, key string) (*timewheel.RTaskElement, time.Time, error) {
+	task, ok := f.tasks[key]
+	if !ok {
+		return nil, time.Time{}, timewheel.ErrTaskNotFound
+	}
+	return task, time.Unix(1700000000, 0), nil
+}
+
+func (f *fakeWheel) RemoveTaskByKey(ctx context.Context, key string) error {
+	if _, ok := f.tasks[key]; !ok {
+		return timewheel.ErrTaskNotFound
+	}
+	delete(f.tasks, key)
+	return nil
+}
+
+func (f *fakeWheel) RescheduleTask(ctx context.Context, key string, newExecuteAt time.Time) error {
+	if newExecuteAt.Before(time.Now()) {
+		return timewheel.ErrExecuteAtInPast
+	}
+	return nil
+}
+
+func (f *fakeWheel) ListDeadLetters(ctx context.Context, offset, limit int) ([]*timewheel.DeadLetter, error) {
+	if offset >= len(f.deadLetters) {
+		return nil, nil
+	}
+	end := offset + limit
+	if end > len(f.deadLetters) {
+		end = len(f.deadLetters)
+	}
+	return f.deadLetters[offset:end], nil
+}
+
+func (f *fakeWheel) RequeueDeadLetter(ctx context.Context, deadLetter *timewheel.DeadLetter, executeAt time.Time) error {
+	f.requeued = append(f.requeued, deadLetter.Task.Key)
+	return nil
+}
+
+func (f *fakeWheel) Stats(ctx context.Context, horizon time.Duration) (timewheel.WheelStats, error) {
+	return timewheel.WheelStats{}, nil
+}
+
+func (f *fakeWheel) HealthCheck(ctx context.Context) error {
+	return f.healthErr
+}
+
+func (f *fakeWheel) State() timewheel.WheelState {
+	return timewheel.WheelStarted
+}
+
+func newFakeWheel() *fakeWheel {
+	return &fakeWheel{
+		tasks: map[string]*timewheel.RTaskElement{
+			"a/b": {Key: "a/b", CallbackURL: "http://localhost/callback"},
+		},
+		deadLetters: []*timewheel.DeadLetter{
+			{Task: &timewheel.RTaskElement{Key: "dead1"}, Error: "boom"},
+		},
+	}
+}
+
+func do(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func Test_adminServer(t *testing.T) {
+	wheel := newFakeWheel()
+	server := New(wheel)
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	cases := []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodGet, "/tasks?limit=10", "", http.StatusOK},
+		{http.MethodGet, "/tasks?from=bad", "", http.StatusBadRequest},
+		{http.MethodGet, "/tasks/a%2Fb", "", http.StatusOK},
+		{http.MethodGet, "/tasks/missing", "", http.StatusNotFound},
+		{http.MethodPost, "/tasks/a%2Fb/reschedule", `{"execute_at":"` + future + `"}`, http.StatusOK},
+		{http.MethodPost, "/tasks/a%2Fb/reschedule", `{"execute_at":"2000-01-01T00:00:00Z"}`, http.StatusBadRequest},
+		{http.MethodPut, "/tasks/a%2Fb", "", http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/tasks/a%2Fb", "", http.StatusOK},
+		{http.MethodDelete, "/tasks/a%2Fb", "", http.StatusNotFound},
+		{http.MethodGet, "/deadletters", "", http.StatusOK},
+		{http.MethodPost, "/deadletters/requeue", `{"index":0,"key":"other"}`, http.StatusConflict},
+		{http.MethodPost, "/deadletters/requeue", `{"index":0,"key":"dead1"}`, http.StatusOK},
+		{http.MethodGet, "/stats?horizon=10m", "", http.StatusOK},
+		{http.MethodGet, "/stats?horizon=bad", "", http.StatusBadRequest},
+		{http.MethodGet, "/unknown", "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		rec := do(server, c.method, c.target, c.body)
+		if rec.Code != c.status {
+			t.Errorf("%s %s, got status: %d, want: %d, body: %s", c.method, c.target, rec.Code, c.status, rec.Body.String())
+		}
+	}
+
+	if len(wheel.requeued) != 1 || wheel.requeued[0] != "dead1" {
+		t.Errorf("got requeued: %v, want [dead1]", wheel.requeued)
+	}
+}
+
+func Test_adminServerResponse(t *testing.T) {
+	server := New(newFakeWheel())
+
+	rec := do(server, http.MethodGet, "/tasks/missing", "")
+	var errResp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || errResp["error"] == "" {
+		t.Errorf("got body: %s, want error message", rec.Body.String())
+	}
+
+	rec = do(server, http.MethodGet, "/deadletters", "")
+	var deadLetters []struct {
+		Index int                     `json:"index"`
+		Task  *timewheel.RTaskElement `json:"task"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &deadLetters); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Index != 0 || deadLetters[0].Task.Key != "dead1" {
+		t.Errorf("got dead letters: %s", rec.Body.String())
+	}
+}
+
+func Test_adminServerMiddleware(t *testing.T) {
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	server := New(newFakeWheel(), WithMiddleware(auth))
+
+	if rec := do(server, http.MethodGet, "/stats", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("got status: %d, want: %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+	req.Header.Set("Authorization", "token")
+	server.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status: %d, want: %d", rec.Code, http.StatusOK)
+	}
+}
+
+func Test_adminServerHealthz(t *testing.T) {
+	wheel := newFakeWheel()
+	server := New(wheel)
+
+	cases := []struct {
+		healthErr error
+		status    int
+		reason    string
+	}{
+		{nil, http.StatusOK, ""},
+		{&timewheel.HealthError{Kind: timewheel.ErrRedisUnreachable, State: timewheel.WheelStarted}, http.StatusServiceUnavailable, "redis_unreachable"},
+		{&timewheel.HealthError{Kind: timewheel.ErrScanStalled, State: timewheel.WheelStarted}, http.StatusServiceUnavailable, "scan_stalled"},
+		{&timewheel.HealthError{Kind: timewheel.ErrWheelStopped, State: timewheel.WheelStopped}, http.StatusServiceUnavailable, "stopped"},
+	}
+	for _, c := range cases {
+		wheel.healthErr = c.healthErr
+		rec := do(server, http.MethodGet, "/healthz", "")
+		var resp map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Error(err)
+			continue
+		}
+		if rec.Code != c.status || resp["reason"] != c.reason {
+			t.Errorf("got status: %d, body: %s, want status: %d, reason: %s", rec.Code, rec.Body.String(), c.status, c.reason)
+		}
+	}
+}
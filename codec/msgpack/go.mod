@@ -0,0 +1,17 @@
+module github.com/xiaoxuxiansheng/timewheel/codec/msgpack
+
+go 1.19
+
+require (
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	github.com/xiaoxuxiansheng/timewheel v0.0.0
+)
+
+require (
+	github.com/demdxx/gocast v1.2.0 // indirect
+	github.com/gomodule/redigo v1.8.9 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
+
+replace github.com/xiaoxuxiansheng/timewheel => ../..
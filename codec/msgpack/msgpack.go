@@ -0,0 +1,46 @@
+// Package msgpack 基于 MessagePack 实现时间轮任务明细的编解码.
+//
+// 相比默认的 json，编码结果体积更小，解码开销更低，适合任务量较大、对 redis 内存敏感的场景.
+// 沿用 RTaskElement 的 json tag 作为字段名，独立为子模块，避免未使用 msgpack 的使用方引入相关依赖. 使用示例：
+//
+//	rTimeWheel := timewheel.NewRTimeWheel(redisClient, httpClient, timewheel.WithCodec(msgpack.New(), timewheel.JSONCodec{}))
+package msgpack
+
+import (
+	"bytes"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+// Format msgpack 编码结果的格式标识
+const Format byte = 0x01
+
+// Codec 基于 MessagePack 的 timewheel.Codec 实现.
+type Codec struct{}
+
+// New 创建基于 MessagePack 的 Codec.
+func New() *Codec {
+	return &Codec{}
+}
+
+func (c *Codec) Format() byte {
+	return Format
+}
+
+func (c *Codec) Marshal(task *timewheel.RTaskElement) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(task); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *Codec) Unmarshal(data []byte, task *timewheel.RTaskElement) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(task)
+}
@@ -0,0 +1,71 @@
+package msgpack
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+func newTask() *timewheel.RTaskElement {
+	return &timewheel.RTaskElement{
+		Key:         "order_timeout_1024",
+		CallbackURL: "http://localhost:8080/callback",
+		Method:      http.MethodPost,
+		Req:         map[string]interface{}{"order_id": "1024", "user_id": "42"},
+		Header:      map[string]string{"X-Request-Id": "abc"},
+	}
+}
+
+func Test_codec(t *testing.T) {
+	codec := New()
+	task := newTask()
+	data, err := codec.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got timewheel.RTaskElement
+	if err := codec.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != task.Key || got.CallbackURL != task.CallbackURL || got.Method != task.Method ||
+		!reflect.DeepEqual(got.Header, task.Header) {
+		t.Errorf("round trip mismatch: %+v", got)
+	}
+	if codec.Format() == '{' {
+		t.Errorf("format must differ from json")
+	}
+}
+
+func benchmarkMarshal(b *testing.B, codec timewheel.Codec) {
+	task := newTask()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := codec.Marshal(task); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkUnmarshal(b *testing.B, codec timewheel.Codec) {
+	data, err := codec.Marshal(newTask())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(len(data)), "bytes/task")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var task timewheel.RTaskElement
+		if err := codec.Unmarshal(data, &task); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_marshal_json(b *testing.B)      { benchmarkMarshal(b, timewheel.JSONCodec{}) }
+func Benchmark_marshal_msgpack(b *testing.B)   { benchmarkMarshal(b, New()) }
+func Benchmark_unmarshal_json(b *testing.B)    { benchmarkUnmarshal(b, timewheel.JSONCodec{}) }
+func Benchmark_unmarshal_msgpack(b *testing.B) { benchmarkUnmarshal(b, New()) }
@@ -0,0 +1,232 @@
+// Package prometheus 基于 prometheus 实现时间轮的监控指标.
+//
+// 独立为子模块，避免未使用 prometheus 的使用方引入相关依赖. 使用示例：
+//
+//	metrics := prometheus.NewMetrics("timewheel")
+//	registry.MustRegister(metrics)
+//	rTimeWheel := timewheel.NewRTimeWheel(redisClient, httpClient, timewheel.WithMetrics(metrics))
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+var _ timewheel.Metrics = (*Metrics)(nil)
+
+// Metrics 实现了 timewheel.Metrics 以及 prometheus.Collector，注册到 prometheus 之后即可采集.
+type Metrics struct {
+	tasksAdded          prometheus.Counter
+	tasksRemoved        prometheus.Counter
+	tasksExecuted       prometheus.Counter
+	tasksFailed         prometheus.Counter
+	payloadDecodeErrors prometheus.Counter
+	circuitsOpened      prometheus.Counter
+	resultFailures      prometheus.Counter
+	scansSkipped        prometheus.Counter
+	bufferDropped       prometheus.Counter
+	redisRetries        prometheus.Counter
+	httpRetries         prometheus.Counter
+	tasksPromoted       prometheus.Counter
+	duplicates          prometheus.Counter
+
+	pendingTasks       prometheus.Gauge
+	inflightExecutions prometheus.Gauge
+	leader             prometheus.Gauge
+	openCircuits       prometheus.Gauge
+	bufferedTasks      prometheus.Gauge
+	deferredTasks      prometheus.Gauge
+
+	callbackLatency prometheus.Histogram
+	rateLimitWait   prometheus.Histogram
+	scanDuration    prometheus.Histogram
+	redisTime       prometheus.Histogram
+	payloadSize     prometheus.Histogram
+	scanPages       prometheus.Histogram
+}
+
+// NewMetrics 创建监控指标，namespace 为指标名称的前缀.
+func NewMetrics(namespace string) *Metrics {
+	counter := func(name, help string) prometheus.Counter {
+		return prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help})
+	}
+	gauge := func(name, help string) prometheus.Gauge {
+		return prometheus.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help})
+	}
+	histogram := func(name, help string) prometheus.Histogram {
+		return prometheus.NewHistogram(prometheus.HistogramOpts{Namespace: namespace, Name: name, Help: help, Buckets: prometheus.DefBuckets})
+	}
+
+	return &Metrics{
+		tasksAdded:          counter("tasks_added", "Number of tasks written to the wheel, including retries and recurring occurrences."),
+		tasksRemoved:        counter("tasks_removed", "Number of tasks removed before execution."),
+		tasksExecuted:       counter("tasks_executed", "Number of successful task callbacks."),
+		tasksFailed:         counter("tasks_failed", "Number of failed task callbacks."),
+		payloadDecodeErrors: counter("payload_decode_errors", "Number of task payloads that could not be decoded."),
+		circuitsOpened:      counter("circuits_opened", "Number of times a callback host circuit breaker opened."),
+		resultFailures:      counter("result_delivery_failures", "Number of task results that could not be delivered to the result url."),
+		scansSkipped:        counter("scans_skipped", "Number of ticks skipped because the previous scan was still running."),
+		bufferDropped:       counter("buffer_dropped", "Number of tasks dropped by the local write buffer."),
+		redisRetries:        counter("redis_retries", "Number of idempotent redis commands retried after a connection error."),
+		httpRetries:         counter("http_retries", "Number of callback requests retried inside the HTTP client."),
+		tasksPromoted:       counter("tasks_promoted", "Number of far-future tasks promoted from the overflow store into time slices."),
+		duplicates:          counter("duplicates_suppressed", "Number of exactly-once executions skipped because the execution was already claimed."),
+
+		pendingTasks:       gauge("pending_tasks", "Change in pending tasks caused by this instance; sum across instances for the wheel total."),
+		inflightExecutions: gauge("inflight_executions", "Number of task callbacks in flight."),
+		leader:             gauge("leader", "Whether this instance currently holds the scan leadership (1) or not (0)."),
+		openCircuits:       gauge("open_circuits", "Number of callback hosts whose circuit breaker is open or half-open."),
+		bufferedTasks:      gauge("buffered_tasks", "Number of tasks held in the local write buffer waiting for redis to recover."),
+		deferredTasks:      gauge("deferred_tasks", "Number of due tasks left in redis while fetching is paused because the executor cannot keep up."),
+
+		callbackLatency: histogram("callback_latency_seconds", "Latency of task callbacks."),
+		rateLimitWait:   histogram("rate_limit_wait_seconds", "Time task callbacks waited for the rate limiter before dispatch."),
+		scanDuration:    histogram("scan_duration_seconds", "Duration of a single scan of due tasks."),
+		redisTime:       histogram("redis_time_latency_seconds", "Latency of reading the redis server clock at each tick."),
+		payloadSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "payload_size_bytes",
+			Help:      "Size of serialized task payloads written to the wheel.",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}),
+		scanPages: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "scan_pages",
+			Help:      "Number of pages fetched by a single scan; sustained values above 1 mean the wheel is falling behind.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 8),
+		}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.tasksAdded, m.tasksRemoved, m.tasksExecuted, m.tasksFailed, m.payloadDecodeErrors, m.circuitsOpened, m.resultFailures, m.scansSkipped, m.bufferDropped, m.redisRetries, m.httpRetries, m.tasksPromoted, m.duplicates,
+		m.pendingTasks, m.inflightExecutions, m.leader, m.openCircuits, m.bufferedTasks, m.deferredTasks,
+		m.callbackLatency, m.rateLimitWait, m.scanDuration, m.redisTime, m.payloadSize, m.scanPages,
+	}
+}
+
+// Describe 实现 prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect 实现 prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (m *Metrics) IncTasksAdded() {
+	m.tasksAdded.Inc()
+}
+
+func (m *Metrics) ObservePayloadSize(bytes int) {
+	m.payloadSize.Observe(float64(bytes))
+}
+
+func (m *Metrics) IncTasksRemoved(n int) {
+	m.tasksRemoved.Add(float64(n))
+}
+
+func (m *Metrics) IncTasksExecuted() {
+	m.tasksExecuted.Inc()
+}
+
+func (m *Metrics) IncTasksFailed() {
+	m.tasksFailed.Inc()
+}
+
+func (m *Metrics) IncPayloadDecodeErrors() {
+	m.payloadDecodeErrors.Inc()
+}
+
+func (m *Metrics) AddPendingTasks(delta int) {
+	m.pendingTasks.Add(float64(delta))
+}
+
+func (m *Metrics) AddInflightExecutions(delta int) {
+	m.inflightExecutions.Add(float64(delta))
+}
+
+func (m *Metrics) ObserveCallbackLatency(latency time.Duration) {
+	m.callbackLatency.Observe(latency.Seconds())
+}
+
+func (m *Metrics) ObserveRateLimitWait(wait time.Duration) {
+	m.rateLimitWait.Observe(wait.Seconds())
+}
+
+func (m *Metrics) ObserveScanDuration(duration time.Duration) {
+	m.scanDuration.Observe(duration.Seconds())
+}
+
+func (m *Metrics) IncScansSkipped() {
+	m.scansSkipped.Inc()
+}
+
+func (m *Metrics) SetLeader(isLeader bool) {
+	if isLeader {
+		m.leader.Set(1)
+		return
+	}
+	m.leader.Set(0)
+}
+
+func (m *Metrics) IncResultDeliveryFailures() {
+	m.resultFailures.Inc()
+}
+
+func (m *Metrics) AddBufferedTasks(delta int) {
+	m.bufferedTasks.Add(float64(delta))
+}
+
+func (m *Metrics) IncBufferDropped() {
+	m.bufferDropped.Inc()
+}
+
+func (m *Metrics) IncRedisRetries() {
+	m.redisRetries.Inc()
+}
+
+func (m *Metrics) IncHTTPRetries() {
+	m.httpRetries.Inc()
+}
+
+func (m *Metrics) ObserveRedisTimeLatency(latency time.Duration) {
+	m.redisTime.Observe(latency.Seconds())
+}
+
+func (m *Metrics) IncTasksPromoted(n int) {
+	m.tasksPromoted.Add(float64(n))
+}
+
+func (m *Metrics) ObserveScanPages(pages int) {
+	m.scanPages.Observe(float64(pages))
+}
+
+func (m *Metrics) SetDeferredTasks(n int) {
+	m.deferredTasks.Set(float64(n))
+}
+
+func (m *Metrics) IncDuplicatesSuppressed() {
+	m.duplicates.Inc()
+}
+
+func (m *Metrics) ObserveCircuitTransition(host string, from, to timewheel.CircuitState) {
+	if to == timewheel.CircuitOpen {
+		m.circuitsOpened.Inc()
+	}
+	switch {
+	case from == timewheel.CircuitClosed:
+		m.openCircuits.Inc()
+	case to == timewheel.CircuitClosed:
+		m.openCircuits.Dec()
+	}
+}
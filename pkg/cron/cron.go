@@ -0,0 +1,222 @@
+// 标准 5 段式 cron 表达式解析：分 时 日 月 周
+//
+// 每一段支持 *、数字、范围 a-b、步长 */n 或 a-b/n，以及使用逗号分隔的列表；月份与星期支持英文缩写（JAN、MON 等）.
+// 与 crontab 保持一致：当日与周同时被限定时，满足其一即可.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type bounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteBounds = bounds{name: "minute", min: 0, max: 59}
+	hourBounds   = bounds{name: "hour", min: 0, max: 23}
+	domBounds    = bounds{name: "day of month", min: 1, max: 31}
+	monthBounds  = bounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 星期允许使用 7 表示周日
+	dowBounds = bounds{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+// 检索下一次执行时刻时的最大年份跨度，避免 2 月 30 日这类永远不会满足的表达式陷入死循环
+const maxSearchYears = 5
+
+// Schedule 解析后的 cron 表达式.
+type Schedule struct {
+	spec                          string
+	minute, hour, dom, month, dow uint64
+	domRestricted, dowRestricted  bool
+	location                      *time.Location
+}
+
+// Parse 解析 5 段式 cron 表达式，loc 为计算执行时刻时采用的时区，为 nil 时使用 time.Local.
+func Parse(spec string, loc *time.Location) (*Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron spec %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	s := Schedule{
+		spec:          spec,
+		location:      loc,
+		domRestricted: fields[2] != "*" && fields[2] != "?",
+		dowRestricted: fields[4] != "*" && fields[4] != "?",
+	}
+
+	var err error
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	if s.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	// 周日既可以用 0 也可以用 7 表示
+	if s.dow&(1<<7) > 0 {
+		s.dow |= 1
+	}
+
+	return &s, nil
+}
+
+// String 返回原始的 cron 表达式.
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next 返回严格晚于 t 的下一次执行时刻. 倘若在可检索的范围内不存在满足条件的时刻，则返回零值.
+func (s *Schedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.location)
+
+	// 从下一分钟的整点开始检索
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + maxSearchYears
+
+WRAP:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+		if t.Month() == time.January {
+			goto WRAP
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+		if t.Day() == 1 {
+			goto WRAP
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+		if t.Hour() == 0 {
+			goto WRAP
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto WRAP
+		}
+	}
+
+	return t.In(origLoc)
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) > 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) > 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		partBits, err := parsePart(part, b)
+		if err != nil {
+			return 0, err
+		}
+		bits |= partBits
+	}
+	return bits, nil
+}
+
+func parsePart(part string, b bounds) (uint64, error) {
+	rangeAndStep := strings.Split(part, "/")
+	if len(rangeAndStep) > 2 {
+		return 0, fmt.Errorf("invalid %s %q", b.name, part)
+	}
+
+	start, end := b.min, b.max
+	switch rangePart := rangeAndStep[0]; {
+	case rangePart == "*" || rangePart == "?":
+	case strings.Contains(rangePart, "-"):
+		lowHigh := strings.Split(rangePart, "-")
+		if len(lowHigh) != 2 {
+			return 0, fmt.Errorf("invalid %s range %q", b.name, rangePart)
+		}
+		var err error
+		if start, err = parseValue(lowHigh[0], b); err != nil {
+			return 0, err
+		}
+		if end, err = parseValue(lowHigh[1], b); err != nil {
+			return 0, err
+		}
+	default:
+		v, err := parseValue(rangePart, b)
+		if err != nil {
+			return 0, err
+		}
+		start = v
+		// 形如 5/10 的写法表示从 5 开始直到上界，每 10 个单位一次
+		if len(rangeAndStep) == 1 {
+			end = v
+		}
+	}
+
+	step := 1
+	if len(rangeAndStep) == 2 {
+		var err error
+		if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+			return 0, fmt.Errorf("invalid %s step %q", b.name, rangeAndStep[1])
+		}
+	}
+
+	if start > end {
+		return 0, fmt.Errorf("invalid %s range %q: start %d is greater than end %d", b.name, part, start, end)
+	}
+
+	var bits uint64
+	for v := start; v <= end; v += step {
+		bits |= 1 << uint(v)
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (int, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", b.name, s)
+	}
+	if v < b.min || v > b.max {
+		return 0, fmt.Errorf("%s %d out of range [%d, %d]", b.name, v, b.min, b.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,52 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_cronNext(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	base := time.Date(2023, 3, 31, 23, 58, 30, 0, loc)
+
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/5 * * * *", time.Date(2023, 4, 1, 0, 0, 0, 0, loc)},
+		{"59 23 * * *", time.Date(2023, 3, 31, 23, 59, 0, 0, loc)},
+		{"30 9 * * mon-fri", time.Date(2023, 4, 3, 9, 30, 0, 0, loc)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, loc)},
+		{"0 12 15 * 0", time.Date(2023, 4, 2, 12, 0, 0, 0, loc)},
+	}
+	for _, c := range cases {
+		schedule, err := Parse(c.spec, loc)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := schedule.Next(base); !got.Equal(c.want) {
+			t.Errorf("spec: %s, got: %v, want: %v", c.spec, got, c.want)
+		}
+	}
+}
+
+func Test_cronInvalidSpec(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := Parse(spec, time.UTC); err == nil {
+			t.Errorf("spec: %q, expect error", spec)
+		}
+	}
+
+	schedule, err := Parse("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if next := schedule.Next(time.Now()); !next.IsZero() {
+		t.Errorf("got: %v, want zero time", next)
+	}
+}
@@ -3,21 +3,283 @@ package http
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	// 非 2xx 响应中保留到错误信息里的响应体长度上限
+	maxErrorBodySize = 512
+	// 读取响应体之后最多继续排空的字节数
+	maxDrainSize = 1 << 20
+)
+
+// ErrResponseTooLarge 响应体超过了 WithMaxResponseBodySize 的上限，无法完整解码.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// ErrTLSHandshake 与回调地址的 TLS 握手失败，如服务端证书不受信任、域名不匹配，或者服务端拒绝了客户端证书.
+// 通过 errors.Is 判断，原始的错误可以通过 errors.As 获取.
+var ErrTLSHandshake = errors.New("tls handshake failed")
+
+type tlsError struct {
+	err error
+}
+
+func (e *tlsError) Error() string {
+	return ErrTLSHandshake.Error() + ": " + e.err.Error()
+}
+
+func (e *tlsError) Is(target error) bool {
+	return target == ErrTLSHandshake
+}
+
+func (e *tlsError) Unwrap() error {
+	return e.err
+}
+
+func isTLSErr(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	// 服务端返回的 TLS alert 没有导出的错误类型
+	return strings.Contains(err.Error(), "tls: ")
+}
+
+// StatusError 服务端返回了非 2xx 的响应.
+type StatusError struct {
+	StatusCode int
+	Body       string        // 截断后的响应体
+	RetryAfter time.Duration // 429、503 响应中 Retry-After 要求的等待时长，未携带时为 0
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("invalid status: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// StatusCode 返回 err 链路中 StatusError 的状态码，不存在时返回 0.
+func StatusCode(err error) int {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode
+	}
+	return 0
+}
+
+// RetryAfter 返回 err 链路中 StatusError 携带的 Retry-After 等待时长，不存在时返回 0.
+func RetryAfter(err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
+
+// 解析 Retry-After，支持秒数以及 HTTP-date 两种格式，无法解析或者时刻已经过去时返回 0
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	at, err := http.ParseTime(value)
+	if err != nil || !at.After(now) {
+		return 0
+	}
+	return at.Sub(now)
+}
+
+const (
+	// 默认每个主机保留的空闲连接数. 回调请求集中发往少数几个主机，http.DefaultTransport 的 2 个空闲连接远远不够
+	DefaultMaxIdleConnsPerHost = 32
+	// 默认读取的响应体长度上限
+	DefaultMaxResponseBodySize = 64 << 10
+	// 默认第一次重试之前的等待时长
+	DefaultRetryBackoff = 50 * time.Millisecond
+	// 默认重试的最大等待时长
+	DefaultMaxRetryBackoff = time.Second
+)
+
+type ClientOptions struct {
+	requestTimeout      time.Duration
+	transport           http.RoundTripper
+	maxIdleConnsPerHost int
+	proxy               *url.URL
+	tlsConfig           *tls.Config
+	getCertificate      func() (*tls.Certificate, error)
+	maxResponseBodySize int64
+	middlewares         []Middleware
+	retryAttempts       int
+	retryBackoff        BackoffFunc
+	retryIf             func(resp Response, err error) bool
+}
+
+type ClientOption func(c *ClientOptions)
+
+// WithRequestTimeout 单次请求的超时时间，包括建立连接、发送请求以及读取响应. 缺省时不限制，仅受 ctx 的约束.
+// 时间轮中 ctx 的截止时间为批次的超时时间，因此实际生效的是两者中更早的一个.
+func WithRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.requestTimeout = timeout
+	}
+}
+
+// WithTransport 替换发送请求的 RoundTripper. 设置之后 WithMaxIdleConnsPerHost、WithProxy、WithClientTLS 以及 WithClientCertificate 不再生效.
+func WithTransport(rt http.RoundTripper) ClientOption {
+	return func(c *ClientOptions) {
+		c.transport = rt
+	}
+}
+
+// WithMaxIdleConnsPerHost 每个主机保留的空闲连接数，缺省为 DefaultMaxIdleConnsPerHost.
+func WithMaxIdleConnsPerHost(n int) ClientOption {
+	return func(c *ClientOptions) {
+		c.maxIdleConnsPerHost = n
+	}
+}
+
+// WithProxy 通过代理发送请求. 缺省时与 http.DefaultTransport 相同，读取 HTTP_PROXY 等环境变量.
+func WithProxy(proxyURL *url.URL) ClientOption {
+	return func(c *ClientOptions) {
+		c.proxy = proxyURL
+	}
+}
+
+// WithClientTLS 设置 https 回调的 TLS 配置，如客户端证书（Certificates）、私有 CA（RootCAs）以及校验的域名（ServerName）.
+// cfg 在创建客户端时复制，之后的修改不会生效；需要轮换客户端证书时使用 WithClientCertificate.
+func WithClientTLS(cfg *tls.Config) ClientOption {
+	return func(c *ClientOptions) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithClientCertificate 每次建立 TLS 连接时通过 getCert 获取客户端证书，优先于 WithClientTLS 中的 Certificates.
+// getCert 可以在证书轮换之后返回新的证书，新建立的连接即会使用，无需重新创建客户端以及时间轮；已经建立的长连接不受影响.
+func WithClientCertificate(getCert func() (*tls.Certificate, error)) ClientOption {
+	return func(c *ClientOptions) {
+		c.getCertificate = getCert
+	}
+}
+
+// WithMaxResponseBodySize 读取的响应体长度上限，超出的部分被丢弃，缺省为 DefaultMaxResponseBodySize.
+func WithMaxResponseBodySize(n int64) ClientOption {
+	return func(c *ClientOptions) {
+		c.maxResponseBodySize = n
+	}
+}
+
+// WithHTTPRetry 请求失败时在客户端内部立即重试，最多发送 attempts 次，attempts 不大于 1 时不重试. 缺省时不重试.
+// backoff 为 nil 时使用 DefaultRetryBackoff 起始的指数退避；retryIf 为 nil 时仅重试连接被拒绝、重置等短暂的网络异常.
+// 幂等的请求（GET、HEAD、PUT、DELETE 等）满足 retryIf 即重试；非幂等的请求只有在写出请求之前失败时才会重试，避免重复投递.
+// 重试不会超过 ctx 的截止时间，重试之后仍然失败时返回 *RetryError，包含请求的次数以及最后一次的错误.
+// 这里的重试发生在一次任务执行之内，与时间轮按照退避策略重新调度任务的重试相互独立.
+func WithHTTPRetry(attempts int, backoff BackoffFunc, retryIf func(resp Response, err error) bool) ClientOption {
+	return func(c *ClientOptions) {
+		c.retryAttempts = attempts
+		c.retryBackoff = backoff
+		c.retryIf = retryIf
+	}
+}
+
+// WithMiddleware 注册中间件，可以多次调用. 先注册的中间件位于外层，请求按照注册的顺序经过各个中间件.
+func WithMiddleware(mw Middleware) ClientOption {
+	return func(c *ClientOptions) {
+		c.middlewares = append(c.middlewares, mw)
+	}
+}
+
+func repairClient(c *ClientOptions) {
+	if c.maxResponseBodySize <= 0 {
+		c.maxResponseBodySize = DefaultMaxResponseBodySize
+	}
+
+	if c.retryBackoff == nil {
+		c.retryBackoff = ExponentialBackoff(DefaultRetryBackoff, DefaultMaxRetryBackoff)
+	}
+
+	if c.retryIf == nil {
+		c.retryIf = isTransientErr
+	}
+
+	if c.requestTimeout < 0 {
+		c.requestTimeout = 0
+	}
+
+	if c.maxIdleConnsPerHost <= 0 {
+		c.maxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	if c.transport == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConnsPerHost = c.maxIdleConnsPerHost
+		if transport.MaxIdleConns < c.maxIdleConnsPerHost {
+			transport.MaxIdleConns = c.maxIdleConnsPerHost
+		}
+		if c.proxy != nil {
+			transport.Proxy = http.ProxyURL(c.proxy)
+		}
+		if c.tlsConfig != nil {
+			transport.TLSClientConfig = c.tlsConfig.Clone()
+		}
+		if getCert := c.getCertificate; getCert != nil {
+			if transport.TLSClientConfig == nil {
+				transport.TLSClientConfig = &tls.Config{}
+			}
+			transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, err := getCert()
+				if err != nil {
+					return nil, &tlsError{err: fmt.Errorf("get client certificate, err: %w", err)}
+				}
+				return cert, nil
+			}
+		}
+		c.transport = transport
+	}
+}
+
 type Client struct {
-	core *http.Client
+	opts       *ClientOptions
+	core       *http.Client
+	do         Doer
+	retryHooks retryHooks
 }
 
-func NewClient() *Client {
-	return &Client{
-		core: http.DefaultClient,
+func NewClient(opts ...ClientOption) *Client {
+	c := Client{
+		opts: &ClientOptions{},
+	}
+
+	for _, opt := range opts {
+		opt(c.opts)
+	}
+
+	repairClient(c.opts)
+
+	c.core = &http.Client{
+		Transport: c.opts.transport,
+		Timeout:   c.opts.requestTimeout,
 	}
+	c.do = chain(c.sendWithRetry, c.opts.middlewares)
+	return &c
 }
 
 func (c *Client) JSONGet(ctx context.Context, url string, header, params map[string]string, resp interface{}) error {
@@ -28,46 +290,104 @@ func (c *Client) JSONPost(ctx context.Context, url string, header map[string]str
 	return c.JSONDo(ctx, http.MethodPost, url, header, req, resp)
 }
 
+// JSONDo 以 json 编码 req 作为请求体，resp 不为 nil 时将响应体按照 json 解码到 resp 中.
+// 响应体超过 WithMaxResponseBodySize 的上限时无法完整解码，返回 ErrResponseTooLarge.
 func (c *Client) JSONDo(ctx context.Context, method, url string, header map[string]string, req, resp interface{}) error {
-	var reqReader io.Reader
+	var body []byte
 	if req != nil {
-		body, _ := json.Marshal(req)
-		reqReader = bytes.NewReader(body)
+		body, _ = json.Marshal(req)
 	}
 
-	request, err := http.NewRequestWithContext(ctx, method, url, reqReader)
-	if err != nil {
+	response, err := c.Do(ctx, Request{Method: method, URL: url, Header: header, Body: body, ContentType: "application/json"})
+	if err != nil || resp == nil {
 		return err
 	}
+	if response.Truncated {
+		return fmt.Errorf("%w: limit %d bytes", ErrResponseTooLarge, c.opts.maxResponseBodySize)
+	}
+	return json.Unmarshal(response.Body, resp)
+}
+
+// Request 通过 Do 发送的请求.
+type Request struct {
+	Method string
+	URL    string
+	Header map[string]string
+	// Body 预先编码好的请求体，为 nil 时不携带请求体，也不设置 Content-Type
+	Body []byte
+	// ContentType 请求体的类型，Header 中已经设置 Content-Type 时以 Header 为准
+	ContentType string
+}
+
+// Response 服务端的响应. Body 最多保留 WithMaxResponseBodySize 字节，超出的部分被丢弃，此时 Truncated 为 true.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Truncated  bool
+}
+
+// Do 发送请求并读取响应. 非 2xx 的响应在返回 *StatusError 的同时也会返回 Response，便于记录状态码以及响应体.
+// 响应体无论是否读取完整都会被排空并关闭，保证连接能够复用. 请求依次经过 WithMiddleware 注册的中间件，中间件对请求的修改不会影响 req.
+func (c *Client) Do(ctx context.Context, req Request) (Response, error) {
+	header := make(map[string]string, len(req.Header))
+	for k, v := range req.Header {
+		header[k] = v
+	}
+	req.Header = header
+	return c.do(ctx, &req)
+}
+
+func (c *Client) send(ctx context.Context, req *Request) (Response, error) {
+	var reqReader io.Reader
+	if req.Body != nil {
+		reqReader = bytes.NewReader(req.Body)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, req.Method, req.URL, reqReader)
+	if err != nil {
+		return Response{}, err
+	}
 
 	if request.Header == nil {
 		request.Header = make(http.Header)
 	}
-	for k, v := range header {
+	for k, v := range req.Header {
 		request.Header.Add(k, v)
 	}
-	request.Header.Add("Content-Type", "application/json")
+	// 没有请求体时（如 GET、HEAD、DELETE）不声明 Content-Type
+	if req.Body != nil && req.ContentType != "" && request.Header.Get("Content-Type") == "" {
+		request.Header.Set("Content-Type", req.ContentType)
+	}
 
 	response, err := c.core.Do(request)
 	if err != nil {
-		return err
+		if !errors.Is(err, ErrTLSHandshake) && isTLSErr(err) {
+			return Response{}, &tlsError{err: err}
+		}
+		return Response{}, err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusOK {
-		return fmt.Errorf("invalid status: %d", response.StatusCode)
-	}
-
-	if resp == nil {
-		return nil
+	resp := Response{StatusCode: response.StatusCode, Header: response.Header}
+	resp.Body, err = io.ReadAll(io.LimitReader(response.Body, c.opts.maxResponseBodySize+1))
+	if int64(len(resp.Body)) > c.opts.maxResponseBodySize {
+		resp.Body, resp.Truncated = resp.Body[:c.opts.maxResponseBodySize], true
 	}
+	// 排空剩余的响应体，超过 maxDrainSize 时放弃复用连接
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, maxDrainSize))
 
-	respBody, err := io.ReadAll(response.Body)
-	if err != nil {
-		return err
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		statusErr := &StatusError{StatusCode: response.StatusCode, Body: string(resp.Body)}
+		if len(statusErr.Body) > maxErrorBodySize {
+			statusErr.Body = statusErr.Body[:maxErrorBodySize]
+		}
+		if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(response.Header.Get("Retry-After"), time.Now())
+		}
+		return resp, statusErr
 	}
-
-	return json.Unmarshal(respBody, resp)
+	return resp, err
 }
 
 func getCompleteURL(origin string, params map[string]string) string {
@@ -0,0 +1,282 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_requestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// 单次请求的超时时间早于 ctx 的截止时间时，先于 ctx 触发
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := NewClient(WithRequestTimeout(100*time.Millisecond)).JSONPost(ctx, server.URL, nil, map[string]string{"k": "v"}, nil)
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("got err: %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || ctx.Err() != nil {
+		t.Errorf("request timeout not applied, elapsed: %v", elapsed)
+	}
+
+	// 未设置单次请求的超时时间时，以 ctx 为准
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := NewClient().JSONPost(ctx, server.URL, nil, nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err: %v, want deadline exceeded", err)
+	}
+}
+
+func Test_clientOptions(t *testing.T) {
+	transport := NewClient(WithMaxIdleConnsPerHost(8)).core.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 8 {
+		t.Errorf("got max idle conns per host: %d, want: 8", transport.MaxIdleConnsPerHost)
+	}
+	if NewClient().core.Transport.(*http.Transport).MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost {
+		t.Error("default max idle conns per host not applied")
+	}
+
+	// 通过代理转发的请求以完整的 URL 作为请求目标
+	var target string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target = r.URL.String()
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	if _, err := NewClient(WithProxy(proxyURL)).Do(context.Background(), Request{Method: http.MethodGet, URL: "http://callback.example.com/hook"}); err != nil {
+		t.Fatal(err)
+	}
+	if target != "http://callback.example.com/hook" {
+		t.Errorf("got proxied target: %s", target)
+	}
+
+	// 自定义的 RoundTripper 原样使用
+	var called bool
+	rt := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		called = true
+		return nil, errors.New("blocked")
+	})
+	if _, err := NewClient(WithTransport(rt), WithProxy(proxyURL)).Do(context.Background(), Request{Method: http.MethodGet, URL: "http://callback.example.com/hook"}); err == nil || !called {
+		t.Errorf("custom transport not used, err: %v", err)
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func Test_response(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Id", "abc")
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewClient(WithMaxResponseBodySize(10))
+	resp, err := c.Do(ctx, Request{Method: http.MethodGet, URL: server.URL})
+	if err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("X-Request-Id") != "abc" {
+		t.Errorf("got resp: %+v, err: %v", resp, err)
+	}
+	if len(resp.Body) != 10 || !resp.Truncated {
+		t.Errorf("got body: %q, truncated: %v", resp.Body, resp.Truncated)
+	}
+
+	// 非 2xx 的响应同时返回 Response 以及 StatusError
+	resp, err = c.Do(ctx, Request{Method: http.MethodPost, URL: server.URL + "?fail=1", Body: []byte("{}")})
+	if StatusCode(err) != http.StatusInternalServerError || resp.StatusCode != http.StatusInternalServerError || len(resp.Body) != 10 {
+		t.Errorf("got resp: %+v, err: %v", resp, err)
+	}
+
+	// 被截断的响应无法解码
+	if err := c.JSONGet(ctx, server.URL, nil, nil, &struct{}{}); !errors.Is(err, ErrResponseTooLarge) {
+		t.Errorf("got err: %v, want ErrResponseTooLarge", err)
+	}
+
+	// 响应体被排空，连接得到复用
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("got %d connections, want 1", n)
+	}
+}
+
+type recordLogger struct {
+	msgs []string
+}
+
+func (l *recordLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.msgs = append(l.msgs, "DEBUG "+msg)
+}
+func (l *recordLogger) Info(ctx context.Context, msg string, kv ...interface{}) {}
+func (l *recordLogger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.msgs = append(l.msgs, "WARN "+msg)
+}
+func (l *recordLogger) Error(ctx context.Context, msg string, kv ...interface{}) {}
+
+func Test_middleware(t *testing.T) {
+	var gotID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotID = r.Header.Get(DefaultRequestIDHeader)
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	// 中间件按照注册的顺序执行
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Doer) Doer {
+			return func(ctx context.Context, req *Request) (Response, error) {
+				order = append(order, name)
+				resp, err := next(ctx, req)
+				order = append(order, name+" "+strconv.Itoa(resp.StatusCode))
+				return resp, err
+			}
+		}
+	}
+	logger := &recordLogger{}
+	client := NewClient(
+		WithMiddleware(trace("a")),
+		WithMiddleware(trace("b")),
+		WithMiddleware(RequestIDMiddleware("", func() string { return "id" })),
+		WithMiddleware(LoggingMiddleware(logger)),
+	)
+	header := map[string]string{}
+	if _, err := client.Do(context.Background(), Request{Method: http.MethodGet, URL: server.URL, Header: header}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a,b,b 200,a 200"; strings.Join(order, ",") != want {
+		t.Errorf("got order: %v, want: %s", order, want)
+	}
+	// 中间件修改的是请求的副本
+	if gotID != "id" || len(header) != 0 {
+		t.Errorf("got request id: %q, header: %v", gotID, header)
+	}
+
+	// 调用方设置的请求 ID 保持不变
+	if _, err := client.Do(context.Background(), Request{Method: http.MethodGet, URL: server.URL + "/fail", Header: map[string]string{DefaultRequestIDHeader: "custom"}}); StatusCode(err) != http.StatusInternalServerError {
+		t.Errorf("got err: %v", err)
+	}
+	if gotID != "custom" {
+		t.Errorf("got request id: %q, want: custom", gotID)
+	}
+	if want := "DEBUG http request,WARN http request failed"; strings.Join(logger.msgs, ",") != want {
+		t.Errorf("got logs: %v", logger.msgs)
+	}
+
+	// 中间件直接返回错误时不发送请求
+	errReject := errors.New("reject")
+	gotID = ""
+	reject := NewClient(WithMiddleware(func(next Doer) Doer {
+		return func(ctx context.Context, req *Request) (Response, error) {
+			return Response{}, errReject
+		}
+	}))
+	if _, err := reject.Do(context.Background(), Request{Method: http.MethodGet, URL: server.URL}); !errors.Is(err, errReject) || gotID != "" {
+		t.Errorf("got err: %v, request id: %q", err, gotID)
+	}
+}
+
+func Test_retry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 前两次请求直接关闭连接，模拟连接被重置
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	var retries []int
+	c := NewClient(WithHTTPRetry(3, ExponentialBackoff(time.Millisecond, 10*time.Millisecond), nil))
+	c.OnRetry(func(req *Request, attempt int, err error) {
+		retries = append(retries, attempt)
+	})
+	if _, err := c.Do(ctx, Request{Method: http.MethodGet, URL: server.URL}); err != nil || len(retries) != 2 {
+		t.Errorf("got err: %v, retries: %v", err, retries)
+	}
+
+	// 次数耗尽之后返回最后一次的错误以及请求的次数
+	atomic.StoreInt32(&calls, 0)
+	var retryErr *RetryError
+	if _, err := NewClient(WithHTTPRetry(2, nil, nil)).Do(ctx, Request{Method: http.MethodGet, URL: server.URL}); !errors.As(err, &retryErr) || retryErr.Attempts != 2 {
+		t.Errorf("got err: %v, want 2 attempts", err)
+	}
+
+	// 非幂等的请求已经写出之后不重试
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.Do(ctx, Request{Method: http.MethodPost, URL: server.URL, Body: []byte("{}")}); err == nil || errors.As(err, &retryErr) || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("got err: %v, calls: %d", err, calls)
+	}
+
+	// 写出之前失败的非幂等请求可以重试
+	var dials int32
+	refused := NewClient(WithHTTPRetry(3, ExponentialBackoff(time.Millisecond, time.Millisecond), nil), WithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, syscall.ECONNREFUSED
+	})))
+	if _, err := refused.Do(ctx, Request{Method: http.MethodPost, URL: server.URL}); !errors.Is(err, syscall.ECONNREFUSED) || atomic.LoadInt32(&dials) != 3 {
+		t.Errorf("got err: %v, dials: %d", err, dials)
+	}
+
+	// 非 2xx 响应默认不重试，retryIf 可以放开
+	fail := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer fail.Close()
+	atomic.StoreInt32(&calls, 0)
+	if _, err := c.Do(ctx, Request{Method: http.MethodGet, URL: fail.URL}); StatusCode(err) != http.StatusBadGateway || atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("got err: %v, calls: %d", err, calls)
+	}
+	atomic.StoreInt32(&calls, 0)
+	retryBadGateway := NewClient(WithHTTPRetry(2, ExponentialBackoff(time.Millisecond, time.Millisecond), func(resp Response, err error) bool {
+		return resp.StatusCode == http.StatusBadGateway
+	}))
+	if _, err := retryBadGateway.Do(ctx, Request{Method: http.MethodGet, URL: fail.URL}); StatusCode(err) != http.StatusBadGateway || atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("got err: %v, calls: %d", err, calls)
+	}
+
+	// 距离截止时间不足以完成退避时不再重试
+	atomic.StoreInt32(&calls, 0)
+	tctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	slow := NewClient(WithHTTPRetry(3, ExponentialBackoff(time.Hour, time.Hour), nil))
+	if _, err := slow.Do(tctx, Request{Method: http.MethodGet, URL: server.URL}); err == nil || errors.As(err, &retryErr) || tctx.Err() != nil {
+		t.Errorf("got err: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultRequestIDHeader RequestIDMiddleware 缺省写入的请求头.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+// Doer 发送请求并读取响应，即 Client.Do 的核心逻辑. req 在每次调用 Client.Do 时复制，中间件可以直接修改.
+type Doer func(ctx context.Context, req *Request) (Response, error)
+
+// Middleware 包装 Doer 的中间件. 可以在调用 next 之前修改请求，或者不调用 next 直接返回错误以中断请求；
+// 也可以在 next 返回之后观察响应、错误以及耗时.
+type Middleware func(next Doer) Doer
+
+// 按照注册的顺序组合中间件，第一个中间件位于最外层
+func chain(do Doer, middlewares []Middleware) Doer {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		do = middlewares[i](do)
+	}
+	return do
+}
+
+// Logger 结构化日志接口，与 timewheel.Logger 的方法一致，可以直接传入时间轮使用的 Logger.
+type Logger interface {
+	Debug(ctx context.Context, msg string, kv ...interface{})
+	Info(ctx context.Context, msg string, kv ...interface{})
+	Warn(ctx context.Context, msg string, kv ...interface{})
+	Error(ctx context.Context, msg string, kv ...interface{})
+}
+
+// RequestIDMiddleware 为没有携带 header 的请求生成请求 ID，便于与服务端的日志关联. header 为空时使用 DefaultRequestIDHeader，
+// newID 为 nil 时生成 16 字节的随机十六进制串. 调用方已经设置的请求 ID 保持不变.
+func RequestIDMiddleware(header string, newID func() string) Middleware {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	if newID == nil {
+		newID = randomID
+	}
+	return func(next Doer) Doer {
+		return func(ctx context.Context, req *Request) (Response, error) {
+			if _, ok := req.Header[header]; !ok {
+				req.Header[header] = newID()
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoggingMiddleware 记录每次请求的方法、地址、状态码以及耗时. 成功的请求记录为 Debug，失败的请求记录为 Warn.
+func LoggingMiddleware(logger Logger) Middleware {
+	return func(next Doer) Doer {
+		return func(ctx context.Context, req *Request) (Response, error) {
+			start := time.Now()
+			resp, err := next(ctx, req)
+			kv := []interface{}{"method", req.Method, "url", req.URL, "status_code", resp.StatusCode, "latency", time.Since(start)}
+			if err != nil {
+				logger.Warn(ctx, "http request failed", append(kv, "err", err)...)
+				return resp, err
+			}
+			logger.Debug(ctx, "http request", kv...)
+			return resp, nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// 重复发送不会改变服务端状态的方法，参见 RFC 9110 9.2.2
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// BackoffFunc 返回第 attempt 次重试之前的等待时长，attempt 从 1 开始.
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff 第 1 次重试等待 base，之后每次翻倍，不超过 max.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		backoff := base
+		for i := 1; i < attempt && backoff < max; i++ {
+			backoff *= 2
+		}
+		if backoff > max {
+			backoff = max
+		}
+		return backoff
+	}
+}
+
+// RetryHook 请求重试时回调. attempt 为重试的次数，从 1 开始；err 为上一次请求的错误.
+type RetryHook func(req *Request, attempt int, err error)
+
+type retryHooks struct {
+	mu    sync.RWMutex
+	hooks []RetryHook
+}
+
+// OnRetry 注册重试的回调，用于统计重试次数等监控指标. 可以注册多个，按照注册的顺序依次调用.
+func (c *Client) OnRetry(hook RetryHook) {
+	c.retryHooks.mu.Lock()
+	defer c.retryHooks.mu.Unlock()
+	c.retryHooks.hooks = append(c.retryHooks.hooks, hook)
+}
+
+func (c *Client) notifyRetry(req *Request, attempt int, err error) {
+	c.retryHooks.mu.RLock()
+	defer c.retryHooks.mu.RUnlock()
+	for _, hook := range c.retryHooks.hooks {
+		hook(req, attempt, err)
+	}
+}
+
+// RetryError 经过重试之后请求仍然失败，Err 为最后一次请求的错误.
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v, attempts: %d", e.Err, e.Attempts)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// 连接被拒绝、重置或者被对端关闭，属于短暂的网络异常. 超时以及非 2xx 响应不在此列，交由时间轮按照重试策略处理
+func isTransientErr(resp Response, err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE)
+}
+
+// 按照 WithHTTPRetry 的配置发送请求. 幂等的请求失败之后总是可以重试；非幂等的请求仅在尚未写出任何请求头时重试，
+// 此时服务端不可能收到请求. 距离 ctx 的截止时间不足以完成退避时不再重试
+func (c *Client) sendWithRetry(ctx context.Context, req *Request) (Response, error) {
+	idempotent := idempotentMethods[req.Method]
+	for attempt := 1; ; attempt++ {
+		var wrote atomic.Bool
+		traceCtx := ctx
+		if !idempotent {
+			traceCtx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+				WroteHeaderField: func(key string, value []string) { wrote.Store(true) },
+			})
+		}
+		resp, err := c.send(traceCtx, req)
+		if err == nil || attempt >= c.opts.retryAttempts || !c.opts.retryIf(resp, err) || (!idempotent && wrote.Load()) {
+			return resp, retryErr(attempt, err)
+		}
+
+		backoff := c.opts.retryBackoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return resp, retryErr(attempt, err)
+		}
+		c.notifyRetry(req, attempt, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, retryErr(attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// 发生过重试时，在错误中附加请求的次数
+func retryErr(attempts int, err error) error {
+	if err == nil || attempts <= 1 {
+		return err
+	}
+	return &RetryError{Attempts: attempts, Err: err}
+}
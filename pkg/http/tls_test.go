@@ -0,0 +1,108 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// 签发证书，parent 为 nil 时生成自签名的 CA
+func issueCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = &template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func Test_clientTLS(t *testing.T) {
+	ca, caKey, _ := issueCert(t, "private ca", nil, nil)
+	_, _, clientCert := issueCert(t, "timewheel", ca, caKey)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	var subjects []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subjects = append(subjects, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	ctx := context.Background()
+	post := func(c *Client) error {
+		return c.JSONPost(ctx, server.URL, nil, map[string]string{"k": "v"}, nil)
+	}
+
+	// 服务端证书由私有 CA 签发，未设置 RootCAs 时无法校验
+	if err := post(NewClient()); !errors.Is(err, ErrTLSHandshake) {
+		t.Errorf("untrusted server, got err: %v, want ErrTLSHandshake", err)
+	}
+	// 服务端要求客户端证书
+	if err := post(NewClient(WithClientTLS(&tls.Config{RootCAs: rootCAs}))); !errors.Is(err, ErrTLSHandshake) {
+		t.Errorf("missing client cert, got err: %v, want ErrTLSHandshake", err)
+	}
+	// 通过 ServerName 指定校验的域名，httptest 的证书签发给 example.com
+	if err := post(NewClient(WithClientTLS(&tls.Config{RootCAs: rootCAs, ServerName: "example.com", Certificates: []tls.Certificate{clientCert}}))); err != nil {
+		t.Errorf("static client cert, got err: %v", err)
+	}
+
+	// 每次建立连接时重新获取客户端证书
+	_, _, rotated := issueCert(t, "timewheel-rotated", ca, caKey)
+	current := clientCert
+	c := NewClient(WithClientTLS(&tls.Config{RootCAs: rootCAs}), WithClientCertificate(func() (*tls.Certificate, error) {
+		return &current, nil
+	}))
+	if err := post(c); err != nil {
+		t.Fatal(err)
+	}
+	current = rotated
+	c.core.Transport.(*http.Transport).CloseIdleConnections()
+	if err := post(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(subjects) != 3 || subjects[1] != "timewheel" || subjects[2] != "timewheel-rotated" {
+		t.Errorf("got client cert subjects: %v", subjects)
+	}
+
+	// 获取证书失败同样视为握手失败
+	failing := NewClient(WithClientTLS(&tls.Config{RootCAs: rootCAs}), WithClientCertificate(func() (*tls.Certificate, error) {
+		return nil, errors.New("cert file not found")
+	}))
+	if err := post(failing); !errors.Is(err, ErrTLSHandshake) {
+		t.Errorf("cert getter failed, got err: %v, want ErrTLSHandshake", err)
+	}
+}
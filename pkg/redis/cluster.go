@@ -0,0 +1,413 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// 集群的 slot 数量
+	clusterSlots = 16384
+	// 单次请求最多跟随的 MOVED/ASK 重定向次数
+	clusterMaxRedirects = 3
+)
+
+// ErrCrossSlot 集群模式下同一次请求（单条指令、lua 脚本或者事务）中的 key 不属于同一个 slot.
+// 需要一起操作的 key 可以通过 {hash tag} 保证落在同一个 slot.
+var ErrCrossSlot = errors.New("keys in request don't hash to the same slot")
+
+// NewClusterClient 创建 redis 集群模式的客户端. addrs 为集群中的部分节点地址，用于通过 CLUSTER SLOTS 发现集群的拓扑.
+// 客户端为每个 master 节点维护独立的连接池，根据 key 所在的 slot 将请求路由到对应的节点，
+// 收到 MOVED 重定向时更新 slot 的归属并在下一次请求前刷新拓扑，收到 ASK 重定向时只对当前请求生效.
+// 同一次请求中的全部 key 必须属于同一个 slot，否则返回 ErrCrossSlot；流水线中的指令按照节点分组执行，不受此限制.
+func NewClusterClient(addrs []string, password string, opts ...ClientOption) *Client {
+	return newClient(&ClientOptions{
+		network:      "tcp",
+		password:     password,
+		clusterAddrs: append([]string(nil), addrs...),
+	}, opts...)
+}
+
+type cluster struct {
+	opts  *ClientOptions
+	seeds []string
+
+	mu    sync.RWMutex
+	slots []string // slot 所在的 master 节点地址
+	stale bool     // 收到 MOVED 重定向之后标记，下一次请求前刷新拓扑
+	nodes map[string]*Client
+}
+
+func newCluster(opts *ClientOptions) *cluster {
+	return &cluster{
+		opts:  opts,
+		seeds: opts.clusterAddrs,
+		nodes: make(map[string]*Client),
+	}
+}
+
+// 返回只连接 addr 节点的客户端
+func (cl *cluster) node(addr string) *Client {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if node, ok := cl.nodes[addr]; ok {
+		return node
+	}
+	opts := *cl.opts
+	opts.address, opts.clusterAddrs = addr, nil
+	node := &Client{opts: &opts}
+	node.pool = node.getRedisPool()
+	cl.nodes[addr] = node
+	return node
+}
+
+// 关闭全部节点的连接池，返回第一个失败的原因
+func (cl *cluster) close() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	var firstErr error
+	for _, node := range cl.nodes {
+		if err := node.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// 依次通过已知节点以及初始节点执行 CLUSTER SLOTS，使用第一个成功的结果更新拓扑
+func (cl *cluster) refresh(ctx context.Context) error {
+	cl.mu.RLock()
+	addrs := make([]string, 0, len(cl.nodes)+len(cl.seeds))
+	for addr := range cl.nodes {
+		addrs = append(addrs, addr)
+	}
+	cl.mu.RUnlock()
+	sort.Strings(addrs)
+	addrs = append(addrs, cl.seeds...)
+
+	lastErr := errors.New("no cluster address")
+	for _, addr := range addrs {
+		reply, err := cl.node(addr).run(ctx, nil, func(conn redis.Conn) (interface{}, error) {
+			return do(ctx, conn, cl.opts.readTimeout, "CLUSTER", "SLOTS")
+		})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		slots, err := parseClusterSlots(reply, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cl.mu.Lock()
+		cl.slots, cl.stale = slots, false
+		cl.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("refresh cluster slots failed, err: %w", lastErr)
+}
+
+// 解析 CLUSTER SLOTS 的回复：[[start, end, [host, port, id], replicas...], ...]
+func parseClusterSlots(reply interface{}, from string) ([]string, error) {
+	ranges, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("invalid cluster slots reply %v", r)
+		}
+		start, err1 := redis.Int(fields[0], nil)
+		end, err2 := redis.Int(fields[1], nil)
+		master, err3 := redis.Values(fields[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 || start < 0 || end >= clusterSlots || start > end {
+			return nil, fmt.Errorf("invalid cluster slots reply %v", r)
+		}
+		host, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		// host 为空表示与响应请求的节点相同
+		if host == "" {
+			host, _, _ = net.SplitHostPort(from)
+		}
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+	return slots, nil
+}
+
+// 返回 slot 所在的 master 节点地址，slot 为 -1 时返回任意一个节点
+func (cl *cluster) addr(ctx context.Context, slot int) (string, error) {
+	cl.mu.RLock()
+	slots, stale := cl.slots, cl.stale
+	cl.mu.RUnlock()
+	if slots == nil || stale {
+		if err := cl.refresh(ctx); err != nil && slots == nil {
+			return "", err
+		}
+		cl.mu.RLock()
+		slots = cl.slots
+		cl.mu.RUnlock()
+	}
+
+	if slot < 0 {
+		for _, addr := range slots {
+			if addr != "" {
+				return addr, nil
+			}
+		}
+	} else if addr := slots[slot]; addr != "" {
+		return addr, nil
+	}
+	return "", fmt.Errorf("slot %d not served by any node", slot)
+}
+
+// 返回全部 master 节点的地址
+func (cl *cluster) masters(ctx context.Context) ([]string, error) {
+	if _, err := cl.addr(ctx, -1); err != nil {
+		return nil, err
+	}
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+	seen := make(map[string]bool)
+	var masters []string
+	for _, addr := range cl.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			masters = append(masters, addr)
+		}
+	}
+	sort.Strings(masters)
+	return masters, nil
+}
+
+// 收到 MOVED 重定向，更新 slot 的归属，并在下一次请求前刷新完整的拓扑
+func (cl *cluster) moved(slot int, addr string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.slots != nil {
+		slots := append([]string(nil), cl.slots...)
+		slots[slot] = addr
+		cl.slots = slots
+	}
+	cl.stale = true
+}
+
+func (cl *cluster) conn(ctx context.Context, keys []string) (redis.Conn, error) {
+	slot, err := hashSlot(keys)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := cl.addr(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+	return cl.node(addr).pool.GetContext(ctx)
+}
+
+// 在 keys 所在的节点上执行 fn，跟随 MOVED/ASK 重定向
+func (cl *cluster) run(ctx context.Context, keys []string, fn func(conn redis.Conn) (interface{}, error)) (interface{}, error) {
+	slot, err := hashSlot(keys)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := cl.addr(ctx, slot)
+	if err != nil {
+		return nil, err
+	}
+
+	var asking bool
+	for i := 0; ; i++ {
+		reply, err := cl.node(addr).run(ctx, nil, func(conn redis.Conn) (interface{}, error) {
+			if asking {
+				if _, err := do(ctx, conn, cl.opts.readTimeout, "ASKING"); err != nil {
+					return nil, err
+				}
+			}
+			return fn(conn)
+		})
+		kind, movedSlot, target, ok := parseRedirect(err)
+		if !ok || i >= clusterMaxRedirects {
+			return reply, err
+		}
+		if asking = kind == "ASK"; !asking {
+			cl.moved(movedSlot, target)
+		}
+		addr = target
+	}
+}
+
+// 流水线中的指令按照所在的节点分组，每个节点执行一次流水线，结果按照指令的顺序返回. 被重定向的指令单独重试
+func (cl *cluster) pipeline(ctx context.Context, cmds []command) ([]interface{}, error) {
+	var (
+		addrs  []string
+		groups = make(map[string][]int)
+	)
+	for i, cmd := range cmds {
+		slot, err := hashSlot(keysOf(cmd.name, cmd.args))
+		if err != nil {
+			return nil, fmt.Errorf("command %s, err: %w", cmd.name, err)
+		}
+		addr, err := cl.addr(ctx, slot)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := groups[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		groups[addr] = append(groups[addr], i)
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for _, addr := range addrs {
+		group := make([]command, 0, len(groups[addr]))
+		for _, i := range groups[addr] {
+			group = append(group, cmds[i])
+		}
+		reply, err := cl.node(addr).run(ctx, nil, func(conn redis.Conn) (interface{}, error) {
+			return pipeline(ctx, conn, cl.opts.readTimeout, group)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for j, reply := range reply.([]interface{}) {
+			replies[groups[addr][j]] = reply
+		}
+	}
+
+	for i, reply := range replies {
+		if _, _, _, ok := parseRedirect(asError(reply)); !ok {
+			continue
+		}
+		cmd := cmds[i]
+		reply, err := cl.run(ctx, keysOf(cmd.name, cmd.args), func(conn redis.Conn) (interface{}, error) {
+			return do(ctx, conn, cl.opts.readTimeout, cmd.name, cmd.args...)
+		})
+		if _, ok := err.(redis.Error); err != nil && !ok {
+			return nil, err
+		}
+		if err != nil {
+			reply = err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func asError(reply interface{}) error {
+	err, _ := reply.(error)
+	return err
+}
+
+// 解析 MOVED/ASK 重定向，如 "MOVED 3999 127.0.0.1:6381"
+func parseRedirect(err error) (kind string, slot int, addr string, ok bool) {
+	replyErr, isReply := err.(redis.Error)
+	if !isReply {
+		return "", 0, "", false
+	}
+	fields := strings.Fields(string(replyErr))
+	if len(fields) != 3 || fields[0] != "MOVED" && fields[0] != "ASK" {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
+
+// 计算 keys 所在的 slot，keys 为空时返回 -1，keys 不属于同一个 slot 时返回 ErrCrossSlot
+func hashSlot(keys []string) (int, error) {
+	slot := -1
+	for _, key := range keys {
+		s := keySlot(key)
+		if slot >= 0 && s != slot {
+			return 0, fmt.Errorf("%w: %v", ErrCrossSlot, keys)
+		}
+		slot = s
+	}
+	return slot, nil
+}
+
+// key 中包含非空的 {hash tag} 时只对 hash tag 计算 slot
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// CRC16-CCITT（XMODEM），与 redis 集群计算 slot 的算法一致
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// 返回指令中的 key，用于集群模式下的路由
+func keysOf(commandName string, args []interface{}) []string {
+	switch strings.ToUpper(commandName) {
+	case "EVAL", "EVALSHA":
+		if len(args) < 2 {
+			return nil
+		}
+		n, err := strconv.Atoi(fmt.Sprint(args[1]))
+		if err != nil {
+			return nil
+		}
+		return evalKeys(n, args[2:])
+	case "DEL", "EXISTS", "UNLINK", "TOUCH", "MGET", "WATCH":
+		return argStrings(args)
+	case "PING", "INFO", "SCAN", "ROLE", "CLUSTER", "SCRIPT", "TIME", "DBSIZE", "MULTI", "EXEC", "DISCARD", "UNWATCH", "ASKING", "PUBLISH":
+		return nil
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return argStrings(args[:1])
+}
+
+// 返回 lua 脚本的 key
+func evalKeys(keyCount int, keysAndArgs []interface{}) []string {
+	if keyCount < 0 || keyCount > len(keysAndArgs) {
+		return nil
+	}
+	return argStrings(keysAndArgs[:keyCount])
+}
+
+func argStrings(args []interface{}) []string {
+	strs := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg := arg.(type) {
+		case string:
+			strs = append(strs, arg)
+		case []byte:
+			strs = append(strs, string(arg))
+		default:
+			strs = append(strs, fmt.Sprint(arg))
+		}
+	}
+	return strs
+}
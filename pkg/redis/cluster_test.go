@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 模拟 redis 集群，每个节点的数据保存在独立的 memDB 中
+type fakeCluster struct {
+	mu        sync.Mutex
+	owner     []string       // slot 所在的节点
+	migrating map[int]string // 正在迁移的 slot 以及目标节点，源节点对其中的 key 回复 ASK
+	dbs       map[string]*memDB
+}
+
+func newFakeCluster(addrs ...string) *fakeCluster {
+	fc := fakeCluster{owner: make([]string, clusterSlots), migrating: make(map[int]string), dbs: make(map[string]*memDB)}
+	for i, addr := range addrs {
+		fc.dbs[addr] = newMemDB()
+		for slot := i * clusterSlots / len(addrs); slot < (i+1)*clusterSlots/len(addrs); slot++ {
+			fc.owner[slot] = addr
+		}
+	}
+	return &fc
+}
+
+func (fc *fakeCluster) dial(ctx context.Context, network, address string, options ...redis.DialOption) (redis.Conn, error) {
+	db, ok := fc.dbs[address]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	var asking bool
+	return &memConn{db: db, intercept: func(name string, args []string) interface{} {
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		switch name {
+		case "CLUSTER":
+			return fc.slotsReply()
+		case "ASKING":
+			asking = true
+			return "OK"
+		}
+		wasAsking := asking
+		asking = false
+		keys := make([]interface{}, 0, len(args))
+		for _, arg := range args {
+			keys = append(keys, arg)
+		}
+		routed := keysOf(name, keys)
+		if len(routed) == 0 {
+			return nil
+		}
+		slot := keySlot(routed[0])
+		switch {
+		case fc.owner[slot] == address && fc.migrating[slot] != "":
+			return redis.Error(fmt.Sprintf("ASK %d %s", slot, fc.migrating[slot]))
+		case fc.owner[slot] == address, fc.migrating[slot] == address && wasAsking:
+			return nil
+		}
+		return redis.Error(fmt.Sprintf("MOVED %d %s", slot, fc.owner[slot]))
+	}}, nil
+}
+
+func (fc *fakeCluster) slotsReply() []interface{} {
+	var reply []interface{}
+	for start := 0; start < clusterSlots; {
+		end := start
+		for end+1 < clusterSlots && fc.owner[end+1] == fc.owner[start] {
+			end++
+		}
+		host, port, _ := net.SplitHostPort(fc.owner[start])
+		p, _ := strconv.Atoi(port)
+		reply = append(reply, []interface{}{int64(start), int64(end), []interface{}{[]byte(host), int64(p), []byte("id")}})
+		start = end + 1
+	}
+	return reply
+}
+
+func Test_keySlot(t *testing.T) {
+	tests := map[string]int{
+		"123456789":             12739,
+		"foo":                   12182,
+		"bar":                   5061,
+		"{user1000}.following":  keySlot("user1000"),
+		"timewheel_task_{2023}": keySlot("2023"),
+	}
+	for key, want := range tests {
+		if got := keySlot(key); got != want {
+			t.Errorf("key: %s, got slot: %d, want: %d", key, got, want)
+		}
+	}
+	if keySlot("foo{}{bar}") == keySlot("bar") {
+		t.Error("empty hash tag should not be used")
+	}
+	if _, err := hashSlot([]string{"{a}1", "{a}2"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := hashSlot([]string{"foo", "bar"}); !errors.Is(err, ErrCrossSlot) {
+		t.Errorf("got err: %v, want ErrCrossSlot", err)
+	}
+}
+
+func Test_clusterClient(t *testing.T) {
+	const nodeA, nodeB = "10.0.0.1:7000", "10.0.0.2:7000"
+	fc := newFakeCluster(nodeA, nodeB)
+	c := NewClusterClient([]string{"10.0.0.9:7000", nodeA}, "", func(o *ClientOptions) { o.dial = fc.dial })
+	ctx := context.Background()
+
+	// bar 位于 slot 5061（nodeA），foo 位于 slot 12182（nodeB）
+	for _, key := range []string{"foo", "bar"} {
+		if _, err := c.SAdd(ctx, key, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := fc.dbs[nodeA].data["bar"]; !ok {
+		t.Error("bar not routed to nodeA")
+	}
+	if _, ok := fc.dbs[nodeB].data["foo"]; !ok {
+		t.Error("foo not routed to nodeB")
+	}
+
+	// 流水线按照节点分组执行，结果保持指令的顺序
+	replies, err := c.Pipeline(ctx, func(p Pipeliner) error {
+		p.Send("SCARD", "foo")
+		p.Send("SADD", "bar", "y")
+		p.Send("SCARD", "bar")
+		return nil
+	})
+	if err != nil || len(replies) != 3 || replies[0] != int64(1) || replies[1] != int64(1) || replies[2] != int64(2) {
+		t.Errorf("got replies: %v, err: %v", replies, err)
+	}
+
+	// 同一次请求中的 key 必须属于同一个 slot
+	if _, err := c.Eval(ctx, "return 1", 2, []interface{}{"foo", "bar"}); !errors.Is(err, ErrCrossSlot) {
+		t.Errorf("eval, got err: %v, want ErrCrossSlot", err)
+	}
+	if _, err := c.Tx(ctx, func(tx Txer) error {
+		tx.Send("SADD", "foo", "z")
+		tx.Send("SADD", "bar", "z")
+		return nil
+	}); !errors.Is(err, ErrCrossSlot) {
+		t.Errorf("tx, got err: %v, want ErrCrossSlot", err)
+	}
+	if replies, err := c.Tx(ctx, func(tx Txer) error {
+		tx.Send("SADD", "{foo}1", "z")
+		tx.Send("SADD", "{foo}2", "z")
+		return nil
+	}); err != nil || len(replies) != 2 {
+		t.Errorf("tx, got replies: %v, err: %v", replies, err)
+	}
+
+	// slot 迁移完成之后，旧节点回复 MOVED，客户端跟随重定向并刷新拓扑
+	fc.mu.Lock()
+	fc.owner[keySlot("foo")] = nodeA
+	fc.mu.Unlock()
+	if n, err := c.SAdd(ctx, "foo", "moved"); err != nil || n != 1 {
+		t.Errorf("moved, got: %d, err: %v", n, err)
+	}
+	if _, ok := fc.dbs[nodeA].data["foo"]; !ok {
+		t.Error("foo not redirected to nodeA")
+	}
+	if addr, _ := c.cluster.addr(ctx, keySlot("foo")); addr != nodeA {
+		t.Errorf("got slot owner: %s, want: %s", addr, nodeA)
+	}
+
+	// slot 迁移过程中，源节点回复 ASK，只有当前请求重定向到目标节点
+	fc.mu.Lock()
+	fc.migrating[keySlot("bar")] = nodeB
+	fc.mu.Unlock()
+	if _, err := c.SAdd(ctx, "bar", "asked"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fc.dbs[nodeB].data["bar"]; !ok {
+		t.Error("bar not redirected to nodeB")
+	}
+	if addr, _ := c.cluster.addr(ctx, keySlot("bar")); addr != nodeA {
+		t.Errorf("got slot owner: %s, want: %s", addr, nodeA)
+	}
+
+	// ForEachMaster 遍历全部 master 节点
+	var nodes []string
+	if err := c.ForEachMaster(ctx, func(node *Client) error {
+		nodes = append(nodes, node.opts.address)
+		return nil
+	}); err != nil || len(nodes) != 2 {
+		t.Errorf("got nodes: %v, err: %v", nodes, err)
+	}
+}
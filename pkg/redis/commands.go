@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNil 指令的回复为 nil，如 HGet 的 field 不存在. 集合类的查询在 key 不存在时返回空结果，而不是 ErrNil.
+var ErrNil = redis.ErrNil
+
+// 从 key 所在节点的连接池获取连接执行单条指令，只读指令在连接异常时重试
+func (c *Client) do(ctx context.Context, commandName string, args ...interface{}) (interface{}, error) {
+	fn := func(conn redis.Conn) (interface{}, error) {
+		return do(ctx, conn, c.opts.readTimeout, commandName, args...)
+	}
+	if idempotentCommands[commandName] {
+		return c.retry(ctx, commandName, keysOf(commandName, args), fn)
+	}
+	return c.run(ctx, keysOf(commandName, args), fn)
+}
+
+// 在连接上执行单条指令. ctx 的截止时间早于 readTimeout 时，等待回复的时间不会超过截止时间，否则采用连接的读超时
+func do(ctx context.Context, conn redis.Conn, readTimeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if timeout, ok := deadlineTimeout(ctx, readTimeout); ok {
+		return redis.DoWithTimeout(conn, timeout, commandName, args...)
+	}
+	return conn.Do(commandName, args...)
+}
+
+// 返回距离 ctx 截止时间的时长，ctx 没有截止时间或者截止时间晚于 readTimeout 时返回 false
+func deadlineTimeout(ctx context.Context, readTimeout time.Duration) (time.Duration, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	if timeout := time.Until(deadline); timeout < readTimeout {
+		return timeout, true
+	}
+	return 0, false
+}
+
+// 将 key 与可变参数拼接为指令参数
+func keyArgs(key string, args []string) []interface{} {
+	keysAndArgs := make([]interface{}, 0, 1+len(args))
+	keysAndArgs = append(keysAndArgs, key)
+	for _, arg := range args {
+		keysAndArgs = append(keysAndArgs, arg)
+	}
+	return keysAndArgs
+}
+
+// ZAdd 向 zset 中添加成员，返回新增的成员数量.
+func (c *Client) ZAdd(ctx context.Context, key string, score float64, member string) (int, error) {
+	return redis.Int(c.do(ctx, "ZADD", key, score, member))
+}
+
+// ZRangeByScore 按照 score 升序返回 [min, max] 范围内的成员. min、max 支持 "-inf"、"+inf" 以及 "(" 开头的开区间写法.
+func (c *Client) ZRangeByScore(ctx context.Context, key, min, max string) ([]string, error) {
+	return redis.Strings(c.do(ctx, "ZRANGEBYSCORE", key, min, max))
+}
+
+// ZRem 从 zset 中移除成员，返回移除的成员数量.
+func (c *Client) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	return redis.Int(c.do(ctx, "ZREM", keyArgs(key, members)...))
+}
+
+// ZCard 返回 zset 的成员数量.
+func (c *Client) ZCard(ctx context.Context, key string) (int, error) {
+	return redis.Int(c.do(ctx, "ZCARD", key))
+}
+
+// ZCount 返回 score 位于 [min, max] 范围内的成员数量. min、max 的写法与 ZRangeByScore 相同.
+func (c *Client) ZCount(ctx context.Context, key, min, max string) (int, error) {
+	return redis.Int(c.do(ctx, "ZCOUNT", key, min, max))
+}
+
+// SRem 从集合中移除成员，返回移除的成员数量.
+func (c *Client) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	return redis.Int(c.do(ctx, "SREM", keyArgs(key, members)...))
+}
+
+// SMembers 返回集合的全部成员.
+func (c *Client) SMembers(ctx context.Context, key string) ([]string, error) {
+	return redis.Strings(c.do(ctx, "SMEMBERS", key))
+}
+
+// SCard 返回集合的成员数量.
+func (c *Client) SCard(ctx context.Context, key string) (int, error) {
+	return redis.Int(c.do(ctx, "SCARD", key))
+}
+
+// HSet 设置 hash 的 field，新增 field 时返回 1，覆盖已有 field 时返回 0.
+func (c *Client) HSet(ctx context.Context, key, field, value string) (int, error) {
+	return redis.Int(c.do(ctx, "HSET", key, field, value))
+}
+
+// HGet 读取 hash 的 field，field 不存在时返回 ErrNil.
+func (c *Client) HGet(ctx context.Context, key, field string) (string, error) {
+	return redis.String(c.do(ctx, "HGET", key, field))
+}
+
+// HDel 删除 hash 的 field，返回删除的 field 数量.
+func (c *Client) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	return redis.Int(c.do(ctx, "HDEL", keyArgs(key, fields)...))
+}
+
+// Expire 设置 key 的过期时间，不足 1 s 的部分向上取整. key 不存在时返回 false.
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	return redis.Bool(c.do(ctx, "EXPIRE", key, seconds))
+}
+
+// SetNX 仅在 key 不存在时写入 value，并设置毫秒级的过期时间. 返回是否写入成功，key 已经存在时返回 false.
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	_, err := redis.String(c.do(ctx, "SET", key, value, "NX", "PX", ttl.Milliseconds()))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Del 删除 key，返回删除的 key 数量.
+func (c *Client) Del(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.do(ctx, "DEL", keyArgs(keys[0], keys[1:])...))
+}
+
+// Exists 返回 keys 中存在的 key 的数量，重复的 key 重复计数.
+func (c *Client) Exists(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	return redis.Int(c.do(ctx, "EXISTS", keyArgs(keys[0], keys[1:])...))
+}
+
+// IncrBy 将 key 的整数值增加 delta，返回增加之后的值. key 不存在时视为 0.
+func (c *Client) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return redis.Int64(c.do(ctx, "INCRBY", key, delta))
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrTLSHandshake 建立连接时 TLS 握手失败，如证书不受信任、域名不匹配或者服务端未开启 TLS
+	ErrTLSHandshake = errors.New("redis tls handshake failed")
+	// ErrAuth 建立连接时认证失败，如密码错误、用户不存在或者服务端未设置密码
+	ErrAuth = errors.New("redis auth failed")
+)
+
+// DialError 建立连接失败时返回的错误. 通过 errors.Is 与 ErrTLSHandshake、ErrAuth 比较，以区分 TLS 握手失败和认证失败；
+// 其余原因（如网络不可达）导致的失败 Kind 为 nil.
+type DialError struct {
+	Kind error
+	Err  error
+}
+
+func (e *DialError) Error() string {
+	if e.Kind == nil {
+		return "dial redis: " + e.Err.Error()
+	}
+	return "dial redis: " + e.Kind.Error() + ", err: " + e.Err.Error()
+}
+
+func (e *DialError) Is(target error) bool {
+	return e.Kind != nil && e.Kind == target
+}
+
+func (e *DialError) Unwrap() error {
+	return e.Err
+}
+
+func classifyDialErr(err error, useTLS bool) error {
+	dialErr := DialError{Err: err}
+	switch {
+	case isAuthErr(err):
+		dialErr.Kind = ErrAuth
+	case useTLS && isTLSErr(err):
+		dialErr.Kind = ErrTLSHandshake
+	}
+	return &dialErr
+}
+
+func isAuthErr(err error) bool {
+	var replyErr redis.Error
+	if !errors.As(err, &replyErr) {
+		return false
+	}
+	msg := replyErr.Error()
+	return strings.HasPrefix(msg, "WRONGPASS") || strings.HasPrefix(msg, "NOAUTH") ||
+		strings.HasPrefix(msg, "ERR") && (strings.Contains(msg, "AUTH") || strings.Contains(msg, "password"))
+}
+
+func isTLSErr(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	// 服务端返回的 TLS alert 没有导出的错误类型
+	return strings.Contains(err.Error(), "tls: ")
+}
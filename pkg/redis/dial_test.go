@@ -0,0 +1,41 @@
+package redis
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func Test_classifyDialErr(t *testing.T) {
+	tests := []struct {
+		err    error
+		useTLS bool
+		want   error
+	}{
+		{redis.Error("WRONGPASS invalid username-password pair or user is disabled."), true, ErrAuth},
+		{redis.Error("ERR AUTH <password> called without any password configured for the default user."), false, ErrAuth},
+		{redis.Error("NOAUTH Authentication required."), false, ErrAuth},
+		{x509.UnknownAuthorityError{}, true, ErrTLSHandshake},
+		{x509.HostnameError{Host: "redis.example.com"}, true, ErrTLSHandshake},
+		{errors.New("remote error: tls: handshake failure"), true, ErrTLSHandshake},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true, nil},
+	}
+	for _, tt := range tests {
+		err := classifyDialErr(tt.err, tt.useTLS)
+		for _, kind := range []error{ErrAuth, ErrTLSHandshake} {
+			if errors.Is(err, kind) != (kind == tt.want) {
+				t.Errorf("err: %v, errors.Is(%v) = %v", tt.err, kind, !(kind == tt.want))
+			}
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("err: %v, underlying err lost", err)
+		}
+	}
+	// 认证失败不属于连接错误，网络错误仍然属于连接错误
+	if IsConnError(classifyDialErr(redis.Error("WRONGPASS"), false)) || !IsConnError(classifyDialErr(&net.OpError{Op: "dial", Err: errors.New("refused")}, false)) {
+		t.Error("IsConnError misclassified dial error")
+	}
+}
@@ -1,5 +1,15 @@
 package redis
 
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
 const (
 	// 默认连接池超过 10 s 释放连接
 	DefaultIdleTimeoutSeconds = 10
@@ -7,6 +17,16 @@ const (
 	DefaultMaxActive = 100
 	// 默认最大空闲连接数
 	DefaultMaxIdle = 20
+	// 默认建立连接的超时时间
+	DefaultDialTimeout = 2 * time.Second
+	// 默认读取回复的超时时间
+	DefaultReadTimeout = 3 * time.Second
+	// 默认发送指令的超时时间
+	DefaultWriteTimeout = 3 * time.Second
+	// 默认幂等的指令在连接异常时最多重试 2 次
+	DefaultMaxRetries = 2
+	// 默认首次重试之前的退避时长，之后每次重试翻倍
+	DefaultRetryBackoff = 50 * time.Millisecond
 )
 
 type ClientOptions struct {
@@ -14,6 +34,26 @@ type ClientOptions struct {
 	idleTimeoutSeconds int
 	maxActive          int
 	wait               bool
+	dialTimeout        time.Duration
+	readTimeout        time.Duration
+	writeTimeout       time.Duration
+	maxRetries         int
+	retryBackoff       time.Duration
+
+	useTLS        bool
+	tlsConfig     *tls.Config
+	tlsSkipVerify bool
+	username      string
+	database      int
+
+	sentinel         *sentinel
+	sentinelPassword string
+	clusterAddrs     []string
+
+	// 建立连接，默认为 redis.DialContext
+	dial func(ctx context.Context, network, address string, options ...redis.DialOption) (redis.Conn, error)
+	// repairClient 校验配置失败的原因，建立连接时直接返回
+	err error
 
 	// 必填参数
 	network  string
@@ -23,6 +63,9 @@ type ClientOptions struct {
 
 type ClientOption func(c *ClientOptions)
 
+// ErrInvalidClientOptions 客户端的配置不合法，如设置了用户名但没有设置密码.
+var ErrInvalidClientOptions = errors.New("invalid redis client options")
+
 func WithMaxIdle(maxIdle int) ClientOption {
 	return func(c *ClientOptions) {
 		c.maxIdle = maxIdle
@@ -47,6 +90,77 @@ func WithWaitMode() ClientOption {
 	}
 }
 
+// WithDialTimeout 建立连接的超时时间，缺省为 DefaultDialTimeout.
+func WithDialTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithReadTimeout 读取回复的超时时间，缺省为 DefaultReadTimeout. ctx 的截止时间更早时以 ctx 为准.
+func WithReadTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.readTimeout = timeout
+	}
+}
+
+// WithWriteTimeout 发送指令的超时时间，缺省为 DefaultWriteTimeout.
+func WithWriteTimeout(timeout time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.writeTimeout = timeout
+	}
+}
+
+// WithRetry 幂等的指令（只读指令、EvalRetryable 以及 EvalScriptRetryable）在连接被关闭、重置或者连接池耗尽时，
+// 最多重试 maxRetries 次，第 n 次重试之前退避 backoff * 2^(n-1). maxRetries 小于等于 0 时不重试；backoff 小于等于 0 时采用 DefaultRetryBackoff.
+// 缺省时最多重试 DefaultMaxRetries 次.
+func WithRetry(maxRetries int, backoff time.Duration) ClientOption {
+	return func(c *ClientOptions) {
+		c.maxRetries = maxRetries
+		if maxRetries <= 0 {
+			c.maxRetries = -1
+		}
+		c.retryBackoff = backoff
+	}
+}
+
+// WithTLS 通过 TLS 连接 redis. cfg 为 nil 时使用默认配置，未设置 ServerName 时取连接地址中的 host.
+func WithTLS(cfg *tls.Config) ClientOption {
+	return func(c *ClientOptions) {
+		c.useTLS = true
+		c.tlsConfig = cfg
+	}
+}
+
+// WithTLSSkipVerify 通过 TLS 连接 redis，并且不校验服务端证书. 仅用于测试环境或者自签名证书.
+func WithTLSSkipVerify() ClientOption {
+	return func(c *ClientOptions) {
+		c.useTLS = true
+		c.tlsSkipVerify = true
+	}
+}
+
+// WithDatabase 建立连接时通过 SELECT 选择逻辑数据库，缺省为 0. 集群模式只支持 0 号数据库.
+func WithDatabase(db int) ClientOption {
+	return func(c *ClientOptions) {
+		c.database = db
+	}
+}
+
+// WithUsername 通过 redis 6 的 ACL 用户认证，需要同时设置密码. 缺省时以 default 用户认证.
+func WithUsername(username string) ClientOption {
+	return func(c *ClientOptions) {
+		c.username = username
+	}
+}
+
+// WithSentinelPassword sentinel 节点的密码，仅在 NewSentinelClient 创建的客户端中生效. 缺省时 sentinel 节点不需要认证.
+func WithSentinelPassword(password string) ClientOption {
+	return func(c *ClientOptions) {
+		c.sentinelPassword = password
+	}
+}
+
 func repairClient(c *ClientOptions) {
 	if c.maxIdle < 0 {
 		c.maxIdle = DefaultMaxIdle
@@ -59,4 +173,54 @@ func repairClient(c *ClientOptions) {
 	if c.maxActive < 0 {
 		c.maxActive = DefaultMaxActive
 	}
+
+	if c.dialTimeout <= 0 {
+		c.dialTimeout = DefaultDialTimeout
+	}
+
+	if c.readTimeout <= 0 {
+		c.readTimeout = DefaultReadTimeout
+	}
+
+	if c.writeTimeout <= 0 {
+		c.writeTimeout = DefaultWriteTimeout
+	}
+
+	if c.maxRetries == 0 {
+		c.maxRetries = DefaultMaxRetries
+	}
+	if c.maxRetries < 0 {
+		c.maxRetries = 0
+	}
+
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = DefaultRetryBackoff
+	}
+
+	if c.dial == nil {
+		c.dial = redis.DialContext
+	}
+
+	c.err = validateClient(c)
+}
+
+func validateClient(c *ClientOptions) error {
+	switch c.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+	default:
+		return fmt.Errorf("%w: unsupported network %q", ErrInvalidClientOptions, c.network)
+	}
+	if c.address == "" && c.sentinel == nil && len(c.clusterAddrs) == 0 {
+		return fmt.Errorf("%w: address is empty", ErrInvalidClientOptions)
+	}
+	if c.username != "" && c.password == "" {
+		return fmt.Errorf("%w: username %q requires password", ErrInvalidClientOptions, c.username)
+	}
+	if c.database < 0 {
+		return fmt.Errorf("%w: database %d is negative", ErrInvalidClientOptions, c.database)
+	}
+	if c.database > 0 && len(c.clusterAddrs) > 0 {
+		return fmt.Errorf("%w: cluster mode only supports database 0, got %d", ErrInvalidClientOptions, c.database)
+	}
+	return nil
 }
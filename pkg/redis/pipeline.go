@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Pipeliner 向流水线中追加指令.
+type Pipeliner interface {
+	Send(commandName string, args ...interface{})
+}
+
+type command struct {
+	name string
+	args []interface{}
+}
+
+type pipeliner struct {
+	cmds []command
+}
+
+func (p *pipeliner) Send(commandName string, args ...interface{}) {
+	p.cmds = append(p.cmds, command{name: commandName, args: args})
+}
+
+// Pipeline 通过流水线批量执行 fn 中追加的指令，全部指令复用同一个连接，只需要一次网络往返. 返回的结果与指令一一对应.
+// 流水线不具备原子性，单条指令执行失败时，对应位置的结果为 redis.Error. fn 返回错误时不会发送任何指令.
+// ctx 设置了截止时间时，读取结果的等待时间不会超过截止时间.
+func (c *Client) Pipeline(ctx context.Context, fn func(p Pipeliner) error) ([]interface{}, error) {
+	var p pipeliner
+	if err := fn(&p); err != nil {
+		return nil, err
+	}
+	if len(p.cmds) == 0 {
+		return nil, nil
+	}
+
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	if c.cluster != nil {
+		return c.cluster.pipeline(ctx, p.cmds)
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return pipeline(ctx, conn, c.opts.readTimeout, p.cmds)
+}
+
+func pipeline(ctx context.Context, conn redis.Conn, readTimeout time.Duration, cmds []command) ([]interface{}, error) {
+	for _, cmd := range cmds {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, 0, len(cmds))
+	for range cmds {
+		reply, err := receive(ctx, conn, readTimeout)
+		if _, ok := err.(redis.Error); err != nil && !ok {
+			return nil, err
+		}
+		if err != nil {
+			reply = err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+func receive(ctx context.Context, conn redis.Conn, readTimeout time.Duration) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if timeout, ok := deadlineTimeout(ctx, readTimeout); ok {
+		return redis.ReceiveWithTimeout(conn, timeout)
+	}
+	return conn.Receive()
+}
@@ -0,0 +1,59 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func Test_pipeline(t *testing.T) {
+	db := newMemDB()
+	db.data["text"] = "a"
+	conn := &memConn{db: db}
+	var p pipeliner
+	p.Send("SET", "counter", "1")
+	p.Send("INCR", "counter")
+	p.Send("INCR", "text")
+	p.Send("GET", "counter")
+	p.Send("GET", "missing")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	replies, err := pipeline(ctx, conn, DefaultReadTimeout, p.cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 5 || replies[0] != "OK" || replies[1] != int64(2) || string(replies[3].([]byte)) != "2" || replies[4] != nil {
+		t.Errorf("got replies: %v", replies)
+	}
+	// 单条指令的错误回复只影响对应位置的结果
+	if _, ok := replies[2].(redis.Error); !ok {
+		t.Errorf("got reply: %v, want redis.Error", replies[2])
+	}
+
+	// context 已经结束时不再等待结果
+	cancel()
+	p = pipeliner{}
+	p.Send("GET", "counter")
+	if _, err := pipeline(ctx, conn, DefaultReadTimeout, p.cmds); !errors.Is(err, context.Canceled) {
+		t.Errorf("got err: %v, want context canceled", err)
+	}
+}
+
+func Test_clientPipeline(t *testing.T) {
+	// fn 返回错误或者没有追加指令时，不会从连接池获取连接
+	var c Client
+	wantErr := errors.New("build failed")
+	if _, err := c.Pipeline(context.Background(), func(p Pipeliner) error {
+		p.Send("GET", "key")
+		return wantErr
+	}); err != wantErr {
+		t.Errorf("got err: %v, want: %v", err, wantErr)
+	}
+	if replies, err := c.Pipeline(context.Background(), func(p Pipeliner) error { return nil }); err != nil || replies != nil {
+		t.Errorf("got replies: %v, err: %v", replies, err)
+	}
+}
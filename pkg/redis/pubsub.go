@@ -0,0 +1,166 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 重新订阅的退避时长上限
+const maxResubscribeBackoff = 5 * time.Second
+
+// Message 订阅收到的消息.
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Subscription 通过 Subscribe 建立的订阅.
+type Subscription struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Close 结束订阅并关闭订阅的连接，等待正在执行的 handler 返回. 重复调用是安全的.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// Publish 向 channel 发布消息，返回收到消息的订阅者数量.
+func (c *Client) Publish(ctx context.Context, channel string, payload []byte) (int, error) {
+	return redis.Int(c.do(ctx, "PUBLISH", channel, payload))
+}
+
+// Subscribe 订阅 channel，首次订阅成功之后返回. 订阅使用不属于连接池的独立连接，收到的消息在订阅协程中依次交给 handler 处理，
+// handler 阻塞时后续的消息会在连接中积压. 订阅期间每隔 readTimeout 发送一次 PING 检测连接，连接中断时退避之后重新建立连接并重新订阅，
+// 重新订阅之前发布的消息会丢失. ctx 取消或者调用 Subscription.Close 时结束订阅.
+func (c *Client) Subscribe(ctx context.Context, channel string, handler func(msg *Message)) (*Subscription, error) {
+	conn, err := c.subscribe(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sub := Subscription{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(sub.done)
+		for {
+			c.receiveMessages(ctx, conn, handler)
+			conn.Close()
+			if conn = c.resubscribe(ctx, channel); conn == nil {
+				return
+			}
+		}
+	}()
+	return &sub, nil
+}
+
+// 在独立的连接上发送 SUBSCRIBE，并等待订阅的确认
+func (c *Client) subscribe(ctx context.Context, channel string) (redis.Conn, error) {
+	conn, err := c.dedicatedConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.Send("SUBSCRIBE", channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply, err := redis.Values(redis.ReceiveWithTimeout(conn, c.opts.readTimeout))
+	if err == nil && (len(reply) < 2 || fmt.Sprintf("%s", reply[0]) != "subscribe") {
+		err = fmt.Errorf("unexpected subscribe reply %v", reply)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("subscribe %s, err: %w", channel, err)
+	}
+	return conn, nil
+}
+
+// 退避之后重新订阅，直到成功、ctx 取消或者客户端关闭，后两种情况返回 nil
+func (c *Client) resubscribe(ctx context.Context, channel string) redis.Conn {
+	backoff := c.opts.retryBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		conn, err := c.subscribe(ctx, channel)
+		if err == nil {
+			return conn
+		}
+		if errors.Is(err, ErrClosed) {
+			return nil
+		}
+		if backoff *= 2; backoff > maxResubscribeBackoff {
+			backoff = maxResubscribeBackoff
+		}
+	}
+}
+
+// 持续读取订阅的消息，直到连接中断或者 ctx 取消
+func (c *Client) receiveMessages(ctx context.Context, conn redis.Conn, handler func(msg *Message)) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(c.opts.readTimeout)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				// 关闭连接以中断阻塞中的读取
+				conn.Close()
+				return
+			case <-ticker.C:
+				if conn.Send("PING") != nil || conn.Flush() != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		// 超过 3 次 PING 的间隔没有收到任何回复，认为连接已经中断
+		reply, err := redis.Values(redis.ReceiveWithTimeout(conn, 3*c.opts.readTimeout))
+		if err != nil || len(reply) == 0 {
+			return
+		}
+		if kind := fmt.Sprintf("%s", reply[0]); kind != "message" || len(reply) != 3 {
+			continue
+		}
+		payload, _ := redis.Bytes(reply[2], nil)
+		handler(&Message{Channel: fmt.Sprintf("%s", reply[1]), Payload: payload})
+	}
+}
+
+// 建立不属于连接池的连接. 集群模式下连接任意一个 master 节点，集群中的 PUBLISH 会广播到全部节点
+func (c *Client) dedicatedConn(ctx context.Context) (redis.Conn, error) {
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	if c.cluster == nil {
+		return c.getRedisConn()
+	}
+	masters, err := c.cluster.masters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(masters) == 0 {
+		return nil, errors.New("no cluster master")
+	}
+	return c.cluster.node(masters[0]).getRedisConn()
+}
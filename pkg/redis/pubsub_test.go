@@ -0,0 +1,162 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 模拟 redis 的发布订阅，SUBSCRIBE 之后连接阻塞读取推送的消息
+type fakeBroker struct {
+	mu    sync.Mutex
+	conns map[*brokerConn]string // 订阅中的连接以及订阅的 channel
+}
+
+type brokerConn struct {
+	broker  *fakeBroker
+	replies chan []interface{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (b *fakeBroker) dial(ctx context.Context, network, address string, options ...redis.DialOption) (redis.Conn, error) {
+	return &brokerConn{broker: b, replies: make(chan []interface{}, 16), closed: make(chan struct{})}, nil
+}
+
+func (b *fakeBroker) subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.conns)
+}
+
+// 断开全部订阅中的连接
+func (b *fakeBroker) kill() {
+	b.mu.Lock()
+	conns := b.conns
+	b.conns = make(map[*brokerConn]string)
+	b.mu.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+func (c *brokerConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName != "PUBLISH" {
+		return nil, nil
+	}
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	var n int64
+	for conn, channel := range c.broker.conns {
+		if channel == args[0] {
+			conn.push([]interface{}{[]byte("message"), []byte(channel), args[1]})
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (c *brokerConn) Send(commandName string, args ...interface{}) error {
+	switch commandName {
+	case "SUBSCRIBE":
+		c.broker.mu.Lock()
+		c.broker.conns[c] = fmt.Sprint(args[0])
+		c.broker.mu.Unlock()
+		c.push([]interface{}{[]byte("subscribe"), []byte(fmt.Sprint(args[0])), int64(1)})
+	case "PING":
+		c.push([]interface{}{[]byte("pong"), []byte("")})
+	}
+	return nil
+}
+
+func (c *brokerConn) push(reply []interface{}) {
+	select {
+	case c.replies <- reply:
+	case <-c.closed:
+	}
+}
+
+func (c *brokerConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	select {
+	case reply := <-c.replies:
+		return reply, nil
+	case <-c.closed:
+		return nil, io.EOF
+	case <-time.After(timeout):
+		return nil, io.ErrUnexpectedEOF
+	}
+}
+
+func (c *brokerConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return c.Do(commandName, args...)
+}
+
+func (c *brokerConn) Receive() (interface{}, error) { return c.ReceiveWithTimeout(time.Hour) }
+func (c *brokerConn) Flush() error                  { return nil }
+func (c *brokerConn) Err() error                    { return nil }
+
+func (c *brokerConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.broker.mu.Lock()
+		delete(c.broker.conns, c)
+		c.broker.mu.Unlock()
+	})
+	return nil
+}
+
+func Test_pubsub(t *testing.T) {
+	ctx := context.Background()
+	broker := fakeBroker{conns: make(map[*brokerConn]string)}
+	c := newClient(&ClientOptions{network: "tcp", address: "127.0.0.1:6379"}, WithRetry(1, time.Millisecond),
+		func(o *ClientOptions) { o.dial = broker.dial })
+
+	messages := make(chan string, 4)
+	sub, err := c.Subscribe(ctx, "events", func(msg *Message) {
+		messages <- msg.Channel + ":" + string(msg.Payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-messages:
+			if got != want {
+				t.Errorf("got message: %s, want: %s", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("message %s not received", want)
+		}
+	}
+	if n, err := c.Publish(ctx, "events", []byte("hello")); err != nil || n != 1 {
+		t.Errorf("got receivers: %d, err: %v", n, err)
+	}
+	expect("events:hello")
+
+	// 连接中断之后自动重新订阅
+	broker.kill()
+	deadline := time.Now().Add(time.Second)
+	for broker.subscribers() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Publish(ctx, "events", []byte("again")); err != nil {
+		t.Error(err)
+	}
+	expect("events:again")
+
+	// 结束订阅之后不再有订阅者
+	if err := sub.Close(); err != nil {
+		t.Error(err)
+	}
+	if n, err := c.Publish(ctx, "events", []byte("bye")); err != nil || n != 0 {
+		t.Errorf("after close, got receivers: %d, err: %v", n, err)
+	}
+}
@@ -2,24 +2,63 @@ package redis
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
 
+// ErrClosed 客户端已经关闭.
+var ErrClosed = errors.New("redis client closed")
+
 // Client Redis 客户端.
 type Client struct {
-	opts *ClientOptions
-	pool *redis.Pool
+	opts    *ClientOptions
+	pool    *redis.Pool
+	cluster *cluster // 集群模式下按照 slot 路由到各个节点，不使用 pool
+
+	retryHooks retryHooks
+	closed     atomic.Bool
 }
 
-func NewClient(network, address, password string, opts ...ClientOption) *Client {
+// NewClient 创建 redis 客户端. 创建时校验配置并通过 PING 建立一次连接，配置不合法或者无法连通 redis 时返回错误，
+// 以便在启动阶段发现问题，而不是在第一次读写时才暴露.
+func NewClient(network, address, password string, opts ...ClientOption) (*Client, error) {
+	c := newClient(&ClientOptions{
+		network:  network,
+		address:  address,
+		password: password,
+	}, opts...)
+	if err := c.check(); err != nil {
+		// PING 失败之前可能已经建立了节点或者 sentinel 的连接池
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// MustNewClient 创建 redis 客户端，配置不合法时 panic. 不会在创建时建立连接，无法连通 redis 的错误在第一次读写时返回.
+//
+// Deprecated: 使用 NewClient，在启动阶段处理配置错误以及连通性问题.
+func MustNewClient(network, address, password string, opts ...ClientOption) *Client {
+	c := newClient(&ClientOptions{
+		network:  network,
+		address:  address,
+		password: password,
+	}, opts...)
+	if c.opts.err != nil {
+		panic(c.opts.err)
+	}
+	return c
+}
+
+func newClient(options *ClientOptions, opts ...ClientOption) *Client {
 	c := Client{
-		opts: &ClientOptions{
-			network:  network,
-			address:  address,
-			password: password,
-		},
+		opts: options,
 	}
 
 	for _, opt := range opts {
@@ -28,10 +67,12 @@ func NewClient(network, address, password string, opts ...ClientOption) *Client
 
 	repairClient(c.opts)
 
-	pool := c.getRedisPool()
-	return &Client{
-		pool: pool,
+	if len(c.opts.clusterAddrs) > 0 {
+		c.cluster = newCluster(c.opts)
+		return &c
 	}
+	c.pool = c.getRedisPool()
+	return &c
 }
 
 func (c *Client) getRedisPool() *redis.Pool {
@@ -47,41 +88,176 @@ func (c *Client) getRedisPool() *redis.Pool {
 		},
 		MaxActive: c.opts.maxActive,
 		Wait:      c.opts.wait,
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			_, err := c.Do("PING")
+		TestOnBorrow: func(conn redis.Conn, t time.Time) error {
+			// sentinel 模式下通过 ROLE 代替 PING，故障转移之后丢弃连接到旧 master 的空闲连接
+			if c.opts.sentinel != nil {
+				return checkMaster(conn)
+			}
+			_, err := conn.Do("PING")
 			return err
 		},
 	}
 }
 
+// 校验配置并通过 PING 确认 redis 可以连通
+func (c *Client) check() error {
+	if c.opts.err != nil {
+		return c.opts.err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.dialTimeout+c.opts.readTimeout)
+	defer cancel()
+	if err := c.Ping(ctx); err != nil {
+		return fmt.Errorf("ping redis %s, err: %w", c.opts.address, err)
+	}
+	return nil
+}
+
+// GetConn 从连接池获取连接，使用完毕之后需要 Close 归还. 集群模式下返回任意一个 master 节点的连接.
 func (c *Client) GetConn(ctx context.Context) (redis.Conn, error) {
+	return c.getConn(ctx, nil)
+}
+
+// Close 关闭连接池，释放全部空闲连接，正在使用的连接在归还时关闭. 集群模式下关闭每个节点的连接池.
+// Close 之后的请求返回 ErrClosed，重复调用 Close 是安全的.
+func (c *Client) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+	if c.cluster != nil {
+		return c.cluster.close()
+	}
+	return c.pool.Close()
+}
+
+// 获取 keys 所在节点的连接
+func (c *Client) getConn(ctx context.Context, keys []string) (redis.Conn, error) {
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	if c.cluster != nil {
+		return c.cluster.conn(ctx, keys)
+	}
 	return c.pool.GetContext(ctx)
 }
 
+// 在 keys 所在的节点上执行 fn. 集群模式下 keys 必须属于同一个 slot，并且会跟随 MOVED/ASK 重定向
+func (c *Client) run(ctx context.Context, keys []string, fn func(conn redis.Conn) (interface{}, error)) (interface{}, error) {
+	if c.closed.Load() {
+		return nil, ErrClosed
+	}
+	if c.cluster != nil {
+		return c.cluster.run(ctx, keys, fn)
+	}
+	conn, err := c.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return fn(conn)
+}
+
+// IsCluster 返回客户端是否运行在集群模式下. 集群模式下同一个 lua 脚本涉及的 key 必须位于同一个 slot
+func (c *Client) IsCluster() bool {
+	return c.cluster != nil
+}
+
+// ForEachMaster 依次在每个 master 节点上执行 fn，用于 SCAN 等只作用于单个节点的指令.
+// 单节点模式下 node 即为 c；集群模式下 node 为只连接对应节点的客户端.
+func (c *Client) ForEachMaster(ctx context.Context, fn func(node *Client) error) error {
+	if c.cluster == nil {
+		return fn(c)
+	}
+	masters, err := c.cluster.masters(ctx)
+	if err != nil {
+		return err
+	}
+	for _, addr := range masters {
+		if err := fn(c.cluster.node(addr)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *Client) getRedisConn() (redis.Conn, error) {
-	if c.opts.address == "" {
-		panic("Cannot get redis address from config")
+	if c.opts.err != nil {
+		return nil, c.opts.err
+	}
+	if c.opts.sentinel != nil {
+		return c.getMasterConn()
 	}
+	return c.dial(c.opts.address, c.opts.username, c.opts.password, c.opts.database)
+}
 
-	var dialOpts []redis.DialOption
-	if len(c.opts.password) > 0 {
-		dialOpts = append(dialOpts, redis.DialPassword(c.opts.password))
+func (c *Client) dial(address, username, password string, database int) (redis.Conn, error) {
+	dialOpts := []redis.DialOption{
+		redis.DialConnectTimeout(c.opts.dialTimeout),
+		redis.DialReadTimeout(c.opts.readTimeout),
+		redis.DialWriteTimeout(c.opts.writeTimeout),
+	}
+	if len(password) > 0 {
+		dialOpts = append(dialOpts, redis.DialPassword(password))
+	}
+	if len(username) > 0 {
+		dialOpts = append(dialOpts, redis.DialUsername(username))
+	}
+	if database > 0 {
+		dialOpts = append(dialOpts, redis.DialDatabase(database))
 	}
-	conn, err := redis.DialContext(context.Background(),
-		c.opts.network, c.opts.address, dialOpts...)
+	if c.opts.useTLS {
+		dialOpts = append(dialOpts, redis.DialUseTLS(true), redis.DialTLSSkipVerify(c.opts.tlsSkipVerify))
+		if c.opts.tlsConfig != nil {
+			dialOpts = append(dialOpts, redis.DialTLSConfig(c.opts.tlsConfig))
+		}
+	}
+	// 连接超时同时覆盖 TLS 握手以及认证
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.dialTimeout)
+	defer cancel()
+	conn, err := c.opts.dial(ctx, c.opts.network, address, dialOpts...)
 	if err != nil {
-		return nil, err
+		return nil, classifyDialErr(err, c.opts.useTLS)
 	}
 	return conn, nil
 }
 
-func (c *Client) SAdd(ctx context.Context, key, val string) (int, error) {
-	conn, err := c.pool.GetContext(ctx)
+// Ping 检查 redis 的连通性.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.retry(ctx, "PING", nil, func(conn redis.Conn) (interface{}, error) {
+		return do(ctx, conn, c.opts.readTimeout, "PING")
+	})
+	return err
+}
+
+// Time 通过 TIME 指令获取 redis 服务端的当前时刻，精确到微秒.
+func (c *Client) Time(ctx context.Context) (time.Time, error) {
+	reply, err := redis.Int64s(c.do(ctx, "TIME"))
 	if err != nil {
-		return -1, err
+		return time.Time{}, err
 	}
-	defer conn.Close()
-	return redis.Int(conn.Do("SADD", key, val))
+	if len(reply) != 2 {
+		return time.Time{}, fmt.Errorf("invalid time reply %v", reply)
+	}
+	return time.Unix(reply[0], reply[1]*int64(time.Microsecond)), nil
+}
+
+func (c *Client) SAdd(ctx context.Context, key, val string) (int, error) {
+	return redis.Int(c.do(ctx, "SADD", key, val))
+}
+
+// IsConnError 判断错误是否由于无法连接 redis、连接中断或者连接池耗尽导致，而不是 redis 返回的错误回复.
+func IsConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		return false
+	}
+	if errors.Is(err, redis.ErrPoolExhausted) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
 }
 
 // Eval 支持使用 lua 脚本.
@@ -96,12 +272,8 @@ func (c *Client) Eval(ctx context.Context, src string, keyCount int, keysAndArgs
 	args[1] = keyCount
 	copy(args[2:], keysAndArgs)
 
-	// 从 redis 链接池中获取一个连接
-	conn, err := c.pool.GetContext(ctx)
-	if err != nil {
-		return -1, err
-	}
-	defer conn.Close()
-
-	return conn.Do("EVAL", args...)
+	// 从 key 所在节点的连接池中获取一个连接
+	return c.run(ctx, evalKeys(keyCount, keysAndArgs), func(conn redis.Conn) (interface{}, error) {
+		return do(ctx, conn, c.opts.readTimeout, "EVAL", args...)
+	})
 }
@@ -0,0 +1,534 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// memConn 在内存中模拟 redis 的连接，支持测试用到的字符串、zset、集合以及 hash 指令.
+// Send 的指令在 Flush 之后才会执行，与真实连接的流水线行为一致. 多个连接可以共享同一份数据
+type memConn struct {
+	db      *memDB
+	pending [][]string
+	flushed [][]string
+	multi   bool
+	queued  [][]string
+	watched map[string]string // WATCH 时 key 的快照，EXEC 时比较快照判断 key 是否被修改
+
+	// 非 nil 时优先处理指令，返回 nil 表示交给 memConn 处理. 用于模拟集群的重定向等节点级别的行为
+	intercept func(name string, args []string) interface{}
+}
+
+type memDB struct {
+	mu   sync.Mutex
+	role string                 // ROLE 指令返回的角色，默认为 master
+	data map[string]interface{} // string、map[string]float64（zset）、map[string]struct{}（集合）、map[string]string（hash）
+	ttl  map[string]time.Duration
+}
+
+func newMemDB() *memDB {
+	return &memDB{data: make(map[string]interface{}), ttl: make(map[string]time.Duration)}
+}
+
+// 创建连接池中的连接均指向 db 的客户端
+func newTestClient(db *memDB) *Client {
+	return &Client{opts: &ClientOptions{readTimeout: DefaultReadTimeout}, pool: &redis.Pool{Dial: func() (redis.Conn, error) {
+		return &memConn{db: db}, nil
+	}}}
+}
+
+func (c *memConn) Send(commandName string, args ...interface{}) error {
+	cmd := []string{strings.ToUpper(commandName)}
+	for _, arg := range args {
+		cmd = append(cmd, fmt.Sprint(arg))
+	}
+	c.pending = append(c.pending, cmd)
+	return nil
+}
+
+func (c *memConn) Flush() error {
+	c.flushed = append(c.flushed, c.pending...)
+	c.pending = nil
+	return nil
+}
+
+func (c *memConn) Receive() (interface{}, error) {
+	if len(c.flushed) == 0 {
+		return nil, errors.New("no pending reply")
+	}
+	cmd := c.flushed[0]
+	c.flushed = c.flushed[1:]
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	reply := c.exec(cmd[0], cmd[1:])
+	if err, ok := reply.(redis.Error); ok {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// 处理事务相关的指令，其余指令在事务中入队，在事务之外直接执行
+func (c *memConn) exec(name string, args []string) interface{} {
+	if c.intercept != nil {
+		if reply := c.intercept(name, args); reply != nil {
+			return reply
+		}
+	}
+	switch name {
+	case "WATCH":
+		if c.watched == nil {
+			c.watched = make(map[string]string)
+		}
+		for _, key := range args {
+			c.watched[key] = c.db.snapshot(key)
+		}
+		return "OK"
+	case "UNWATCH":
+		c.watched = nil
+		return "OK"
+	case "MULTI":
+		if c.multi {
+			return redis.Error("ERR MULTI calls can not be nested")
+		}
+		c.multi = true
+		return "OK"
+	case "DISCARD", "EXEC":
+		if !c.multi {
+			return redis.Error("ERR " + name + " without MULTI")
+		}
+		queued, watched := c.queued, c.watched
+		c.multi, c.queued, c.watched = false, nil, nil
+		if name == "DISCARD" {
+			return "OK"
+		}
+		for key, snapshot := range watched {
+			if c.db.snapshot(key) != snapshot {
+				return nil
+			}
+		}
+		replies := make([]interface{}, 0, len(queued))
+		for _, cmd := range queued {
+			replies = append(replies, c.db.exec(cmd[0], cmd[1:]))
+		}
+		return replies
+	}
+	if !c.multi {
+		return c.db.exec(name, args)
+	}
+	if !memCommands[name] {
+		return redis.Error("ERR unknown command '" + name + "'")
+	}
+	c.queued = append(c.queued, append([]string{name}, args...))
+	return "QUEUED"
+}
+
+func (c *memConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		return nil, nil
+	}
+	_ = c.Send(commandName, args...)
+	_ = c.Flush()
+	return c.Receive()
+}
+
+func (c *memConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return c.Do(commandName, args...)
+}
+
+func (c *memConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+func (c *memConn) Close() error { return nil }
+func (c *memConn) Err() error   { return nil }
+
+func (db *memDB) snapshot(key string) string {
+	v, ok := db.data[key]
+	return fmt.Sprint(ok, v)
+}
+
+var memCommands = map[string]bool{
+	"PING": true, "ROLE": true, "SET": true, "GET": true, "INCR": true, "INCRBY": true, "DEL": true, "EXISTS": true, "EXPIRE": true,
+	"ZADD": true, "ZRANGEBYSCORE": true, "ZREM": true, "ZCARD": true, "SADD": true, "SREM": true, "SMEMBERS": true, "SCARD": true,
+	"HSET": true, "HGET": true, "HDEL": true, "TIME": true,
+}
+
+var errWrongType = redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func (db *memDB) exec(name string, args []string) interface{} {
+	key := ""
+	if len(args) > 0 {
+		key = args[0]
+	}
+	switch name {
+	case "PING":
+		return "PONG"
+	case "TIME":
+		return []interface{}{[]byte("1700000000"), []byte("123456")}
+	case "ROLE":
+		if db.role == "" {
+			return []interface{}{[]byte("master"), int64(0), []interface{}{}}
+		}
+		return []interface{}{[]byte(db.role)}
+	case "SET":
+		// 支持 NX 以及 PX 选项
+		options := strings.ToUpper(strings.Join(args[2:], " "))
+		if _, exists := db.data[key]; exists && strings.Contains(options, "NX") {
+			return nil
+		}
+		db.data[key] = args[1]
+		for i := 2; i+1 < len(args); i++ {
+			if strings.ToUpper(args[i]) == "PX" {
+				ms, _ := strconv.Atoi(args[i+1])
+				db.ttl[key] = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return "OK"
+	case "GET":
+		v, ok := db.data[key].(string)
+		if !ok {
+			return nil
+		}
+		return []byte(v)
+	case "INCR", "INCRBY":
+		delta := int64(1)
+		if name == "INCRBY" {
+			delta, _ = strconv.ParseInt(args[1], 10, 64)
+		}
+		v, _ := db.data[key].(string)
+		n, err := strconv.ParseInt(v, 10, 64)
+		if _, exists := db.data[key]; exists && err != nil {
+			return redis.Error("ERR value is not an integer or out of range")
+		}
+		db.data[key] = strconv.FormatInt(n+delta, 10)
+		return n + delta
+	case "DEL", "EXISTS":
+		var n int64
+		for _, k := range args {
+			if _, ok := db.data[k]; ok {
+				n++
+				if name == "DEL" {
+					delete(db.data, k)
+					delete(db.ttl, k)
+				}
+			}
+		}
+		return n
+	case "EXPIRE":
+		if _, ok := db.data[key]; !ok {
+			return int64(0)
+		}
+		seconds, _ := strconv.Atoi(args[1])
+		db.ttl[key] = time.Duration(seconds) * time.Second
+		return int64(1)
+	case "ZADD", "ZRANGEBYSCORE", "ZCOUNT", "ZREM", "ZCARD":
+		return db.execZset(name, key, args[1:])
+	case "SADD", "SREM", "SMEMBERS", "SCARD":
+		return db.execSet(name, key, args[1:])
+	case "HSET", "HGET", "HDEL":
+		return db.execHash(name, key, args[1:])
+	}
+	return redis.Error("ERR unknown command '" + name + "'")
+}
+
+func (db *memDB) execZset(name, key string, args []string) interface{} {
+	zset, ok := db.data[key].(map[string]float64)
+	if _, exists := db.data[key]; exists && !ok {
+		return errWrongType
+	}
+	if zset == nil {
+		zset = make(map[string]float64)
+	}
+	switch name {
+	case "ZADD":
+		score, _ := strconv.ParseFloat(args[0], 64)
+		_, existed := zset[args[1]]
+		zset[args[1]] = score
+		db.data[key] = zset
+		if existed {
+			return int64(0)
+		}
+		return int64(1)
+	case "ZRANGEBYSCORE", "ZCOUNT":
+		min, minExclusive := parseScoreBound(args[0])
+		max, maxExclusive := parseScoreBound(args[1])
+		var members []string
+		for member, score := range zset {
+			if score < min || score > max || minExclusive && score == min || maxExclusive && score == max {
+				continue
+			}
+			members = append(members, member)
+		}
+		if name == "ZCOUNT" {
+			return int64(len(members))
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if zset[members[i]] != zset[members[j]] {
+				return zset[members[i]] < zset[members[j]]
+			}
+			return members[i] < members[j]
+		})
+		return bulks(members)
+	case "ZREM":
+		return db.removeMembers(key, len(zset), func() int {
+			for _, member := range args {
+				delete(zset, member)
+			}
+			return len(zset)
+		})
+	default:
+		return int64(len(zset))
+	}
+}
+
+func (db *memDB) execSet(name, key string, args []string) interface{} {
+	set, ok := db.data[key].(map[string]struct{})
+	if _, exists := db.data[key]; exists && !ok {
+		return errWrongType
+	}
+	if set == nil {
+		set = make(map[string]struct{})
+	}
+	switch name {
+	case "SADD":
+		var n int64
+		for _, member := range args {
+			if _, ok := set[member]; !ok {
+				set[member] = struct{}{}
+				n++
+			}
+		}
+		db.data[key] = set
+		return n
+	case "SREM":
+		return db.removeMembers(key, len(set), func() int {
+			for _, member := range args {
+				delete(set, member)
+			}
+			return len(set)
+		})
+	case "SMEMBERS":
+		members := make([]string, 0, len(set))
+		for member := range set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		return bulks(members)
+	default:
+		return int64(len(set))
+	}
+}
+
+func (db *memDB) execHash(name, key string, args []string) interface{} {
+	hash, ok := db.data[key].(map[string]string)
+	if _, exists := db.data[key]; exists && !ok {
+		return errWrongType
+	}
+	if hash == nil {
+		hash = make(map[string]string)
+	}
+	switch name {
+	case "HSET":
+		_, existed := hash[args[0]]
+		hash[args[0]] = args[1]
+		db.data[key] = hash
+		if existed {
+			return int64(0)
+		}
+		return int64(1)
+	case "HGET":
+		v, ok := hash[args[0]]
+		if !ok {
+			return nil
+		}
+		return []byte(v)
+	default:
+		return db.removeMembers(key, len(hash), func() int {
+			for _, field := range args {
+				delete(hash, field)
+			}
+			return len(hash)
+		})
+	}
+}
+
+// 移除成员并返回移除的数量，成员全部移除之后 key 随之删除
+func (db *memDB) removeMembers(key string, before int, remove func() int) int64 {
+	after := remove()
+	if after == 0 {
+		delete(db.data, key)
+		delete(db.ttl, key)
+	}
+	return int64(before - after)
+}
+
+func parseScoreBound(s string) (float64, bool) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+	switch s {
+	case "-inf":
+		return math.Inf(-1), exclusive
+	case "+inf", "inf":
+		return math.Inf(1), exclusive
+	}
+	v, _ := strconv.ParseFloat(s, 64)
+	return v, exclusive
+}
+
+func bulks(values []string) []interface{} {
+	reply := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		reply = append(reply, []byte(v))
+	}
+	return reply
+}
+
+func Test_commands(t *testing.T) {
+	ctx := context.Background()
+	db := newMemDB()
+	c := newTestClient(db)
+
+	// zset
+	for i, member := range []string{"c", "a", "b"} {
+		if n, err := c.ZAdd(ctx, "zset", float64(i), member); err != nil || n != 1 {
+			t.Errorf("zadd %s, got: %d, err: %v", member, n, err)
+		}
+	}
+	if members, err := c.ZRangeByScore(ctx, "zset", "(0", "+inf"); err != nil || strings.Join(members, ",") != "a,b" {
+		t.Errorf("zrangebyscore, got: %v, err: %v", members, err)
+	}
+	if n, err := c.ZCount(ctx, "zset", "(0", "+inf"); err != nil || n != 2 {
+		t.Errorf("zcount, got: %d, err: %v", n, err)
+	}
+	if n, err := c.ZRem(ctx, "zset", "a", "missing"); err != nil || n != 1 {
+		t.Errorf("zrem, got: %d, err: %v", n, err)
+	}
+	if n, err := c.ZCard(ctx, "zset"); err != nil || n != 2 {
+		t.Errorf("zcard, got: %d, err: %v", n, err)
+	}
+
+	// 集合
+	for _, member := range []string{"x", "y", "x"} {
+		if _, err := c.SAdd(ctx, "set", member); err != nil {
+			t.Error(err)
+		}
+	}
+	if members, err := c.SMembers(ctx, "set"); err != nil || strings.Join(members, ",") != "x,y" {
+		t.Errorf("smembers, got: %v, err: %v", members, err)
+	}
+	if n, err := c.SRem(ctx, "set", "x"); err != nil || n != 1 {
+		t.Errorf("srem, got: %d, err: %v", n, err)
+	}
+	if n, err := c.SCard(ctx, "set"); err != nil || n != 1 {
+		t.Errorf("scard, got: %d, err: %v", n, err)
+	}
+	// key 不存在时集合类查询返回空结果，而不是 ErrNil
+	if members, err := c.SMembers(ctx, "missing"); err != nil || len(members) != 0 {
+		t.Errorf("smembers missing, got: %v, err: %v", members, err)
+	}
+
+	// hash
+	if n, err := c.HSet(ctx, "hash", "f", "v"); err != nil || n != 1 {
+		t.Errorf("hset, got: %d, err: %v", n, err)
+	}
+	if v, err := c.HGet(ctx, "hash", "f"); err != nil || v != "v" {
+		t.Errorf("hget, got: %s, err: %v", v, err)
+	}
+	if _, err := c.HGet(ctx, "hash", "missing"); !errors.Is(err, ErrNil) {
+		t.Errorf("hget missing field, got err: %v, want ErrNil", err)
+	}
+	if n, err := c.HDel(ctx, "hash", "f", "missing"); err != nil || n != 1 {
+		t.Errorf("hdel, got: %d, err: %v", n, err)
+	}
+
+	// 通用指令
+	if ok, err := c.SetNX(ctx, "claim", "a", 1500*time.Millisecond); err != nil || !ok {
+		t.Errorf("setnx, got: %v, err: %v", ok, err)
+	}
+	if ok, err := c.SetNX(ctx, "claim", "b", time.Second); err != nil || ok {
+		t.Errorf("setnx existing, got: %v, err: %v", ok, err)
+	}
+	if db.data["claim"] != "a" || db.ttl["claim"] != 1500*time.Millisecond {
+		t.Errorf("got claim: %v, ttl: %v", db.data["claim"], db.ttl["claim"])
+	}
+	if n, err := c.IncrBy(ctx, "counter", 5); err != nil || n != 5 {
+		t.Errorf("incrby, got: %d, err: %v", n, err)
+	}
+	if ok, err := c.Expire(ctx, "counter", 1500*time.Millisecond); err != nil || !ok {
+		t.Errorf("expire, got: %v, err: %v", ok, err)
+	}
+	if ok, err := c.Expire(ctx, "missing", time.Second); err != nil || ok {
+		t.Errorf("expire missing, got: %v, err: %v", ok, err)
+	}
+	if n, err := c.Exists(ctx, "counter", "zset", "missing"); err != nil || n != 2 {
+		t.Errorf("exists, got: %d, err: %v", n, err)
+	}
+	if n, err := c.Del(ctx, "counter", "zset", "missing"); err != nil || n != 2 {
+		t.Errorf("del, got: %d, err: %v", n, err)
+	}
+	if now, err := c.Time(ctx); err != nil || !now.Equal(time.Unix(1700000000, 123456000)) {
+		t.Errorf("time, got: %v, err: %v", now, err)
+	}
+
+	// 错误回复原样返回
+	if _, err := c.ZCard(ctx, "set"); err == nil || errors.Is(err, ErrNil) {
+		t.Errorf("zcard on set, got err: %v, want wrongtype", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := c.ZCard(cancelled, "zset"); !errors.Is(err, context.Canceled) {
+		t.Errorf("got err: %v, want context canceled", err)
+	}
+}
+
+func Test_close(t *testing.T) {
+	ctx := context.Background()
+	c := newTestClient(newMemDB())
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// 重复关闭是安全的，关闭之后的请求返回 ErrClosed
+	for i := 0; i < 2; i++ {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Ping(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("ping, got err: %v, want ErrClosed", err)
+	}
+	if _, err := c.GetConn(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("get conn, got err: %v, want ErrClosed", err)
+	}
+	if _, err := c.Pipeline(ctx, func(p Pipeliner) error {
+		p.Send("PING")
+		return nil
+	}); !errors.Is(err, ErrClosed) {
+		t.Errorf("pipeline, got err: %v, want ErrClosed", err)
+	}
+
+	const node = "10.0.0.1:7000"
+	fc := newFakeCluster(node)
+	cc := NewClusterClient([]string{node}, "", func(o *ClientOptions) { o.dial = fc.dial })
+	if _, err := cc.SAdd(ctx, "foo", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !cc.cluster.node(node).closed.Load() {
+		t.Error("cluster node not closed")
+	}
+	if _, err := cc.SAdd(ctx, "foo", "x"); !errors.Is(err, ErrClosed) {
+		t.Errorf("cluster, got err: %v, want ErrClosed", err)
+	}
+}
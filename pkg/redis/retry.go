@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 只读指令重复执行不会改变数据，连接异常时可以安全地在新的连接上重试
+var idempotentCommands = map[string]bool{
+	"PING":          true,
+	"GET":           true,
+	"EXISTS":        true,
+	"HGET":          true,
+	"SCARD":         true,
+	"SMEMBERS":      true,
+	"ZCARD":         true,
+	"ZCOUNT":        true,
+	"ZRANGEBYSCORE": true,
+}
+
+// RetryHook 幂等的指令因连接异常而重试时回调. attempt 为重试的次数，从 1 开始；err 为上一次执行的错误.
+type RetryHook func(commandName string, attempt int, err error)
+
+type retryHooks struct {
+	mu    sync.RWMutex
+	hooks []RetryHook
+}
+
+// OnRetry 注册重试的回调，用于统计重试次数等监控指标. 可以注册多个，按照注册的顺序依次调用.
+func (c *Client) OnRetry(hook RetryHook) {
+	c.retryHooks.mu.Lock()
+	defer c.retryHooks.mu.Unlock()
+	c.retryHooks.hooks = append(c.retryHooks.hooks, hook)
+}
+
+func (c *Client) notifyRetry(commandName string, attempt int, err error) {
+	c.retryHooks.mu.RLock()
+	defer c.retryHooks.mu.RUnlock()
+	for _, hook := range c.retryHooks.hooks {
+		hook(commandName, attempt, err)
+	}
+}
+
+// EvalRetryable 与 Eval 相同，区别在于连接异常时按照 WithRetry 的配置在新的连接上重试.
+// 只能用于重复执行不会产生副作用的脚本，如只读的查询，或者 ZADD、SREM 这类重复执行结果相同的写入.
+// 取出并删除任务的脚本重复执行会丢失第一次取出的任务，不能使用.
+func (c *Client) EvalRetryable(ctx context.Context, src string, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0] = src
+	args[1] = keyCount
+	copy(args[2:], keysAndArgs)
+
+	return c.retry(ctx, "EVAL", evalKeys(keyCount, keysAndArgs), func(conn redis.Conn) (interface{}, error) {
+		return do(ctx, conn, c.opts.readTimeout, "EVAL", args...)
+	})
+}
+
+// EvalScriptRetryable 与 EvalScript 相同，区别在于连接异常时重试，对脚本的要求与 EvalRetryable 相同.
+func (c *Client) EvalScriptRetryable(ctx context.Context, script *Script, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
+	return c.retry(ctx, "EVALSHA", evalKeys(keyCount, keysAndArgs), func(conn redis.Conn) (interface{}, error) {
+		return script.do(ctx, conn, c.opts.readTimeout, keyCount, keysAndArgs)
+	})
+}
+
+// 在 keys 所在的节点上执行 fn，连接异常时退避之后在新的连接上重试. 距离 ctx 的截止时间不足以完成退避时不再重试，直接返回上一次的错误
+func (c *Client) retry(ctx context.Context, commandName string, keys []string, fn func(conn redis.Conn) (interface{}, error)) (interface{}, error) {
+	for attempt := 0; ; attempt++ {
+		reply, err := c.run(ctx, keys, fn)
+		if err == nil || attempt >= c.opts.maxRetries || !isRetryableErr(err) {
+			return reply, err
+		}
+
+		backoff := c.opts.retryBackoff << attempt
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return reply, err
+		}
+		c.notifyRetry(commandName, attempt+1, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return reply, err
+		case <-timer.C:
+		}
+	}
+}
+
+// 连接被对端关闭、重置或者连接池等待超时，指令没有执行或者执行结果丢失. 读写超时时指令可能仍在执行，不重试
+func isRetryableErr(err error) bool {
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, redis.ErrPoolExhausted)
+}
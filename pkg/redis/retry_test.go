@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 前 fails 次执行指令返回 err，之后正常执行. 连接池借出连接时的 PING 以及归还连接时的 Do("") 不计入次数
+type flakyConn struct {
+	*memConn
+	fails *int
+	err   error
+}
+
+func (c *flakyConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if *c.fails > 0 && commandName != "PING" && commandName != "" {
+		*c.fails--
+		return nil, c.err
+	}
+	return c.memConn.Do(commandName, args...)
+}
+
+func (c *flakyConn) DoWithTimeout(timeout time.Duration, commandName string, args ...interface{}) (interface{}, error) {
+	return c.Do(commandName, args...)
+}
+
+func newFlakyClient(db *memDB, fails *int, err error, opts ...ClientOption) *Client {
+	opts = append(opts, func(o *ClientOptions) {
+		o.dial = func(ctx context.Context, network, address string, options ...redis.DialOption) (redis.Conn, error) {
+			conn := memConn{db: db, intercept: func(name string, args []string) interface{} {
+				if name == "EVAL" {
+					return int64(1)
+				}
+				return nil
+			}}
+			return &flakyConn{memConn: &conn, fails: fails, err: err}, nil
+		}
+	})
+	return newClient(&ClientOptions{network: "tcp", address: "127.0.0.1:6379"}, opts...)
+}
+
+func Test_retry(t *testing.T) {
+	ctx := context.Background()
+	db := newMemDB()
+	db.data["zset"] = map[string]float64{"a": 1}
+
+	var retries []int
+	fails := 2
+	c := newFlakyClient(db, &fails, io.EOF, WithRetry(2, time.Millisecond))
+	c.OnRetry(func(commandName string, attempt int, err error) {
+		retries = append(retries, attempt)
+	})
+	if n, err := c.ZCard(ctx, "zset"); err != nil || n != 1 {
+		t.Errorf("got: %d, err: %v", n, err)
+	}
+	if len(retries) != 2 || retries[1] != 2 {
+		t.Errorf("got retries: %v", retries)
+	}
+
+	// 重试次数耗尽之后返回最后一次的错误
+	fails = 3
+	if _, err := c.ZCard(ctx, "zset"); !errors.Is(err, io.EOF) {
+		t.Errorf("got err: %v, want io.EOF", err)
+	}
+
+	// 非幂等的指令以及 Eval 不重试
+	fails = 1
+	if _, err := c.ZAdd(ctx, "zset", 2, "b"); !errors.Is(err, io.EOF) {
+		t.Errorf("zadd, got err: %v, want io.EOF", err)
+	}
+	fails = 1
+	if _, err := c.Eval(ctx, "return 1", 0, nil); !errors.Is(err, io.EOF) {
+		t.Errorf("eval, got err: %v, want io.EOF", err)
+	}
+	fails = 1
+	if _, err := c.EvalRetryable(ctx, "return 1", 0, nil); err != nil {
+		t.Errorf("eval retryable, got err: %v", err)
+	}
+
+	// 距离截止时间不足以完成退避时不再重试
+	fails = 1
+	slow := newFlakyClient(db, &fails, syscall.ECONNRESET, WithRetry(1, time.Hour))
+	tctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := slow.ZCard(tctx, "zset"); !errors.Is(err, syscall.ECONNRESET) || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("got err: %v after %v", err, time.Since(start))
+	}
+
+	// redis 返回的错误回复以及关闭重试时不重试
+	fails = 1
+	if _, err := newFlakyClient(db, &fails, redis.Error("ERR"), WithRetry(2, time.Millisecond)).ZCard(ctx, "zset"); err == nil {
+		t.Error("reply error should not be retried")
+	}
+	fails = 1
+	if _, err := newFlakyClient(db, &fails, io.EOF, WithRetry(0, 0)).ZCard(ctx, "zset"); !errors.Is(err, io.EOF) {
+		t.Errorf("retry disabled, got err: %v", err)
+	}
+}
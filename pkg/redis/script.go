@@ -0,0 +1,61 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// Script lua 脚本. 创建时计算脚本的 SHA1，执行时优先通过 EVALSHA 只发送摘要，
+// 服务端的脚本缓存中不存在该脚本（NOSCRIPT）时回退为 EVAL 发送完整脚本，EVAL 同时会将脚本重新写入服务端的缓存.
+// Script 创建之后不可变，可以并发使用.
+type Script struct {
+	src  string
+	hash string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, hash: hex.EncodeToString(sum[:])}
+}
+
+// Hash 返回脚本的 SHA1 摘要.
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Src 返回脚本的原文.
+func (s *Script) Src() string {
+	return s.src
+}
+
+// EvalScript 与 Eval 相同，区别在于优先通过 EVALSHA 执行脚本，节省每次发送完整脚本的带宽以及服务端的解析开销.
+func (c *Client) EvalScript(ctx context.Context, script *Script, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
+	return c.run(ctx, evalKeys(keyCount, keysAndArgs), func(conn redis.Conn) (interface{}, error) {
+		return script.do(ctx, conn, c.opts.readTimeout, keyCount, keysAndArgs)
+	})
+}
+
+func (s *Script) do(ctx context.Context, conn redis.Conn, readTimeout time.Duration, keyCount int, keysAndArgs []interface{}) (interface{}, error) {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0] = s.hash
+	args[1] = keyCount
+	copy(args[2:], keysAndArgs)
+
+	reply, err := do(ctx, conn, readTimeout, "EVALSHA", args...)
+	if !isNoScript(err) {
+		return reply, err
+	}
+	// 脚本缓存被清空（SCRIPT FLUSH、服务端重启或者主从切换），通过 EVAL 执行并重新缓存
+	args[0] = s.src
+	return do(ctx, conn, readTimeout, "EVAL", args...)
+}
+
+func isNoScript(err error) bool {
+	e, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(e), "NOSCRIPT")
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 模拟服务端脚本缓存的连接，记录收到的指令
+type scriptCacheConn struct {
+	mu       sync.Mutex
+	scripts  map[string]string
+	commands []string
+}
+
+func (c *scriptCacheConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts = make(map[string]string)
+}
+
+func (c *scriptCacheConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commands = append(c.commands, commandName)
+	if len(args) < 2+args[1].(int) {
+		return nil, redis.Error("ERR Number of keys can't be greater than number of args")
+	}
+	switch commandName {
+	case "EVALSHA":
+		if _, ok := c.scripts[args[0].(string)]; !ok {
+			return nil, redis.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+	case "EVAL":
+		sum := sha1.Sum([]byte(args[0].(string)))
+		c.scripts[hex.EncodeToString(sum[:])] = args[0].(string)
+	default:
+		return nil, errors.New("unexpected command " + commandName)
+	}
+	return args[2], nil
+}
+
+func (c *scriptCacheConn) Close() error                                       { return nil }
+func (c *scriptCacheConn) Err() error                                         { return nil }
+func (c *scriptCacheConn) Send(commandName string, args ...interface{}) error { return nil }
+func (c *scriptCacheConn) Flush() error                                       { return nil }
+func (c *scriptCacheConn) Receive() (interface{}, error)                      { return nil, nil }
+
+func Test_script(t *testing.T) {
+	script := NewScript("return KEYS[1]")
+	conn := &scriptCacheConn{}
+	conn.flush()
+
+	// 脚本缓存为空时回退为 EVAL，之后的执行只发送摘要
+	for i := 0; i < 2; i++ {
+		reply, err := script.do(context.Background(), conn, DefaultReadTimeout, 1, []interface{}{"key"})
+		if err != nil || reply != "key" {
+			t.Errorf("got reply: %v, err: %v", reply, err)
+		}
+	}
+	if want := []string{"EVALSHA", "EVAL", "EVALSHA"}; !equalStrings(conn.commands, want) {
+		t.Errorf("got commands: %v, want: %v", conn.commands, want)
+	}
+
+	// 模拟 SCRIPT FLUSH，并发执行时全部调用都能回退成功
+	conn.flush()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reply, err := script.do(context.Background(), conn, DefaultReadTimeout, 1, []interface{}{"key"}); err != nil || reply != "key" {
+				t.Errorf("got reply: %v, err: %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, ok := conn.scripts[script.Hash()]; !ok {
+		t.Errorf("script not cached after fallback")
+	}
+
+	// NOSCRIPT 以外的错误直接返回，不回退为 EVAL
+	conn = &scriptCacheConn{scripts: map[string]string{script.Hash(): script.Src()}}
+	if _, err := script.do(context.Background(), conn, DefaultReadTimeout, 1, nil); err == nil || len(conn.commands) != 1 {
+		t.Errorf("got err: %v, commands: %v", err, conn.commands)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrNotMaster 通过 sentinel 获取的节点不是 master，通常发生在故障转移的过程中.
+var ErrNotMaster = errors.New("redis node is not master")
+
+// NewSentinelClient 创建 sentinel 模式的客户端. 连接池每次建立连接时都会通过 sentinel 查询 masterName 当前的 master 地址，
+// 并通过 ROLE 确认节点的角色，因此故障转移之后新建立的连接会自动指向新的 master，已有的空闲连接会在取出时被丢弃.
+// password 为 master 的密码，sentinel 节点的密码通过 WithSentinelPassword 设置.
+func NewSentinelClient(masterName string, sentinelAddrs []string, password string, opts ...ClientOption) *Client {
+	return newClient(&ClientOptions{
+		network:  "tcp",
+		password: password,
+		sentinel: &sentinel{
+			masterName: masterName,
+			addrs:      append([]string(nil), sentinelAddrs...),
+		},
+	}, opts...)
+}
+
+type sentinel struct {
+	masterName string
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+// 依次询问 sentinel 节点，返回 master 的地址. 成功响应的 sentinel 节点移动到首位，之后优先询问
+func (s *sentinel) masterAddr(dial func(address string) (redis.Conn, error)) (string, error) {
+	s.mu.Lock()
+	addrs := append([]string(nil), s.addrs...)
+	s.mu.Unlock()
+
+	if len(addrs) == 0 {
+		return "", errors.New("no sentinel address")
+	}
+	var lastErr error
+	for _, addr := range addrs {
+		master, err := s.queryMaster(dial, addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		s.promote(addr)
+		return master, nil
+	}
+	return "", fmt.Errorf("get master %s from sentinels failed, err: %w", s.masterName, lastErr)
+}
+
+func (s *sentinel) queryMaster(dial func(address string) (redis.Conn, error), addr string) (string, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", s.masterName))
+	if err != nil {
+		return "", fmt.Errorf("sentinel %s, err: %w", addr, err)
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("sentinel %s, unexpected reply %v", addr, reply)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+func (s *sentinel) promote(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.addrs {
+		if s.addrs[i] == addr {
+			copy(s.addrs[1:i+1], s.addrs[:i])
+			s.addrs[0] = addr
+			return
+		}
+	}
+}
+
+// 通过 sentinel 获取 master 的地址并建立连接
+func (c *Client) getMasterConn() (redis.Conn, error) {
+	address, err := c.opts.sentinel.masterAddr(func(address string) (redis.Conn, error) {
+		return c.dial(address, "", c.opts.sentinelPassword, 0)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.dial(address, c.opts.username, c.opts.password, c.opts.database)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMaster(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", address, err)
+	}
+	return conn, nil
+}
+
+func checkMaster(conn redis.Conn) error {
+	reply, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return errors.New("empty ROLE reply")
+	}
+	if role, _ := redis.String(reply[0], nil); role != "master" {
+		return fmt.Errorf("%w, role: %s", ErrNotMaster, role)
+	}
+	return nil
+}
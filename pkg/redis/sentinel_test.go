@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// 模拟 sentinel 节点，返回 master 的当前地址
+type sentinelConn struct {
+	mu     *sync.Mutex
+	master *string
+}
+
+func (c *sentinelConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if commandName != "SENTINEL" || len(args) != 2 || args[1] != "mymaster" {
+		return nil, redis.Error("ERR unknown command")
+	}
+	host, port, _ := net.SplitHostPort(*c.master)
+	return []interface{}{[]byte(host), []byte(port)}, nil
+}
+
+func (c *sentinelConn) Close() error                                       { return nil }
+func (c *sentinelConn) Err() error                                         { return nil }
+func (c *sentinelConn) Send(commandName string, args ...interface{}) error { return nil }
+func (c *sentinelConn) Flush() error                                       { return nil }
+func (c *sentinelConn) Receive() (interface{}, error)                      { return nil, nil }
+
+func Test_sentinelClient(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		master  = "10.0.0.1:6379"
+		nodes   = map[string]*memDB{"10.0.0.1:6379": newMemDB(), "10.0.0.2:6379": newMemDB()}
+		dialled []string
+	)
+	c := NewSentinelClient("mymaster", []string{"sentinel-down:26379", "sentinel:26379"}, "", func(o *ClientOptions) {
+		o.dial = func(ctx context.Context, network, address string, options ...redis.DialOption) (redis.Conn, error) {
+			dialled = append(dialled, address)
+			switch {
+			case address == "sentinel:26379":
+				return &sentinelConn{mu: &mu, master: &master}, nil
+			case nodes[address] != nil:
+				return &memConn{db: nodes[address]}, nil
+			}
+			return nil, errors.New("connection refused")
+		}
+	})
+
+	ctx := context.Background()
+	if _, err := c.SAdd(ctx, "set", "a"); err != nil {
+		t.Fatal(err)
+	}
+	// 不可用的 sentinel 节点被跳过，之后优先询问可用的节点
+	if got := strings.Join(dialled, ","); got != "sentinel-down:26379,sentinel:26379,10.0.0.1:6379" {
+		t.Errorf("got dialled: %s", got)
+	}
+	idle, err := c.GetConn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟故障转移：旧 master 降级为 replica，sentinel 返回新 master 的地址
+	mu.Lock()
+	master = "10.0.0.2:6379"
+	nodes["10.0.0.1:6379"].role = "slave"
+	mu.Unlock()
+
+	if err := c.pool.TestOnBorrow(idle, time.Now()); !errors.Is(err, ErrNotMaster) {
+		t.Errorf("borrow conn to old master, got err: %v, want ErrNotMaster", err)
+	}
+	dialled = nil
+	if _, err := c.SAdd(ctx, "set", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(dialled, ","); got != "sentinel:26379,10.0.0.2:6379" {
+		t.Errorf("got dialled: %s", got)
+	}
+	if _, ok := nodes["10.0.0.2:6379"].data["set"]; !ok {
+		t.Error("new master not written")
+	}
+
+	// sentinel 返回的节点尚未完成角色切换时拒绝建立连接
+	mu.Lock()
+	master = "10.0.0.1:6379"
+	mu.Unlock()
+	if _, err := c.SAdd(ctx, "set", "c"); !errors.Is(err, ErrNotMaster) {
+		t.Errorf("got err: %v, want ErrNotMaster", err)
+	}
+}
@@ -0,0 +1,114 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrTxAborted WATCH 的 key 在事务提交之前被修改，EXEC 放弃执行事务.
+var ErrTxAborted = errors.New("redis: transaction aborted, watched keys modified")
+
+// Txer 向事务中追加指令. Send 追加的指令在 fn 返回之后通过 MULTI/EXEC 原子执行；
+// Do 立即在事务使用的连接上执行指令并返回结果，用于在 WATCH 之后读取数据，结果不计入事务.
+type Txer interface {
+	Pipeliner
+	Do(commandName string, args ...interface{}) (interface{}, error)
+}
+
+type txer struct {
+	pipeliner
+	ctx    context.Context
+	client *Client
+	conn   redis.Conn
+	keys   []string // 事务涉及的 key，集群模式下用于选择节点并校验是否属于同一个 slot
+}
+
+func (t *txer) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if err := t.connect(keysOf(commandName, args)); err != nil {
+		return nil, err
+	}
+	return do(t.ctx, t.conn, t.client.opts.readTimeout, commandName, args...)
+}
+
+// 记录事务涉及的 key，首次调用时获取 key 所在节点的连接
+func (t *txer) connect(keys []string) error {
+	t.keys = append(t.keys, keys...)
+	if t.client.cluster != nil {
+		if _, err := hashSlot(t.keys); err != nil {
+			return err
+		}
+	}
+	if t.conn != nil {
+		return nil
+	}
+	conn, err := t.client.getConn(t.ctx, t.keys)
+	if err != nil {
+		return err
+	}
+	t.conn = conn
+	return nil
+}
+
+// Tx 通过 MULTI/EXEC 原子执行 fn 中追加的指令，返回的结果与指令一一对应，单条指令执行失败时对应位置的结果为 redis.Error.
+// 指定 watchKeys 时会在调用 fn 之前 WATCH 这些 key 实现乐观锁，key 在提交之前被修改时返回 ErrTxAborted，由调用方决定是否重试.
+// fn 返回错误或者没有追加指令时不会提交事务；指令入队失败（如指令名或参数个数错误）时通过 DISCARD 放弃事务并返回错误.
+// 集群模式下事务中的全部 key（包括 watchKeys）必须位于同一个 slot，否则返回 ErrCrossSlot，可以通过 {hash tag} 保证.
+func (c *Client) Tx(ctx context.Context, fn func(tx Txer) error, watchKeys ...string) ([]interface{}, error) {
+	tx := txer{ctx: ctx, client: c}
+	defer func() {
+		if tx.conn != nil {
+			tx.conn.Close()
+		}
+	}()
+
+	if len(watchKeys) > 0 {
+		if _, err := tx.Do("WATCH", keyArgs(watchKeys[0], watchKeys[1:])...); err != nil {
+			return nil, err
+		}
+	}
+	unwatch := func() {
+		if len(watchKeys) > 0 {
+			_, _ = tx.conn.Do("UNWATCH")
+		}
+	}
+
+	if err := fn(&tx); err != nil || len(tx.cmds) == 0 {
+		unwatch()
+		return nil, err
+	}
+	var keys []string
+	for _, cmd := range tx.cmds {
+		keys = append(keys, keysOf(cmd.name, cmd.args)...)
+	}
+	if err := tx.connect(keys); err != nil {
+		unwatch()
+		return nil, err
+	}
+	return exec(ctx, tx.conn, c.opts.readTimeout, tx.cmds)
+}
+
+func exec(ctx context.Context, conn redis.Conn, readTimeout time.Duration, cmds []command) ([]interface{}, error) {
+	queued, err := pipeline(ctx, conn, readTimeout, append([]command{{name: "MULTI"}}, cmds...))
+	if err != nil {
+		return nil, err
+	}
+	for i, reply := range queued {
+		if err, ok := reply.(redis.Error); ok {
+			_, _ = do(ctx, conn, readTimeout, "DISCARD")
+			if i == 0 {
+				return nil, err
+			}
+			return nil, fmt.Errorf("queue command %s, err: %w", cmds[i-1].name, err)
+		}
+	}
+
+	replies, err := redis.Values(do(ctx, conn, readTimeout, "EXEC"))
+	if errors.Is(err, redis.ErrNil) {
+		return nil, ErrTxAborted
+	}
+	return replies, err
+}
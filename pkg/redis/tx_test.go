@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func Test_tx(t *testing.T) {
+	ctx := context.Background()
+	db := newMemDB()
+	c := newTestClient(db)
+
+	// EXEC 的结果与指令一一对应，单条指令执行失败不影响其他指令
+	replies, err := c.Tx(ctx, func(tx Txer) error {
+		tx.Send("SET", "state", "done")
+		tx.Send("SADD", "state", "x")
+		tx.Send("ZREM", "tasks", "task1")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || replies[0] != "OK" || replies[2] != int64(0) {
+		t.Errorf("got replies: %v", replies)
+	}
+	if _, ok := replies[1].(redis.Error); !ok {
+		t.Errorf("got reply: %v, want redis.Error", replies[1])
+	}
+
+	// 指令入队失败时放弃整个事务
+	if _, err := c.Tx(ctx, func(tx Txer) error {
+		tx.Send("SET", "state", "discarded")
+		tx.Send("NOSUCHCOMMAND")
+		return nil
+	}); err == nil {
+		t.Error("got nil err, want queue error")
+	}
+	if v, _ := redis.String(c.do(ctx, "GET", "state")); v != "done" {
+		t.Errorf("got state: %s, want done", v)
+	}
+
+	// fn 返回错误时不提交事务
+	wantErr := errors.New("build failed")
+	if _, err := c.Tx(ctx, func(tx Txer) error {
+		tx.Send("SET", "state", "failed")
+		return wantErr
+	}, "state"); err != wantErr {
+		t.Errorf("got err: %v, want: %v", err, wantErr)
+	}
+
+	// WATCH 之后读取数据，读取与提交之间 key 被其他连接修改时放弃事务
+	for _, modify := range []bool{false, true} {
+		_, err := c.Tx(ctx, func(tx Txer) error {
+			n, err := redis.Int64(tx.Do("GET", "version"))
+			if err != nil && !errors.Is(err, ErrNil) {
+				return err
+			}
+			if modify {
+				if _, err := c.IncrBy(ctx, "version", 1); err != nil {
+					return err
+				}
+			}
+			tx.Send("SET", "version", n+10)
+			return nil
+		}, "version")
+		if modify && !errors.Is(err, ErrTxAborted) || !modify && err != nil {
+			t.Errorf("modify: %v, got err: %v", modify, err)
+		}
+	}
+	if n, _ := c.IncrBy(ctx, "version", 0); n != 11 {
+		t.Errorf("got version: %d, want 11", n)
+	}
+}
@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// NewClientFromURL 通过 URL 创建客户端，格式为 redis://[[user]:password@]host[:port][/db]，
+// 采用 rediss:// 时通过 TLS 连接. 端口缺省为 6379，db 缺省为 0. opts 中的配置优先于 URL 中的配置.
+// 与 NewClient 相同，创建时校验配置并通过 PING 建立一次连接.
+func NewClientFromURL(rawURL string, opts ...ClientOption) (*Client, error) {
+	options, err := parseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(options, opts...)
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func parseURL(rawURL string) (*ClientOptions, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url, err: %w", err)
+	}
+
+	options := ClientOptions{network: "tcp"}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		options.useTLS = true
+	default:
+		return nil, fmt.Errorf("invalid redis url scheme %q, want redis or rediss", u.Scheme)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = "6379"
+	}
+	if host == "" {
+		return nil, errors.New("invalid redis url, host is empty")
+	}
+	options.address = net.JoinHostPort(host, port)
+
+	if u.User != nil {
+		// 只有一段认证信息时视为密码，兼容 redis://password@host 的写法
+		if password, ok := u.User.Password(); ok {
+			options.username, options.password = u.User.Username(), password
+		} else {
+			options.password = u.User.Username()
+		}
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if options.database, err = strconv.Atoi(db); err != nil || options.database < 0 {
+			return nil, fmt.Errorf("invalid redis url database %q", db)
+		}
+	}
+	return &options, nil
+}
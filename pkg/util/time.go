@@ -3,13 +3,37 @@ package util
 import "time"
 
 const (
-	YYYY_MM_DD_HH_MM = "2006-01-02-15:04"
+	YYYY_MM_DD_HH_MM    = "2006-01-02-15:04"
+	YYYY_MM_DD_HH_MM_SS = "2006-01-02-15:04:05"
 )
 
+// GetTimeMinuteStr 按照 t 自身的时区格式化到分钟，需要与时区无关的结果时先通过 t.In 转换.
 func GetTimeMinuteStr(t time.Time) string {
 	return t.Format(YYYY_MM_DD_HH_MM)
 }
 
+func GetTimeSecondStr(t time.Time) string {
+	return t.Format(YYYY_MM_DD_HH_MM_SS)
+}
+
 func GetTimeSecond(t time.Time) time.Time {
 	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local)
 }
+
+func ParseTimeMinuteStr(s string) (time.Time, error) {
+	return ParseTimeMinuteStrInLocation(s, time.Local)
+}
+
+func ParseTimeSecondStr(s string) (time.Time, error) {
+	return ParseTimeSecondStrInLocation(s, time.Local)
+}
+
+// ParseTimeMinuteStrInLocation 按照 loc 时区解析 GetTimeMinuteStr 格式化的时刻.
+func ParseTimeMinuteStrInLocation(s string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(YYYY_MM_DD_HH_MM, s, loc)
+}
+
+// ParseTimeSecondStrInLocation 按照 loc 时区解析 GetTimeSecondStr 格式化的时刻.
+func ParseTimeSecondStrInLocation(s string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(YYYY_MM_DD_HH_MM_SS, s, loc)
+}
@@ -11,19 +11,69 @@ package timewheel
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/demdxx/gocast"
 
+	"github.com/xiaoxuxiansheng/timewheel/pkg/cron"
 	thttp "github.com/xiaoxuxiansheng/timewheel/pkg/http"
 	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
 	"github.com/xiaoxuxiansheng/timewheel/pkg/util"
 )
 
+// 删除集合在任务所属时间片结束之后额外保留的时长
+const deleteSetSlack = 120 * time.Second
+
+// 批量删除时每次 lua 调用至多写入的唯一键数量，避免 unpack 超出 lua 栈的上限
+const removeTasksBatchSize = 1000
+
+// 回调请求体的编码方式
+const (
+	// BodyTypeJSON 将 Req 序列化为 json
+	BodyTypeJSON = "json"
+	// BodyTypeForm 将 FormValues 编码为 application/x-www-form-urlencoded
+	BodyTypeForm = "form"
+	// BodyTypeRaw 原样发送 RawBody
+	BodyTypeRaw = "raw"
+)
+
+var (
+	// ErrExecuteAtInPast 任务的执行时刻早于当前扫描窗口
+	ErrExecuteAtInPast = errors.New("execute at in past")
+	// ErrTaskNotFound 任务不存在，可能已经执行或者被删除
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskAlreadyExecuted 任务的执行时刻已经过去，并且已经被取出执行
+	ErrTaskAlreadyExecuted = errors.New("task already executed")
+	// ErrTaskExpired 任务的延迟超过了允许的最大延迟
+	ErrTaskExpired = errors.New("task expired")
+	// ErrInvalidTaskKey 任务的唯一键过长或者包含无法编码的字符
+	ErrInvalidTaskKey = errors.New("invalid task key")
+	// ErrPayloadTooLarge 任务序列化之后的大小超过了 WithMaxPayloadBytes 设置的上限
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrVersionConflict 任务当前的版本号与期望的版本号不一致，说明任务已经被并发修改
+	ErrVersionConflict = errors.New("version conflict")
+	// ErrTaskExists 唯一键对应的任务处于等待状态，AddTaskNX 不会覆盖已存在的任务
+	ErrTaskExists = errors.New("task exists")
+)
+
+// TaskHandle 添加任务的结果. Key 与 ExecuteAt 可以直接用于 RemoveTask，Key 可以直接用于 GetTask、RescheduleTask 以及 RemoveTaskByKey.
+type TaskHandle struct {
+	Key       string    // 任务的唯一键，添加时传入空字符串则自动生成
+	ExecuteAt time.Time // 任务实际的执行时刻，已经校正到扫描窗口并且叠加了抖动
+	Slice     string    // 任务所在的时间片，即 TaskStore 中使用的时间片表达式
+}
+
 type RTaskElement struct {
 	Key string `json:"key"`
 
@@ -31,181 +81,2197 @@ type RTaskElement struct {
 	Method      string            `json:"method"`
 	Req         interface{}       `json:"req"`
 	Header      map[string]string `json:"header"`
+
+	BodyType   string            `json:"body_type,omitempty"`   // 请求体的编码方式，为空时使用 BodyTypeJSON
+	FormValues map[string]string `json:"form_values,omitempty"` // BodyTypeForm 时的表单参数，取代 Req
+	RawBody    []byte            `json:"raw_body,omitempty"`    // BodyTypeRaw 时原样发送的请求体，取代 Req. Content-Type 通过 Header 设置，默认为 application/octet-stream
+
+	Topic      string `json:"topic,omitempty"`       // 任务到期时投递消息的 kafka topic，设置后不再发起 http 回调，需要通过 WithKafkaProducer 设置生产者
+	MessageKey string `json:"message_key,omitempty"` // 投递 kafka 消息时使用的 key
+
+	HandlerName string `json:"handler_name,omitempty"` // 本地处理函数的名称，设置后不再发起 http 回调，而是调用通过 RegisterHandler 注册的同名处理函数
+
+	ExecutorName string `json:"executor_name,omitempty"` // 执行器的名称，设置后使用通过 WithNamedExecutor 注册的同名执行器执行任务
+
+	Interval       time.Duration `json:"interval,omitempty"`        // 周期任务的重复执行间隔，为 0 时表示一次性任务
+	MaxOccurrences int           `json:"max_occurrences,omitempty"` // 周期任务的最大执行次数，为 0 时表示不限次数
+	Occurrences    int           `json:"occurrences,omitempty"`     // 周期任务已经执行的次数，由时间轮内部维护
+
+	CronSpec string `json:"cron_spec,omitempty"` // 5 段式 cron 表达式，通过 AddCronTask 设置
+	Location string `json:"location,omitempty"`  // 计算 cron 执行时刻采用的时区，如 Asia/Shanghai，为空时使用本地时区
+
+	MaxRetries  int           `json:"max_retries,omitempty"`  // 执行失败后的最大重试次数，为 0 时表示不重试
+	BackoffBase time.Duration `json:"backoff_base,omitempty"` // 重试退避基数，第 n 次重试的延迟为 BackoffBase * 2^(n-1)，为 0 时使用 DefaultBackoffBase
+	Attempt     int           `json:"attempt,omitempty"`      // 已经发起的重试次数，由时间轮内部维护，添加任务时必须为 0. 回调请求的 X-Timewheel-Attempt 为 Attempt+1
+
+	RetryableStatusCodes []int `json:"retryable_status_codes,omitempty"` // 允许重试的回调响应状态码，为空时使用时间轮的默认值
+
+	FirstScheduledAt time.Time `json:"first_scheduled_at,omitempty"` // 重试或者因限流延后的任务对应的首次执行时刻，由时间轮内部维护
+
+	MaxStaleness time.Duration `json:"max_staleness,omitempty"` // 任务允许的最大延迟，执行时已经晚于执行时刻超过该时长的任务不再执行，为 0 时使用时间轮的默认值
+
+	Priority int `json:"priority,omitempty"` // 任务优先级，同一批次内优先级高的任务先派发执行. 重试以及周期任务的后续执行沿用相同的优先级
+
+	JitterSeconds int `json:"jitter_seconds,omitempty"` // 执行时刻的随机抖动上限，任务实际在 [executeAt, executeAt+JitterSeconds] 范围内均匀分布，为 0 时使用时间轮的默认值
+	JitterOffset  int `json:"jitter_offset,omitempty"`  // 本次执行实际叠加的抖动秒数，由时间轮内部维护
+
+	TraceCarrier map[string]string `json:"trace_carrier,omitempty"` // 添加任务时的链路信息，开启链路追踪时由时间轮内部维护
+
+	Tags []string `json:"tags,omitempty"` // 任务的标签，可以通过 RemoveByTag 按照标签批量删除任务
+
+	ResultURL string `json:"result_url,omitempty"` // 任务执行成功或者最终失败后，以 POST 方式投递 TaskResult 的 http url，投递失败不影响任务自身的状态
+
+	// 调用执行器之前写入执行标识，同一次执行的重复副本（租约回收、重叠窗口、重复写入的重试）不会再次执行.
+	// 写入执行标识之后、调用之前进程崩溃时任务不会再被执行，即以可能丢失执行为代价避免重复执行，参见 WithDuplicateHook
+	ExactlyOnce bool `json:"exactly_once,omitempty"`
+
+	scheduledAt time.Time // 任务在 zset 中的 score 对应的执行时刻，检索任务时回填，不参与序列化
+	leaseKey    string    // 租约模式下，任务所在的 in-flight zset
+	leaseMember string    // 租约模式下，任务在 in-flight zset 中的成员
+	firedAt     time.Time // 最后一次发起执行的时刻
+	late        bool      // 任务是否由启动回填取出
+	version     int64     // 任务的版本号，GetTask 查询时回填
+	overflow    bool      // 任务是否位于长期 zset 中，GetTask 查询时回填
+	// 周期任务的下一次执行是否已经在任务移出 zset 之前写入
+	nextScheduled bool
+}
+
+// ScheduledAt 返回任务的执行时刻，即任务在 zset 中的 score. 只有从时间轮中查询得到的任务才会回填该值.
+func (t *RTaskElement) ScheduledAt() time.Time {
+	return t.scheduledAt
+}
+
+// Late 返回任务是否由启动回填取出，即任务在时间轮停机期间到期，启动之后才得以执行. 参见 WithStartupLookback.
+func (t *RTaskElement) Late() bool {
+	return t.late
+}
+
+// Version 返回任务的版本号，每次添加、更新、迁移任务时递增. 只有通过 GetTask 查询得到的任务才会回填该值，
+// 可以作为 UpdateTask、RescheduleTaskIfVersion 期望的版本号，实现读取-修改-写入的乐观并发控制.
+func (t *RTaskElement) Version() int64 {
+	return t.version
+}
+
+// IdempotencyKey 返回任务本次执行的幂等键，由任务唯一键以及首次执行时刻组成，同一次执行的所有重试都具有相同的幂等键.
+func (t *RTaskElement) IdempotencyKey() string {
+	return fmt.Sprintf("%s:%d", t.Key, t.firstScheduledAt().Unix())
+}
+
+// 任务本次执行的首次执行时刻，重试以及因限流延后的任务沿用最初的执行时刻
+func (t *RTaskElement) firstScheduledAt() time.Time {
+	if t.FirstScheduledAt.IsZero() {
+		return t.scheduledAt
+	}
+	return t.FirstScheduledAt
 }
 
 type RTimeWheel struct {
-	sync.Once // 用于保证 stopc 只被关闭一次
+	opts *RTimeWheelOptions
 
 	redisClient *redis.Client // 定时任务的存储是基于 redis zset 实现的
+	store       TaskStore     // 等待执行的任务的存储，默认基于 redisClient 实现
 	httpClient  *thttp.Client // 定时任务执行时，是通过请求使用方预留回调地址的方式实现的
 
-	stopc  chan struct{} // 用于停止时间轮的控制器 channel
-	ticker *time.Ticker  // 触发定时扫描任务的定时器
+	ctx     context.Context    // 时间轮的根 context，内部发起的 redis 以及 http 请求均派生自该 context
+	stop    context.CancelFunc // 停止 run 协程，每次 Start 重新创建
+	ticker  *time.Ticker       // 触发定时扫描任务的定时器
+	runDone chan struct{}      // run 协程退出时关闭
+	done    chan struct{}      // run 协程退出并且正在进行的扫描以及任务执行全部完成时关闭，每次 Start 重新创建
+	err     error              // run 协程异常退出的原因
+	wg      sync.WaitGroup     // 追踪正在进行的扫描以及任务执行，用于优雅退出
+
+	wakeMu sync.Mutex    // 保护 wakeAt
+	wakeAt time.Time     // 需要提前扫描到的最晚执行时刻
+	wakeC  chan struct{} // 添加即将到期的任务时通知 run 协程提前扫描
+
+	batchSem chan struct{} // 限制同时进行的批次数量
+	scanSem  chan struct{} // 采用 ScanOverlapSkip 时，保证同一时刻只有一次扫描
+
+	limiter      *tokenBucket            // 全局的回调限流，未开启时为 nil
+	hostLimiters map[string]*tokenBucket // 按照回调地址主机名的限流
+
+	breakersMu sync.Mutex                 // 保护 breakers
+	breakers   map[string]*circuitBreaker // 开启熔断时，每个回调主机的熔断器
+
+	hostSlotsMu sync.Mutex           // 保护 hostSlots
+	hostSlots   map[string]*hostSlot // 开启单主机并发限制时，每个回调主机的并发名额
+
+	pending *pendingLimiter // 开启背压时，进程内已经取出、尚未执行完成的任务数量，未开启时为 nil
+
+	poolMu sync.Mutex  // 保护 pool
+	pool   *workerPool // 设置并发上限时，运行期间执行任务的协程池
+
+	handlersMu sync.RWMutex       // 保护 handlers
+	handlers   map[string]Handler // 通过 RegisterHandler 注册的本地处理函数
+
+	prefetchMu      sync.Mutex               // 保护 prefetchTimers 以及 prefetchStopped
+	prefetchTimers  map[*time.Timer]struct{} // 预取模式下尚未触发的本地定时器
+	prefetchStopped bool                     // 时间轮停止后不再设置本地定时器
+
+	retryMu     sync.Mutex    // 保护 retryBuffer
+	retryBuffer []*retryEntry // 重新入队失败的任务，等待 redis 恢复后再次写入
+
+	addBufferMu sync.Mutex    // 保护 addBuffer 以及 flushing
+	addBuffer   []*retryEntry // 开启本地写缓冲时，因 redis 连接异常而暂存的新任务
+	flushing    bool          // 是否有协程正在重新写入 addBuffer
+
+	mu       sync.Mutex // 保护时间轮的运行以及暂停状态
+	started  bool       // 时间轮是否处于运行状态
+	paused   bool       // 时间轮是否处于暂停状态
+	pausedAt time.Time  // 时间轮暂停的时刻
+	leader   bool       // 开启 leader 选举时，当前实例是否为 leader
+	released bool       // 采用 WithOwnedClient 时，Stop 之后 redis 客户端即将关闭或者已经关闭
+
+	lastScanAt time.Time // 最近一次成功完成扫描的时刻，受 mu 保护
+
+	shardMu     sync.Mutex // 保护 ownedShards
+	ownedShards []int      // 开启分片时，根据心跳分配给当前实例的分片
+}
+
+func NewRTimeWheel(redisClient *redis.Client, httpClient *thttp.Client, opts ...RTimeWheelOption) *RTimeWheel {
+	return NewRTimeWheelWithContext(context.Background(), redisClient, httpClient, opts...)
 }
 
-func NewRTimeWheel(redisClient *redis.Client, httpClient *thttp.Client) *RTimeWheel {
+// NewRTimeWheelWithContext 创建以 ctx 为根 context 的时间轮，时间轮内部发起的 redis 以及 http 请求均派生自 ctx.
+// ctx 取消后时间轮自动停止：不再扫描任务，正在执行的任务随 ctx 一同取消，run 协程等待这些任务退出后结束，
+// 此时调用 Shutdown 即可等待时间轮完全退出. ctx 取消之后时间轮无法再次 Start.
+func NewRTimeWheelWithContext(ctx context.Context, redisClient *redis.Client, httpClient *thttp.Client, opts ...RTimeWheelOption) *RTimeWheel {
 	r := RTimeWheel{
+		ctx:         ctx,
+		opts:        &RTimeWheelOptions{},
 		redisClient: redisClient,
 		httpClient:  httpClient,
-		stopc:       make(chan struct{}),
-		ticker:      time.NewTicker(time.Second),
 	}
 
-	go r.run()
+	for _, opt := range opts {
+		opt(r.opts)
+	}
+
+	repairRTimeWheel(r.opts)
+
+	r.store = r.opts.taskStore
+	if r.store == nil {
+		r.store = &redisTaskStore{client: redisClient, opts: r.opts}
+	}
+	if redisClient != nil {
+		redisClient.OnRetry(func(commandName string, attempt int, err error) {
+			r.opts.metrics.IncRedisRetries()
+		})
+	}
+	if httpClient != nil {
+		httpClient.OnRetry(func(req *thttp.Request, attempt int, err error) {
+			r.opts.metrics.IncHTTPRetries()
+		})
+	}
+	r.wakeC = make(chan struct{}, 1)
+	r.batchSem = make(chan struct{}, r.opts.maxConcurrentBatches)
+	r.scanSem = make(chan struct{}, 1)
+	if r.opts.rateLimit.RPS > 0 {
+		r.limiter = newTokenBucket(r.opts.rateLimit)
+	}
+	r.hostLimiters = newHostLimiters(r.opts.hostRateLimits)
+	r.breakers = make(map[string]*circuitBreaker)
+	r.hostSlots = make(map[string]*hostSlot)
+	if r.opts.maxPendingTasks > 0 {
+		r.pending = newPendingLimiter(r.opts.maxPendingTasks)
+	}
+	// 尚未启动的时间轮视为已经退出
+	r.done = make(chan struct{})
+	close(r.done)
 	return &r
 }
 
-func (r *RTimeWheel) Stop() {
-	r.Do(func() {
-		close(r.stopc)
-		r.ticker.Stop()
-	})
-}
+// Start 启动时间轮. 启动前会检查 redis 的连通性，时间轮已经处于运行状态时返回错误.
+// 时间轮 Stop 之后可以再次 Start.
+func (r *RTimeWheel) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started {
+		return errors.New("time wheel already started")
+	}
+	if err := r.ctx.Err(); err != nil {
+		return fmt.Errorf("time wheel context done, err: %w", err)
+	}
+	if err := r.checkKeyPrefix(); err != nil {
+		return err
+	}
+	if r.opts.leaseDuration > 0 {
+		if err := r.checkRedisStore(); err != nil {
+			return fmt.Errorf("lease mode requires redis task store, err: %w", err)
+		}
+	}
 
-func (r *RTimeWheel) AddTask(ctx context.Context, key string, task *RTaskElement, executeAt time.Time) error {
-	if err := r.addTaskPrecheck(task); err != nil {
+	if r.redisClient == nil {
+		return errors.New("redis client is nil, check the error returned by redis.NewClient")
+	}
+	if r.released {
+		return fmt.Errorf("time wheel stopped with owned redis client, err: %w", redis.ErrClosed)
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+	if err := r.redisClient.Ping(ctx); err != nil {
+		return fmt.Errorf("redis unreachable, err: %w", err)
+	}
+	r.detectRedisVersion(ctx)
+	if err := r.registerSliceGranularity(ctx); err != nil {
+		return err
+	}
+	if err := r.registerShardCount(ctx); err != nil {
+		return err
+	}
+	if err := r.registerBucketCount(ctx); err != nil {
 		return err
 	}
 
-	task.Key = key
-	taskBody, _ := json.Marshal(task)
-	_, err := r.redisClient.Eval(ctx, LuaAddTasks, 2, []interface{}{
-		// 分钟级 zset 时间片
-		r.getMinuteSlice(executeAt),
-		// 标识任务删除的集合
-		r.getDeleteSetKey(executeAt),
-		// 以执行时刻的秒级时间戳作为 zset 中的 score
-		executeAt.Unix(),
-		// 任务明细
-		string(taskBody),
-		// 任务 key，用于存放在删除集合中
-		key,
-	})
-	return err
+	r.launch()
+	return nil
 }
 
-// 将定时任务追加到分钟级的已删除任务 set 中. 之后在检索定时任务时，会根据这个 set 对定时任务进行过滤，实现惰性删除机制
-func (r *RTimeWheel) RemoveTask(ctx context.Context, key string, executeAt time.Time) error {
-	// 标识任务已被删除
-	_, err := r.redisClient.Eval(ctx, LuaDeleteTask, 1, []interface{}{
-		r.getDeleteSetKey(executeAt),
-		key,
-	})
-	return err
+// 启动 run 协程，调用方需要持有 mu
+func (r *RTimeWheel) launch() {
+	r.started = true
+	r.resetPrefetch()
+	runCtx, stop := context.WithCancel(r.ctx)
+	r.stop = stop
+	r.runDone = make(chan struct{})
+	r.done = make(chan struct{})
+	r.err = nil
+	r.lastScanAt = time.Now()
+	r.ticker = time.NewTicker(r.opts.tickInterval)
+	stopPool := r.startWorkerPool()
+	go r.run(runCtx, r.ticker, r.runDone)
+	go func(runDone, done chan struct{}) {
+		<-runDone
+		r.wg.Wait()
+		stopPool()
+		r.closeClient()
+		close(done)
+	}(r.runDone, r.done)
 }
 
-func (r *RTimeWheel) run() {
-	for {
-		select {
-		case <-r.stopc:
-			return
-		case <-r.ticker.C:
-			// 每次 tick 获取任务
-			go r.executeTasks()
-		}
+// Running 返回时间轮是否处于运行状态. 调用 Stop、根 context 取消或者 run 协程异常退出之后返回 false.
+func (r *RTimeWheel) Running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.started
+}
+
+// Done 返回本次运行完全退出时关闭的 channel：run 协程已经退出，并且正在进行的扫描以及任务执行全部完成.
+// 每次 Start 都会创建新的 channel，尚未启动的时间轮返回已经关闭的 channel.
+func (r *RTimeWheel) Done() <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.done
+}
+
+// Err 返回 run 协程异常退出的原因，如根 context 取消或者 run 协程发生 panic. 通过 Stop 停止或者仍在运行时返回 nil.
+func (r *RTimeWheel) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// run 协程异常退出，记录原因并停止时间轮
+func (r *RTimeWheel) exitWithErr(err error) {
+	r.mu.Lock()
+	r.err = err
+	r.mu.Unlock()
+	r.Stop()
+}
+
+// Stop 停止时间轮，不会等待正在执行的任务. 时间轮未运行时调用 Stop 不会产生任何效果.
+// 预取模式下尚未到期的任务不再触发，租约到期后由其他实例接管.
+// 与根 context 取消不同，Stop 只停止 run 协程，正在执行的任务不会被取消.
+func (r *RTimeWheel) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.started {
+		return
+	}
+	r.started = false
+	r.stop()
+	r.ticker.Stop()
+	r.stopPrefetch()
+	if r.opts.ownedClient {
+		r.released = true
+		runDone := r.runDone
+		go func() {
+			<-runDone
+			r.wg.Wait()
+			r.closeClient()
+		}()
 	}
 }
 
-func (r *RTimeWheel) executeTasks() {
-	defer func() {
-		if err := recover(); err != nil {
-			// log
+// 采用 WithOwnedClient 时关闭 redis 客户端，重复关闭是安全的
+func (r *RTimeWheel) closeClient() {
+	if !r.opts.ownedClient || r.redisClient == nil {
+		return
+	}
+	if err := r.redisClient.Close(); err != nil {
+		r.opts.logger.Warn(r.ctx, "close redis client failed", "err", err)
+	}
+}
+
+// Shutdown 停止时间轮，并等待正在进行的扫描以及已经取出的任务执行完成.
+// ctx 到期时不再等待，返回 ctx 的错误，此时仍未执行完成的任务会在各自批次的超时时间内继续执行.
+func (r *RTimeWheel) Shutdown(ctx context.Context) error {
+	r.mu.Lock()
+	runDone := r.runDone
+	r.mu.Unlock()
+	r.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		// run 协程退出后不会再有新的扫描发起，此时等待 wg 是安全的
+		if runDone != nil {
+			<-runDone
 		}
+		r.wg.Wait()
+		close(done)
 	}()
 
-	// 并发控制，保证 30 s 之内完成该批次全量任务的执行，及时回收 goroutine，避免发生 goroutine 泄漏
-	tctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
-	defer cancel()
-	// 根据当前时间条件扫描 redis zset，获取所有满足执行条件的定时任务
-	tasks, err := r.getExecutableTasks(tctx)
-	if err != nil {
-		// log
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 异步执行 fn，并纳入 wg 的追踪
+func (r *RTimeWheel) goTracked(fn func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		fn()
+	}()
+}
+
+// Pause 暂停时间轮. 暂停期间不再扫描 redis，任务保留在 zset 中，不会丢失.
+func (r *RTimeWheel) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
 		return
 	}
+	r.paused = true
+	r.pausedAt = time.Now()
+}
 
-	// 并发执行任务，通过 waitGroup 进行聚合收口
-	var wg sync.WaitGroup
-	for _, task := range tasks {
-		wg.Add(1)
-		// shadow
-		task := task
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-				}
-				wg.Done()
-			}()
-			// 执行定时任务
-			if err := r.executeTask(tctx, task); err != nil {
-				// log
-			}
-		}()
+// Resume 恢复时间轮，并对暂停期间到期的任务执行一次补偿扫描.
+func (r *RTimeWheel) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.paused {
+		return
 	}
-	wg.Wait()
+	r.paused = false
+	r.lastScanAt = time.Now()
+	pausedAt := r.pausedAt
+	r.goTracked(func() { r.catchUpSince(pausedAt) })
 }
 
-func (r *RTimeWheel) executeTask(ctx context.Context, task *RTaskElement) error {
-	return r.httpClient.JSONDo(ctx, task.Method, task.CallbackURL, task.Header, task.Req, nil)
+// IsPaused 返回时间轮是否处于暂停状态.
+func (r *RTimeWheel) IsPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
 }
 
-func (r *RTimeWheel) addTaskPrecheck(task *RTaskElement) error {
-	if task.Method != http.MethodGet && task.Method != http.MethodPost {
-		return fmt.Errorf("invalid method: %s", task.Method)
+// AddTask 添加定时任务，返回定位任务的 TaskHandle. key 为空字符串时自动生成 UUID 作为唯一键.
+// 执行时刻落在当前扫描窗口之内时，当前实例会立即提前扫描一次，任务无需等待下一次 tick 即可执行.
+func (r *RTimeWheel) AddTask(ctx context.Context, key string, task *RTaskElement, executeAt time.Time) (TaskHandle, error) {
+	return r.addTaskWithKey(ctx, key, task, time.Now(), executeAt, false)
+}
+
+// AddTaskAfter 添加在 delay 之后执行的定时任务，返回任务实际的执行时刻，可以直接用于 RemoveTask.
+// 当前时刻与扫描使用的时钟一致，开启 WithRedisTime 时以 redis 服务端的时刻为准. delay 不大于 0 时与 AddTask 校正执行时刻的方式相同：
+// 落在当前扫描窗口之内时顺延到下一个窗口；早于当前窗口时默认返回 ErrExecuteAtInPast，开启 WithExecutePastImmediately 后同样顺延.
+func (r *RTimeWheel) AddTaskAfter(ctx context.Context, key string, task *RTaskElement, delay time.Duration) (time.Time, error) {
+	now := r.clockNow(time.Now())
+	handle, err := r.addTaskWithKey(ctx, key, task, now, now.Add(delay), false)
+	return handle.ExecuteAt, err
+}
+
+// AddTaskNX 与 AddTask 相同，但唯一键对应的任务处于等待状态时不会重复添加，而是返回 ErrTaskExists.
+// 唯一键通过索引占用，任务添加失败时释放. 为了保证占用的结果可靠，redis 不可用时不会写入本地缓冲.
+func (r *RTimeWheel) AddTaskNX(ctx context.Context, key string, task *RTaskElement, executeAt time.Time) (TaskHandle, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return TaskHandle{}, err
+	}
+	return r.addTaskWithKey(ctx, key, task, time.Now(), executeAt, true)
+}
+
+func (r *RTimeWheel) addTaskWithKey(ctx context.Context, key string, task *RTaskElement, now, executeAt time.Time, nx bool) (TaskHandle, error) {
+	if key == "" {
+		key = util.NewUUID()
+	}
+	task.Key = key
+	if err := r.addTaskPrecheck(task); err != nil {
+		return TaskHandle{}, err
+	}
+
+	resolvedAt, err := r.resolveExecuteAt(now, executeAt)
+	if err != nil {
+		return TaskHandle{}, err
+	}
+
+	// 执行时刻落在当前窗口之内的任务被顺延到了下一个窗口，需要提前扫描，而不是等待下一次 tick
+	deferred := resolvedAt.After(executeAt)
+
+	r.injectTrace(ctx, task)
+	executeAt = r.applyJitter(task, resolvedAt)
+	if nx {
+		if err := r.reserveTaskKey(ctx, key, executeAt); err != nil {
+			return TaskHandle{}, err
+		}
+	}
+	if err := r.addTask(ctx, task, executeAt); err == nil {
+		r.publishEvent(ctx, key, TaskEventAdded, executeAt)
+		if deferred && task.JitterOffset == 0 {
+			r.wakeScanner(executeAt)
+		}
+	} else if nx {
+		_ = r.cleanIndex(ctx, map[string]int64{key: executeAt.Unix()})
+		return TaskHandle{}, err
+	} else if !r.bufferAdd(ctx, task, executeAt, err) {
+		return TaskHandle{}, err
+	}
+	return TaskHandle{Key: key, ExecuteAt: executeAt, Slice: r.getTaskSliceStr(key, executeAt)}, nil
+}
+
+// 通过索引占用任务唯一键. 唯一键已有索引时，根据 zset 确认任务是否仍处于等待状态：任务存在，
+// 或者索引尚未写入版本号（其他调用方占用之后正在添加）并且执行时刻尚未到来时，返回 ErrTaskExists；
+// 否则说明任务取出之后索引清理失败，清理失效的索引之后重新占用
+func (r *RTimeWheel) reserveTaskKey(ctx context.Context, key string, executeAt time.Time) error {
+	for i := 0; i < 2; i++ {
+		reserved, err := r.reserveIndex(ctx, key, executeAt.Unix())
+		if err != nil || reserved {
+			return err
+		}
+		score, version, err := r.getIndexVersion(ctx, key)
+		if errors.Is(err, ErrTaskNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, _, err := r.GetTask(ctx, key); err == nil {
+			return ErrTaskExists
+		} else if !errors.Is(err, ErrTaskNotFound) {
+			return err
+		}
+		if version == 0 && time.Unix(score, 0).After(time.Now()) {
+			return ErrTaskExists
+		}
+		if err := r.cleanIndex(ctx, map[string]int64{key: score}); err != nil {
+			return err
+		}
+	}
+	return ErrTaskExists
+}
+
+// 校验任务的唯一键. 非 json 格式的任务明细以 2 字节记录唯一键的长度，lua 脚本通过 cjson 解析 json 格式的任务明细，
+// 因此唯一键不能超过 maxKeyLength 并且必须是合法的 utf8 字符串，否则删除以及索引无法匹配到任务.
+// 唯一键同样不能包含 {} 以及换行：唯一键会写入删除集合等位置，一旦出现在 key 名称中，{} 会改变 redis cluster 的 hash tag，
+// 导致同一个 lua 脚本访问的 key 分布在不同的节点上
+func (r *RTimeWheel) checkTaskKey(key string) error {
+	if len(key) > r.opts.maxKeyLength {
+		return fmt.Errorf("%w: length %d exceeds %d", ErrInvalidTaskKey, len(key), r.opts.maxKeyLength)
 	}
-	if !strings.HasPrefix(task.CallbackURL, "http://") && !strings.HasPrefix(task.CallbackURL, "https://") {
-		return fmt.Errorf("invalid url: %s", task.CallbackURL)
+	if !utf8.ValidString(key) {
+		return fmt.Errorf("%w: invalid utf8 %q", ErrInvalidTaskKey, key)
+	}
+	if strings.ContainsAny(key, "{}\r\n") {
+		return fmt.Errorf("%w: %q contains braces or newlines", ErrInvalidTaskKey, key)
 	}
 	return nil
 }
 
-// !检索定时任务
-func (r *RTimeWheel) getExecutableTasks(ctx context.Context) ([]*RTaskElement, error) {
-	now := time.Now()
-	minuteSlice := r.getMinuteSlice(now)
-	deleteSetKey := r.getDeleteSetKey(now)
-	nowSecond := util.GetTimeSecond(now)
-	score1 := nowSecond.Unix()
-	score2 := nowSecond.Add(time.Second).Unix()
-	rawReply, err := r.redisClient.Eval(ctx, LuaZrangeTasks, 2, []interface{}{
-		minuteSlice, deleteSetKey, score1, score2,
-	})
+// 为执行时刻叠加 [0, jitter] 秒的随机抖动，并将抖动记录在任务中
+func (r *RTimeWheel) applyJitter(task *RTaskElement, executeAt time.Time) time.Time {
+	return r.applyJitterN(task, executeAt, rand.Intn)
+}
+
+// 与 applyJitter 相同，抖动由 intn 在 [0, jitter] 范围内选取
+func (r *RTimeWheel) applyJitterN(task *RTaskElement, executeAt time.Time, intn func(n int) int) time.Time {
+	jitter := task.JitterSeconds
+	if jitter == 0 {
+		jitter = int(r.opts.jitter / time.Second)
+	}
+	task.JitterOffset = 0
+	if jitter > 0 {
+		task.JitterOffset = intn(jitter + 1)
+	}
+	return executeAt.Add(time.Duration(task.JitterOffset) * time.Second)
+}
+
+// 扣除抖动之后的原始执行时刻
+func (t *RTaskElement) unjitteredAt() time.Time {
+	return t.scheduledAt.Add(-time.Duration(t.JitterOffset) * time.Second)
+}
+
+// 校正任务的执行时刻.
+// 当前扫描窗口可能已经扫描完成，因此执行时刻落在当前窗口内的任务会被调整到下一个窗口的起点，保证能够被执行；
+// 执行时刻早于当前窗口的任务，默认返回 ErrExecuteAtInPast，开启 WithExecutePastImmediately 后同样调整到下一个窗口的起点.
+func (r *RTimeWheel) resolveExecuteAt(now, executeAt time.Time) (time.Time, error) {
+	if start, _ := r.getScanWindow(now); executeAt.Before(start) && !r.opts.executePastImmediately {
+		return time.Time{}, fmt.Errorf("%w: %v", ErrExecuteAtInPast, executeAt)
+	}
+	return r.notBeforeNextWindow(now, executeAt), nil
+}
+
+// 将 score 落在已经扫描或者正在扫描的窗口内的执行时刻调整到下一个窗口的起点.
+// score 为秒级时间戳，亚秒级的扫描间隔下窗口 [start, end) 实际扫描的 score 为 [ceil(start), ceil(end))，
+// 因此以执行时刻的 score 与 ceil(end) 比较，调整后的执行时刻同样向上取整到秒
+func (r *RTimeWheel) notBeforeNextWindow(now, executeAt time.Time) time.Time {
+	_, end := r.getScanWindow(now)
+	if next := ceilSeconds(end); executeAt.Unix() < next {
+		return time.Unix(next, 0).In(end.Location())
+	}
+	return executeAt
+}
+
+func (r *RTimeWheel) addTask(ctx context.Context, task *RTaskElement, executeAt time.Time) error {
+	taskBody, err := r.encodeTask(task)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// 任务明细每次扫描都会完整地从 redis 取回并反序列化，过大的任务会拖慢整个批次
+	r.opts.metrics.ObservePayloadSize(len(taskBody))
+	if len(taskBody) > r.opts.maxPayloadBytes {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrPayloadTooLarge, len(taskBody), r.opts.maxPayloadBytes)
+	}
+	if err := r.addTaskBody(ctx, task.Key, string(taskBody), executeAt); err != nil {
+		return err
+	}
+	r.opts.metrics.IncTasksAdded()
+	r.opts.metrics.AddPendingTasks(1)
+	return r.tagTask(ctx, task, executeAt)
+}
+
+func (r *RTimeWheel) addTaskBody(ctx context.Context, key, taskBody string, executeAt time.Time) error {
+	if err := r.storeTaskBody(ctx, key, []byte(taskBody), executeAt); err != nil {
+		return err
 	}
+	return r.setIndex(ctx, key, executeAt.Unix())
+}
 
-	replies := gocast.ToInterfaceSlice(rawReply) // 0: 已删除任务集合，1: 定时任务明细
-	if len(replies) == 0 {
-		return nil, fmt.Errorf("invalid replies: %v", replies)
+// 以执行时刻的秒级时间戳作为 score 写入任务，执行时刻晚于晋升 horizon 的任务写入长期 zset
+func (r *RTimeWheel) storeTaskBody(ctx context.Context, key string, taskBody []byte, executeAt time.Time) error {
+	if r.isOverflow(executeAt) {
+		return r.addOverflowTask(ctx, key, taskBody, executeAt, true)
 	}
+	return r.store.Add(ctx, r.getTaskSliceStr(key, executeAt), executeAt.Unix(), taskBody, key)
+}
 
-	deleteds := gocast.ToStringSlice(replies[0])
-	deletedSet := make(map[string]struct{}, len(deleteds))
-	for _, deleted := range deleteds {
-		deletedSet[deleted] = struct{}{}
+// AddCronTask 按照 cron 表达式添加周期任务. 任务的时区通过 task.Location 指定.
+// 每次执行时都会根据表达式计算下一次的执行时刻，并调度到对应的分钟级 zset 中.
+func (r *RTimeWheel) AddCronTask(ctx context.Context, key string, task *RTaskElement, spec string) (TaskHandle, error) {
+	task.CronSpec = spec
+	schedule, err := task.cronSchedule()
+	if err != nil {
+		return TaskHandle{}, err
 	}
 
-	tasks := make([]*RTaskElement, 0, len(replies)-1)
-	for i := 1; i < len(replies); i++ {
-		var task RTaskElement
-		if err := json.Unmarshal([]byte(gocast.ToString(replies[i])), &task); err != nil {
-			// log
-			continue
+	executeAt := schedule.Next(time.Now())
+	if executeAt.IsZero() {
+		return TaskHandle{}, fmt.Errorf("cron spec %q never fires", spec)
+	}
+	return r.AddTask(ctx, key, task, executeAt)
+}
+
+// 将定时任务追加到分钟级的已删除任务 set 中. 之后在检索定时任务时，会根据这个 set 对定时任务进行过滤，实现惰性删除机制
+// 写入删除标识的同时会检查任务是否仍处于等待状态：返回 nil 说明本次删除确实阻止了任务的执行；
+// 任务此前已经被删除或者从未添加时返回 ErrTaskNotFound；执行时刻已经过去并且任务已被取出时返回 ErrTaskAlreadyExecuted.
+//
+// 对于周期任务，下一次执行只会在本次执行被检索到时才会调度，因此删除当前处于等待状态的那一次执行即可终止后续所有周期.
+// executeAt 早于当前处于等待状态的那一次执行时（如首次添加时的执行时刻），通过唯一键索引找到当前的那一次执行并将其删除.
+// 也可以提前删除未来某一次的执行，此时虽然返回 ErrTaskNotFound，但删除标识依然生效，周期任务重新调度时不会清除已存在的删除标识.
+func (r *RTimeWheel) RemoveTask(ctx context.Context, key string, executeAt time.Time) error {
+	if err := r.checkTaskKey(key); err != nil {
+		return err
+	}
+	// 索引指向同一个分钟级时间片时，使用索引中的 score 加速检索
+	score := executeAt.Unix()
+	indexed, err := r.getIndex(ctx, key)
+	if err != nil && !errors.Is(err, ErrTaskNotFound) {
+		return err
+	}
+	sameSlice := err == nil && r.getMinuteSlice(time.Unix(indexed, 0)) == r.getMinuteSlice(executeAt)
+	if sameSlice {
+		score = indexed
+	}
+	// 任务带有抖动时，实际的执行时刻晚于 executeAt，可能落在之后的时间片中，此时根据任务记录的抖动还原原始执行时刻进行匹配；
+	// 周期任务当前处于等待状态的执行晚于 executeAt 时，删除当前的那一次执行，从而终止整个周期
+	if err == nil && !sameSlice && indexed > executeAt.Unix() {
+		if task, jitteredAt, err := r.GetTask(ctx, key); err == nil &&
+			(task.isRecurring() || r.getMinuteSlice(task.unjitteredAt()) == r.getMinuteSlice(executeAt)) {
+			executeAt, score, sameSlice = jitteredAt, indexed, true
+		}
+	}
+
+	// 标识任务已被删除
+	err = r.store.MarkDeleted(ctx, r.getTaskSliceStr(key, executeAt), key, score)
+	if errors.Is(err, ErrTaskAlreadyExecuted) && r.isPrefetchable(executeAt, time.Now()) {
+		// 任务可能已经被预取，删除标识会在本地触发执行之前生效
+		err = nil
+	}
+	if errors.Is(err, ErrTaskAlreadyExecuted) && r.overflowMode() {
+		// 任务可能仍位于长期 zset 中. 删除标识保留在时间片中，即便任务在移除之前已经被晋升，删除依然生效
+		taken, takeErr := r.getOverflowTask(ctx, key, score, true)
+		if takeErr != nil {
+			return takeErr
 		}
+		if taken != nil {
+			err = nil
+		}
+	}
+	if errors.Is(err, ErrTaskAlreadyExecuted) && !executeAt.Before(time.Now()) {
+		// 执行时刻尚未到来，说明任务从未添加到该时间片中
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+	r.opts.metrics.IncTasksRemoved(1)
+	r.publishEvent(ctx, key, TaskEventRemoved, executeAt)
+
+	// 索引指向同一个分钟级时间片时，一并清理索引
+	if !sameSlice {
+		return nil
+	}
+	return r.cleanIndex(ctx, map[string]int64{key: indexed})
+}
+
+// RemoveTaskByKey 只根据唯一键删除处于等待状态的任务，任务所在的分钟级时间片通过唯一键索引获取.
+// 索引不存在时返回 ErrTaskNotFound，其余返回值与 RemoveTask 保持一致.
+func (r *RTimeWheel) RemoveTaskByKey(ctx context.Context, key string) error {
+	score, err := r.getIndex(ctx, key)
+	if err != nil {
+		return err
+	}
+	// 索引与 zset 无法在同一个 lua 脚本中操作，读取索引之后任务可能已被取出，此时由 RemoveTask 根据 zset 的实际状态返回结果
+	return r.RemoveTask(ctx, key, time.Unix(score, 0))
+}
 
-		if _, ok := deletedSet[task.Key]; ok {
+// KeyWithTime 标识一个待删除的任务.
+type KeyWithTime struct {
+	Key       string
+	ExecuteAt time.Time
+}
+
+// RemoveTasks 批量删除任务. 任务按照分钟级时间片分组，每个时间片只需要执行一次 lua 脚本.
+// 返回新增的删除标识数量，重复的任务以及此前已经被删除的任务不会被计入.
+func (r *RTimeWheel) RemoveTasks(ctx context.Context, items []KeyWithTime) (int, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if err := r.checkTaskKey(item.Key); err != nil {
+			return 0, err
+		}
+	}
+	now := time.Now()
+	deleteSetKeyToArgs := make(map[string][]interface{})
+	seen := make(map[[2]string]struct{}, len(items))
+	for _, item := range items {
+		deleteSetKey := r.getTaskDeleteSetKey(item.Key, item.ExecuteAt)
+		if _, ok := seen[[2]string{deleteSetKey, item.Key}]; ok {
 			continue
 		}
-		tasks = append(tasks, &task)
+		seen[[2]string{deleteSetKey, item.Key}] = struct{}{}
+		if _, ok := deleteSetKeyToArgs[deleteSetKey]; !ok {
+			deleteSetKeyToArgs[deleteSetKey] = []interface{}{deleteSetKey, r.getDeleteSetExpireSeconds(now, item.ExecuteAt)}
+		}
+		deleteSetKeyToArgs[deleteSetKey] = append(deleteSetKeyToArgs[deleteSetKey], item.Key)
 	}
 
-	return tasks, nil
+	var removed int
+	for _, args := range deleteSetKeyToArgs {
+		// 同一个时间片内的唯一键过多时拆分为多次调用，每次调用至多写入 removeTasksBatchSize 个
+		head, keys := args[:2], args[2:]
+		for len(keys) > 0 {
+			n := len(keys)
+			if n > removeTasksBatchSize {
+				n = removeTasksBatchSize
+			}
+			reply, err := r.redisClient.Eval(ctx, LuaDeleteTasks, 1, append(append([]interface{}{}, head...), keys[:n]...))
+			if err != nil {
+				r.opts.metrics.IncTasksRemoved(removed)
+				return removed, err
+			}
+			removed += gocast.ToInt(reply)
+			keys = keys[n:]
+		}
+	}
+	r.opts.metrics.IncTasksRemoved(removed)
+	return removed, nil
 }
 
-// 通过以分钟级表达式作为 {hash_tag} 的方式，确保 minuteSlice 和 deleteSet 一定会分发到相同的 redis 节点之上，进一步保证 lua 脚本的原子性能够生效
-func (r *RTimeWheel) getMinuteSlice(executeAt time.Time) string {
-	return fmt.Sprintf("xiaoxu_timewheel_task_{%s}", util.GetTimeMinuteStr(executeAt))
+// GetTask 根据唯一键查询处于等待状态的任务及其执行时刻. 任务已经执行或者被删除时，返回 ErrTaskNotFound.
+// 任务当前的版本号可以通过 Version 获取.
+func (r *RTimeWheel) GetTask(ctx context.Context, key string) (*RTaskElement, time.Time, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return nil, time.Time{}, err
+	}
+	score, version, err := r.getIndexVersion(ctx, key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	executeAt := time.Unix(score, 0)
+	// 晋升时先写入时间片、后从长期 zset 中移除，因此先查询长期 zset，再查询时间片，不会错过正在晋升的任务
+	var body []byte
+	if r.overflowMode() {
+		if body, err = r.getOverflowTask(ctx, key, score, false); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	overflow := body != nil
+	if !overflow {
+		reply, err := r.redisClient.EvalRetryable(ctx, LuaGetTask, 2, []interface{}{
+			r.getTaskZsetKey(key, executeAt),
+			r.getTaskDeleteSetKey(key, executeAt),
+			score,
+			key,
+		})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		if reply == nil {
+			return nil, time.Time{}, ErrTaskNotFound
+		}
+		body = []byte(gocast.ToString(reply))
+	}
+
+	task, err := r.decodeTask(body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	task.scheduledAt = executeAt
+	task.version = version
+	task.overflow = overflow
+	return task, executeAt, nil
 }
 
-func (r *RTimeWheel) getDeleteSetKey(executeAt time.Time) string {
-	return fmt.Sprintf("xiaoxu_timewheel_delset_{%s}", util.GetTimeMinuteStr(executeAt))
+// RescheduleTask 将任务迁移到新的执行时刻. 任务已经执行或者被删除时，返回 ErrTaskNotFound.
+// 任务带有抖动时，新的执行时刻会叠加相同的抖动.
+//
+// 任务当前所在的位置通过唯一键索引获取. 新旧执行时刻处于同一个分钟级时间片时，迁移在一个 lua 脚本中原子完成；
+// 否则新旧 zset 可能分布在 redis cluster 的不同节点上，迁移会拆分为先取出、后添加两步，添加失败时会将任务放回原处.
+func (r *RTimeWheel) RescheduleTask(ctx context.Context, key string, newExecuteAt time.Time) error {
+	_, err := r.RescheduleTaskIfVersion(ctx, key, newExecuteAt, 0)
+	return err
+}
+
+// RescheduleTaskIfVersion 与 RescheduleTask 相同，但只有任务当前的版本号等于 expectedVersion 时才会迁移，否则返回 ErrVersionConflict.
+// expectedVersion 为 0 时不校验版本号. 迁移成功后返回任务新的版本号.
+func (r *RTimeWheel) RescheduleTaskIfVersion(ctx context.Context, key string, newExecuteAt time.Time, expectedVersion int64) (int64, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return 0, err
+	}
+	newExecuteAt, err := r.resolveExecuteAt(time.Now(), newExecuteAt)
+	if err != nil {
+		return 0, err
+	}
+
+	task, executeAt, err := r.GetTask(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if expectedVersion > 0 && task.version != expectedVersion {
+		return 0, fmt.Errorf("%w: expected version %d, current %d", ErrVersionConflict, expectedVersion, task.version)
+	}
+	score := executeAt.Unix()
+	newExecuteAt = newExecuteAt.Add(time.Duration(task.JitterOffset) * time.Second)
+
+	// 先递增版本号，并发的修改中只有一个能够通过校验
+	version, err := r.bumpVersion(ctx, key, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+
+	if task.overflow {
+		if newExecuteAt.Unix() == score {
+			return version, nil
+		}
+		// 晋升标识写入失败说明任务已经晋升到时间片中，按照时间片中的任务迁移
+		claimed, err := r.claimPromotion(ctx, key, score)
+		if err != nil {
+			return 0, err
+		}
+		if claimed {
+			return version, r.rescheduleOverflowTask(ctx, key, score, newExecuteAt)
+		}
+	}
+
+	if r.getMinuteSlice(executeAt) == r.getMinuteSlice(newExecuteAt) {
+		reply, err := r.redisClient.Eval(ctx, LuaMoveTask, 2, []interface{}{
+			r.getTaskZsetKey(key, executeAt),
+			r.getTaskDeleteSetKey(key, executeAt),
+			score,
+			key,
+			newExecuteAt.Unix(),
+		})
+		if err != nil {
+			return 0, err
+		}
+		if reply == nil {
+			_ = r.cleanIndex(ctx, map[string]int64{key: score})
+			return 0, ErrTaskNotFound
+		}
+		return version, r.moveIndex(ctx, key, newExecuteAt.Unix())
+	}
+
+	reply, err := r.redisClient.Eval(ctx, LuaTakeTask, 2, []interface{}{
+		r.getTaskZsetKey(key, executeAt),
+		r.getTaskDeleteSetKey(key, executeAt),
+		score,
+		key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		_ = r.cleanIndex(ctx, map[string]int64{key: score})
+		return 0, ErrTaskNotFound
+	}
+
+	taskBody := []byte(gocast.ToString(reply))
+	if err := r.moveTaskBody(ctx, key, taskBody, newExecuteAt); err != nil {
+		// 添加到新位置失败，将任务放回原处
+		if restoreErr := r.moveTaskBody(ctx, key, taskBody, executeAt); restoreErr != nil {
+			return 0, fmt.Errorf("reschedule err: %w, restore err: %v", err, restoreErr)
+		}
+		return 0, err
+	}
+	return version, nil
+}
+
+// UpdateTask 以 task 替换处于等待状态的任务明细，执行时刻保持不变. 任务已经执行或者被删除时，返回 ErrTaskNotFound.
+// expectedVersion 大于 0 时，只有任务当前的版本号与之相同才会写入，否则返回 ErrVersionConflict；为 0 时不校验版本号.
+// 写入成功后返回任务新的版本号. 周期任务的执行次数、重试次数以及抖动等由时间轮内部维护的字段沿用原任务.
+func (r *RTimeWheel) UpdateTask(ctx context.Context, key string, task *RTaskElement, expectedVersion int64) (int64, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return 0, err
+	}
+	// task 可能是 GetTask 查询得到的任务，其内部维护的字段在校验时忽略
+	next := *task
+	next.Key = key
+	next.Occurrences = 0
+	if err := r.addTaskPrecheck(&next); err != nil {
+		return 0, err
+	}
+
+	current, executeAt, err := r.GetTask(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if expectedVersion > 0 && current.version != expectedVersion {
+		return 0, fmt.Errorf("%w: expected version %d, current %d", ErrVersionConflict, expectedVersion, current.version)
+	}
+	next.Occurrences = current.Occurrences
+	next.Attempt = current.Attempt
+	next.FirstScheduledAt = current.FirstScheduledAt
+	next.JitterOffset = current.JitterOffset
+	next.TraceCarrier = current.TraceCarrier
+	taskBody, err := r.encodeTask(&next)
+	if err != nil {
+		return 0, err
+	}
+	r.opts.metrics.ObservePayloadSize(len(taskBody))
+	if len(taskBody) > r.opts.maxPayloadBytes {
+		return 0, fmt.Errorf("%w: %d bytes exceeds %d", ErrPayloadTooLarge, len(taskBody), r.opts.maxPayloadBytes)
+	}
+
+	version, err := r.bumpVersion(ctx, key, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	score := executeAt.Unix()
+	if current.overflow {
+		claimed, err := r.claimPromotion(ctx, key, score)
+		if err != nil {
+			return 0, err
+		}
+		if claimed {
+			return version, r.replaceOverflowTask(ctx, &next, score, taskBody)
+		}
+	}
+	reply, err := r.redisClient.Eval(ctx, LuaReplaceTask, 2, []interface{}{
+		r.getTaskZsetKey(key, executeAt),
+		r.getTaskDeleteSetKey(key, executeAt),
+		score,
+		key,
+		string(taskBody),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		_ = r.cleanIndex(ctx, map[string]int64{key: score})
+		return 0, ErrTaskNotFound
+	}
+	return version, r.tagTask(ctx, &next, executeAt)
+}
+
+// 替换长期 zset 中的任务. 晋升标识已经写入，任务无法再回到长期 zset，替换之后直接写入所在的时间片
+func (r *RTimeWheel) replaceOverflowTask(ctx context.Context, task *RTaskElement, score int64, taskBody []byte) error {
+	old, err := r.getOverflowTask(ctx, task.Key, score, true)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		_ = r.cleanIndex(ctx, map[string]int64{task.Key: score})
+		return ErrTaskNotFound
+	}
+	executeAt := time.Unix(score, 0)
+	if err := r.store.Add(ctx, r.getTaskSliceStr(task.Key, executeAt), score, taskBody, task.Key); err != nil {
+		return err
+	}
+	return r.tagTask(ctx, task, executeAt)
+}
+
+// 迁移长期 zset 中的任务. 晋升标识已经写入，任务无法再回到长期 zset 中原来的 score，添加到新位置失败时放回原本所在的时间片
+func (r *RTimeWheel) rescheduleOverflowTask(ctx context.Context, key string, score int64, newExecuteAt time.Time) error {
+	taskBody, err := r.getOverflowTask(ctx, key, score, true)
+	if err != nil {
+		return err
+	}
+	if taskBody == nil {
+		_ = r.cleanIndex(ctx, map[string]int64{key: score})
+		return ErrTaskNotFound
+	}
+	if err := r.moveTaskBody(ctx, key, taskBody, newExecuteAt); err != nil {
+		if restoreErr := r.store.Add(ctx, r.getTaskSliceStr(key, time.Unix(score, 0)), score, taskBody, key); restoreErr != nil {
+			return fmt.Errorf("reschedule err: %w, restore err: %v", err, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// 将迁移的任务写入新的位置，版本号保持不变
+func (r *RTimeWheel) moveTaskBody(ctx context.Context, key string, taskBody []byte, executeAt time.Time) error {
+	if err := r.storeTaskBody(ctx, key, taskBody, executeAt); err != nil {
+		return err
+	}
+	return r.moveIndex(ctx, key, executeAt.Unix())
+}
+
+// ListPendingTasks 查询执行时刻位于 [from, to) 范围内处于等待状态的任务，最多返回 limit 个，结果按照执行时刻升序排列.
+// 任务的执行时刻可以通过 ScheduledAt 获取. 查询逐个时间片分页进行，不会修改时间轮中的任务.
+func (r *RTimeWheel) ListPendingTasks(ctx context.Context, from, to time.Time, limit int) ([]*RTaskElement, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %d", limit)
+	}
+
+	score1 := formatScore(from)
+	score2 := "(" + formatScore(to)
+	tasks := make([]*RTaskElement, 0, limit)
+	for _, slice := range r.getSlices(from, to) {
+		if len(tasks) >= limit {
+			break
+		}
+		// 开启分桶、分片时逐个查询，合并后按照执行时刻排序
+		var sliceTasks []*RTaskElement
+		for _, sliceStr := range r.getAllSliceStrs(slice) {
+			rawReply, err := r.redisClient.EvalRetryable(ctx, LuaListTasks, 2, []interface{}{
+				sliceTaskKey(r.opts.keyPrefix, sliceStr),
+				sliceDeleteSetKey(r.opts.keyPrefix, sliceStr),
+				score1,
+				score2,
+				limit - len(tasks),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			replies := gocast.ToInterfaceSlice(rawReply)
+			for i := 0; i+1 < len(replies); i += 2 {
+				task, err := r.decodeTask([]byte(gocast.ToString(replies[i])))
+				if err != nil {
+					r.opts.metrics.IncPayloadDecodeErrors()
+					r.opts.logger.Warn(ctx, "decode task failed", "slice", sliceTaskKey(r.opts.keyPrefix, sliceStr), "task", gocast.ToString(replies[i]), "err", err)
+					continue
+				}
+				task.scheduledAt = time.Unix(gocast.ToInt64(replies[i+1]), 0)
+				sliceTasks = append(sliceTasks, task)
+			}
+		}
+		sort.SliceStable(sliceTasks, func(i, j int) bool {
+			return sliceTasks[i].scheduledAt.Before(sliceTasks[j].scheduledAt)
+		})
+		if len(sliceTasks) > limit-len(tasks) {
+			sliceTasks = sliceTasks[:limit-len(tasks)]
+		}
+		tasks = append(tasks, sliceTasks...)
+	}
+	return tasks, nil
+}
+
+func (r *RTimeWheel) run(ctx context.Context, ticker *time.Ticker, runDone chan struct{}) {
+	defer close(runDone)
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			r.handlePanic(recovered, nil)
+			r.exitWithErr(fmt.Errorf("run loop panic: %v", recovered))
+		}
+	}()
+
+	// 开启分片时，启动后立即写入心跳获取负责的分片，之后定期续期，退出时退出成员 hash
+	var heartbeatC <-chan time.Time
+	if r.opts.shardCount > 0 {
+		r.heartbeatShards()
+		defer r.leaveShards()
+		heartbeatTicker := time.NewTicker(r.shardHeartbeatInterval())
+		defer heartbeatTicker.Stop()
+		heartbeatC = heartbeatTicker.C
+	}
+
+	// 开启 leader 选举时，启动后立即竞选，之后定期续约，退出时释放 leader 锁
+	var electC <-chan time.Time
+	if r.opts.leaderLeaseTTL > 0 {
+		r.campaign()
+		defer r.resign()
+		electTicker := time.NewTicker(r.leaderRenewInterval())
+		defer electTicker.Stop()
+		electC = electTicker.C
+	}
+
+	// 开启熔断或者单主机并发限制时，定期清理空闲主机的状态
+	var sweepC <-chan time.Time
+	if r.opts.breakerThreshold > 0 || r.opts.perHostConcurrency > 0 {
+		sweepTicker := time.NewTicker(hostStateSweepInterval)
+		defer sweepTicker.Stop()
+		sweepC = sweepTicker.C
+	}
+
+	// 开启补偿扫描时，启动后立即执行一次，之后每分钟执行一次
+	var catchUpC <-chan time.Time
+	if r.opts.lookback > 0 {
+		catchUpTicker := time.NewTicker(time.Minute)
+		defer catchUpTicker.Stop()
+		catchUpC = catchUpTicker.C
+		r.goTracked(r.catchUp)
+	}
+
+	// 开启启动回填时，在分片分配以及 leader 竞选完成之后执行一次
+	if r.opts.startupLookback > 0 {
+		r.goTracked(r.backfill)
+	}
+
+	// 开启租约模式时，定期回收过期的租约
+	var reapC <-chan time.Time
+	if r.opts.leaseDuration > 0 {
+		reapTicker := time.NewTicker(r.reapInterval())
+		defer reapTicker.Stop()
+		reapC = reapTicker.C
+	}
+
+	// 开启时间片清理时，定期清理超过保留时长的时间片
+	var janitorC <-chan time.Time
+	if r.opts.janitorInterval > 0 {
+		janitorTicker := time.NewTicker(r.opts.janitorInterval)
+		defer janitorTicker.Stop()
+		janitorC = janitorTicker.C
+	}
+
+	// 开启长期任务存储时，启动后立即晋升一次，之后定期晋升
+	var promoteC <-chan time.Time
+	if r.overflowMode() {
+		promoteTicker := time.NewTicker(r.promotionInterval())
+		defer promoteTicker.Stop()
+		promoteC = promoteTicker.C
+		r.goTracked(r.promoteTasks)
+	}
+
+	// 开启预取模式时，记录已经预取到的时刻，每次 tick 从该时刻继续预取
+	var prefetchedUntil time.Time
+	// 已经扫描到的时刻，每次 tick 从该时刻继续扫描
+	var scannedUntil time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			// 根 context 取消时，自行完成停止流程，并等待正在进行的扫描以及任务执行退出
+			if err := r.ctx.Err(); err != nil {
+				r.exitWithErr(err)
+				r.wg.Wait()
+			}
+			return
+		case now := <-ticker.C:
+			r.goTracked(r.flushRetryBuffer)
+			// 开启 leader 选举时，只有 leader 扫描任务
+			if !r.IsLeader() {
+				prefetchedUntil, scannedUntil = time.Time{}, time.Time{}
+				r.markScanned(now)
+				continue
+			}
+			now = r.clockNow(now)
+			// 每次 tick 获取任务. 扫描窗口在 tick 时确定，即便批次需要排队等待，也不会遗漏窗口
+			start, end := r.getScanWindow(now)
+			if r.opts.prefetchWindow > 0 {
+				// 暂停期间到期的任务由恢复时的补偿扫描执行，恢复之后从当前窗口重新开始预取
+				if r.IsPaused() || prefetchedUntil.IsZero() {
+					prefetchedUntil = start
+				}
+				_, end = r.getScanWindow(now.Add(r.opts.prefetchWindow))
+				start, prefetchedUntil = prefetchedUntil, end
+				r.goTracked(func() { r.prefetchTasks(start, end) })
+			} else {
+				scannedUntil = r.scan(scannedUntil, now)
+			}
+		case <-r.wakeC:
+			at := r.takeWakeAt()
+			if r.opts.prefetchWindow > 0 || !r.IsLeader() {
+				continue
+			}
+			scannedUntil = r.scanEarly(scannedUntil, at)
+		case <-catchUpC:
+			r.goTracked(r.catchUp)
+		case <-reapC:
+			r.goTracked(r.reapLeases)
+		case <-janitorC:
+			r.goTracked(r.cleanSlices)
+		case <-sweepC:
+			r.sweepHostState()
+		case <-promoteC:
+			r.goTracked(r.promoteTasks)
+		case <-electC:
+			r.campaign()
+		case <-heartbeatC:
+			r.heartbeatShards()
+		}
+	}
+}
+
+// 发起 now 所处窗口的扫描，返回扫描之后已经扫描到的时刻
+func (r *RTimeWheel) scan(scannedUntil, now time.Time) time.Time {
+	start, end, ok := r.nextScanWindow(scannedUntil, now)
+	if !ok {
+		return scannedUntil
+	}
+	release, ok := r.tryAcquireScan()
+	if !ok {
+		// 上一次扫描尚未完成，本次窗口顺延到下一次扫描
+		r.opts.metrics.IncScansSkipped()
+		return scannedUntil
+	}
+	r.goTracked(func() {
+		defer release()
+		r.executeTasks(r.overlapStart(start), end)
+	})
+	return end
+}
+
+// 通过当前实例添加的任务需要在 at 执行，通知 run 协程提前扫描
+func (r *RTimeWheel) wakeScanner(at time.Time) {
+	r.wakeMu.Lock()
+	if at.After(r.wakeAt) {
+		r.wakeAt = at
+	}
+	r.wakeMu.Unlock()
+	select {
+	case r.wakeC <- struct{}{}:
+	default:
+	}
+}
+
+func (r *RTimeWheel) takeWakeAt() time.Time {
+	r.wakeMu.Lock()
+	defer r.wakeMu.Unlock()
+	at := r.wakeAt
+	r.wakeAt = time.Time{}
+	return at
+}
+
+// 提前扫描到 at 所在的秒，返回扫描之后已经扫描到的时刻. 扫描的起点为已经扫描到的时刻，之后的 tick 从本次的截止时刻继续扫描，
+// 因此同一个 score 范围不会被重复扫描. 尚未进行过 tick 扫描或者 at 已经被扫描过时不做处理
+func (r *RTimeWheel) scanEarly(scannedUntil, at time.Time) time.Time {
+	end := at.Truncate(time.Second).Add(time.Second)
+	if scannedUntil.IsZero() || !end.After(scannedUntil) {
+		return scannedUntil
+	}
+	release, ok := r.tryAcquireScan()
+	if !ok {
+		return scannedUntil
+	}
+	r.goTracked(func() {
+		defer release()
+		r.executeTasks(r.overlapStart(scannedUntil), end)
+	})
+	return end
+}
+
+func (r *RTimeWheel) executeTasks(start, end time.Time) {
+	defer r.recoverPanic(nil)
+
+	if r.IsPaused() {
+		return
+	}
+
+	// 限制同时进行的批次数量，避免批次超时时间较长时，连续的 tick 堆积出大量的批次
+	release := r.acquireBatch()
+	defer release()
+
+	// 并发控制，保证在批次超时时间之内完成该批次全量任务的执行，及时回收 goroutine，避免发生 goroutine 泄漏
+	tctx, cancel := r.newBatchContext()
+	defer cancel()
+	// 根据扫描窗口条件扫描 redis zset，获取所有满足执行条件的定时任务. 检索的 score 范围为左闭右开区间 [start, end)
+	// 时间片粒度小于扫描间隔时，扫描窗口会跨越多个时间片，需要逐个检索
+	// 每一轮从各个仍有剩余任务的时间片中取出一页，直到窗口内的任务全部取出. 取出的任务立即写入 stream 交给派发方执行，
+	// 无需等待整个窗口取出完毕；执行的并发名额耗尽时写入阻塞，暂停取出下一页. 开启背压时每页至多取出空闲名额数量的任务
+	report := ScanReport{WindowStart: start, WindowEnd: end}
+	scanStart := time.Now()
+	stream := make(chan *RTaskElement, r.opts.fetchBatchSize)
+	var pages int
+	go func() {
+		defer close(stream)
+		defer r.recoverPanic(nil)
+
+		// 等待空闲名额直到批次的截止时间临近，剩余的任务交给新的批次
+		waitCtx, cancel := context.WithTimeout(tctx, r.opts.batchTimeout-r.opts.batchTimeout/4)
+		defer cancel()
+		from, to := ceilSeconds(start), ceilSeconds(end)
+		for slices := r.getSlices(start, end); len(slices) > 0; {
+			pages++
+			var (
+				remaining []time.Time
+				scanErrs  int
+				deferred  bool
+			)
+			for i, slice := range slices {
+				limit, ok := r.acquireFetch(waitCtx, slices[i:], from, to)
+				if !ok {
+					remaining, deferred = append(remaining, slices[i:]...), true
+					break
+				}
+				fetchStart := time.Now()
+				tasks, counts, err := r.getExecutableTasksPage(tctx, slice, from, to, limit)
+				report.ScanDuration += time.Since(fetchStart)
+				if err != nil {
+					r.opts.logger.Error(tctx, "scan tasks failed", "slice", r.getMinuteSlice(slice), "start", start, "end", end, "err", err)
+					r.onScanError(tctx, err)
+					scanErrs++
+				} else if counts.more {
+					remaining = append(remaining, slice)
+				}
+				report.Fetched, report.Deleted, report.Malformed = report.Fetched+counts.fetched, report.Deleted+counts.deleted, report.Malformed+counts.malformed
+				r.addPending(len(tasks))
+				// 优先级只在同一页内生效
+				for _, task := range r.orderTasks(tasks) {
+					stream <- task
+				}
+			}
+			report.ScanErrors += scanErrs
+			if scanErrs == 0 {
+				r.markScanned(time.Now())
+			}
+
+			slices = remaining
+			// 批次的截止时间临近时，剩余的任务交给新的批次继续取出，已经取出的任务不会被重复检索
+			if deadline, ok := tctx.Deadline(); len(slices) > 0 && ok && (deferred || time.Until(deadline) < r.opts.batchTimeout/4) {
+				if r.ctx.Err() != nil {
+					return
+				}
+				r.opts.logger.Warn(tctx, "scan window not exhausted before batch deadline, continue in a new batch", "start", start, "end", end, "pages", pages, "deferred", deferred)
+				r.goTracked(func() { r.executeTasks(start, end) })
+				return
+			}
+		}
+	}()
+
+	// stream 关闭之后取出方不再修改 report
+	outcomes := r.dispatchTasks(tctx, stream, func() { r.addPending(-1) })
+	report.Executed, report.Failed, report.Skipped = outcomes.executed, outcomes.failed, outcomes.skipped
+	r.opts.metrics.ObserveScanDuration(report.ScanDuration)
+	r.opts.metrics.ObserveScanPages(pages)
+	report.Duration = time.Since(scanStart)
+	r.onScanComplete(report)
+}
+
+func (r *RTimeWheel) newBatchContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.ctx, r.opts.batchTimeout)
+}
+
+// 采用 ScanOverlapSkip 时，尝试获取扫描名额，上一次扫描尚未完成时返回 false
+func (r *RTimeWheel) tryAcquireScan() (func(), bool) {
+	if r.opts.scanOverlap != ScanOverlapSkip {
+		return func() {}, true
+	}
+	select {
+	case r.scanSem <- struct{}{}:
+		return func() { <-r.scanSem }, true
+	default:
+		return nil, false
+	}
+}
+
+// 获取一个批次的执行名额，名额耗尽时阻塞等待，返回释放名额的函数
+func (r *RTimeWheel) acquireBatch() func() {
+	r.batchSem <- struct{}{}
+	return func() {
+		<-r.batchSem
+	}
+}
+
+// 任务的执行结果，用于汇总扫描报告
+type taskOutcome int
+
+const (
+	taskExecuted taskOutcome = iota
+	taskFailed
+	taskSkipped
+)
+
+// 批次中各个执行结果的任务数量
+type batchOutcomes struct {
+	executed, failed, skipped int
+}
+
+// 执行一批任务，等待全部任务执行完成后返回各个执行结果的数量
+func (r *RTimeWheel) executeBatch(tctx context.Context, tasks []*RTaskElement) batchOutcomes {
+	stream := make(chan *RTaskElement, len(tasks))
+	for _, task := range r.orderTasks(tasks) {
+		stream <- task
+	}
+	close(stream)
+	return r.dispatchTasks(tctx, stream, func() {})
+}
+
+// 按照优先级从高到低派发任务，相同优先级的任务保持检索时的顺序
+func (r *RTimeWheel) orderTasks(tasks []*RTaskElement) []*RTaskElement {
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].Priority > tasks[j].Priority
+	})
+	// 开启单主机并发限制时，相同优先级的任务按照回调主机轮流派发，避免单个主机的任务集中在批次的前部
+	if r.opts.perHostConcurrency > 0 {
+		tasks = interleaveByHost(tasks, r.concurrencyHost)
+	}
+	return tasks
+}
+
+// 派发 stream 中的任务，直到 stream 关闭并且全部任务执行完成，返回各个执行结果的数量. 每个任务结束时调用一次 finished.
+// 并发名额耗尽时阻塞在派发上，不再读取 stream，写入方随之阻塞，从而形成背压
+func (r *RTimeWheel) dispatchTasks(tctx context.Context, stream <-chan *RTaskElement, finished func()) batchOutcomes {
+	// 时间轮运行时由协程池执行任务，并发数量由全部批次共享；否则限制批次内并发执行的任务数量
+	pool := r.workerPool()
+	var sem chan struct{}
+	if pool == nil && r.opts.maxConcurrency > 0 {
+		sem = make(chan struct{}, r.opts.maxConcurrency)
+	}
+
+	// 并发执行任务，通过 waitGroup 进行聚合收口
+	var (
+		wg        sync.WaitGroup
+		outcomeMu sync.Mutex
+		outcomes  batchOutcomes
+	)
+	record := func(outcome taskOutcome) {
+		defer finished()
+		outcomeMu.Lock()
+		defer outcomeMu.Unlock()
+		switch outcome {
+		case taskExecuted:
+			outcomes.executed++
+		case taskFailed:
+			outcomes.failed++
+		default:
+			outcomes.skipped++
+		}
+	}
+	timeout := func(task *RTaskElement, releaseHost func()) {
+		releaseHost()
+		r.dispatchTimeout(tctx, task)
+		record(taskFailed)
+	}
+	dispatch := func(task *RTaskElement, releaseHost func()) {
+		run := func() {
+			outcome := taskFailed
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					r.handlePanic(recovered, task)
+				}
+				record(outcome)
+				releaseHost()
+				wg.Done()
+			}()
+			outcome = r.runTask(tctx, task)
+		}
+
+		wg.Add(1)
+		if pool != nil {
+			// 协程池已经销毁时退化为单独的协程
+			err := pool.submit(tctx, run)
+			if err == nil {
+				return
+			}
+			if err != errPoolStopped {
+				wg.Done()
+				timeout(task, releaseHost)
+				return
+			}
+		}
+		if sem == nil {
+			go run()
+			return
+		}
+		select {
+		case sem <- struct{}{}:
+			go func() {
+				defer func() { <-sem }()
+				run()
+			}()
+		case <-tctx.Done():
+			wg.Done()
+			timeout(task, releaseHost)
+		}
+	}
+
+	// 回调主机的并发名额已满时，该主机后续的任务排在已经派发的任务之后，由单独的协程等待名额，批次继续派发其他主机的任务.
+	// 排队的任务数量与 stream 的容量一致，排满时同样阻塞派发
+	queueSize := cap(stream)
+	if queueSize == 0 {
+		queueSize = 1
+	}
+	queues := make(map[string]chan *RTaskElement)
+	for task := range stream {
+		host := r.concurrencyHost(task)
+		if host == "" {
+			dispatch(task, func() {})
+			continue
+		}
+		if queue, ok := queues[host]; ok {
+			queue <- task
+			continue
+		}
+		if release, ok := r.tryAcquireHost(host); ok {
+			dispatch(task, release)
+			continue
+		}
+
+		queue := make(chan *RTaskElement, queueSize)
+		queue <- task
+		queues[host] = queue
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			for task := range queue {
+				release, ok := r.acquireHost(tctx, host)
+				if !ok {
+					r.dispatchTimeout(tctx, task)
+					record(taskFailed)
+					continue
+				}
+				dispatch(task, release)
+			}
+		}(host)
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return outcomes
+}
+
+// 批次超时前未能派发的任务按照执行失败处理
+func (r *RTimeWheel) dispatchTimeout(tctx context.Context, task *RTaskElement) {
+	r.opts.metrics.IncTasksFailed()
+	r.opts.logger.Warn(tctx, "dispatch task timeout", "key", task.Key, "callback_url", task.CallbackURL, "err", tctx.Err())
+	r.onFailure(tctx, task, tctx.Err())
+	r.handleFailure(task, tctx.Err())
+}
+
+func (r *RTimeWheel) runTask(tctx context.Context, task *RTaskElement) taskOutcome {
+	// 周期任务的下一次执行通常在任务移出 zset 之前已经写入. 租约模式、自定义 TaskStore 以及提前调度失败时，在执行前完成下一次的调度，
+	// 避免执行过程中宕机导致后续周期丢失
+	if !task.nextScheduled {
+		if err := r.scheduleNextOccurrence(tctx, task); err != nil {
+			r.opts.logger.Error(tctx, "schedule next occurrence failed", "key", task.Key, "scheduled_at", task.scheduledAt, "err", err)
+		}
+	}
+	// 执行定时任务. 过期的任务不再执行，交给过期回调处理
+	outcome := taskSkipped
+	if r.isStale(task, time.Now()) {
+		r.handleExpired(task)
+	} else if circuit, wait, ok := r.allowCircuit(task); !ok {
+		// 回调主机处于熔断状态，不发起请求
+		r.handleCircuitOpen(task, wait)
+	} else if wait, ok := r.waitRateLimit(tctx, task); !ok {
+		// 批次超时之前无法获取令牌，延后执行
+		circuit.cancel()
+		r.deferTask(task, wait, "rate limited")
+	} else if claimed, err := r.claimExecution(tctx, task); !claimed {
+		// 执行标识已经存在或者写入失败，均不调用执行器
+		circuit.cancel()
+		if err == nil {
+			r.handleDuplicate(tctx, task)
+		} else {
+			err = fmt.Errorf("claim execution, err: %w", err)
+			r.opts.metrics.IncTasksFailed()
+			r.opts.logger.Warn(tctx, "claim execution failed", "key", task.Key, "scheduled_at", task.scheduledAt, "err", err)
+			r.onFailure(tctx, task, err)
+			r.handleFailure(task, err)
+			outcome = taskFailed
+		}
+	} else {
+		r.opts.metrics.ObserveRateLimitWait(wait)
+		start := time.Now()
+		task.firedAt = start
+		r.opts.metrics.AddInflightExecutions(1)
+		err := r.withRetryAfter(r.executeTask(tctx, task))
+		circuit.done(err)
+		r.opts.metrics.AddInflightExecutions(-1)
+		r.opts.metrics.ObserveCallbackLatency(time.Since(start))
+		r.recordHistory(task, time.Now(), err)
+		if err != nil {
+			r.opts.metrics.IncTasksFailed()
+			r.opts.logger.Warn(tctx, "execute task failed", "key", task.Key, "slice", r.getMinuteSlice(task.scheduledAt),
+				"callback_url", task.CallbackURL, "attempt", task.Attempt+1, "status_code", thttp.StatusCode(err), "err", err)
+			r.onFailure(tctx, task, err)
+			r.handleFailure(task, err)
+			outcome = taskFailed
+		} else {
+			r.opts.metrics.IncTasksExecuted()
+			r.onSuccess(tctx, task, time.Since(start))
+			r.reportResult(task, nil)
+			outcome = taskExecuted
+		}
+	}
+	// 任务已执行完成（失败的任务已经重新入队或者交给失败回调），确认租约
+	r.ackTask(task)
+	return outcome
+}
+
+func (r *RTimeWheel) executeTask(ctx context.Context, task *RTaskElement) (err error) {
+	// 依次合并默认 header、任务自身的 header，后者优先. header 名称不区分大小写，合并时统一转换为规范格式
+	var defaultHeaders map[string]string
+	if r.opts.defaultHeaders != nil {
+		defaultHeaders = r.opts.defaultHeaders(ctx)
+	}
+	header := make(map[string]string, len(defaultHeaders)+len(task.Header)+6)
+	for k, v := range defaultHeaders {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range task.Header {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	header[http.CanonicalHeaderKey(r.opts.idempotencyKeyHeader)] = task.IdempotencyKey()
+	header[http.CanonicalHeaderKey(r.opts.attemptHeader)] = strconv.Itoa(task.Attempt + 1)
+	header[http.CanonicalHeaderKey(r.opts.keyHeader)] = task.Key
+	header[http.CanonicalHeaderKey(r.opts.scheduledAtHeader)] = task.scheduledAt.Format(time.RFC3339)
+	header[http.CanonicalHeaderKey(r.opts.firedAtHeader)] = time.Now().Format(time.RFC3339)
+
+	// 开启链路追踪时，在任务执行的 span 下发起回调请求，并通过 header 透传链路信息
+	if r.opts.tracing != nil {
+		var end func(err error)
+		ctx, end = r.opts.tracing.StartExecute(ctx, task)
+		defer func() { end(err) }()
+		r.opts.tracing.Inject(ctx, header)
+	}
+
+	executor, err := r.executorFor(task)
+	if err != nil {
+		return err
+	}
+	if r.opts.dryRunSink != nil {
+		executor = dryRunExecutor{sink: r.opts.dryRunSink}
+	}
+	if len(r.opts.signingSecrets) > 0 {
+		// 请求体的编码是确定的，签名的内容与执行器实际发送的请求体一致
+		body, _, err := task.EncodeBody()
+		if err != nil {
+			return err
+		}
+		header[http.CanonicalHeaderKey(r.opts.signatureHeader)] = signWebhook(body, time.Now(), r.opts.signingSecrets)
+	}
+
+	// 执行器收到的是任务的副本，附加的 header 不会影响重试以及周期任务写回 redis 的内容
+	execTask := *task
+	execTask.Header = header
+	return executor.Execute(ctx, &execTask)
+}
+
+// EncodeBody 按照 BodyType 编码请求体，返回请求体以及对应的 Content-Type. 相同的任务总是得到相同的编码结果.
+func (t *RTaskElement) EncodeBody() ([]byte, string, error) {
+	switch t.BodyType {
+	case BodyTypeForm:
+		values := make(url.Values, len(t.FormValues))
+		for k, v := range t.FormValues {
+			values.Set(k, v)
+		}
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	case BodyTypeRaw:
+		return t.RawBody, "application/octet-stream", nil
+	default:
+		if t.Req == nil {
+			return nil, "application/json", nil
+		}
+		body, err := json.Marshal(t.Req)
+		return body, "application/json", err
+	}
+}
+
+// 为检索到的周期任务调度下一次执行. 在任务移出 zset 之前调用，实例在取出任务之后、执行之前宕机也不会丢失后续的周期.
+// 已经解码的任务记录在 StoredTask 中，调度失败的任务在执行时重新调度
+func (r *RTimeWheel) scheduleNextOccurrences(ctx context.Context, stored []StoredTask) {
+	for i := range stored {
+		st := &stored[i]
+		if st.Deleted {
+			continue
+		}
+		task, err := r.decodeTask(st.Body)
+		if err != nil {
+			continue
+		}
+		st.task = task
+		if !task.isRecurring() {
+			continue
+		}
+		task.scheduledAt = time.Unix(st.Score, 0)
+		if err := r.scheduleNextOccurrence(ctx, task); err != nil {
+			r.opts.logger.Error(ctx, "schedule next occurrence failed", "key", task.Key, "scheduled_at", task.scheduledAt, "err", err)
+			continue
+		}
+		task.nextScheduled = true
+	}
+}
+
+// 为周期任务调度下一次执行. 下一次的执行时刻以本次的 score 为基准，跳过已经错过的周期
+func (r *RTimeWheel) scheduleNextOccurrence(ctx context.Context, task *RTaskElement) error {
+	// 重试以及因限流延后的任务不再重复调度下一次执行
+	if !task.isRecurring() || task.Attempt > 0 || !task.FirstScheduledAt.IsZero() {
+		return nil
+	}
+	if task.MaxOccurrences > 0 && task.Occurrences+1 >= task.MaxOccurrences {
+		return nil
+	}
+
+	now := time.Now()
+	nextExecuteAt, err := task.nextExecuteAt(now)
+	if err != nil {
+		return err
+	}
+	if nextExecuteAt.IsZero() {
+		return nil
+	}
+	// 扫描间隔大于 cron 周期，或者本次执行延迟较大时，下一次执行可能落在当前窗口内
+	nextExecuteAt = r.notBeforeNextWindow(now, nextExecuteAt)
+
+	next := *task
+	next.Occurrences++
+	// 多个实例可能在任务移出 zset 之前同时调度下一次执行，抖动由唯一键以及执行次数确定，各个实例写入相同的成员
+	nextExecuteAt = r.applyJitterN(&next, nextExecuteAt, func(n int) int {
+		h := fnv.New64a()
+		_, _ = fmt.Fprintf(h, "%s:%d", next.Key, next.Occurrences)
+		return int(h.Sum64() % uint64(n))
+	})
+	taskBody, err := r.encodeTask(&next)
+	if err != nil {
+		return err
+	}
+	// 自定义的 TaskStore 不支持提前删除周期任务未来的某一次执行，直接写入下一次执行
+	if r.opts.taskStore != nil {
+		if err := r.store.Add(ctx, r.getTaskSliceStr(task.Key, nextExecuteAt), nextExecuteAt.Unix(), taskBody, task.Key); err != nil {
+			return err
+		}
+		r.opts.metrics.IncTasksAdded()
+		r.opts.metrics.AddPendingTasks(1)
+		if err := r.setIndex(ctx, task.Key, nextExecuteAt.Unix()); err != nil {
+			return err
+		}
+		return r.tagTask(ctx, &next, nextExecuteAt)
+	}
+	// 下一次执行晚于晋升 horizon 时写入长期 zset. 提前删除的标识保留在时间片中，晋升之后生效
+	if r.isOverflow(nextExecuteAt) {
+		if err := r.addOverflowTask(ctx, task.Key, taskBody, nextExecuteAt, false); err != nil {
+			return err
+		}
+		r.opts.metrics.IncTasksAdded()
+		r.opts.metrics.AddPendingTasks(1)
+		if err := r.setIndex(ctx, task.Key, nextExecuteAt.Unix()); err != nil {
+			return err
+		}
+		return r.tagTask(ctx, &next, nextExecuteAt)
+	}
+	reply, err := r.redisClient.Eval(ctx, LuaRepeatTask, 2, []interface{}{
+		r.getTaskZsetKey(task.Key, nextExecuteAt),
+		r.getTaskDeleteSetKey(task.Key, nextExecuteAt),
+		nextExecuteAt.Unix(),
+		string(taskBody),
+		task.Key,
+		r.getSliceExpireSeconds(time.Now(), nextExecuteAt),
+	})
+	if err != nil {
+		return err
+	}
+	// 下一次执行已被提前删除
+	cnt := gocast.ToInt(reply)
+	if cnt < 0 {
+		return nil
+	}
+	// 下一次执行已经由其他实例写入时不重复计数
+	if cnt > 0 {
+		r.opts.metrics.IncTasksAdded()
+		r.opts.metrics.AddPendingTasks(1)
+	}
+	if err := r.setIndex(ctx, task.Key, nextExecuteAt.Unix()); err != nil {
+		return err
+	}
+	return r.tagTask(ctx, &next, nextExecuteAt)
+}
+
+func (t *RTaskElement) isRecurring() bool {
+	return t.Interval > 0 || t.CronSpec != ""
+}
+
+// 计算周期任务晚于 now 的下一次执行时刻
+func (t *RTaskElement) nextExecuteAt(now time.Time) (time.Time, error) {
+	if t.CronSpec != "" {
+		schedule, err := t.cronSchedule()
+		if err != nil {
+			return time.Time{}, err
+		}
+		base := t.unjitteredAt()
+		if now.After(base) {
+			base = now
+		}
+		return schedule.Next(base), nil
+	}
+
+	next := t.unjitteredAt().Add(t.Interval)
+	for !next.After(now) {
+		next = next.Add(t.Interval)
+	}
+	return next, nil
+}
+
+func (t *RTaskElement) cronSchedule() (*cron.Schedule, error) {
+	loc := time.Local
+	if t.Location != "" {
+		var err error
+		if loc, err = time.LoadLocation(t.Location); err != nil {
+			return nil, fmt.Errorf("invalid location: %s, err: %w", t.Location, err)
+		}
+	}
+	return cron.Parse(t.CronSpec, loc)
+}
+
+// key 前缀中不能包含 {}，否则 redis cluster 会以前缀中的内容作为 hash tag，分钟级 zset 与删除集合将无法保证分布在同一个节点上
+func (r *RTimeWheel) checkKeyPrefix() error {
+	if strings.ContainsAny(r.opts.keyPrefix, "{}") {
+		return fmt.Errorf("invalid key prefix: %s", r.opts.keyPrefix)
+	}
+	return nil
+}
+
+func (r *RTimeWheel) addTaskPrecheck(task *RTaskElement) error {
+	if err := r.checkKeyPrefix(); err != nil {
+		return err
+	}
+	if err := r.checkTaskKey(task.Key); err != nil {
+		return err
+	}
+	if err := r.checkTarget(task); err != nil {
+		return err
+	}
+	if err := checkBody(task); err != nil {
+		return err
+	}
+	// score 为秒级时间戳，周期间隔不能小于 1 s；同时不能小于扫描间隔，否则下一次执行总是落在当前窗口内
+	if task.Interval != 0 && (task.Interval < time.Second || task.Interval < r.opts.tickInterval) {
+		return fmt.Errorf("invalid interval: %v", task.Interval)
+	}
+	if task.MaxOccurrences < 0 {
+		return fmt.Errorf("invalid max occurrences: %d", task.MaxOccurrences)
+	}
+	if task.Occurrences != 0 {
+		return fmt.Errorf("invalid occurrences: %d", task.Occurrences)
+	}
+	if task.MaxStaleness < 0 {
+		return fmt.Errorf("invalid max staleness: %v", task.MaxStaleness)
+	}
+	if task.MaxRetries < 0 {
+		return fmt.Errorf("invalid max retries: %d", task.MaxRetries)
+	}
+	if task.BackoffBase < 0 {
+		return fmt.Errorf("invalid backoff base: %v", task.BackoffBase)
+	}
+	for _, code := range task.RetryableStatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid retryable status code: %d", code)
+		}
+	}
+	if task.Attempt != 0 || !task.FirstScheduledAt.IsZero() {
+		return fmt.Errorf("invalid attempt: %d, first scheduled at: %v", task.Attempt, task.FirstScheduledAt)
+	}
+	for _, tag := range task.Tags {
+		if tag == "" {
+			return fmt.Errorf("empty tag")
+		}
+	}
+	if task.ResultURL != "" && !strings.HasPrefix(task.ResultURL, "http://") && !strings.HasPrefix(task.ResultURL, "https://") {
+		return fmt.Errorf("invalid result url: %s", task.ResultURL)
+	}
+	if task.JitterSeconds < 0 || task.JitterOffset != 0 {
+		return fmt.Errorf("invalid jitter seconds: %d, jitter offset: %d", task.JitterSeconds, task.JitterOffset)
+	}
+	if task.CronSpec != "" {
+		if task.Interval != 0 {
+			return fmt.Errorf("interval and cron spec are mutually exclusive")
+		}
+		if _, err := task.cronSchedule(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 校验任务的执行目标：本地处理函数、kafka、具名执行器只能选择其一，均未设置时使用默认执行器
+func (r *RTimeWheel) checkTarget(task *RTaskElement) error {
+	var targets int
+	for _, target := range []string{task.HandlerName, task.Topic, task.ExecutorName} {
+		if target != "" {
+			targets++
+		}
+	}
+	if targets > 1 {
+		return fmt.Errorf("handler name, topic and executor name are mutually exclusive")
+	}
+	if task.MessageKey != "" && task.Topic == "" {
+		return fmt.Errorf("message key requires topic")
+	}
+
+	switch {
+	case task.HandlerName != "":
+		// 处理函数可能注册在其他实例上，添加任务时不做校验
+		if task.CallbackURL != "" || task.Method != "" || (task.BodyType != "" && task.BodyType != BodyTypeJSON) {
+			return fmt.Errorf("handler task only accepts json req")
+		}
+	case task.Topic != "":
+		if r.opts.kafkaProducer == nil {
+			return ErrKafkaProducerNotConfigured
+		}
+		if task.CallbackURL != "" || task.Method != "" {
+			return fmt.Errorf("topic and callback are mutually exclusive")
+		}
+	case task.ExecutorName != "":
+		if _, ok := r.opts.executors[task.ExecutorName]; !ok {
+			return fmt.Errorf("%w: %s", ErrExecutorNotFound, task.ExecutorName)
+		}
+	case r.opts.executor == nil:
+		// 默认的 http 执行器
+		if !r.isAllowedMethod(task.Method) {
+			return fmt.Errorf("invalid method: %s", task.Method)
+		}
+		if !strings.HasPrefix(task.CallbackURL, "http://") && !strings.HasPrefix(task.CallbackURL, "https://") {
+			return fmt.Errorf("invalid url: %s", task.CallbackURL)
+		}
+	}
+	return nil
+}
+
+func (r *RTimeWheel) isAllowedMethod(method string) bool {
+	for _, allowed := range r.opts.allowedMethods {
+		if method == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验请求体的编码方式与请求体是否匹配. GET、HEAD 请求只允许携带 json 请求体，与之前的行为保持一致
+func checkBody(task *RTaskElement) error {
+	switch task.BodyType {
+	case "", BodyTypeJSON:
+		if task.FormValues != nil || task.RawBody != nil {
+			return fmt.Errorf("form values and raw body require body type %s or %s", BodyTypeForm, BodyTypeRaw)
+		}
+		return nil
+	case BodyTypeForm:
+		if task.Req != nil || task.RawBody != nil {
+			return fmt.Errorf("body type %s only accepts form values", task.BodyType)
+		}
+	case BodyTypeRaw:
+		if task.Req != nil || task.FormValues != nil {
+			return fmt.Errorf("body type %s only accepts raw body", task.BodyType)
+		}
+	default:
+		return fmt.Errorf("invalid body type: %s", task.BodyType)
+	}
+	if task.Method == http.MethodGet || task.Method == http.MethodHead {
+		return fmt.Errorf("body type %s is not allowed with method %s", task.BodyType, task.Method)
+	}
+	return nil
+}
+
+// !检索定时任务. 从 slice 所属的分钟级 zset 中取出 score 位于 [score1, score2] 范围内的任务，取出的同时会将其从 zset 中移除
+// 开启分桶、分片时并发检索当前实例负责的全部 zset，部分 zset 检索失败时，仍然返回其余 zset 中取出的任务
+// 从 zset 中取出任务时的计数，用于汇总扫描报告
+type fetchCounts struct {
+	fetched   int  // 取出的成员数量
+	deleted   int  // 命中删除集合而被过滤的数量
+	malformed int  // 无法解码而被丢弃的数量
+	more      bool // 取出的成员达到一页的上限，zset 中可能仍有剩余的任务
+}
+
+func (c *fetchCounts) add(o fetchCounts) {
+	c.fetched += o.fetched
+	c.deleted += o.deleted
+	c.malformed += o.malformed
+	c.more = c.more || o.more
+}
+
+// 逐页取出时间片中的全部任务
+func (r *RTimeWheel) getExecutableTasks(ctx context.Context, slice time.Time, from, to int64) ([]*RTaskElement, fetchCounts, error) {
+	var (
+		tasks []*RTaskElement
+		total fetchCounts
+	)
+	for {
+		page, counts, err := r.getExecutableTasksPage(ctx, slice, from, to, r.opts.fetchBatchSize)
+		tasks = append(tasks, page...)
+		total.add(counts)
+		if err != nil || !counts.more {
+			total.more = false
+			return tasks, total, err
+		}
+	}
+}
+
+// 从时间片的每个 zset 中取出一页任务
+func (r *RTimeWheel) getExecutableTasksPage(ctx context.Context, slice time.Time, from, to int64, limit int) ([]*RTaskElement, fetchCounts, error) {
+	sliceStrs := r.getScanSliceStrs(slice)
+	if len(sliceStrs) == 1 {
+		return r.fetchExecutableTasks(ctx, slice, sliceStrs[0], from, to, limit)
+	}
+	return r.fetchBuckets(ctx, slice, sliceStrs, from, to, limit)
+}
+
+func (r *RTimeWheel) fetchExecutableTasks(ctx context.Context, slice time.Time, sliceStr string, from, to int64, limit int) ([]*RTaskElement, fetchCounts, error) {
+	minuteSlice := sliceTaskKey(r.opts.keyPrefix, sliceStr)
+	var (
+		stored []StoredTask
+		more   bool
+		err    error
+	)
+	if r.opts.leaseDuration > 0 {
+		// 租约模式下，任务在取出时被转移到当前实例的 in-flight zset 中，而不是直接删除
+		stored, err = r.leaseTasks(ctx, slice, sliceStr, from, to, limit)
+		more = len(stored) >= limit
+	} else if r.claimMode() {
+		// 先检索、再认领，周期任务的下一次执行在认领之前写入
+		stored, more, err = r.claimTasks(ctx, sliceStr, from, to, limit)
+	} else {
+		// 自定义的 TaskStore 一次取出全部任务
+		stored, err = r.store.FetchDue(ctx, sliceStr, from, to)
+	}
+	if err != nil {
+		return nil, fetchCounts{}, err
+	}
+
+	r.opts.metrics.AddPendingTasks(-len(stored))
+	counts := fetchCounts{fetched: len(stored), more: more}
+	tasks := make([]*RTaskElement, 0, len(stored))
+	fetched := make(map[string]int64, len(stored))
+	var (
+		discarded []string
+		tagged    []*RTaskElement
+	)
+	for _, st := range stored {
+		leaseMember := strconv.FormatInt(st.Score, 10) + "|" + string(st.Body)
+		task := st.task
+		var err error
+		if task == nil {
+			task, err = r.decodeTask(st.Body)
+		}
+		if err != nil {
+			r.handleMalformedTask(ctx, minuteSlice, st.Body, err)
+			discarded = append(discarded, leaseMember)
+			counts.malformed++
+			continue
+		}
+
+		fetched[task.Key] = st.Score
+		if len(task.Tags) > 0 {
+			tagged = append(tagged, task)
+		}
+		if st.Deleted {
+			discarded = append(discarded, leaseMember)
+			counts.deleted++
+			continue
+		}
+		task.scheduledAt = time.Unix(st.Score, 0)
+		if r.opts.leaseDuration > 0 {
+			task.leaseKey = r.getInflightKey(sliceStr)
+			task.leaseMember = leaseMember
+		}
+		tasks = append(tasks, task)
+	}
+
+	// 无需执行的任务直接确认租约
+	if r.opts.leaseDuration > 0 && len(discarded) > 0 {
+		if err := r.ackLease(ctx, r.getInflightKey(sliceStr), discarded...); err != nil {
+			r.opts.logger.Warn(ctx, "ack discarded tasks failed", "slice", minuteSlice, "err", err)
+		}
+	}
+
+	// 任务已从 zset 中取出，清理对应的索引
+	if err := r.cleanIndex(ctx, fetched); err != nil {
+		r.opts.logger.Warn(ctx, "clean index failed", "slice", minuteSlice, "err", err)
+	}
+	if err := r.untagTasks(ctx, tagged); err != nil {
+		r.opts.logger.Warn(ctx, "untag tasks failed", "slice", minuteSlice, "err", err)
+	}
+
+	return tasks, counts, nil
+}
+
+// 计算 now 所处的扫描窗口 [start, end). 由于扫描间隔能够整除一分钟，窗口之间首尾相接，并且不会跨越分钟级时间片
+func (r *RTimeWheel) getScanWindow(now time.Time) (time.Time, time.Time) {
+	start := now.Truncate(r.opts.tickInterval)
+	return start, start.Add(r.opts.tickInterval)
+}
+
+// 根据已经扫描到的时刻计算本次 tick 的扫描窗口.
+// tick 的触发时刻存在抖动，直接以 now 计算窗口时，相邻两次 tick 可能跨越两个窗口而遗漏中间的窗口，也可能落在同一个窗口而重复扫描.
+// 因此窗口的起点取上一次扫描的截止时刻，保证每个窗口恰好被扫描一次. now 所处的窗口已经扫描过时返回 false
+func (r *RTimeWheel) nextScanWindow(scannedUntil, now time.Time) (time.Time, time.Time, bool) {
+	start, end := r.getScanWindow(now)
+	if scannedUntil.IsZero() {
+		return start, end, true
+	}
+	if !end.After(scannedUntil) {
+		return time.Time{}, time.Time{}, false
+	}
+	return scannedUntil, end, true
+}
+
+// 将时刻转换为 zset 中以秒为单位的 score 表达式，保留亚秒级精度
+func formatScore(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/float64(time.Second), 'f', -1, 64)
+}
+
+// 通过以时间片表达式作为 {hash_tag} 的方式，确保 minuteSlice 和 deleteSet 一定会分发到相同的 redis 节点之上，进一步保证 lua 脚本的原子性能够生效.
+// 时间片的粒度默认为分钟级，可以通过 WithSliceGranularity 调整
+func (r *RTimeWheel) getMinuteSlice(executeAt time.Time) string {
+	return sliceTaskKey(r.opts.keyPrefix, r.getSliceStr(executeAt))
+}
+
+// 转义写入 key 名称的用户字符串. {} 会被 redis cluster 识别为 hash tag，转义之后用户字符串不再影响 key 所属的节点
+var keyPartEscaper = strings.NewReplacer("%", "%25", "{", "%7B", "}", "%7D")
+
+func escapeKeyPart(s string) string {
+	return keyPartEscaper.Replace(s)
+}
+
+func sliceTaskKey(keyPrefix, slice string) string {
+	slice, shard := splitSliceShard(slice)
+	return fmt.Sprintf("%s_task_{%s}%s", keyPrefix, slice, shard)
+}
+
+// 获取时刻所属时间片的起始时刻
+func (r *RTimeWheel) getSliceStart(t time.Time) time.Time {
+	return t.Truncate(r.opts.sliceGranularity)
+}
+
+// 获取 [from, to) 范围涉及的全部时间片的起始时刻
+func (r *RTimeWheel) getSlices(from, to time.Time) []time.Time {
+	var slices []time.Time
+	for slice := r.getSliceStart(from); slice.Before(to); slice = slice.Add(r.opts.sliceGranularity) {
+		slices = append(slices, slice)
+	}
+	return slices
+}
+
+// 时间片的 {hash_tag} 表达式，按照 WithLocation 设置的时区格式化，与进程的本地时区无关. 粒度为整分钟时沿用分钟级表达式，否则精确到秒
+func (r *RTimeWheel) getSliceStr(t time.Time) string {
+	return formatSliceStr(r.getSliceStart(t).In(r.opts.location), r.opts.sliceGranularity)
+}
+
+func formatSliceStr(sliceStart time.Time, granularity time.Duration) string {
+	if granularity%time.Minute == 0 {
+		return util.GetTimeMinuteStr(sliceStart)
+	}
+	return util.GetTimeSecondStr(sliceStart)
+}
+
+// 解析时间片的起始时刻，忽略分桶、分片后缀
+func (r *RTimeWheel) parseSliceStr(s string) (time.Time, error) {
+	return parseSliceStrInLocation(s, r.opts.location)
+}
+
+func parseSliceStrInLocation(s string, loc *time.Location) (time.Time, error) {
+	s, _ = splitSliceShard(s)
+	s = trimSliceBucket(s)
+	if t, err := util.ParseTimeSecondStrInLocation(s, loc); err == nil {
+		return t, nil
+	}
+	return util.ParseTimeMinuteStrInLocation(s, loc)
+}
+
+// 计算删除集合需要保留的秒数. 删除标识需要保留到任务所属时间片的结束时刻之后，并为补偿扫描以及租约的接管预留足够的时间
+func (r *RTimeWheel) getDeleteSetExpireSeconds(now, executeAt time.Time) int64 {
+	return getDeleteSetExpireSeconds(r.opts, now, executeAt)
+}
+
+func getDeleteSetExpireSeconds(opts *RTimeWheelOptions, now, executeAt time.Time) int64 {
+	sliceEnd := executeAt.Truncate(opts.sliceGranularity).Add(opts.sliceGranularity)
+	expire := sliceEnd.Sub(now) + deleteSetSlack + opts.lookback + opts.leaseDuration + opts.prefetchWindow
+	if expire < deleteSetSlack {
+		expire = deleteSetSlack
+	}
+	return int64((expire + time.Second - 1) / time.Second)
+}
+
+// 计算时间片 zset 需要保留的秒数. zset 保留到时间片结束之后 sliceRetention，开启时间片清理时额外保留一个清理间隔，保证过期之前被清理一次
+func (r *RTimeWheel) getSliceExpireSeconds(now, executeAt time.Time) int64 {
+	return getSliceExpireSeconds(r.opts, now, executeAt)
+}
+
+func getSliceExpireSeconds(opts *RTimeWheelOptions, now, executeAt time.Time) int64 {
+	sliceEnd := executeAt.Truncate(opts.sliceGranularity).Add(opts.sliceGranularity)
+	expire := sliceEnd.Sub(now) + opts.sliceRetention + opts.janitorInterval
+	if expire < deleteSetSlack {
+		expire = deleteSetSlack
+	}
+	return int64((expire + time.Second - 1) / time.Second)
+}
+
+func (r *RTimeWheel) getDeleteSetKey(executeAt time.Time) string {
+	return sliceDeleteSetKey(r.opts.keyPrefix, r.getSliceStr(executeAt))
+}
+
+// 同一时间片、同一分桶的全部分片共享删除集合
+func sliceDeleteSetKey(keyPrefix, slice string) string {
+	slice, _ = splitSliceShard(slice)
+	return fmt.Sprintf("%s_delset_{%s}", keyPrefix, slice)
+}
+
+func (r *RTimeWheel) getMetaKey() string {
+	return r.opts.keyPrefix + "_meta"
+}
+
+// 登记时间片粒度. 使用相同前缀的时间轮必须采用相同的粒度，否则彼此无法检索到对方添加的任务
+func (r *RTimeWheel) registerSliceGranularity(ctx context.Context) error {
+	granularity := int64(r.opts.sliceGranularity / time.Second)
+	reply, err := r.redisClient.Eval(ctx, LuaRegisterSliceGranularity, 1, []interface{}{
+		r.getMetaKey(),
+		granularity,
+	})
+	if err != nil {
+		return err
+	}
+	if registered := gocast.ToInt64(reply); registered != granularity {
+		return fmt.Errorf("slice granularity mismatch, prefix: %s, registered: %v, got: %v",
+			r.opts.keyPrefix, time.Duration(registered)*time.Second, r.opts.sliceGranularity)
+	}
+	return nil
 }
@@ -0,0 +1,72 @@
+package timewheel
+
+import (
+	"math"
+	"time"
+)
+
+// 启动回填. 时间轮停机期间到期的任务滞留在已经错过的时间片中，启动时回溯 startupLookback 范围内的时间片，
+// 逐个时间片取出 score 早于当前扫描窗口的任务，按照 backfillRate 分批派发执行.
+//
+// 与补偿扫描一样，任务的取出复用 TaskStore.FetchDue 的原子认领语义，多个实例同时重启并执行回填时，同一个任务只会被其中一个实例取得.
+// 每次只取出一个时间片的任务，取出之后即使时间轮停止也会继续派发完成，避免已经认领的任务丢失.
+func (r *RTimeWheel) backfill() {
+	defer r.recoverPanic(nil)
+
+	if !r.IsLeader() {
+		return
+	}
+
+	now := time.Now()
+	windowStart, _ := r.getScanWindow(now)
+	var backfilled int
+	for _, slice := range r.getSlices(now.Add(-r.opts.startupLookback), windowStart) {
+		if r.ctx.Err() != nil || !r.Running() {
+			break
+		}
+
+		ctx, cancel := r.newBatchContext()
+		tasks, _, err := r.getExecutableTasks(ctx, slice, math.MinInt64, ceilSeconds(windowStart))
+		cancel()
+		if err != nil {
+			r.opts.logger.Error(r.ctx, "backfill scan failed", "slice", r.getMinuteSlice(slice), "err", err)
+			r.onScanError(r.ctx, err)
+		}
+		for _, task := range tasks {
+			task.late = true
+		}
+		r.backfillTasks(tasks)
+		backfilled += len(tasks)
+	}
+	if backfilled > 0 {
+		r.opts.logger.Info(r.ctx, "startup backfill finished", "lookback", r.opts.startupLookback, "tasks", backfilled)
+	}
+}
+
+// 每秒派发至多 backfillRate 个任务. 单个分批执行超过一秒时，下一个分批在其完成之后立即派发
+func (r *RTimeWheel) backfillTasks(tasks []*RTaskElement) {
+	for len(tasks) > 0 {
+		n := r.opts.backfillRate
+		if n > len(tasks) {
+			n = len(tasks)
+		}
+		chunk := tasks[:n]
+		tasks = tasks[n:]
+
+		next := time.Now().Add(time.Second)
+		release := r.acquireBatch()
+		ctx, cancel := r.newBatchContext()
+		r.executeBatch(ctx, chunk)
+		cancel()
+		release()
+
+		if wait := time.Until(next); wait > 0 && len(tasks) > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-r.ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package timewheel
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 背压.
+// 回调较慢时，扫描取出的任务在进程内排队等待执行，而任务在取出的同时已经从 zset 中移除，进程在此时崩溃会丢失全部排队的任务.
+// 开启 WithMaxPendingTasks 后，进程内已经取出、尚未执行完成的任务数量达到上限时暂停取出，每页至多取出空闲名额数量的任务，
+// 其余到期任务保留在 redis 中，由当前批次在名额释放之后继续取出，或者交给新的批次以及其他实例.
+
+// 进程内已经取出、尚未执行完成的任务数量. 取出的任务数量可能略微超出上限：分桶检索时每个分桶各自取出一部分
+type pendingLimiter struct {
+	mu    sync.Mutex
+	n     int
+	max   int
+	freed chan struct{} // 有任务执行完成时关闭并替换，唤醒全部等待的批次
+}
+
+func newPendingLimiter(max int) *pendingLimiter {
+	return &pendingLimiter{max: max, freed: make(chan struct{})}
+}
+
+// 空闲名额的数量，以及名额释放时关闭的 channel
+func (l *pendingLimiter) free() (int, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.max - l.n, l.freed
+}
+
+func (l *pendingLimiter) add(delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.n += delta
+	if delta < 0 {
+		close(l.freed)
+		l.freed = make(chan struct{})
+	}
+}
+
+// 等待出现空闲名额，返回空闲名额的数量. ctx 到期之前未能等到时返回 false
+func (l *pendingLimiter) wait(ctx context.Context) (int, bool) {
+	for {
+		free, freed := l.free()
+		if free > 0 {
+			return free, true
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, false
+		}
+	}
+}
+
+// 取出下一页之前等待执行的空闲名额，返回本页至多取出的任务数量. 未开启背压时直接返回 fetchBatchSize.
+// 需要等待时，记录 slices 中仍保留在 redis 中的到期任务数量；ctx 到期之前未能等到空闲名额时返回 false
+func (r *RTimeWheel) acquireFetch(ctx context.Context, slices []time.Time, from, to int64) (int, bool) {
+	if r.pending == nil {
+		return r.opts.fetchBatchSize, true
+	}
+	limit, _ := r.pending.free()
+	if limit <= 0 {
+		r.opts.metrics.SetDeferredTasks(r.countDeferred(ctx, slices, from, to))
+		var ok bool
+		if limit, ok = r.pending.wait(ctx); !ok {
+			return 0, false
+		}
+		r.opts.metrics.SetDeferredTasks(0)
+	}
+	if limit > r.opts.fetchBatchSize {
+		limit = r.opts.fetchBatchSize
+	}
+	return limit, true
+}
+
+func (r *RTimeWheel) addPending(delta int) {
+	if r.pending != nil {
+		r.pending.add(delta)
+	}
+}
+
+// 统计 slices 中 score 位于 [from, to) 范围内的任务数量，包含已经标记删除的任务. 自定义 TaskStore 无法统计，返回 0
+func (r *RTimeWheel) countDeferred(ctx context.Context, slices []time.Time, from, to int64) int {
+	if r.opts.taskStore != nil {
+		return 0
+	}
+	score1, score2 := formatScoreRange(from, to)
+	var deferred int
+	for _, slice := range slices {
+		for _, sliceStr := range r.getScanSliceStrs(slice) {
+			n, err := r.redisClient.ZCount(ctx, sliceTaskKey(r.opts.keyPrefix, sliceStr), score1, score2)
+			if err != nil {
+				r.opts.logger.Warn(ctx, "count deferred tasks failed", "slice", sliceStr, "err", err)
+				continue
+			}
+			deferred += n
+		}
+	}
+	return deferred
+}
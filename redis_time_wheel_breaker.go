@@ -0,0 +1,232 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	thttp "github.com/xiaoxuxiansheng/timewheel/pkg/http"
+)
+
+// 熔断.
+// 某个回调接收方不可用时，发往该主机的请求会逐个超时，耗尽批次的超时时间，拖累同一批次中其他健康的接收方.
+// 开启熔断后，每个回调主机维护一个熔断器：窗口时间内连续失败达到阈值时熔断器打开，冷却期间发往该主机的任务不发起请求，
+// 直接延后到冷却结束之后（或者写入死信队列）；冷却结束后熔断器进入半开状态，放行少量探测请求，探测成功则关闭，失败则重新打开.
+//
+// 只有未拿到响应、请求超时、限流以及服务端错误计为失败，其余 4xx 说明请求本身有误，与接收方是否可用无关.
+// 导致熔断器打开的响应携带 Retry-After 时，冷却时间至少为其要求的时长.
+// 熔断仅在单个实例内生效，不同实例分别统计.
+
+// ErrCircuitOpen 任务的回调主机处于熔断状态，任务没有发起请求
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitState 熔断器的状态.
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // 关闭，请求正常放行
+	CircuitOpen                         // 打开，请求全部拒绝
+	CircuitHalfOpen                     // 半开，放行少量探测请求
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenAction 熔断器打开期间对任务的处理方式.
+type CircuitOpenAction int
+
+const (
+	CircuitOpenReschedule CircuitOpenAction = iota // 延后到冷却结束之后重新执行，不计入重试次数
+	CircuitOpenDeadLetter                          // 写入死信队列，并交给失败回调处理
+)
+
+type circuitBreaker struct {
+	mu          sync.Mutex
+	state       CircuitState
+	failures    int       // 连续失败的次数
+	firstFailAt time.Time // 本轮连续失败中第一次失败的时刻
+	openUntil   time.Time // 熔断器打开后，冷却结束的时刻
+	probes      int       // 半开状态下正在进行的探测请求数量
+	calls       int       // 已经取得熔断器、尚未结束的请求数量，不为 0 时熔断器不会被清理
+}
+
+// 一次经过熔断器放行的请求，熔断未开启时为 nil
+type circuitCall struct {
+	r       *RTimeWheel
+	host    string
+	breaker *circuitBreaker
+	probe   bool
+}
+
+// 回调主机对应的熔断器，任务不是 http 回调或者未开启熔断时返回 nil. 返回的熔断器已经计入一次请求，
+// 需要通过 circuitCall 的 done、cancel 或者在未放行时释放
+func (r *RTimeWheel) breakerFor(task *RTaskElement) (string, *circuitBreaker) {
+	if r.opts.breakerThreshold <= 0 {
+		return "", nil
+	}
+	host := callbackHost(task)
+	if host == "" {
+		return "", nil
+	}
+
+	r.breakersMu.Lock()
+	defer r.breakersMu.Unlock()
+	breaker, ok := r.breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{}
+		r.breakers[host] = breaker
+	}
+	// 在 breakersMu 之内计数，清理流程不会移除即将使用的熔断器
+	breaker.mu.Lock()
+	breaker.calls++
+	breaker.mu.Unlock()
+	return host, breaker
+}
+
+// 检查熔断器是否放行任务. 不放行时返回距离下一次允许探测的时长
+func (r *RTimeWheel) allowCircuit(task *RTaskElement) (*circuitCall, time.Duration, bool) {
+	host, breaker := r.breakerFor(task)
+	if breaker == nil {
+		return nil, 0, true
+	}
+
+	now := time.Now()
+	breaker.mu.Lock()
+	from := breaker.state
+	var (
+		wait    time.Duration
+		allowed bool
+		probe   bool
+	)
+	switch breaker.state {
+	case CircuitClosed:
+		allowed = true
+	case CircuitOpen:
+		if wait = breaker.openUntil.Sub(now); wait > 0 {
+			break
+		}
+		breaker.state = CircuitHalfOpen
+		fallthrough
+	case CircuitHalfOpen:
+		if breaker.probes < r.opts.breakerProbes {
+			breaker.probes++
+			allowed, probe = true, true
+		}
+	}
+	if !allowed {
+		breaker.calls--
+	}
+	to := breaker.state
+	breaker.mu.Unlock()
+
+	r.onCircuitTransition(host, from, to)
+	if !allowed {
+		return nil, wait, false
+	}
+	return &circuitCall{r: r, host: host, breaker: breaker, probe: probe}, 0, true
+}
+
+// 根据请求的结果更新熔断器的状态
+func (c *circuitCall) done(err error) {
+	if c == nil {
+		return
+	}
+
+	now := time.Now()
+	b := c.breaker
+	b.mu.Lock()
+	from := b.state
+	b.calls--
+	if c.probe {
+		b.probes--
+	}
+	if !isCircuitFailure(err) {
+		if c.probe || b.state == CircuitClosed {
+			b.state, b.failures = CircuitClosed, 0
+		}
+	} else {
+		if b.failures == 0 || now.Sub(b.firstFailAt) > c.r.opts.breakerWindow {
+			b.failures, b.firstFailAt = 0, now
+		}
+		b.failures++
+		if c.probe || (b.state == CircuitClosed && b.failures >= c.r.opts.breakerThreshold) {
+			cooldown := c.r.opts.breakerCooldown
+			if retryAfter := c.r.retryAfter(err); retryAfter > cooldown {
+				cooldown = retryAfter
+			}
+			b.state, b.openUntil = CircuitOpen, now.Add(cooldown)
+		}
+	}
+	to := b.state
+	b.mu.Unlock()
+
+	c.r.onCircuitTransition(c.host, from, to)
+}
+
+// 放弃已经放行的请求，不影响熔断器的统计
+func (c *circuitCall) cancel() {
+	if c == nil {
+		return
+	}
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	c.breaker.calls--
+	if c.probe {
+		c.breaker.probes--
+	}
+}
+
+// 熔断器处于关闭状态、没有失败记录并且没有进行中的请求时，与新建的熔断器等价，可以清理
+func (b *circuitBreaker) idle() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == CircuitClosed && b.failures == 0 && b.calls == 0
+}
+
+func isCircuitFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	statusCode := thttp.StatusCode(err)
+	return statusCode == 0 || statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+}
+
+func (r *RTimeWheel) onCircuitTransition(host string, from, to CircuitState) {
+	if from == to {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+	defer r.recoverPanic(nil)
+	r.opts.metrics.ObserveCircuitTransition(host, from, to)
+	r.opts.logger.Info(ctx, "circuit state changed", "host", host, "from", from.String(), "to", to.String())
+	r.opts.circuitHook(ctx, host, from, to)
+}
+
+// 处理熔断期间的任务
+func (r *RTimeWheel) handleCircuitOpen(task *RTaskElement, wait time.Duration) {
+	if r.opts.circuitOpenAction != CircuitOpenDeadLetter {
+		r.deferTask(task, wait, "circuit open")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+	err := ErrCircuitOpen
+	if dlErr := r.pushDeadLetter(ctx, task, ErrCircuitOpen); dlErr != nil {
+		err = fmt.Errorf("execute err: %w, dead letter err: %v", err, dlErr)
+	}
+	r.onTerminalFailure(ctx, task, err)
+}
@@ -0,0 +1,89 @@
+package timewheel
+
+import (
+	"context"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 分桶.
+// 单个时间片中的任务数量很大时，时间片对应的 zset 会成为热点 key，一次检索的返回结果也会非常庞大.
+// 开启分桶后，每个时间片根据唯一键的哈希值拆分为 bucketCount 个桶，桶的标识作为 {hash_tag} 的一部分，
+// 因此不同的桶能够分布在 redis cluster 的不同节点上. 每个桶拥有独立的删除集合以及 in-flight zset，保证 lua 脚本涉及的 key 位于同一节点.
+// 与分片不同，每个实例都会并发扫描全部的桶并合并结果.
+//
+// 时间片的标识中以 _bucket_<n> 后缀区分分桶，后缀位于 {hash_tag} 之内. 桶的数量一经确定不能修改，否则已有的任务将无法被检索到.
+
+const bucketSep = "_bucket_"
+
+// 唯一键所属的桶. 使用与分片不同的哈希函数，避免开启分片时桶与分片的分布相互关联
+func (r *RTimeWheel) bucketOf(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % uint32(r.opts.bucketCount))
+}
+
+func bucketSliceStr(slice string, bucket int) string {
+	return slice + bucketSep + strconv.Itoa(bucket)
+}
+
+// 去除时间片标识中的分桶后缀
+func trimSliceBucket(slice string) string {
+	if i := strings.Index(slice, bucketSep); i >= 0 {
+		return slice[:i]
+	}
+	return slice
+}
+
+// 时间片中全部桶的标识，未开启分桶时只包含时间片自身
+func (r *RTimeWheel) getBucketSliceStrs(slice time.Time) []string {
+	sliceStr := r.getSliceStr(slice)
+	if r.opts.bucketCount == 0 {
+		return []string{sliceStr}
+	}
+	sliceStrs := make([]string, 0, r.opts.bucketCount)
+	for bucket := 0; bucket < r.opts.bucketCount; bucket++ {
+		sliceStrs = append(sliceStrs, bucketSliceStr(sliceStr, bucket))
+	}
+	return sliceStrs
+}
+
+// 并发检索多个桶，按照 sliceStrs 的顺序合并结果. 部分桶检索失败时，仍然返回其余桶中取出的任务
+func (r *RTimeWheel) fetchBuckets(ctx context.Context, slice time.Time, sliceStrs []string, from, to int64, limit int) ([]*RTaskElement, fetchCounts, error) {
+	// 每个分桶各自取出一部分，合计与 limit 大致相当
+	limit = (limit + len(sliceStrs) - 1) / len(sliceStrs)
+	results := make([][]*RTaskElement, len(sliceStrs))
+	counts := make([]fetchCounts, len(sliceStrs))
+	errs := make([]error, len(sliceStrs))
+	var wg sync.WaitGroup
+	for i, sliceStr := range sliceStrs {
+		wg.Add(1)
+		go func(i int, sliceStr string) {
+			defer wg.Done()
+			defer r.recoverPanic(nil)
+			results[i], counts[i], errs[i] = r.fetchExecutableTasks(ctx, slice, sliceStr, from, to, limit)
+		}(i, sliceStr)
+	}
+	wg.Wait()
+
+	var (
+		tasks   []*RTaskElement
+		total   fetchCounts
+		lastErr error
+	)
+	for i := range sliceStrs {
+		if errs[i] != nil {
+			lastErr = errs[i]
+			continue
+		}
+		tasks = append(tasks, results[i]...)
+		total.add(counts[i])
+	}
+	return tasks, total, lastErr
+}
+
+// 登记分桶数量. 使用相同前缀的时间轮必须采用相同的分桶数量，否则彼此无法检索到对方添加的任务
+func (r *RTimeWheel) registerBucketCount(ctx context.Context) error {
+	return r.registerMeta(ctx, "bucket_count", r.opts.bucketCount)
+}
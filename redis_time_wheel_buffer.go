@@ -0,0 +1,105 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 本地写缓冲.
+// redis 短暂不可用期间，AddTask 因连接异常失败的任务暂存在内存中，并向调用方返回成功. 后台协程每秒尝试将缓冲中的任务重新写入 redis，
+// 执行时刻保持不变；执行时刻已经错过的任务调整到下一个扫描窗口立即执行.
+//
+// 缓冲已满时任务不再暂存，AddTask 返回原本的错误. 缓冲只存在于内存中，进程退出时尚未写入的任务会丢失，
+// 这部分数量可以通过 Metrics.AddBufferedTasks 观察.
+
+// 任务因连接异常添加失败时暂存到缓冲中，返回是否暂存成功
+func (r *RTimeWheel) bufferAdd(ctx context.Context, task *RTaskElement, executeAt time.Time, err error) bool {
+	if r.opts.localBufferSize <= 0 || !redis.IsConnError(err) {
+		return false
+	}
+
+	r.addBufferMu.Lock()
+	if len(r.addBuffer) >= r.opts.localBufferSize {
+		r.addBufferMu.Unlock()
+		r.opts.logger.Warn(ctx, "local buffer full", "key", task.Key, "size", r.opts.localBufferSize, "err", err)
+		r.dropBuffered(ctx, task, err)
+		return false
+	}
+	buffered := *task
+	r.addBuffer = append(r.addBuffer, &retryEntry{task: &buffered, executeAt: executeAt, err: err})
+	startFlush := !r.flushing
+	r.flushing = true
+	r.addBufferMu.Unlock()
+
+	r.opts.metrics.AddBufferedTasks(1)
+	r.opts.logger.Warn(ctx, "task buffered locally", "key", task.Key, "execute_at", executeAt, "err", err)
+	// 写入方可能并未启动时间轮，因此由独立的协程负责重新写入，缓冲清空或者根 context 取消后退出
+	if startFlush {
+		go r.flushAddBufferLoop()
+	}
+	return true
+}
+
+func (r *RTimeWheel) flushAddBufferLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			r.addBufferMu.Lock()
+			r.flushing = false
+			r.addBufferMu.Unlock()
+			return
+		case <-ticker.C:
+			if r.flushAddBuffer() {
+				return
+			}
+		}
+	}
+}
+
+// 将缓冲中的任务重新写入 redis，缓冲清空时返回 true. 再次遇到连接异常时，剩余的任务留在缓冲中等待下一次写入
+func (r *RTimeWheel) flushAddBuffer() bool {
+	r.addBufferMu.Lock()
+	entries := r.addBuffer
+	r.addBuffer = nil
+	r.addBufferMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+
+	for i, entry := range entries {
+		executeAt := r.notBeforeNextWindow(time.Now(), entry.executeAt)
+		err := r.addTask(ctx, entry.task, executeAt)
+		if err == nil {
+			r.opts.metrics.AddBufferedTasks(-1)
+			continue
+		}
+		if redis.IsConnError(err) {
+			r.opts.logger.Warn(ctx, "flush local buffer failed", "key", entry.task.Key, "left", len(entries)-i, "err", err)
+			r.addBufferMu.Lock()
+			r.addBuffer = append(entries[i:], r.addBuffer...)
+			r.addBufferMu.Unlock()
+			return false
+		}
+		r.opts.metrics.AddBufferedTasks(-1)
+		r.opts.logger.Error(ctx, "flush local buffer failed, task dropped", "key", entry.task.Key, "err", err)
+		r.dropBuffered(ctx, entry.task, err)
+	}
+
+	r.addBufferMu.Lock()
+	defer r.addBufferMu.Unlock()
+	if len(r.addBuffer) > 0 {
+		return false
+	}
+	r.flushing = false
+	return true
+}
+
+func (r *RTimeWheel) dropBuffered(ctx context.Context, task *RTaskElement, err error) {
+	defer r.recoverPanic(nil)
+	r.opts.metrics.IncBufferDropped()
+	r.opts.bufferDropHook(ctx, task, err)
+}
@@ -0,0 +1,51 @@
+package timewheel
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// 补偿扫描. 时间轮的所有实例停机期间到期的任务会滞留在已经错过的分钟级 zset 中，
+// 补偿扫描会回溯 lookback 范围内的分钟级时间片，取出 score 早于当前扫描窗口的全部任务并执行.
+//
+// 任务的取出复用 TaskStore.FetchDue 的原子认领语义，因此多个实例并发执行补偿扫描时，同一个任务只会被其中一个实例取得.
+func (r *RTimeWheel) catchUp() {
+	if r.IsPaused() {
+		return
+	}
+	r.catchUpSince(time.Now().Add(-r.opts.lookback))
+}
+
+// 补偿执行 since 之后到期但尚未执行的任务
+func (r *RTimeWheel) catchUpSince(since time.Time) {
+	defer r.recoverPanic(nil)
+
+	if !r.IsLeader() {
+		return
+	}
+
+	release := r.acquireBatch()
+	defer release()
+
+	tctx, cancel := r.newBatchContext()
+	defer cancel()
+
+	windowStart, _ := r.getScanWindow(time.Now())
+	r.catchUpRange(tctx, since, windowStart)
+}
+
+// 回溯 [from, to) 范围内的时间片，执行其中 score 早于 to 的全部任务
+func (r *RTimeWheel) catchUpRange(ctx context.Context, from, to time.Time) {
+	for _, slice := range r.getSlices(from, to) {
+		tasks, _, err := r.getExecutableTasks(ctx, slice, math.MinInt64, ceilSeconds(to))
+		if err != nil {
+			r.opts.logger.Error(ctx, "catch up scan failed", "slice", r.getMinuteSlice(slice), "err", err)
+			r.onScanError(ctx, err)
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		r.executeBatch(ctx, tasks)
+	}
+}
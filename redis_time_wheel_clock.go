@@ -0,0 +1,26 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+)
+
+// 扫描使用的当前时刻. 开启 WithRedisTime 时以 redis 服务端的时刻为准，local 为 tick 触发时的本地时刻，获取失败时使用 local
+func (r *RTimeWheel) clockNow(local time.Time) time.Time {
+	if !r.opts.redisTime || r.redisClient == nil {
+		return local
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.opts.tickInterval/2)
+	defer cancel()
+	start := time.Now()
+	now, err := r.redisClient.Time(ctx)
+	rtt := time.Since(start)
+	r.opts.metrics.ObserveRedisTimeLatency(rtt)
+	if err != nil {
+		r.opts.logger.Warn(ctx, "get redis time failed, fall back to local clock", "err", err)
+		return local
+	}
+	// TIME 的回复在传输途中，按照往返耗时的一半补偿
+	return now.Add(rtt / 2)
+}
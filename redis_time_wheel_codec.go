@@ -0,0 +1,119 @@
+package timewheel
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// json 编码结果的首字节，同时作为 json 格式的标识
+const jsonFormat = '{'
+
+// Codec 任务明细写入存储时的编解码方式，默认使用 json.
+//
+// 除 json 之外，编码结果依次由 1 字节的格式标识、2 字节大端序的唯一键长度、唯一键以及 Marshal 的结果组成，
+// 时间轮以及 lua 脚本无需解码即可读取任务的唯一键，并根据格式标识选择对应的 Codec 解码.
+// json 的编码结果天然以 '{' 开头，不添加前缀，与引入 Codec 之前写入的任务保持兼容.
+type Codec interface {
+	// Format 编码格式的标识，不同的 Codec 需要使用不同的标识，并且不能为 '{'
+	Format() byte
+	Marshal(task *RTaskElement) ([]byte, error)
+	Unmarshal(data []byte, task *RTaskElement) error
+}
+
+// JSONCodec 基于 encoding/json 的 Codec，也是时间轮默认采用的编码方式.
+type JSONCodec struct{}
+
+func (JSONCodec) Format() byte {
+	return jsonFormat
+}
+
+func (JSONCodec) Marshal(task *RTaskElement) ([]byte, error) {
+	return json.Marshal(task)
+}
+
+func (JSONCodec) Unmarshal(data []byte, task *RTaskElement) error {
+	return json.Unmarshal(data, task)
+}
+
+// 使用时间轮设置的 Codec 编码任务
+func (r *RTimeWheel) encodeTask(task *RTaskElement) ([]byte, error) {
+	payload, err := r.opts.codec.Marshal(task)
+	if err != nil {
+		return nil, err
+	}
+	format := r.opts.codec.Format()
+	if format == jsonFormat {
+		return payload, nil
+	}
+	if len(task.Key) > 0xffff {
+		return nil, fmt.Errorf("task key too long: %d", len(task.Key))
+	}
+
+	data := make([]byte, 3+len(task.Key)+len(payload))
+	data[0] = format
+	binary.BigEndian.PutUint16(data[1:3], uint16(len(task.Key)))
+	copy(data[3:], task.Key)
+	copy(data[3+len(task.Key):], payload)
+	return data, nil
+}
+
+// 根据格式标识选择对应的 Codec 解码任务
+func (r *RTimeWheel) decodeTask(data []byte) (*RTaskElement, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty task")
+	}
+
+	var task RTaskElement
+	if data[0] == jsonFormat {
+		if err := json.Unmarshal(data, &task); err != nil {
+			return nil, err
+		}
+		return &task, nil
+	}
+
+	codec, ok := r.opts.decoders[data[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown task format: %#x", data[0])
+	}
+	_, payload, err := splitTaskEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := codec.Unmarshal(payload, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// 无需解码，读取任务明细中的唯一键
+func decodeTaskKey(data []byte) (string, error) {
+	if len(data) > 0 && data[0] == jsonFormat {
+		var keyOnly struct {
+			Key string `json:"key"`
+		}
+		err := json.Unmarshal(data, &keyOnly)
+		return keyOnly.Key, err
+	}
+	key, _, err := splitTaskEnvelope(data)
+	return key, err
+}
+
+func splitTaskEnvelope(data []byte) (string, []byte, error) {
+	if len(data) < 3 {
+		return "", nil, fmt.Errorf("invalid task envelope")
+	}
+	keyLen := int(binary.BigEndian.Uint16(data[1:3]))
+	if len(data) < 3+keyLen {
+		return "", nil, fmt.Errorf("invalid task envelope")
+	}
+	return string(data[3 : 3+keyLen]), data[3+keyLen:], nil
+}
+
+// 无法解码的任务交给 malformedTaskHook 处理，并记录日志以及监控
+func (r *RTimeWheel) handleMalformedTask(ctx context.Context, slice string, data []byte, err error) {
+	r.opts.metrics.IncPayloadDecodeErrors()
+	r.opts.logger.Error(ctx, "decode task failed, task discarded", "slice", slice, "task", string(data), "err", err)
+	r.opts.malformedTaskHook(ctx, data, err)
+}
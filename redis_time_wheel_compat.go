@@ -0,0 +1,59 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 未通过 WithRedisCompatibility 指定版本时，通过 INFO server 检测 redis 的版本，决定是否采用兼容形式的 lua 脚本.
+// 检测失败（如 INFO 指令被禁用）时按照新版本处理，并记录日志
+func (r *RTimeWheel) detectRedisVersion(ctx context.Context) {
+	if r.opts.redisVersion != "" {
+		return
+	}
+	version, err := r.getRedisVersion(ctx)
+	if err != nil {
+		r.opts.logger.Warn(ctx, "detect redis version failed, set it by WithRedisCompatibility if redis is older than 6.2", "err", err)
+		return
+	}
+	r.opts.legacyZrange = isLegacyRedis(version)
+}
+
+func (r *RTimeWheel) getRedisVersion(ctx context.Context) (string, error) {
+	replies, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		p.Send("INFO", "server")
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err, ok := replies[0].(error); ok {
+		return "", err
+	}
+	for _, line := range strings.Split(gocast.ToString(replies[0]), "\n") {
+		if version := strings.TrimPrefix(strings.TrimSpace(line), "redis_version:"); version != strings.TrimSpace(line) {
+			return version, nil
+		}
+	}
+	return "", fmt.Errorf("redis_version not found in INFO server")
+}
+
+// 版本低于 6.2 时返回 true，无法解析的版本按照新版本处理
+func isLegacyRedis(version string) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return major < 6 || major == 6 && minor < 2
+}
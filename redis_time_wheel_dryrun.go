@@ -0,0 +1,45 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+)
+
+// 演练模式.
+// 开启后任务到期时不再交给执行器，而是交给 sink 处理，扫描、认领、删除过滤、重试、监控指标等其余流程保持不变，执行结果也不再投递到 ResultURL.
+// sink 收到的任务与执行器实际收到的一致：Header 已经合并了默认 header 以及时间轮附加的 header，
+// 请求体已经按照 BodyType 编码后写入 RawBody（BodyType 为 BodyTypeRaw），Content-Type 写入 Header，即实际会发送的请求.
+
+type dryRunExecutor struct {
+	sink func(ctx context.Context, task *RTaskElement, scheduledAt time.Time)
+}
+
+func (e dryRunExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	body, contentType, err := task.EncodeBody()
+	if err != nil {
+		return err
+	}
+	header := make(map[string]string, len(task.Header)+1)
+	for k, v := range task.Header {
+		header[k] = v
+	}
+	if body != nil && contentType != "" && header["Content-Type"] == "" {
+		header["Content-Type"] = contentType
+	}
+
+	resolved := *task
+	resolved.Header = header
+	resolved.BodyType = BodyTypeRaw
+	resolved.RawBody = body
+	resolved.Req, resolved.FormValues = nil, nil
+	e.sink(ctx, &resolved, task.scheduledAt)
+	return nil
+}
+
+// 默认的 sink，通过 Logger 输出将要发送的请求
+func dryRunLogSink(logger Logger) func(ctx context.Context, task *RTaskElement, scheduledAt time.Time) {
+	return func(ctx context.Context, task *RTaskElement, scheduledAt time.Time) {
+		logger.Info(ctx, "dry run", "key", task.Key, "scheduled_at", scheduledAt, "method", task.Method, "callback_url", task.CallbackURL,
+			"handler_name", task.HandlerName, "topic", task.Topic, "executor_name", task.ExecutorName, "header", task.Header, "body", string(task.RawBody))
+	}
+}
@@ -0,0 +1,37 @@
+package timewheel
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+const (
+	// 任务被添加
+	TaskEventAdded = "added"
+	// 任务被删除
+	TaskEventRemoved = "removed"
+
+	// 发布任务事件的超时时间
+	eventTimeout = time.Second
+)
+
+// TaskEvent 通过 WithEventChannel 开启之后，任务添加或者删除时发布到 redis channel 的事件，以 json 格式编码.
+type TaskEvent struct {
+	Key       string `json:"key"`
+	Action    string `json:"action"`    // TaskEventAdded 或者 TaskEventRemoved
+	ExecuteAt int64  `json:"executeAt"` // 任务执行时刻的秒级时间戳
+}
+
+// 发布任务事件. 发布是尽力而为的，失败时只输出日志，不影响任务的添加以及删除
+func (r *RTimeWheel) publishEvent(ctx context.Context, key, action string, executeAt time.Time) {
+	if r.opts.eventChannel == "" {
+		return
+	}
+	payload, _ := json.Marshal(TaskEvent{Key: key, Action: action, ExecuteAt: executeAt.Unix()})
+	ctx, cancel := context.WithTimeout(ctx, eventTimeout)
+	defer cancel()
+	if _, err := r.redisClient.Publish(ctx, r.opts.eventChannel, payload); err != nil {
+		r.opts.logger.Warn(ctx, "publish task event failed", "key", key, "action", action, "err", err)
+	}
+}
@@ -0,0 +1,54 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// 恰好一次执行.
+// 设置了 ExactlyOnce 的任务在调用执行器之前，先通过 SET NX PX 写入执行标识 <prefix>_claim_{<唯一键>}:<score>，值为当前实例的标识.
+// 写入失败说明同一次执行已经由其他实例或者其他途径（租约回收、重叠窗口、重复写入的重试）发起过，直接跳过.
+// 与重叠窗口模式下每次扫描的认领标识不同，执行标识在调用执行器之前写入，保留时长覆盖任务全部重试的时间范围.
+//
+// 执行标识与执行器的调用无法原子完成：写入执行标识之后、发起调用之前进程崩溃时，任务不会再被执行，即退化为至多一次.
+// 执行失败后的重试写入新的执行时刻，作为一次新的执行重新写入执行标识.
+
+// 任务本次执行的执行标识
+func (r *RTimeWheel) getExecutionClaimKey(task *RTaskElement) string {
+	return fmt.Sprintf("%s_claim_{%s}:%d", r.opts.keyPrefix, escapeKeyPart(task.Key), task.scheduledAt.Unix())
+}
+
+// 写入任务本次执行的执行标识，返回是否写入成功. 未设置 ExactlyOnce 的任务总是返回 true
+func (r *RTimeWheel) claimExecution(ctx context.Context, task *RTaskElement) (bool, error) {
+	if !task.ExactlyOnce {
+		return true, nil
+	}
+	return r.redisClient.SetNX(ctx, r.getExecutionClaimKey(task), r.executionOwner(), r.exactlyOnceTTL(task))
+}
+
+// 执行标识的保留时长，至少为全部重试耗尽所需时长的两倍
+func (r *RTimeWheel) exactlyOnceTTL(task *RTaskElement) time.Duration {
+	ttl := 2 * r.retryHorizon(task)
+	if ttl < r.opts.exactlyOnceTTL {
+		ttl = r.opts.exactlyOnceTTL
+	}
+	return ttl
+}
+
+// 执行标识的值. 未设置实例标识时使用主机名以及进程号
+func (r *RTimeWheel) executionOwner() string {
+	if r.opts.instanceID != "" {
+		return r.opts.instanceID
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// 执行标识已经存在，跳过本次执行
+func (r *RTimeWheel) handleDuplicate(ctx context.Context, task *RTaskElement) {
+	r.opts.metrics.IncDuplicatesSuppressed()
+	r.opts.logger.Info(ctx, "duplicate execution suppressed", "key", task.Key, "scheduled_at", task.scheduledAt)
+	r.opts.duplicateHook(ctx, task)
+}
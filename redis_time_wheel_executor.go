@@ -0,0 +1,60 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	thttp "github.com/xiaoxuxiansheng/timewheel/pkg/http"
+)
+
+// ErrExecutorNotFound 任务指定的执行器没有通过 WithNamedExecutor 注册
+var ErrExecutorNotFound = errors.New("executor not found")
+
+// Executor 任务的执行器，决定任务到期时"执行什么". 重试、超时、回调、监控等逻辑由时间轮统一处理，与执行器无关.
+// Execute 收到的 task 为副本，其 Header 已经合并了默认 header、幂等键、执行次数、签名等时间轮附加的 header.
+// 返回的错误按照执行失败处理，错误链路中包含 *thttp.StatusError 时按照状态码判断是否允许重试，否则总是允许重试.
+type Executor interface {
+	Execute(ctx context.Context, task *RTaskElement) error
+}
+
+// NewHTTPExecutor 基于 http 回调的执行器，也是时间轮默认采用的执行器. 按照 BodyType 编码请求体，请求 CallbackURL.
+func NewHTTPExecutor(client *thttp.Client) Executor {
+	return &httpExecutor{client: client}
+}
+
+type httpExecutor struct {
+	client *thttp.Client
+}
+
+func (e *httpExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	body, contentType, err := task.EncodeBody()
+	if err != nil {
+		return err
+	}
+	_, err = e.client.Do(ctx, thttp.Request{Method: task.Method, URL: task.CallbackURL, Header: task.Header, Body: body, ContentType: contentType})
+	return err
+}
+
+// 根据任务的设置选择执行器：本地处理函数、kafka、具名执行器，均未设置时使用默认执行器
+func (r *RTimeWheel) executorFor(task *RTaskElement) (Executor, error) {
+	switch {
+	case task.HandlerName != "":
+		return handlerExecutor{r: r}, nil
+	case task.Topic != "":
+		if r.opts.kafkaProducer == nil {
+			return nil, ErrKafkaProducerNotConfigured
+		}
+		return kafkaExecutor{producer: r.opts.kafkaProducer}, nil
+	case task.ExecutorName != "":
+		executor, ok := r.opts.executors[task.ExecutorName]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrExecutorNotFound, task.ExecutorName)
+		}
+		return executor, nil
+	case r.opts.executor != nil:
+		return r.opts.executor, nil
+	default:
+		return &httpExecutor{client: r.httpClient}, nil
+	}
+}
@@ -0,0 +1,45 @@
+package timewheel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrHandlerNotFound 任务指定的处理函数没有在当前实例注册
+var ErrHandlerNotFound = errors.New("handler not found")
+
+// Handler 本地处理函数，payload 为任务 Req 序列化之后的 json.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// RegisterHandler 注册本地处理函数. 设置了 HandlerName 的任务到期时，直接在进程内调用对应的处理函数，而不是发起 http 回调.
+// 任务可能被任意一个实例取出执行，处理函数需要在每个实例上注册，否则任务会因 ErrHandlerNotFound 按照执行失败处理.
+// 重复注册同名的处理函数时，后注册的生效.
+func (r *RTimeWheel) RegisterHandler(name string, fn func(ctx context.Context, payload json.RawMessage) error) {
+	r.handlersMu.Lock()
+	defer r.handlersMu.Unlock()
+	if r.handlers == nil {
+		r.handlers = make(map[string]Handler)
+	}
+	r.handlers[name] = fn
+}
+
+// 调用本地处理函数的执行器
+type handlerExecutor struct {
+	r *RTimeWheel
+}
+
+func (e handlerExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	e.r.handlersMu.RLock()
+	handler, ok := e.r.handlers[task.HandlerName]
+	e.r.handlersMu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrHandlerNotFound, task.HandlerName)
+	}
+	body, _, err := task.EncodeBody()
+	if err != nil {
+		return err
+	}
+	return handler(ctx, body)
+}
@@ -0,0 +1,106 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WheelState 时间轮的运行状态
+type WheelState string
+
+const (
+	WheelStarted WheelState = "started"
+	WheelPaused  WheelState = "paused"
+	WheelStopped WheelState = "stopped"
+)
+
+var (
+	// ErrRedisUnreachable 健康检查时无法通过连接池 ping 通 redis
+	ErrRedisUnreachable = errors.New("redis unreachable")
+	// ErrScanStalled 最近一次成功的扫描距今超过两个扫描间隔
+	ErrScanStalled = errors.New("scan stalled")
+	// ErrWheelStopped 时间轮尚未启动或者已经停止
+	ErrWheelStopped = errors.New("time wheel stopped")
+)
+
+// HealthError HealthCheck 返回的错误. 通过 errors.Is 与 ErrRedisUnreachable、ErrScanStalled 以及 ErrWheelStopped 比较，
+// 以区分不同的故障；通过 errors.As 获取时间轮的运行状态以及底层错误.
+type HealthError struct {
+	Kind       error      // ErrRedisUnreachable、ErrScanStalled 或者 ErrWheelStopped
+	State      WheelState // 健康检查时时间轮的运行状态
+	LastScanAt time.Time  // 最近一次成功完成扫描的时刻
+	Err        error      // 底层错误，如 ping 失败的原因、run 协程异常退出的原因
+}
+
+func (e *HealthError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%v, state: %s", e.Kind, e.State)
+	}
+	return fmt.Sprintf("%v, state: %s, err: %v", e.Kind, e.State, e.Err)
+}
+
+func (e *HealthError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// State 返回时间轮的运行状态.
+func (r *RTimeWheel) State() WheelState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state()
+}
+
+// 调用方需要持有 mu
+func (r *RTimeWheel) state() WheelState {
+	switch {
+	case !r.started:
+		return WheelStopped
+	case r.paused:
+		return WheelPaused
+	default:
+		return WheelStarted
+	}
+}
+
+// HealthCheck 检查时间轮的健康状况：通过连接池 ping redis，并确认最近一次成功的扫描距今不超过两个扫描间隔.
+// 暂停期间以及开启 leader 选举时的非 leader 实例不进行扫描，不检查扫描是否停滞. 时间轮未运行时返回 ErrWheelStopped.
+// 返回的错误均为 *HealthError，可通过 errors.Is 区分故障类型.
+func (r *RTimeWheel) HealthCheck(ctx context.Context) error {
+	if err := r.redisClient.Ping(ctx); err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return &HealthError{Kind: ErrRedisUnreachable, State: r.state(), LastScanAt: r.lastScanAt, Err: err}
+	}
+	return r.checkScan()
+}
+
+// 检查时间轮的运行状态以及扫描是否停滞
+func (r *RTimeWheel) checkScan() error {
+	r.mu.Lock()
+	state, lastScanAt, runErr := r.state(), r.lastScanAt, r.err
+	r.mu.Unlock()
+
+	switch {
+	case state == WheelStopped:
+		return &HealthError{Kind: ErrWheelStopped, State: state, LastScanAt: lastScanAt, Err: runErr}
+	case state == WheelPaused || !r.IsLeader():
+		return nil
+	}
+	if since := time.Since(lastScanAt); since > 2*r.opts.tickInterval {
+		return &HealthError{Kind: ErrScanStalled, State: state, LastScanAt: lastScanAt, Err: fmt.Errorf("last scan completed %v ago", since)}
+	}
+	return nil
+}
+
+// 记录扫描完成的时刻. 无需扫描的时段（启动、恢复以及非 leader 期间）同样记录，避免切换状态后立即被判定为停滞
+func (r *RTimeWheel) markScanned(at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastScanAt = at
+}
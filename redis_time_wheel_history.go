@@ -0,0 +1,126 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+const (
+	// 执行成功
+	HistoryStatusOK = "ok"
+	// 执行失败
+	HistoryStatusFail = "fail"
+
+	// 写入执行记录的超时时间
+	historyTimeout = time.Second
+	// 查询执行记录时每次从 stream 中读取的记录数
+	historyPageSize = 500
+)
+
+// HistoryEntry 任务的一次执行记录.
+type HistoryEntry struct {
+	ID          string    // stream 中的记录 ID
+	Key         string    // 任务唯一键
+	Status      string    // 执行结果，HistoryStatusOK 或者 HistoryStatusFail
+	ScheduledAt time.Time // 任务的执行时刻
+	ExecutedAt  time.Time // 回调请求完成的时刻
+	Error       string    // 执行失败时的错误信息
+}
+
+// 异步写入任务的执行记录，写入失败时只输出日志，不影响任务的执行
+func (r *RTimeWheel) recordHistory(task *RTaskElement, executedAt time.Time, execErr error) {
+	if r.opts.historyMaxLen <= 0 {
+		return
+	}
+
+	status, errMsg := HistoryStatusOK, ""
+	if execErr != nil {
+		status, errMsg = HistoryStatusFail, execErr.Error()
+	}
+	r.goTracked(func() {
+		ctx, cancel := context.WithTimeout(r.ctx, historyTimeout)
+		defer cancel()
+		if _, err := r.redisClient.Eval(ctx, LuaAppendHistory, 1, []interface{}{
+			r.getHistoryKey(),
+			r.opts.historyMaxLen,
+			task.Key,
+			status,
+			task.scheduledAt.UnixMilli(),
+			executedAt.UnixMilli(),
+			errMsg,
+		}); err != nil {
+			r.opts.logger.Warn(ctx, "record history failed", "key", task.Key, "err", err)
+		}
+	})
+}
+
+// QueryHistory 查询任务最近的 limit 条执行记录，按照执行时刻倒序排列. 需要通过 WithHistory 开启执行记录.
+// 执行记录存储在同一个 stream 中，查询时从新到旧逐页扫描，最坏情况下需要扫描整个 stream.
+func (r *RTimeWheel) QueryHistory(ctx context.Context, key string, limit int) ([]*HistoryEntry, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid limit: %d", limit)
+	}
+
+	var entries []*HistoryEntry
+	end := "+"
+	for len(entries) < limit {
+		reply, err := r.redisClient.EvalRetryable(ctx, LuaRangeHistory, 1, []interface{}{
+			r.getHistoryKey(),
+			end,
+			historyPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		records := gocast.ToInterfaceSlice(reply)
+		for _, record := range records {
+			entry := parseHistoryEntry(record)
+			if entry == nil {
+				continue
+			}
+			end = "(" + entry.ID
+			if entry.Key == key && len(entries) < limit {
+				entries = append(entries, entry)
+			}
+		}
+		if len(records) < historyPageSize {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// 解析 stream 中的记录，格式为 [id, [field1, value1, field2, value2, ...]]
+func parseHistoryEntry(record interface{}) *HistoryEntry {
+	idAndFields := gocast.ToInterfaceSlice(record)
+	if len(idAndFields) != 2 {
+		return nil
+	}
+
+	entry := HistoryEntry{ID: gocast.ToString(idAndFields[0])}
+	fields := gocast.ToInterfaceSlice(idAndFields[1])
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := gocast.ToString(fields[i+1])
+		switch gocast.ToString(fields[i]) {
+		case "key":
+			entry.Key = value
+		case "status":
+			entry.Status = value
+		case "scheduled":
+			entry.ScheduledAt = time.UnixMilli(gocast.ToInt64(value))
+		case "executed":
+			entry.ExecutedAt = time.UnixMilli(gocast.ToInt64(value))
+		case "error":
+			entry.Error = value
+		}
+	}
+	return &entry
+}
+
+func (r *RTimeWheel) getHistoryKey() string {
+	return r.opts.keyPrefix + "_history"
+}
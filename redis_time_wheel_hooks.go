@@ -0,0 +1,85 @@
+package timewheel
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+)
+
+// ExecutionHooks 任务执行过程中的回调. 回调在任何锁之外调用，回调中发生的 panic 会被恢复，不会影响时间轮的运行.
+// 未设置回调时，执行过程中不会产生任何额外开销.
+type ExecutionHooks interface {
+	// OnSuccess 任务的回调请求执行成功，latency 为回调请求的耗时
+	OnSuccess(ctx context.Context, task *RTaskElement, latency time.Duration)
+	// OnFailure 任务的回调请求执行失败，每一次失败的执行都会触发，与之后是否重试无关
+	OnFailure(ctx context.Context, task *RTaskElement, err error)
+	// OnScanError 从 redis 中检索任务失败
+	OnScanError(ctx context.Context, err error)
+	// OnScanComplete 每次 tick 的扫描完成，并且取出的任务全部执行完成之后调用，report 为本次扫描的汇总
+	OnScanComplete(report ScanReport)
+}
+
+// ScanReport 一次 tick 扫描的汇总.
+type ScanReport struct {
+	WindowStart  time.Time     // 扫描窗口的左边界（包含）
+	WindowEnd    time.Time     // 扫描窗口的右边界（不包含）
+	Fetched      int           // 从 zset 中取出的成员数量
+	Deleted      int           // 命中删除集合而被过滤的数量
+	Malformed    int           // 无法解码而被丢弃的数量
+	Executed     int           // 回调执行成功的数量
+	Failed       int           // 回调执行失败的数量，包括批次超时前未能派发的任务
+	Skipped      int           // 未发起回调的数量：任务过期、回调主机熔断、被限流延后或者重复的 ExactlyOnce 执行
+	ScanErrors   int           // 检索失败的时间片数量
+	ScanDuration time.Duration // 检索 redis 的耗时
+	Duration     time.Duration // 从开始检索到取出的任务全部执行完成的耗时
+}
+
+func (r *RTimeWheel) onSuccess(ctx context.Context, task *RTaskElement, latency time.Duration) {
+	if r.opts.executionHooks == nil {
+		return
+	}
+	defer r.recoverPanic(task)
+	r.opts.executionHooks.OnSuccess(ctx, task, latency)
+}
+
+func (r *RTimeWheel) onFailure(ctx context.Context, task *RTaskElement, err error) {
+	if r.opts.executionHooks == nil {
+		return
+	}
+	defer r.recoverPanic(task)
+	r.opts.executionHooks.OnFailure(ctx, task, err)
+}
+
+func (r *RTimeWheel) onScanError(ctx context.Context, err error) {
+	if r.opts.executionHooks == nil {
+		return
+	}
+	defer r.recoverPanic(nil)
+	r.opts.executionHooks.OnScanError(ctx, err)
+}
+
+func (r *RTimeWheel) onScanComplete(report ScanReport) {
+	if r.opts.executionHooks == nil {
+		return
+	}
+	defer r.recoverPanic(nil)
+	r.opts.executionHooks.OnScanComplete(report)
+}
+
+// 恢复 panic 并交给 panicHandler 处理，需要直接通过 defer 调用. task 为触发 panic 的任务，无法确定时为 nil
+func (r *RTimeWheel) recoverPanic(task *RTaskElement) {
+	if recovered := recover(); recovered != nil {
+		r.handlePanic(recovered, task)
+	}
+}
+
+// panicHandler 自身发生的 panic 同样会被恢复，保证不会影响时间轮的运行
+func (r *RTimeWheel) handlePanic(recovered interface{}, task *RTaskElement) {
+	stack := debug.Stack()
+	defer func() {
+		if err := recover(); err != nil {
+			r.opts.logger.Error(context.Background(), "panic handler panic", "panic", err, "stack", string(debug.Stack()))
+		}
+	}()
+	r.opts.panicHandler(recovered, stack, task)
+}
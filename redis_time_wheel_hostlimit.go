@@ -0,0 +1,132 @@
+package timewheel
+
+import (
+	"context"
+	"net/url"
+	"time"
+)
+
+// 单主机并发限制.
+// 批次内的并发上限由全部回调主机共享，某个主机的任务数量很多或者响应很慢时，会占满全部的并发名额，其他主机的任务只能排队等待.
+// 开启单主机并发限制后，同一时刻发往同一回调主机（包含端口）的请求不超过 perHostConcurrency 个，超出的任务排在该主机已经派发的任务之后，
+// 批次继续派发其他主机的任务. 并发名额在同一实例的全部批次之间共享.
+
+// 任务的回调主机（包含端口），任务不是 http 回调时返回空字符串
+func callbackHost(task *RTaskElement) string {
+	if task.CallbackURL == "" || task.HandlerName != "" || task.Topic != "" {
+		return ""
+	}
+	u, err := url.Parse(task.CallbackURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// 需要限制并发的回调主机，未开启单主机并发限制时返回空字符串
+func (r *RTimeWheel) concurrencyHost(task *RTaskElement) string {
+	if r.opts.perHostConcurrency <= 0 {
+		return ""
+	}
+	return callbackHost(task)
+}
+
+// 清理空闲主机状态的间隔
+const hostStateSweepInterval = time.Minute
+
+// 回调主机的并发名额. refs 为持有以及等待名额的任务数量，为 0 时可以清理
+type hostSlot struct {
+	c    chan struct{}
+	refs int
+}
+
+func (r *RTimeWheel) refHostSlot(host string) *hostSlot {
+	r.hostSlotsMu.Lock()
+	defer r.hostSlotsMu.Unlock()
+	slot, ok := r.hostSlots[host]
+	if !ok {
+		slot = &hostSlot{c: make(chan struct{}, r.opts.perHostConcurrency)}
+		r.hostSlots[host] = slot
+	}
+	slot.refs++
+	return slot
+}
+
+func (r *RTimeWheel) unrefHostSlot(slot *hostSlot) {
+	r.hostSlotsMu.Lock()
+	defer r.hostSlotsMu.Unlock()
+	slot.refs--
+}
+
+// 尝试获取主机的并发名额，名额已满时立即返回 false
+func (r *RTimeWheel) tryAcquireHost(host string) (func(), bool) {
+	slot := r.refHostSlot(host)
+	select {
+	case slot.c <- struct{}{}:
+		return func() { <-slot.c; r.unrefHostSlot(slot) }, true
+	default:
+		r.unrefHostSlot(slot)
+		return nil, false
+	}
+}
+
+// 等待主机的并发名额，ctx 到期之前未能获取时返回 false
+func (r *RTimeWheel) acquireHost(ctx context.Context, host string) (func(), bool) {
+	slot := r.refHostSlot(host)
+	select {
+	case slot.c <- struct{}{}:
+		return func() { <-slot.c; r.unrefHostSlot(slot) }, true
+	case <-ctx.Done():
+		r.unrefHostSlot(slot)
+		return nil, false
+	}
+}
+
+// 清理空闲主机的并发名额以及熔断器. 回调地址由调用方传入，不清理时主机数量会无限增长
+func (r *RTimeWheel) sweepHostState() {
+	r.hostSlotsMu.Lock()
+	for host, slot := range r.hostSlots {
+		if slot.refs == 0 {
+			delete(r.hostSlots, host)
+		}
+	}
+	r.hostSlotsMu.Unlock()
+
+	r.breakersMu.Lock()
+	defer r.breakersMu.Unlock()
+	for host, breaker := range r.breakers {
+		if breaker.idle() {
+			delete(r.breakers, host)
+		}
+	}
+}
+
+// 相同优先级的任务按照 hostOf 分组后轮流排列，同一分组内保持原有的顺序. tasks 需要已经按照优先级排序
+func interleaveByHost(tasks []*RTaskElement, hostOf func(*RTaskElement) string) []*RTaskElement {
+	interleaved := make([]*RTaskElement, 0, len(tasks))
+	for start := 0; start < len(tasks); {
+		end := start
+		for end < len(tasks) && tasks[end].Priority == tasks[start].Priority {
+			end++
+		}
+
+		var hosts []string
+		groups := make(map[string][]*RTaskElement)
+		for _, task := range tasks[start:end] {
+			host := hostOf(task)
+			if _, ok := groups[host]; !ok {
+				hosts = append(hosts, host)
+			}
+			groups[host] = append(groups[host], task)
+		}
+		for i := 0; len(interleaved) < end; i++ {
+			for _, host := range hosts {
+				if i < len(groups[host]) {
+					interleaved = append(interleaved, groups[host][i])
+				}
+			}
+		}
+		start = end
+	}
+	return interleaved
+}
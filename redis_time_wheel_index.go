@@ -0,0 +1,134 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/demdxx/gocast"
+)
+
+// 任务唯一键索引. 使用 hash 记录每个处于等待状态的任务唯一键及其在 zset 中的 score，
+// 根据 score 即可推导出任务所在的分钟级时间片，从而支持只根据唯一键对任务进行检索、迁移.
+//
+// 索引与分钟级 zset 可能分布在 redis cluster 的不同节点上，因此索引的维护无法与 zset 的操作在同一个 lua 脚本中完成：
+// 添加任务时先写 zset、后写索引；取出任务时先操作 zset、后清理索引. 索引只作为定位任务的线索，任务是否存在始终以 zset 为准.
+//
+// 同一个 hash 中以 {v}<唯一键> 为字段记录任务的版本号，每次添加、更新、迁移任务时递增，清理索引时一并删除.
+// 唯一键不能包含 {}，版本号字段不会与任何唯一键冲突.
+
+func (r *RTimeWheel) getIndexKey() string {
+	return r.opts.keyPrefix + "_index"
+}
+
+// 写入索引并递增任务的版本号
+func (r *RTimeWheel) setIndex(ctx context.Context, key string, score int64) error {
+	_, err := r.writeIndex(ctx, key, score, 1)
+	return err
+}
+
+// 只改写索引指向的 score，版本号已经通过 bumpVersion 递增
+func (r *RTimeWheel) moveIndex(ctx context.Context, key string, score int64) error {
+	_, err := r.writeIndex(ctx, key, score, 0)
+	return err
+}
+
+func (r *RTimeWheel) writeIndex(ctx context.Context, key string, score, versionIncr int64) (int64, error) {
+	reply, err := r.redisClient.Eval(ctx, LuaSetIndex, 1, []interface{}{
+		r.getIndexKey(),
+		key,
+		score,
+		versionIncr,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return gocast.ToInt64(reply), nil
+}
+
+// 唯一键不存在索引时写入索引，返回是否写入成功
+func (r *RTimeWheel) reserveIndex(ctx context.Context, key string, score int64) (bool, error) {
+	reply, err := r.redisClient.Eval(ctx, LuaReserveIndex, 1, []interface{}{
+		r.getIndexKey(),
+		key,
+		score,
+	})
+	if err != nil {
+		return false, err
+	}
+	return gocast.ToInt(reply) == 1, nil
+}
+
+// 获取任务唯一键对应的 score 以及版本号，索引不存在时返回 ErrTaskNotFound. 升级之前添加的任务没有版本号，此时版本号为 0
+func (r *RTimeWheel) getIndexVersion(ctx context.Context, key string) (int64, int64, error) {
+	reply, err := r.redisClient.EvalRetryable(ctx, LuaGetIndexes, 1, []interface{}{
+		r.getIndexKey(),
+		key,
+		versionField(key),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	values := gocast.ToInterfaceSlice(reply)
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("invalid index reply %v", reply)
+	}
+	if values[0] == nil {
+		return 0, 0, ErrTaskNotFound
+	}
+	var version int64
+	if values[1] != nil {
+		version = gocast.ToInt64(values[1])
+	}
+	return gocast.ToInt64(values[0]), version, nil
+}
+
+// 校验并递增任务的版本号. expected 大于 0 并且与当前版本号不同时返回 ErrVersionConflict
+func (r *RTimeWheel) bumpVersion(ctx context.Context, key string, expected int64) (int64, error) {
+	reply, err := r.redisClient.Eval(ctx, LuaCheckVersion, 1, []interface{}{
+		r.getIndexKey(),
+		key,
+		expected,
+	})
+	if err != nil {
+		return 0, err
+	}
+	version := gocast.ToInt64(reply)
+	if version < 0 {
+		return 0, fmt.Errorf("%w: expected version %d", ErrVersionConflict, expected)
+	}
+	return version, nil
+}
+
+func versionField(key string) string {
+	return "{v}" + key
+}
+
+// 获取任务唯一键对应的 score，索引不存在时返回 ErrTaskNotFound
+func (r *RTimeWheel) getIndex(ctx context.Context, key string) (int64, error) {
+	reply, err := r.redisClient.EvalRetryable(ctx, LuaGetIndex, 1, []interface{}{
+		r.getIndexKey(),
+		key,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if reply == nil {
+		return 0, ErrTaskNotFound
+	}
+	return gocast.ToInt64(reply), nil
+}
+
+// 批量清理索引，只有索引仍指向给定的 score 时才会删除
+func (r *RTimeWheel) cleanIndex(ctx context.Context, keyToScore map[string]int64) error {
+	if len(keyToScore) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, 1+2*len(keyToScore))
+	args = append(args, r.getIndexKey())
+	for key, score := range keyToScore {
+		args = append(args, key, score)
+	}
+	_, err := r.redisClient.Eval(ctx, LuaCleanIndex, 1, args)
+	return err
+}
@@ -0,0 +1,142 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 时间片清理时每次 SCAN 的数量
+const janitorScanCount = 100
+
+// ErrSliceExpired 任务所属的时间片超过了保留时长，由时间片清理取出并写入死信队列
+var ErrSliceExpired = errors.New("slice expired")
+
+// 时间片清理. 只有 leader 执行，遍历 keyPrefix 下的全部时间片 zset，取出结束时刻早于保留时长的时间片中的任务
+func (r *RTimeWheel) cleanSlices() {
+	defer r.recoverPanic(nil)
+
+	if !r.IsLeader() || r.checkRedisStore() != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.opts.janitorInterval)
+	defer cancel()
+
+	horizon := time.Now().Add(-r.opts.sliceRetention)
+	// 集群模式下 SCAN 只作用于单个节点，需要遍历每个 master 节点
+	if err := r.redisClient.ForEachMaster(ctx, func(node *redis.Client) error {
+		return r.cleanNodeSlices(ctx, node, horizon)
+	}); err != nil {
+		r.opts.logger.Warn(ctx, "scan slices failed", "err", err)
+	}
+}
+
+func (r *RTimeWheel) cleanNodeSlices(ctx context.Context, node *redis.Client, horizon time.Time) error {
+	cursor := "0"
+	for {
+		replies, err := node.Pipeline(ctx, func(p redis.Pipeliner) error {
+			p.Send("SCAN", cursor, "MATCH", r.getSliceKeyPattern(), "COUNT", janitorScanCount)
+			return nil
+		})
+		if err == nil {
+			err, _ = replies[0].(error)
+		}
+		if err != nil {
+			return err
+		}
+		scan := gocast.ToInterfaceSlice(replies[0]) // 0: 下一次扫描的游标，1: 本批次的 key
+		if len(scan) != 2 {
+			return fmt.Errorf("invalid scan reply %v", scan)
+		}
+		cursor = gocast.ToString(scan[0])
+
+		for _, key := range gocast.ToStringSlice(scan[1]) {
+			sliceStr, ok := r.parseSliceKey(key)
+			if !ok {
+				continue
+			}
+			sliceStart, err := r.parseSliceStr(sliceStr)
+			if err != nil || !sliceStart.Add(r.opts.sliceGranularity).Before(horizon) {
+				continue
+			}
+			if err := r.purgeSlice(ctx, sliceStr); err != nil {
+				r.opts.logger.Warn(ctx, "purge slice failed", "slice", key, "err", err)
+			}
+		}
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// 取出时间片中的全部任务，仍处于等待状态的任务按照设置写入死信队列. 任务取出之后 zset 为空，由 redis 自动删除
+func (r *RTimeWheel) purgeSlice(ctx context.Context, sliceStr string) error {
+	stored, err := r.store.FetchDue(ctx, sliceStr, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		return err
+	}
+	r.opts.metrics.AddPendingTasks(-len(stored))
+
+	minuteSlice := sliceTaskKey(r.opts.keyPrefix, sliceStr)
+	fetched := make(map[string]int64, len(stored))
+	var tagged []*RTaskElement
+	for _, st := range stored {
+		task, err := r.decodeTask(st.Body)
+		if err != nil {
+			r.handleMalformedTask(ctx, minuteSlice, st.Body, err)
+			continue
+		}
+		fetched[task.Key] = st.Score
+		if len(task.Tags) > 0 {
+			tagged = append(tagged, task)
+		}
+		if st.Deleted {
+			continue
+		}
+
+		scheduledAt := time.Unix(st.Score, 0)
+		if !r.opts.janitorDeadLetter {
+			r.opts.logger.Warn(ctx, "expired task discarded", "slice", minuteSlice, "key", task.Key, "scheduled_at", scheduledAt)
+			continue
+		}
+		if err := r.pushDeadLetter(ctx, task, fmt.Errorf("%w, scheduled at: %v", ErrSliceExpired, scheduledAt)); err != nil {
+			r.opts.logger.Error(ctx, "push expired task to dead letter failed", "slice", minuteSlice, "key", task.Key, "err", err)
+		}
+	}
+
+	if err := r.cleanIndex(ctx, fetched); err != nil {
+		r.opts.logger.Warn(ctx, "clean index failed", "slice", minuteSlice, "err", err)
+	}
+	if err := r.untagTasks(ctx, tagged); err != nil {
+		r.opts.logger.Warn(ctx, "untag tasks failed", "slice", minuteSlice, "err", err)
+	}
+	return nil
+}
+
+// 匹配全部时间片 zset 的 SCAN 表达式，key 前缀中的通配符需要转义
+func (r *RTimeWheel) getSliceKeyPattern() string {
+	return globEscaper.Replace(r.opts.keyPrefix) + "_task_{*"
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// 从时间片 zset 的 key 中解析时间片标识，是 sliceTaskKey 的逆过程
+func (r *RTimeWheel) parseSliceKey(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, r.opts.keyPrefix+"_task_{")
+	if rest == key {
+		return "", false
+	}
+	i := strings.Index(rest, "}")
+	if i < 0 {
+		return "", false
+	}
+	return rest[:i] + rest[i+1:], true
+}
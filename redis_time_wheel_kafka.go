@@ -0,0 +1,40 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrKafkaProducerNotConfigured 任务需要投递到 kafka，但是时间轮没有设置 Producer
+var ErrKafkaProducerNotConfigured = errors.New("kafka producer not configured")
+
+// KafkaMessage 任务到期时投递到 kafka 的消息.
+type KafkaMessage struct {
+	Topic   string
+	Key     []byte
+	Value   []byte            // 按照任务的 BodyType 编码后的请求体
+	Headers map[string]string // 与回调请求相同的 header，包括幂等键、执行次数等
+}
+
+// Producer kafka 生产者的扩展点，使用方基于自身采用的 kafka 客户端实现.
+type Producer interface {
+	// Produce 同步投递消息，在 broker 确认写入之后返回. 返回的错误按照执行失败处理，并进入重试流程
+	Produce(ctx context.Context, msg *KafkaMessage) error
+}
+
+type kafkaExecutor struct {
+	producer Producer
+}
+
+func (e kafkaExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	body, _, err := task.EncodeBody()
+	if err != nil {
+		return err
+	}
+	return e.producer.Produce(ctx, &KafkaMessage{
+		Topic:   task.Topic,
+		Key:     []byte(task.MessageKey),
+		Value:   body,
+		Headers: task.Header,
+	})
+}
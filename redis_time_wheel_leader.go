@@ -0,0 +1,87 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// leader 选举.
+// 默认情况下，所有实例在每次 tick 都会扫描 redis，任务的原子取出保证了不会重复执行，但 redis 需要承受实例数倍的请求.
+// 开启 leader 选举后，实例通过 SET NX PX 竞争 leader 锁，只有持有锁的实例执行扫描、补偿扫描以及租约回收，其余实例保持运行，
+// 每隔 leaseTTL/3 尝试竞选或者续约. leader 宕机后，锁在 leaseTTL 内过期，由其他实例接管，并对接管之前可能遗漏的窗口执行一次补偿扫描.
+
+func (r *RTimeWheel) getLeaderKey() string {
+	return r.opts.keyPrefix + "_leader"
+}
+
+func (r *RTimeWheel) leaderRenewInterval() time.Duration {
+	return r.opts.leaderLeaseTTL / 3
+}
+
+// IsLeader 返回当前实例是否为 leader. 未开启 leader 选举时总是返回 true.
+func (r *RTimeWheel) IsLeader() bool {
+	if r.opts.leaderLeaseTTL <= 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// 竞选或者续约 leader. 无法确认锁的归属时主动放弃 leader 身份，避免出现多个 leader
+func (r *RTimeWheel) campaign() {
+	ctx, cancel := context.WithTimeout(r.ctx, r.leaderRenewInterval())
+	defer cancel()
+
+	reply, err := r.redisClient.Eval(ctx, LuaAcquireLeader, 1, []interface{}{
+		r.getLeaderKey(),
+		r.opts.instanceID,
+		r.opts.leaderLeaseTTL.Milliseconds(),
+	})
+	if err != nil {
+		r.opts.logger.Warn(ctx, "campaign leader failed", "instance_id", r.opts.instanceID, "err", err)
+		r.setLeader(ctx, false)
+		return
+	}
+	r.setLeader(ctx, gocast.ToInt(reply) == 1)
+}
+
+// 释放 leader 锁，其他实例能够在下一次竞选时立即接管
+func (r *RTimeWheel) resign() {
+	if !r.IsLeader() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := r.redisClient.Eval(ctx, LuaReleaseLeader, 1, []interface{}{r.getLeaderKey(), r.opts.instanceID}); err != nil {
+		r.opts.logger.Warn(ctx, "release leader failed", "instance_id", r.opts.instanceID, "err", err)
+	}
+	r.setLeader(ctx, false)
+}
+
+func (r *RTimeWheel) setLeader(ctx context.Context, isLeader bool) {
+	r.mu.Lock()
+	changed := r.leader != isLeader
+	r.leader = isLeader
+	r.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	r.opts.metrics.SetLeader(isLeader)
+	r.opts.logger.Info(ctx, "leadership changed", "instance_id", r.opts.instanceID, "leader", isLeader)
+	r.onLeadershipChange(ctx, isLeader)
+	if isLeader {
+		// 上一任 leader 失联到锁过期期间的扫描窗口可能被遗漏，接管后补偿执行
+		since := time.Now().Add(-r.opts.leaderLeaseTTL - r.opts.tickInterval)
+		r.goTracked(func() { r.catchUpSince(since) })
+	}
+}
+
+func (r *RTimeWheel) onLeadershipChange(ctx context.Context, isLeader bool) {
+	defer r.recoverPanic(nil)
+	r.opts.leadershipHook(ctx, isLeader)
+}
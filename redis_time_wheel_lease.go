@@ -0,0 +1,222 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// 租约模式.
+// 默认模式下，任务在被检索的同时即从 zset 中删除，实例在取出任务之后、执行完成之前宕机，任务就会丢失.
+// 租约模式下，任务在被检索时会转移到当前实例的 in-flight zset 中，score 为租约的到期时刻，任务执行完成后再确认租约，将其从 in-flight zset 中删除.
+// 所有实例都会定期检查 in-flight zset，将租约已经到期的任务转移到自身的 in-flight zset 中重新执行，从而接管宕机实例未完成的任务.
+//
+// in-flight zset 的 key 中同时包含分钟级时间片的 {hash_tag} 以及实例标识，保证与任务所在的 zset 分布在相同的 redis 节点上.
+// in-flight zset 中的成员为 "score|任务明细"，以便接管任务时还原任务原本的执行时刻.
+
+// 租约模式下检索任务，任务转移到当前实例的 in-flight zset 中
+func (r *RTimeWheel) leaseTasks(ctx context.Context, slice time.Time, sliceStr string, from, to int64, limit int) ([]StoredTask, error) {
+	inflightKey := r.getInflightKey(sliceStr)
+	score1, score2 := formatScoreRange(from, to)
+	script := LuaLeaseTasks
+	if r.opts.legacyZrange {
+		script = LuaLeaseTasksLegacy
+	}
+	registryKeys, err := r.inflightRegistryKeys(ctx, inflightKey)
+	if err != nil {
+		return nil, err
+	}
+	keysAndArgs := append([]interface{}{
+		sliceTaskKey(r.opts.keyPrefix, sliceStr),
+		sliceDeleteSetKey(r.opts.keyPrefix, sliceStr),
+		inflightKey,
+	}, registryKeys...)
+	keysAndArgs = append(keysAndArgs,
+		score1,
+		score2,
+		// 预取的任务在执行时刻到来之前不会执行，租约需要额外覆盖预取的时间范围
+		time.Now().Add(r.opts.prefetchWindow+r.opts.leaseDuration).Unix(),
+		limit,
+	)
+	reply, err := r.redisClient.Eval(ctx, script, 3+len(registryKeys), keysAndArgs)
+	if err != nil {
+		return nil, err
+	}
+	return parseFetchReply(reply)
+}
+
+// 登记 in-flight zset，以便其他实例发现并接管. 返回需要追加到租约脚本 KEYS 末尾的登记集合.
+// 单节点模式下由租约脚本在转移任务的同时完成登记，进程在租约之后崩溃也不会遗漏；
+// 集群模式下登记集合与 in-flight zset 可能分布在不同的节点上，改为先登记、后租约，两步之间崩溃只会留下空的登记项，由回收流程移除
+func (r *RTimeWheel) inflightRegistryKeys(ctx context.Context, inflightKey string) ([]interface{}, error) {
+	if !r.redisClient.IsCluster() {
+		return []interface{}{r.getInflightRegistryKey()}, nil
+	}
+	if _, err := r.redisClient.SAdd(ctx, r.getInflightRegistryKey(), inflightKey); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// 确认任务的租约
+func (r *RTimeWheel) ackTask(task *RTaskElement) {
+	if task.leaseKey == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+	if err := r.ackLease(ctx, task.leaseKey, task.leaseMember); err != nil {
+		r.opts.logger.Warn(ctx, "ack lease failed", "key", task.Key, "inflight_key", task.leaseKey, "err", err)
+	}
+}
+
+func (r *RTimeWheel) ackLease(ctx context.Context, inflightKey string, leaseMembers ...string) error {
+	args := make([]interface{}, 0, 1+len(leaseMembers))
+	args = append(args, inflightKey)
+	for _, member := range leaseMembers {
+		args = append(args, member)
+	}
+	_, err := r.redisClient.Eval(ctx, LuaAckTasks, 1, args)
+	return err
+}
+
+func (r *RTimeWheel) reapInterval() time.Duration {
+	interval := r.opts.leaseDuration / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// 回收所有实例中租约已经到期的任务，转移到当前实例的 in-flight zset 中重新执行
+func (r *RTimeWheel) reapLeases() {
+	defer r.recoverPanic(nil)
+
+	if r.IsPaused() || !r.IsLeader() {
+		return
+	}
+
+	release := r.acquireBatch()
+	defer release()
+
+	tctx, cancel := r.newBatchContext()
+	defer cancel()
+
+	reply, err := r.redisClient.EvalRetryable(tctx, LuaGetInflightKeys, 1, []interface{}{r.getInflightRegistryKey()})
+	if err != nil {
+		r.opts.logger.Error(tctx, "get inflight keys failed", "err", err)
+		r.onScanError(tctx, err)
+		return
+	}
+
+	now := time.Now()
+	for _, inflightKey := range gocast.ToStringSlice(reply) {
+		sliceStr, ok := parseInflightKey(inflightKey)
+		if !ok {
+			continue
+		}
+		slice, err := r.parseSliceStr(sliceStr)
+		if err != nil {
+			r.opts.logger.Warn(tctx, "parse inflight key failed", "inflight_key", inflightKey, "err", err)
+			continue
+		}
+
+		tasks, empty, err := r.reclaimLeases(tctx, inflightKey, sliceStr, now)
+		if err != nil {
+			r.opts.logger.Error(tctx, "reclaim leases failed", "inflight_key", inflightKey, "err", err)
+			r.onScanError(tctx, err)
+			continue
+		}
+		// in-flight zset 已经清空，并且所属的时间片早已过去，不会再有新的租约写入，从登记集合中移除
+		if empty && slice.Add(r.opts.sliceGranularity+r.opts.lookback+time.Minute).Before(now) {
+			if _, err := r.redisClient.Eval(tctx, LuaUnregisterInflightKey, 1, []interface{}{
+				r.getInflightRegistryKey(), inflightKey,
+			}); err != nil {
+				r.opts.logger.Warn(tctx, "unregister inflight key failed", "inflight_key", inflightKey, "err", err)
+			}
+		}
+		// 预取模式下，任务被预取之后写入的删除标识需要在执行之前检查
+		if r.opts.prefetchWindow > 0 {
+			if tasks, err = r.filterDeleted(tctx, tasks); err != nil {
+				r.opts.logger.Error(tctx, "check deleted tasks failed", "inflight_key", inflightKey, "err", err)
+				r.onScanError(tctx, err)
+				continue
+			}
+		}
+		if len(tasks) > 0 {
+			r.executeBatch(tctx, tasks)
+		}
+	}
+}
+
+// 将 inflightKey 中租约已经到期的任务转移到当前实例的 in-flight zset 中，返回转移的任务以及 inflightKey 是否已经清空
+func (r *RTimeWheel) reclaimLeases(ctx context.Context, inflightKey, sliceStr string, now time.Time) ([]*RTaskElement, bool, error) {
+	myInflightKey := r.getInflightKey(sliceStr)
+	registryKeys, err := r.inflightRegistryKeys(ctx, myInflightKey)
+	if err != nil {
+		return nil, false, err
+	}
+	keysAndArgs := append([]interface{}{inflightKey, myInflightKey}, registryKeys...)
+	keysAndArgs = append(keysAndArgs, now.Unix(), now.Add(r.opts.leaseDuration).Unix())
+	reply, err := r.redisClient.Eval(ctx, LuaReclaimLeases, 2+len(registryKeys), keysAndArgs)
+	if err != nil {
+		return nil, false, err
+	}
+
+	replies := gocast.ToInterfaceSlice(reply) // 0: inflightKey 中剩余的成员数量，之后依次为转移的成员
+	if len(replies) == 0 {
+		return nil, false, fmt.Errorf("invalid replies: %v", replies)
+	}
+
+	tasks := make([]*RTaskElement, 0, len(replies)-1)
+	for _, rawMember := range replies[1:] {
+		member := gocast.ToString(rawMember)
+		task, err := r.decodeLeaseMember(member)
+		if err != nil {
+			r.handleMalformedTask(ctx, inflightKey, []byte(member), err)
+			_ = r.ackLease(ctx, myInflightKey, member)
+			continue
+		}
+		task.leaseKey = myInflightKey
+		task.leaseMember = member
+		tasks = append(tasks, task)
+	}
+	return tasks, gocast.ToInt(replies[0]) == 0, nil
+}
+
+func (r *RTimeWheel) decodeLeaseMember(member string) (*RTaskElement, error) {
+	sep := strings.Index(member, "|")
+	if sep < 0 {
+		return nil, fmt.Errorf("invalid lease member: %s", member)
+	}
+
+	task, err := r.decodeTask([]byte(member[sep+1:]))
+	if err != nil {
+		return nil, err
+	}
+	task.scheduledAt = time.Unix(gocast.ToInt64(member[:sep]), 0)
+	return task, nil
+}
+
+// 时间片 sliceStr 对应的 in-flight zset. 同一时间片、同一分桶的全部分片共享 in-flight zset
+func (r *RTimeWheel) getInflightKey(sliceStr string) string {
+	sliceStr, _ = splitSliceShard(sliceStr)
+	return fmt.Sprintf("%s_inflight_{%s}_%s", r.opts.keyPrefix, sliceStr, r.opts.instanceID)
+}
+
+// 从 in-flight zset 的 key 中解析时间片的 {hash_tag}
+func parseInflightKey(inflightKey string) (string, bool) {
+	start, end := strings.Index(inflightKey, "{"), strings.Index(inflightKey, "}")
+	if start < 0 || end < start {
+		return "", false
+	}
+	return inflightKey[start+1 : end], true
+}
+
+func (r *RTimeWheel) getInflightRegistryKey() string {
+	return r.opts.keyPrefix + "_inflight_registry"
+}
@@ -0,0 +1,67 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger 结构化日志接口. kv 为交替出现的键值对，如 "key", task.Key, "err", err.
+type Logger interface {
+	Debug(ctx context.Context, msg string, kv ...interface{})
+	Info(ctx context.Context, msg string, kv ...interface{})
+	Warn(ctx context.Context, msg string, kv ...interface{})
+	Error(ctx context.Context, msg string, kv ...interface{})
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {}
+func (noopLogger) Info(ctx context.Context, msg string, kv ...interface{})  {}
+func (noopLogger) Warn(ctx context.Context, msg string, kv ...interface{})  {}
+func (noopLogger) Error(ctx context.Context, msg string, kv ...interface{}) {}
+
+// StdLogger 基于标准库 log 的 Logger 实现，键值对以 key=value 的形式追加在日志末尾.
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger 创建基于标准库 log 的 Logger，logger 为 nil 时使用 log.Default().
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &StdLogger{logger: logger}
+}
+
+func (l *StdLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.output("DEBUG", msg, kv)
+}
+
+func (l *StdLogger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	l.output("INFO", msg, kv)
+}
+
+func (l *StdLogger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.output("WARN", msg, kv)
+}
+
+func (l *StdLogger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	l.output("ERROR", msg, kv)
+}
+
+func (l *StdLogger) output(level, msg string, kv []interface{}) {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(" ")
+	b.WriteString(msg)
+	for i := 0; i < len(kv); i += 2 {
+		if i+1 < len(kv) {
+			fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", kv[i])
+		}
+	}
+	_ = l.logger.Output(3, b.String())
+}
@@ -0,0 +1,37 @@
+//go:build go1.21
+
+package timewheel
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogLogger 基于标准库 log/slog 的 Logger 实现.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger 创建基于 log/slog 的 Logger，logger 为 nil 时使用 slog.Default().
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.logger.DebugContext(ctx, msg, kv...)
+}
+
+func (l *SlogLogger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	l.logger.InfoContext(ctx, msg, kv...)
+}
+
+func (l *SlogLogger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.logger.WarnContext(ctx, msg, kv...)
+}
+
+func (l *SlogLogger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	l.logger.ErrorContext(ctx, msg, kv...)
+}
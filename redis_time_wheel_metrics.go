@@ -0,0 +1,85 @@
+package timewheel
+
+import "time"
+
+// Metrics 时间轮的监控指标. 未设置时使用空实现，不产生任何开销.
+//
+// 待执行任务数量以增量的方式维护：任务写入 zset 时增加，从 zset 中取出时减少，被标识删除但尚未被扫描的任务仍然计入.
+// 任务的写入与取出可能发生在不同的实例上，因此单个实例的数值没有意义，需要对全部实例求和.
+type Metrics interface {
+	// IncTasksAdded 任务写入 zset，包括新添加的任务、重试以及周期任务的后续执行
+	IncTasksAdded()
+	// ObservePayloadSize 记录写入 zset 的任务明细的大小（字节）
+	ObservePayloadSize(bytes int)
+	// IncTasksRemoved 任务在执行之前被删除
+	IncTasksRemoved(n int)
+	// IncTasksExecuted 任务的回调请求执行成功
+	IncTasksExecuted()
+	// IncTasksFailed 任务的回调请求执行失败
+	IncTasksFailed()
+	// IncPayloadDecodeErrors 任务明细反序列化失败
+	IncPayloadDecodeErrors()
+	// AddPendingTasks 调整待执行任务数量
+	AddPendingTasks(delta int)
+	// AddInflightExecutions 调整正在执行的回调请求数量
+	AddInflightExecutions(delta int)
+	// ObserveCallbackLatency 记录回调请求的耗时，不包含限流的等待时长
+	ObserveCallbackLatency(latency time.Duration)
+	// ObserveRateLimitWait 开启限流时，记录回调请求派发之前等待令牌的时长
+	ObserveRateLimitWait(wait time.Duration)
+	// ObserveScanDuration 记录一次扫描的耗时
+	ObserveScanDuration(duration time.Duration)
+	// IncScansSkipped 采用 ScanOverlapSkip 时，上一次扫描尚未完成而跳过的 tick
+	IncScansSkipped()
+	// SetLeader 开启 leader 选举时，记录当前实例是否为 leader
+	SetLeader(isLeader bool)
+	// ObserveCircuitTransition 开启熔断时，记录回调主机的熔断器状态变化
+	ObserveCircuitTransition(host string, from, to CircuitState)
+	// IncResultDeliveryFailures 任务的执行结果投递到 ResultURL 失败
+	IncResultDeliveryFailures()
+	// AddBufferedTasks 开启本地写缓冲时，调整缓冲中等待写入 redis 的任务数量
+	AddBufferedTasks(delta int)
+	// IncBufferDropped 开启本地写缓冲时，任务因缓冲已满或者重新写入失败而被丢弃
+	IncBufferDropped()
+	// IncRedisRetries 幂等的 redis 指令因连接异常而重试，参见 redis.WithRetry
+	IncRedisRetries()
+	// IncHTTPRetries 回调请求在 http 客户端内部重试，参见 thttp.WithHTTPRetry
+	IncHTTPRetries()
+	// ObserveRedisTimeLatency 开启 WithRedisTime 时，记录每次 tick 通过 TIME 指令获取 redis 时刻的耗时
+	ObserveRedisTimeLatency(latency time.Duration)
+	// IncTasksPromoted 开启 WithPromotionHorizon 时，任务从长期 zset 晋升到时间片
+	IncTasksPromoted(n int)
+	// ObserveScanPages 记录一次扫描分页取出任务的页数，持续大于 1 说明扫描跟不上任务到期的速度，参见 WithFetchBatchSize
+	ObserveScanPages(pages int)
+	// SetDeferredTasks 开启 WithMaxPendingTasks 时，记录因执行跟不上而暂停取出、保留在 redis 中的到期任务数量，恢复取出时归零
+	SetDeferredTasks(n int)
+	// IncDuplicatesSuppressed ExactlyOnce 任务的执行标识已经存在，跳过重复的执行
+	IncDuplicatesSuppressed()
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) IncTasksAdded()                                              {}
+func (noopMetrics) ObservePayloadSize(bytes int)                                {}
+func (noopMetrics) IncTasksRemoved(n int)                                       {}
+func (noopMetrics) IncTasksExecuted()                                           {}
+func (noopMetrics) IncTasksFailed()                                             {}
+func (noopMetrics) IncPayloadDecodeErrors()                                     {}
+func (noopMetrics) AddPendingTasks(delta int)                                   {}
+func (noopMetrics) AddInflightExecutions(delta int)                             {}
+func (noopMetrics) ObserveCallbackLatency(latency time.Duration)                {}
+func (noopMetrics) ObserveRateLimitWait(wait time.Duration)                     {}
+func (noopMetrics) ObserveScanDuration(duration time.Duration)                  {}
+func (noopMetrics) IncScansSkipped()                                            {}
+func (noopMetrics) SetLeader(isLeader bool)                                     {}
+func (noopMetrics) ObserveCircuitTransition(host string, from, to CircuitState) {}
+func (noopMetrics) IncResultDeliveryFailures()                                  {}
+func (noopMetrics) AddBufferedTasks(delta int)                                  {}
+func (noopMetrics) IncBufferDropped()                                           {}
+func (noopMetrics) IncRedisRetries()                                            {}
+func (noopMetrics) IncHTTPRetries()                                             {}
+func (noopMetrics) ObserveRedisTimeLatency(latency time.Duration)               {}
+func (noopMetrics) IncTasksPromoted(n int)                                      {}
+func (noopMetrics) ObserveScanPages(pages int)                                  {}
+func (noopMetrics) SetDeferredTasks(n int)                                      {}
+func (noopMetrics) IncDuplicatesSuppressed()                                    {}
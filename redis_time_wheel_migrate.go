@@ -0,0 +1,169 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 时间片 key 迁移的一项：将 from 时区下名为 oldStr 的时间片改写为当前时区下的 newStr
+type sliceMove struct {
+	oldStr, newStr string
+	start          time.Time
+}
+
+// MigrateSliceKeys 将按照 from 时区生成的时间片 key（zset 以及删除集合）改写为 WithLocation 设置的时区（缺省为 UTC）下的 key，
+// 返回迁移的 zset 数量. 用于升级之前按照进程本地时区生成 key 的部署，from 即旧版本进程的本地时区. 任务的 score 为 unix 时间戳，无需改写.
+//
+// 新旧两种 key 的格式相同，无法区分，因此迁移必须在全部实例停止之后、以新版本启动之前执行，并且只能执行一次；
+// 迁移不涉及 in-flight 的租约，开启租约模式时需要等待租约全部确认或者被回收之后再执行.
+func (r *RTimeWheel) MigrateSliceKeys(ctx context.Context, from *time.Location) (int, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return 0, err
+	}
+
+	// 先收集全部 key 再迁移，避免 SCAN 遍历到迁移之后新写入的 key
+	var keys []string
+	if err := r.redisClient.ForEachMaster(ctx, func(node *redis.Client) error {
+		nodeKeys, err := r.scanSliceKeys(ctx, node)
+		keys = append(keys, nodeKeys...)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	moves := make([]sliceMove, 0, len(keys))
+	pending := make(map[string]bool, len(keys))
+	forward := false
+	for _, key := range keys {
+		oldStr, ok := r.parseSliceKey(key)
+		if !ok {
+			continue
+		}
+		start, err := parseSliceStrInLocation(oldStr, from)
+		if err != nil {
+			continue
+		}
+		timePart, shard := splitSliceShard(oldStr)
+		bucket := timePart[len(trimSliceBucket(timePart)):]
+		timePart = trimSliceBucket(timePart)
+		newTimePart := formatSliceStr(start.In(r.opts.location), r.opts.sliceGranularity)
+		if newTimePart == timePart {
+			continue
+		}
+		forward = newTimePart > timePart
+		moves = append(moves, sliceMove{oldStr: oldStr, newStr: newTimePart + bucket + shard, start: start})
+		pending[oldStr] = true
+	}
+
+	// 新的 key 可能与尚未迁移的旧 key 同名. 新 key 的时刻晚于旧 key 时从后往前迁移，反之从前往后，保证写入的目标已经迁移完毕
+	sort.Slice(moves, func(i, j int) bool {
+		if forward {
+			return moves[i].start.After(moves[j].start)
+		}
+		return moves[i].start.Before(moves[j].start)
+	})
+
+	for i, move := range moves {
+		if pending[move.newStr] {
+			return i, fmt.Errorf("slice %s conflicts with slice %s not yet migrated", move.oldStr, move.newStr)
+		}
+		if err := r.migrateSlice(ctx, move); err != nil {
+			return i, fmt.Errorf("migrate slice %s, err: %w", move.oldStr, err)
+		}
+		delete(pending, move.oldStr)
+	}
+	return len(moves), nil
+}
+
+// 将旧时间片 zset 以及删除集合的内容写入新的 key，写入成功之后删除旧的 key. 新旧 key 可能位于 redis cluster 的不同节点，无法在同一个 lua 脚本中完成
+func (r *RTimeWheel) migrateSlice(ctx context.Context, move sliceMove) error {
+	oldKey, newKey := sliceTaskKey(r.opts.keyPrefix, move.oldStr), sliceTaskKey(r.opts.keyPrefix, move.newStr)
+	oldDelSet, newDelSet := sliceDeleteSetKey(r.opts.keyPrefix, move.oldStr), sliceDeleteSetKey(r.opts.keyPrefix, move.newStr)
+
+	replies, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		p.Send("ZRANGE", oldKey, 0, -1, "WITHSCORES")
+		p.Send("SMEMBERS", oldDelSet)
+		return nil
+	})
+	if err = firstReplyErr(replies, err); err != nil {
+		return err
+	}
+	members, deleted := gocast.ToStringSlice(replies[0]), gocast.ToStringSlice(replies[1])
+
+	now := time.Now()
+	replies, err = r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		if len(members) >= 2 {
+			args := make([]interface{}, 0, 1+len(members))
+			args = append(args, newKey)
+			for i := 0; i+1 < len(members); i += 2 {
+				args = append(args, members[i+1], members[i])
+			}
+			p.Send("ZADD", args...)
+			p.Send("EXPIRE", newKey, r.getSliceExpireSeconds(now, move.start))
+		}
+		// 同一时间片的全部分片共享删除集合，第一个分片迁移之后旧的删除集合即为空
+		if len(deleted) > 0 {
+			args := make([]interface{}, 0, 1+len(deleted))
+			args = append(args, newDelSet)
+			for _, member := range deleted {
+				args = append(args, member)
+			}
+			p.Send("SADD", args...)
+			p.Send("EXPIRE", newDelSet, r.getDeleteSetExpireSeconds(now, move.start))
+		}
+		return nil
+	})
+	if err = firstReplyErr(replies, err); err != nil {
+		return err
+	}
+
+	replies, err = r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		p.Send("DEL", oldKey)
+		p.Send("DEL", oldDelSet)
+		return nil
+	})
+	return firstReplyErr(replies, err)
+}
+
+// 遍历节点上 keyPrefix 下的全部时间片 zset
+func (r *RTimeWheel) scanSliceKeys(ctx context.Context, node *redis.Client) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		replies, err := node.Pipeline(ctx, func(p redis.Pipeliner) error {
+			p.Send("SCAN", cursor, "MATCH", r.getSliceKeyPattern(), "COUNT", janitorScanCount)
+			return nil
+		})
+		if err = firstReplyErr(replies, err); err != nil {
+			return nil, err
+		}
+		scan := gocast.ToInterfaceSlice(replies[0]) // 0: 下一次扫描的游标，1: 本批次的 key
+		if len(scan) != 2 {
+			return nil, fmt.Errorf("invalid scan reply %v", scan)
+		}
+		cursor = gocast.ToString(scan[0])
+		keys = append(keys, gocast.ToStringSlice(scan[1])...)
+		if cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// 流水线中第一条执行失败的指令的错误
+func firstReplyErr(replies []interface{}, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
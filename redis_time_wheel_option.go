@@ -0,0 +1,1048 @@
+package timewheel
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// 默认的 redis key 前缀
+	DefaultKeyPrefix = "xiaoxu_timewheel"
+	// 默认的时间片粒度
+	DefaultSliceGranularity = time.Minute
+	// 默认的扫描间隔
+	DefaultTickInterval = time.Second
+	// 默认的批次超时时间
+	DefaultBatchTimeout = 30 * time.Second
+	// 默认的最大并发批次数量
+	DefaultMaxConcurrentBatches = 30
+	// 默认的幂等键 header
+	DefaultIdempotencyKeyHeader = "X-Timewheel-Idempotency-Key"
+	// 默认的执行次数 header
+	DefaultAttemptHeader = "X-Timewheel-Attempt"
+	// 默认的任务唯一键 header
+	DefaultKeyHeader = "X-Timewheel-Key"
+	// 默认的计划执行时刻 header
+	DefaultScheduledAtHeader = "X-Timewheel-Scheduled-At"
+	// 默认的实际执行时刻 header
+	DefaultFiredAtHeader = "X-Timewheel-Fired-At"
+	// 分片模式下默认的实例心跳超时时间
+	DefaultShardHeartbeatTimeout = 15 * time.Second
+	// 熔断器默认的失败统计窗口
+	DefaultCircuitWindow = time.Minute
+	// 熔断器打开后默认的冷却时间
+	DefaultCircuitCooldown = 30 * time.Second
+	// 回调响应中 Retry-After 默认的上限
+	DefaultMaxRetryAfter = 10 * time.Minute
+	// 启动回填默认每秒派发的任务数量
+	DefaultBackfillRate = 100
+	// 默认的任务唯一键长度上限
+	DefaultMaxKeyLength = 1024
+	// 默认的任务序列化大小上限
+	DefaultMaxPayloadBytes = 64 << 10
+	// 默认的重试退避基数
+	DefaultBackoffBase = time.Second
+	// 默认的重试退避上限
+	DefaultMaxBackoff = time.Hour
+	// at-least-once 模式下默认的重试间隔
+	DefaultRetryDelay = 10 * time.Second
+	// at-least-once 模式下默认的最大重试次数
+	DefaultMaxRetries = 10
+	// 默认的时间片 zset 保留时长
+	DefaultSliceRetention = 24 * time.Hour
+	// 扫描时默认每页取出的任务数量
+	DefaultFetchBatchSize = 1000
+	// ExactlyOnce 任务的执行标识默认的最短保留时长
+	DefaultExactlyOnceTTL = 24 * time.Hour
+)
+
+type RTimeWheelOptions struct {
+	keyPrefix        string
+	sliceGranularity time.Duration
+	location         *time.Location
+	taskStore        TaskStore
+	codec            Codec
+	decoders         map[byte]Codec
+
+	tickInterval    time.Duration
+	windowOverlap   time.Duration
+	redisTime       bool
+	lookback        time.Duration
+	startupLookback time.Duration
+	backfillRate    int
+
+	executePastImmediately bool
+
+	allowedMethods []string
+	kafkaProducer  Producer
+	executor       Executor
+	executors      map[string]Executor
+
+	batchTimeout         time.Duration
+	fetchBatchSize       int
+	maxPendingTasks      int
+	maxConcurrentBatches int
+	scanOverlap          ScanOverlapPolicy
+	maxConcurrency       int
+	perHostConcurrency   int
+	rateLimit            Rate
+	hostRateLimits       map[string]Rate
+
+	breakerThreshold  int
+	breakerWindow     time.Duration
+	breakerCooldown   time.Duration
+	breakerProbes     int
+	circuitOpenAction CircuitOpenAction
+	circuitHook       func(ctx context.Context, host string, from, to CircuitState)
+
+	idempotencyKeyHeader string
+	attemptHeader        string
+
+	keyHeader         string
+	scheduledAtHeader string
+	firedAtHeader     string
+
+	defaultHeaders func(ctx context.Context) map[string]string
+
+	signingSecrets  [][]byte
+	signatureHeader string
+
+	instanceID    string
+	leaseDuration time.Duration
+
+	prefetchWindow time.Duration
+
+	leaderLeaseTTL time.Duration
+	leadershipHook func(ctx context.Context, isLeader bool)
+
+	shardCount            int
+	staticShards          []int
+	shardHeartbeatTimeout time.Duration
+
+	bucketCount int
+
+	jitter time.Duration
+
+	maxStaleness time.Duration
+	expiredHook  func(ctx context.Context, task *RTaskElement)
+
+	exactlyOnceTTL time.Duration
+	duplicateHook  func(ctx context.Context, task *RTaskElement)
+
+	malformedTaskHook func(ctx context.Context, data []byte, err error)
+
+	executionHooks ExecutionHooks
+	logger         Logger
+	panicHandler   func(recovered interface{}, stack []byte, task *RTaskElement)
+	metrics        Metrics
+	tracing        Tracing
+	historyMaxLen  int64
+
+	maxBackoff    time.Duration
+	maxRetryAfter time.Duration
+	atLeastOnce   bool
+	retryDelay    time.Duration
+	maxRetries    int
+
+	retryableStatusCodes []int
+
+	failureHook func(ctx context.Context, task *RTaskElement, err error)
+
+	dryRunSink func(ctx context.Context, task *RTaskElement, scheduledAt time.Time)
+
+	maxKeyLength    int
+	maxPayloadBytes int
+
+	localBufferSize int
+	bufferDropHook  func(ctx context.Context, task *RTaskElement, err error)
+
+	redisVersion string
+	legacyZrange bool
+
+	sliceRetention    time.Duration
+	janitorInterval   time.Duration
+	janitorDeadLetter bool
+
+	promotionHorizon time.Duration
+
+	ownedClient bool
+
+	eventChannel string
+}
+
+type RTimeWheelOption func(r *RTimeWheelOptions)
+
+// WithKeyPrefix 设置时间轮使用的全部 redis key 的前缀，使用不同前缀的时间轮之间相互隔离. 前缀中不能包含 {}.
+func WithKeyPrefix(keyPrefix string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.keyPrefix = keyPrefix
+	}
+}
+
+// WithSliceGranularity 设置时间片的粒度，即每个 zset 覆盖的时间范围，如 1s、1m、5m、1h.
+// 粒度必须为整秒并且能够整除一小时，否则使用默认值. 使用相同前缀的时间轮必须采用相同的粒度，否则 Start 时返回错误.
+// 粒度越小，单个 zset 的成员越少，但 key 的数量以及补偿扫描需要检索的时间片越多.
+func WithSliceGranularity(sliceGranularity time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.sliceGranularity = sliceGranularity
+	}
+}
+
+// WithLocation 设置生成时间片 key 时采用的时区，缺省为 UTC. 时间片的 key 中包含格式化之后的时刻，
+// 使用相同前缀的时间轮必须采用相同的时区，否则同一时刻会落在名称不同的时间片中，彼此无法检索到对方添加的任务.
+// 仅在需要与按照本地时区生成 key 的旧版本共存时设置，迁移已有的 key 参见 MigrateSliceKeys.
+func WithLocation(loc *time.Location) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.location = loc
+	}
+}
+
+// WithTaskStore 替换等待执行的任务的存储，默认基于 redis 实现. store 需要满足 TaskStore 的约定.
+// 唯一键索引、死信队列、执行记录等其余数据仍然存储在 redis 中. 使用自定义存储时，GetTask、RescheduleTask、UpdateTask、AddTaskNX、ListPendingTasks、
+// RemoveTasks、Stats 返回 ErrTaskStoreUnsupported，租约模式不可用，并且无法提前删除周期任务未来的某一次执行.
+func WithTaskStore(store TaskStore) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.taskStore = store
+	}
+}
+
+// WithCodec 设置任务明细写入存储时的编码方式，默认使用 json. 可以使用 codec/msgpack 中的实现减少 redis 内存以及解码开销.
+// 解码时根据任务明细的格式标识选择 Codec，json 格式总是可以解码，因此从 json 切换到其他格式时，之前写入的任务不受影响.
+// 从其他格式切换回 json，或者在不同格式之间切换时，需要通过 decoders 传入之前使用的 Codec.
+// 滚动升级期间，需要先让所有实例都能够解码新的格式，再切换编码方式.
+func WithCodec(codec Codec, decoders ...Codec) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.codec = codec
+		r.decoders = make(map[byte]Codec, len(decoders)+1)
+		for _, decoder := range append(decoders, codec) {
+			r.decoders[decoder.Format()] = decoder
+		}
+	}
+}
+
+// WithMalformedTaskHook 设置无法解码的任务被丢弃时的回调，data 为任务明细的原始数据.
+func WithMalformedTaskHook(hook func(ctx context.Context, data []byte, err error)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.malformedTaskHook = hook
+	}
+}
+
+// WithTickInterval 设置扫描间隔，每次扫描的 score 窗口与之保持一致.
+// 扫描间隔必须能够整除一分钟（如 500ms、5s、15s），从而保证窗口之间既不留空隙也不会重复扫描，否则使用默认值.
+func WithTickInterval(tickInterval time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.tickInterval = tickInterval
+	}
+}
+
+// WithWindowOverlap 开启重叠窗口模式，每次扫描的窗口向前延伸 overlap（如 2s），容忍实例之间的时钟偏差以及窗口边界的竞争.
+// 该模式下检索任务时不再直接从 zset 中移除，而是逐个通过 zrem 认领任务的执行，成功将任务移出 zset 的实例才会执行任务，
+// 认领失败说明任务已经由其他实例处理，直接跳过.
+// 租约模式以及自定义 TaskStore 仍然按照各自的方式取出任务，只有扫描窗口向前延伸. 预取模式下不生效.
+func WithWindowOverlap(overlap time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.windowOverlap = overlap
+	}
+}
+
+// WithRedisTime 以 redis 服务端的时钟作为扫描的时钟. 每次 tick 通过 TIME 指令获取 redis 的当前时刻，并按照往返耗时的一半进行补偿，
+// 扫描窗口以及窗口所属的时间片均由该时刻推导，各个实例的本地时钟存在偏差时，仍然按照同一个时钟认领到期的任务.
+// 添加任务时的 score 仍然取自调用方传入的执行时刻.
+//
+// 代价是每次 tick 增加一次 redis 往返，同机房部署时通常在 1ms 以内，可以通过 Metrics.ObserveRedisTimeLatency 观察.
+// TIME 指令在 run 协程中同步执行，超时时间为扫描间隔的一半，失败时本次 tick 回退到本地时钟并记录日志.
+func WithRedisTime() RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.redisTime = true
+	}
+}
+
+// WithLookback 开启补偿扫描，lookback 为回溯的时间范围.
+// 开启后，时间轮在启动时以及之后的每分钟，都会回溯 lookback 范围内的分钟级时间片，执行因停机而错过的任务.
+func WithLookback(lookback time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.lookback = lookback
+	}
+}
+
+// WithStartupLookback 开启启动回填，时间轮启动时回溯 lookback 范围内的时间片，执行停机期间到期但尚未执行的任务.
+// 与 WithLookback 不同，启动回填只在启动时执行一次，并且按照 WithStartupBackfillRate 限制派发速率，避免积压的任务在启动时集中冲击 redis 以及回调方.
+// 回填取出的任务 Late 返回 true，任务仍然受 WithMaxStaleness 以及 RTaskElement.MaxStaleness 的约束.
+func WithStartupLookback(lookback time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.startupLookback = lookback
+	}
+}
+
+// WithStartupBackfillRate 设置启动回填每秒派发的任务数量，默认为 DefaultBackfillRate.
+func WithStartupBackfillRate(rate int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.backfillRate = rate
+	}
+}
+
+// WithExecutePastImmediately 添加执行时刻已经过去的任务时，不再返回 ErrExecuteAtInPast，而是将其调整到下一个扫描窗口立即执行.
+func WithExecutePastImmediately() RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.executePastImmediately = true
+	}
+}
+
+// WithAllowedMethods 设置回调允许使用的 http 方法，默认为 GET、POST、PUT、PATCH、DELETE、HEAD.
+func WithAllowedMethods(methods ...string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.allowedMethods = methods
+	}
+}
+
+// WithExecutor 设置默认的执行器，取代基于 http 回调的默认实现. 未设置 HandlerName、Topic、ExecutorName 的任务使用该执行器执行.
+// 设置后，添加任务时不再校验 Method 以及 CallbackURL.
+func WithExecutor(e Executor) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.executor = e
+	}
+}
+
+// WithNamedExecutor 注册具名的执行器，设置了 ExecutorName 的任务使用同名的执行器执行. 可以多次设置以注册多个执行器.
+// 任务可能被任意一个实例取出执行，每个实例都需要注册相同的执行器.
+func WithNamedExecutor(name string, e Executor) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		if r.executors == nil {
+			r.executors = make(map[string]Executor)
+		}
+		r.executors[name] = e
+	}
+}
+
+// WithKafkaProducer 设置 kafka 生产者. 设置了 Topic 的任务到期时，请求体会作为消息投递到对应的 topic，而不是发起 http 回调.
+// 投递失败与回调失败一样会进入重试流程.
+func WithKafkaProducer(p Producer) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.kafkaProducer = p
+	}
+}
+
+// WithBatchTimeout 设置每个批次的超时时间，批次内全部任务的执行都需要在该时间内完成.
+func WithBatchTimeout(batchTimeout time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.batchTimeout = batchTimeout
+	}
+}
+
+// WithFetchBatchSize 设置扫描时每页取出的任务数量，默认为 DefaultFetchBatchSize. 积压的时间片分页取出，
+// 每次 lua 调用只取出并移除一页，取出的任务立即交给派发方执行，避免单次回复过大以及长时间阻塞 redis.
+// 批次的截止时间临近时窗口内仍有剩余的任务，剩余的任务交给新的批次继续取出. 每次扫描的页数参见 Metrics.ObserveScanPages.
+func WithFetchBatchSize(size int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.fetchBatchSize = size
+	}
+}
+
+// WithMaxPendingTasks 开启背压，限制进程内已经取出、尚未执行完成的任务数量，为 0 时不做限制.
+// 达到上限时扫描暂停取出，每页至多取出空闲名额数量的任务，其余到期任务保留在 redis 中，进程崩溃时不会丢失；
+// 名额释放之后由当前批次继续取出，批次的截止时间临近时交给新的批次. 只限制常规扫描，预取、补偿扫描以及回填不受影响.
+// 暂停取出时保留在 redis 中的到期任务数量参见 Metrics.SetDeferredTasks.
+func WithMaxPendingTasks(n int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxPendingTasks = n
+	}
+}
+
+// WithMaxConcurrentBatches 设置同时进行的批次数量上限. 达到上限时，新的批次会排队等待，扫描窗口在 tick 时已经确定，不会遗漏.
+func WithMaxConcurrentBatches(maxConcurrentBatches int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxConcurrentBatches = maxConcurrentBatches
+	}
+}
+
+// ScanOverlapPolicy 上一次扫描尚未完成时，新的 tick 的处理方式.
+type ScanOverlapPolicy int
+
+const (
+	ScanOverlapQueue ScanOverlapPolicy = iota // 发起新的扫描，与其他批次一同受 WithMaxConcurrentBatches 限制
+	ScanOverlapSkip                           // 跳过本次 tick，扫描窗口顺延到下一次扫描，不会遗漏
+)
+
+// WithScanOverlapPolicy 设置上一次扫描尚未完成时新的 tick 的处理方式，默认为 ScanOverlapQueue.
+// 回调普遍较慢时，ScanOverlapQueue 会堆积大量同时进行的批次，ScanOverlapSkip 保证同一时刻只有一次扫描，
+// 被跳过的窗口合并到下一次扫描中，跳过的次数通过 Metrics.IncScansSkipped 统计. 预取模式下不生效.
+func WithScanOverlapPolicy(policy ScanOverlapPolicy) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.scanOverlap = policy
+	}
+}
+
+// WithMaxConcurrency 设置并发执行的任务数量上限，为 0 时不做限制.
+// 设置上限时，时间轮运行期间由 maxConcurrency 个常驻的 worker 执行全部批次的任务，上限由全部批次共享，不再为每个任务创建新的协程.
+// 任务按照优先级从高到低派发，批次超时前未能派发的任务按照执行失败处理.
+func WithMaxConcurrency(maxConcurrency int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxConcurrency = maxConcurrency
+	}
+}
+
+// WithPerHostConcurrency 限制同一时刻发往同一回调主机（包含端口）的请求数量，为 0 时不做限制.
+// 超出限制的任务排在该主机已经派发的任务之后，不会占用批次内其他主机的并发名额.
+func WithPerHostConcurrency(n int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.perHostConcurrency = n
+	}
+}
+
+// WithRateLimit 限制回调的派发速率，每秒最多 rps 个请求，允许 burst 个请求的突发. 速率不足时任务延后派发，
+// 在批次超时之前无法派发的任务重新写入时间轮，在令牌恢复后执行. rps 不大于 0 时不做限制.
+func WithRateLimit(rps float64, burst int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.rateLimit = Rate{RPS: rps, Burst: burst}
+	}
+}
+
+// WithPerHostRateLimit 按照回调地址的主机名（不含端口）分别限制派发速率，与 WithRateLimit 的全局限制同时生效.
+func WithPerHostRateLimit(rates map[string]Rate) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.hostRateLimits = rates
+	}
+}
+
+// WithCircuitBreaker 按照回调主机（包含端口）开启熔断. window 时间内连续失败 threshold 次时熔断器打开，
+// 冷却 cooldown 之后进入半开状态放行探测请求. threshold 不大于 0 时不开启熔断，window、cooldown 不大于 0 时使用默认值.
+func WithCircuitBreaker(threshold int, window, cooldown time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.breakerThreshold = threshold
+		r.breakerWindow = window
+		r.breakerCooldown = cooldown
+	}
+}
+
+// WithCircuitHalfOpenProbes 设置熔断器半开状态下同时放行的探测请求数量，默认为 1.
+func WithCircuitHalfOpenProbes(probes int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.breakerProbes = probes
+	}
+}
+
+// WithCircuitOpenAction 设置熔断器打开期间对任务的处理方式，默认延后到冷却结束之后重新执行.
+func WithCircuitOpenAction(action CircuitOpenAction) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.circuitOpenAction = action
+	}
+}
+
+// WithCircuitBreakerHook 设置熔断器状态变化时的回调.
+func WithCircuitBreakerHook(hook func(ctx context.Context, host string, from, to CircuitState)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.circuitHook = hook
+	}
+}
+
+// WithIdempotencyHeaders 设置回调请求中携带幂等键以及执行次数的 header 名称，传入空字符串时使用默认值.
+func WithIdempotencyHeaders(idempotencyKeyHeader, attemptHeader string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.idempotencyKeyHeader = idempotencyKeyHeader
+		r.attemptHeader = attemptHeader
+	}
+}
+
+// WithMetadataHeaders 设置回调请求中携带任务唯一键、计划执行时刻以及实际执行时刻的 header 名称，传入空字符串时使用默认值.
+// 计划执行时刻为任务在 zset 中的 score（包含抖动），两者均为 RFC3339 格式，接收方可以据此监控任务的延迟.
+func WithMetadataHeaders(keyHeader, scheduledAtHeader, firedAtHeader string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.keyHeader = keyHeader
+		r.scheduledAtHeader = scheduledAtHeader
+		r.firedAtHeader = firedAtHeader
+	}
+}
+
+// WithDefaultHeaders 设置每个回调请求都会携带的默认 header，任务自身的 Header 中存在同名 header 时以任务的设置为准.
+// 默认 header 不会随任务写入 redis.
+func WithDefaultHeaders(header map[string]string) RTimeWheelOption {
+	defaultHeaders := make(map[string]string, len(header))
+	for k, v := range header {
+		defaultHeaders[k] = v
+	}
+	return WithDefaultHeadersFunc(func(ctx context.Context) map[string]string {
+		return defaultHeaders
+	})
+}
+
+// WithDefaultHeadersFunc 与 WithDefaultHeaders 相同，默认 header 在每次执行任务时通过 fn 获取，适用于需要定期刷新的短期 token.
+func WithDefaultHeadersFunc(fn func(ctx context.Context) map[string]string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.defaultHeaders = fn
+	}
+}
+
+// WithWebhookSigning 开启回调签名. 发起回调前，使用 HMAC-SHA256 对时间戳以及请求体进行签名，
+// 以 t=<unix>,v1=<hex> 的格式写入 headerName 对应的 header，headerName 为空时使用 DefaultSignatureHeader.
+// 轮换密钥时通过 oldSecrets 传入旧密钥，每个密钥各自生成一个 v1 签名，接收方使用新旧任意一个密钥都能校验通过.
+// 接收方可以使用 VerifySignature 进行校验.
+func WithWebhookSigning(secret []byte, headerName string, oldSecrets ...[]byte) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.signingSecrets = append([][]byte{secret}, oldSecrets...)
+		r.signatureHeader = headerName
+	}
+}
+
+// WithLease 开启租约模式. 任务被取出后需要在 leaseDuration 内执行完成并确认，否则会被其他实例接管并重新执行.
+// instanceID 为当前实例的唯一标识，同一个实例重启后使用相同的标识，能够更快地接管自身未完成的任务.
+// 租约模式提供的是至少一次的执行语义，租约到期时任务可能仍在执行，leaseDuration 应当大于批次超时时间.
+func WithLease(instanceID string, leaseDuration time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.instanceID = instanceID
+		r.leaseDuration = leaseDuration
+	}
+}
+
+// WithPrefetch 开启预取模式，需要同时通过 WithLease 开启租约模式，否则不生效.
+// 每次 tick 提前取出未来 window 范围内的任务，并通过本地定时器在任务的执行时刻触发执行，减少扫描间隔带来的执行误差.
+// 预取的任务在执行之前处于租约状态，租约的有效期会额外延长 window，window 越大，实例宕机时任务被其他实例接管的延迟越大.
+func WithPrefetch(window time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.prefetchWindow = window
+	}
+}
+
+// WithLeaderElection 开启 leader 选举，只有 leader 扫描 redis，其余实例在 leader 失联后的 leaseTTL 内接管.
+// instanceID 为当前实例的唯一标识，与 WithLease 共用. leaseTTL 至少为 1 秒，leader 每隔 leaseTTL/3 续约一次.
+func WithLeaderElection(instanceID string, leaseTTL time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.instanceID = instanceID
+		r.leaderLeaseTTL = leaseTTL
+	}
+}
+
+// WithLeadershipHook 设置当前实例成为 leader 或者失去 leader 身份时的回调.
+func WithLeadershipHook(hook func(ctx context.Context, isLeader bool)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.leadershipHook = hook
+	}
+}
+
+// WithSharding 开启分片模式，每个时间片的任务按照唯一键的哈希值分散到 shardCount 个 zset 中，每个实例只扫描分配给自己的分片.
+// instanceID 为当前实例的唯一标识，与 WithLease 共用. 实例的心跳超过 heartbeatTimeout 未更新时，其负责的分片由其他实例接管.
+// 使用相同前缀的时间轮必须采用相同的分片数量，shardCount 小于 2 时不开启分片.
+func WithSharding(instanceID string, shardCount int, heartbeatTimeout time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.instanceID = instanceID
+		r.shardCount = shardCount
+		r.shardHeartbeatTimeout = heartbeatTimeout
+	}
+}
+
+// WithOwnedShards 开启分片模式时，静态指定当前实例负责的分片. 未指定时，分片在存活的实例之间自动分配.
+// 当前实例存活期间，指定的分片不会分配给其他实例；其余未被任何实例指定的分片仍然自动分配.
+func WithOwnedShards(shards ...int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.staticShards = shards
+	}
+}
+
+// WithBuckets 将每个时间片按照唯一键的哈希值拆分为 n 个分桶，每个分桶拥有独立的 {hash_tag}，避免单个时间片成为热点 key.
+// 扫描时并发检索全部分桶并合并结果. 使用相同前缀的时间轮必须采用相同的分桶数量，启动时会与 redis 中登记的数量进行校验，n 小于 2 时不开启分桶.
+func WithBuckets(n int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.bucketCount = n
+	}
+}
+
+// WithJitter 设置任务执行时刻随机抖动上限的默认值，用于打散同一时刻大量到期的任务. 抖动以秒为单位，任务自身设置了 JitterSeconds 时以任务的设置为准.
+func WithJitter(jitter time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.jitter = jitter
+	}
+}
+
+// WithMaxStaleness 设置任务允许的最大延迟的默认值，执行时已经晚于执行时刻超过该时长的任务不再执行. 为 0 时任务永不过期.
+func WithMaxStaleness(maxStaleness time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxStaleness = maxStaleness
+	}
+}
+
+// WithExpiredHook 设置任务因过期而不再执行时的回调.
+func WithExpiredHook(hook func(ctx context.Context, task *RTaskElement)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.expiredHook = hook
+	}
+}
+
+// WithExactlyOnceTTL 设置 ExactlyOnce 任务的执行标识最短的保留时长，默认为 DefaultExactlyOnceTTL.
+// 实际的保留时长不小于任务全部重试耗尽所需时长的两倍，保留期间同一次执行的重复副本都会被跳过.
+func WithExactlyOnceTTL(ttl time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.exactlyOnceTTL = ttl
+	}
+}
+
+// WithDuplicateHook 设置 ExactlyOnce 任务因执行标识已经存在而跳过时的回调.
+// 执行标识在调用执行器之前写入，写入之后、调用之前进程崩溃的任务不会再被执行，同样以执行标识已经存在的方式在此处出现，
+// 即 ExactlyOnce 以可能丢失执行为代价避免重复执行. 需要确认的任务可以在回调中结合执行记录进行核对.
+func WithDuplicateHook(hook func(ctx context.Context, task *RTaskElement)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.duplicateHook = hook
+	}
+}
+
+// WithExecutionHooks 设置任务执行过程中的回调，用于接入日志、监控以及告警.
+func WithExecutionHooks(hooks ExecutionHooks) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.executionHooks = hooks
+	}
+}
+
+// WithLogger 设置日志输出，默认不输出任何日志. 可以使用 NewStdLogger、NewSlogLogger 接入标准库的日志.
+func WithLogger(logger Logger) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.logger = logger
+	}
+}
+
+// WithPanicHandler 设置 panic 的处理函数. 扫描、任务执行以及各类回调中发生的 panic 都会被恢复并交给 handler 处理，
+// stack 为发生 panic 时的堆栈，task 为触发 panic 的任务，无法确定时为 nil. 默认通过 Logger 输出.
+func WithPanicHandler(handler func(recovered interface{}, stack []byte, task *RTaskElement)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.panicHandler = handler
+	}
+}
+
+// WithMetrics 设置监控指标的上报，默认不上报. 可以使用 metrics/prometheus 中的实现接入 prometheus.
+func WithMetrics(metrics Metrics) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.metrics = metrics
+	}
+}
+
+// WithTracing 开启链路追踪. 接入 OpenTelemetry 时使用 tracing/otel 中的 WithTracerProvider.
+func WithTracing(tracing Tracing) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.tracing = tracing
+	}
+}
+
+// WithHistory 开启执行记录. 每次执行任务之后，执行结果会异步写入 redis stream，stream 近似保留最近的 maxLen 条记录.
+// 执行记录可以通过 QueryHistory 查询.
+func WithHistory(maxLen int64) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.historyMaxLen = maxLen
+	}
+}
+
+// WithMaxBackoff 设置失败重试时退避时长的上限.
+func WithMaxBackoff(maxBackoff time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxRetryAfter 设置回调响应中 Retry-After 的上限. 429、503 响应携带 Retry-After 时，任务按照其要求的时长延后重试，而不是采用退避策略.
+func WithMaxRetryAfter(maxRetryAfter time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxRetryAfter = maxRetryAfter
+	}
+}
+
+// WithAtLeastOnceDelivery 开启 at-least-once 投递模式.
+// 执行失败的任务会按照 retryDelay 为基数的指数退避重新入队，直到执行成功或者重试次数达到 maxRetries 后写入死信队列.
+// 任务自身设置了 MaxRetries、BackoffBase 时以任务的设置为准. retryDelay、maxRetries 不大于 0 时使用默认值.
+// 重新入队时 redis 不可用，任务会暂存在内存中，待 redis 恢复后写入.
+func WithAtLeastOnceDelivery(retryDelay time.Duration, maxRetries int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.atLeastOnce = true
+		r.retryDelay = retryDelay
+		r.maxRetries = maxRetries
+	}
+}
+
+// WithRetryableStatusCodes 设置允许重试的回调响应状态码的默认值，任务自身设置了 RetryableStatusCodes 时以任务的设置为准.
+// 未设置时仅重试 408、429 以及 5xx，其余非 2xx 响应直接按照最终失败处理. 网络错误、超时等未拿到响应的失败总是允许重试.
+func WithRetryableStatusCodes(codes ...int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.retryableStatusCodes = codes
+	}
+}
+
+// WithFailureHook 设置任务最终执行失败（重试次数耗尽或者无法重试）时的回调.
+func WithFailureHook(hook func(ctx context.Context, task *RTaskElement, err error)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.failureHook = hook
+	}
+}
+
+// WithDryRun 开启演练模式，任务到期时不再执行，而是将实际会发送的请求交给 sink，sink 为 nil 时通过 Logger 输出.
+// sink 收到的任务已经合并了全部 header，请求体编码后写入 RawBody. 扫描、认领、删除过滤以及监控指标等其余流程保持不变.
+func WithDryRun(sink func(ctx context.Context, task *RTaskElement, scheduledAt time.Time)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		if sink == nil {
+			sink = func(ctx context.Context, task *RTaskElement, scheduledAt time.Time) {
+				dryRunLogSink(r.logger)(ctx, task, scheduledAt)
+			}
+		}
+		r.dryRunSink = sink
+	}
+}
+
+// WithMaxKeyLength 设置任务唯一键的长度上限（字节），默认为 DefaultMaxKeyLength，最大为 65535.
+func WithMaxKeyLength(maxKeyLength int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxKeyLength = maxKeyLength
+	}
+}
+
+// WithMaxPayloadBytes 设置任务序列化之后的大小上限（字节），默认为 DefaultMaxPayloadBytes. 超过上限的任务添加时返回 ErrPayloadTooLarge.
+func WithMaxPayloadBytes(n int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.maxPayloadBytes = n
+	}
+}
+
+// WithLocalBuffer 开启本地写缓冲. 添加任务时 redis 连接异常，任务暂存在内存中并向调用方返回成功，redis 恢复之后由后台协程重新写入.
+// 缓冲中至多保存 maxTasks 个任务，已满时返回原本的错误. 缓冲中的任务在进程退出时丢失，也无法通过 RemoveTask 等方法删除或者查询.
+func WithLocalBuffer(maxTasks int) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.localBufferSize = maxTasks
+	}
+}
+
+// WithBufferDropHook 设置本地写缓冲丢弃任务时的回调：缓冲已满无法写入，或者重新写入时遇到连接异常以外的错误.
+func WithBufferDropHook(hook func(ctx context.Context, task *RTaskElement, err error)) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.bufferDropHook = hook
+	}
+}
+
+// WithSliceRetention 设置时间片 zset 在时间片结束之后保留的时长，默认为 DefaultSliceRetention.
+// 写入任务时刷新 zset 的过期时间，长时间停机之后仍未被扫描的任务随 zset 一同过期.
+// retention 不会短于补偿扫描、启动回填、租约以及预取所需的时长，暂停时间超过 retention 时，恢复后的补偿扫描无法取回过期的任务.
+func WithSliceRetention(retention time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.sliceRetention = retention
+	}
+}
+
+// WithSliceJanitor 开启时间片清理. leader 每隔 interval 通过 SCAN 查找结束时刻早于保留时长的时间片 zset 并取出其中的全部任务，
+// deadLetter 为 true 时，仍处于等待状态的任务写入死信队列，否则只记录日志. 用于清理未设置过期时间的历史 zset，
+// 开启后 zset 的过期时间额外延长 interval，保证过期之前至少被清理一次. redis cluster 下 SCAN 只遍历单个节点，不建议开启.
+func WithSliceJanitor(interval time.Duration, deadLetter bool) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.janitorInterval = interval
+		r.janitorDeadLetter = deadLetter
+	}
+}
+
+// WithPromotionHorizon 开启长期任务存储. 执行时刻晚于 horizon（如 1h）的任务写入以执行时刻为 score 的单个 zset <prefix>_overflow，
+// 不再为遥远的每个时间片创建 key，之后每隔 horizon/4 将执行时刻进入 horizon 的任务晋升到对应的时间片中. 开启 leader 选举时只有 leader 执行晋升.
+// horizon 不短于 5 分钟加上预取窗口. 长期 zset 中的任务同样可以通过 GetTask、RemoveTask、RescheduleTask 以及 UpdateTask 操作，
+// 但不会被 ListPendingTasks 以及 Stats 统计. 使用自定义 TaskStore 时不生效.
+func WithPromotionHorizon(horizon time.Duration) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.promotionHorizon = horizon
+	}
+}
+
+// WithOwnedClient 时间轮接管创建时传入的 redis 客户端，Stop 之后等待正在进行的扫描以及任务执行完成，随后关闭客户端，
+// Shutdown 在返回之前关闭客户端. 客户端关闭之后时间轮无法再次 Start. 默认由调用方负责关闭客户端.
+func WithOwnedClient() RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.ownedClient = true
+	}
+}
+
+// WithEventChannel 任务添加以及删除成功之后，向 redis 的 channel 发布 TaskEvent，其他组件可以通过订阅及时感知任务的变化.
+// 事件在 AddTask 以及 RemoveTask 返回之前同步发布，发布失败时只输出日志，不影响添加以及删除的结果；订阅者离线期间的事件不会保留.
+// 通过本地写缓冲暂存的任务以及批量删除的任务不发布事件. 默认不发布.
+func WithEventChannel(channel string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.eventChannel = channel
+	}
+}
+
+// WithRedisCompatibility 指定 redis 的版本，如 "6.0". 未指定时，时间轮在 Start 时通过 INFO server 检测版本.
+// 低于 6.2 的版本不支持 ZRANGE BYSCORE，检索任务时改用 ZRANGEBYSCORE. 通过 NewRedisTaskStore 手动创建的存储不会检测版本，需要指定该选项.
+func WithRedisCompatibility(version string) RTimeWheelOption {
+	return func(r *RTimeWheelOptions) {
+		r.redisVersion = version
+	}
+}
+
+func repairRTimeWheel(r *RTimeWheelOptions) {
+	if r.keyPrefix == "" {
+		r.keyPrefix = DefaultKeyPrefix
+	}
+
+	if r.codec == nil {
+		r.codec = JSONCodec{}
+	}
+
+	if r.sliceGranularity < time.Second || r.sliceGranularity%time.Second != 0 || time.Hour%r.sliceGranularity != 0 {
+		r.sliceGranularity = DefaultSliceGranularity
+	}
+
+	if r.location == nil {
+		r.location = time.UTC
+	}
+
+	if r.tickInterval <= 0 || r.tickInterval > time.Minute || time.Minute%r.tickInterval != 0 {
+		r.tickInterval = DefaultTickInterval
+	}
+
+	if r.windowOverlap < 0 {
+		r.windowOverlap = 0
+	}
+
+	if r.lookback < 0 {
+		r.lookback = 0
+	}
+
+	if r.startupLookback < 0 {
+		r.startupLookback = 0
+	}
+
+	if r.backfillRate <= 0 {
+		r.backfillRate = DefaultBackfillRate
+	}
+
+	if len(r.allowedMethods) == 0 {
+		r.allowedMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead}
+	}
+
+	if r.batchTimeout <= 0 {
+		r.batchTimeout = DefaultBatchTimeout
+	}
+
+	if r.fetchBatchSize <= 0 {
+		r.fetchBatchSize = DefaultFetchBatchSize
+	}
+
+	if r.maxPendingTasks < 0 {
+		r.maxPendingTasks = 0
+	}
+
+	if r.maxConcurrentBatches <= 0 {
+		r.maxConcurrentBatches = DefaultMaxConcurrentBatches
+	}
+
+	if r.maxConcurrency < 0 {
+		r.maxConcurrency = 0
+	}
+
+	if r.perHostConcurrency < 0 {
+		r.perHostConcurrency = 0
+	}
+
+	r.rateLimit = repairRate(r.rateLimit)
+	hostRateLimits := make(map[string]Rate, len(r.hostRateLimits))
+	for host, rate := range r.hostRateLimits {
+		if rate = repairRate(rate); rate.RPS > 0 {
+			hostRateLimits[host] = rate
+		}
+	}
+	r.hostRateLimits = hostRateLimits
+
+	if r.breakerWindow <= 0 {
+		r.breakerWindow = DefaultCircuitWindow
+	}
+
+	if r.breakerCooldown <= 0 {
+		r.breakerCooldown = DefaultCircuitCooldown
+	}
+
+	if r.breakerProbes <= 0 {
+		r.breakerProbes = 1
+	}
+
+	if r.circuitHook == nil {
+		r.circuitHook = func(ctx context.Context, host string, from, to CircuitState) {}
+	}
+
+	if r.idempotencyKeyHeader == "" {
+		r.idempotencyKeyHeader = DefaultIdempotencyKeyHeader
+	}
+
+	if r.attemptHeader == "" {
+		r.attemptHeader = DefaultAttemptHeader
+	}
+
+	if r.keyHeader == "" {
+		r.keyHeader = DefaultKeyHeader
+	}
+
+	if r.scheduledAtHeader == "" {
+		r.scheduledAtHeader = DefaultScheduledAtHeader
+	}
+
+	if r.firedAtHeader == "" {
+		r.firedAtHeader = DefaultFiredAtHeader
+	}
+
+	if r.signatureHeader == "" {
+		r.signatureHeader = DefaultSignatureHeader
+	}
+
+	if r.leaseDuration < 0 || r.instanceID == "" {
+		r.leaseDuration = 0
+	}
+
+	if r.prefetchWindow < 0 || r.leaseDuration == 0 {
+		r.prefetchWindow = 0
+	}
+
+	if r.leaderLeaseTTL < 0 || r.instanceID == "" {
+		r.leaderLeaseTTL = 0
+	}
+
+	if r.leaderLeaseTTL > 0 && r.leaderLeaseTTL < time.Second {
+		r.leaderLeaseTTL = time.Second
+	}
+
+	if r.shardCount < 2 || r.instanceID == "" {
+		r.shardCount = 0
+	}
+
+	if r.shardHeartbeatTimeout <= 0 {
+		r.shardHeartbeatTimeout = DefaultShardHeartbeatTimeout
+	}
+
+	// 去除超出范围以及重复的分片
+	staticShards := make([]int, 0, len(r.staticShards))
+	seenShards := make(map[int]bool, len(r.staticShards))
+	for _, shard := range r.staticShards {
+		if shard < 0 || shard >= r.shardCount || seenShards[shard] {
+			continue
+		}
+		seenShards[shard] = true
+		staticShards = append(staticShards, shard)
+	}
+	sort.Ints(staticShards)
+	r.staticShards = staticShards
+
+	if r.bucketCount < 2 {
+		r.bucketCount = 0
+	}
+
+	if r.leadershipHook == nil {
+		r.leadershipHook = func(ctx context.Context, isLeader bool) {}
+	}
+
+	if r.jitter < 0 {
+		r.jitter = 0
+	}
+
+	if r.maxStaleness < 0 {
+		r.maxStaleness = 0
+	}
+
+	if r.expiredHook == nil {
+		r.expiredHook = func(ctx context.Context, task *RTaskElement) {}
+	}
+
+	if r.exactlyOnceTTL <= 0 {
+		r.exactlyOnceTTL = DefaultExactlyOnceTTL
+	}
+
+	if r.duplicateHook == nil {
+		r.duplicateHook = func(ctx context.Context, task *RTaskElement) {}
+	}
+
+	if r.malformedTaskHook == nil {
+		r.malformedTaskHook = func(ctx context.Context, data []byte, err error) {}
+	}
+
+	if r.logger == nil {
+		r.logger = noopLogger{}
+	}
+
+	if r.panicHandler == nil {
+		logger := r.logger
+		r.panicHandler = func(recovered interface{}, stack []byte, task *RTaskElement) {
+			kv := []interface{}{"panic", recovered, "stack", string(stack)}
+			if task != nil {
+				kv = append(kv, "key", task.Key, "callback_url", task.CallbackURL)
+			}
+			logger.Error(context.Background(), "panic recovered", kv...)
+		}
+	}
+
+	if r.metrics == nil {
+		r.metrics = noopMetrics{}
+	}
+
+	if r.historyMaxLen < 0 {
+		r.historyMaxLen = 0
+	}
+
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = DefaultMaxBackoff
+	}
+
+	if r.maxRetryAfter <= 0 {
+		r.maxRetryAfter = DefaultMaxRetryAfter
+	}
+
+	if r.retryDelay <= 0 {
+		r.retryDelay = DefaultRetryDelay
+	}
+
+	if r.maxRetries <= 0 {
+		r.maxRetries = DefaultMaxRetries
+	}
+
+	if r.failureHook == nil {
+		r.failureHook = func(ctx context.Context, task *RTaskElement, err error) {}
+	}
+
+	if r.maxKeyLength <= 0 {
+		r.maxKeyLength = DefaultMaxKeyLength
+	}
+	if r.maxKeyLength > 0xffff {
+		r.maxKeyLength = 0xffff
+	}
+
+	if r.maxPayloadBytes <= 0 {
+		r.maxPayloadBytes = DefaultMaxPayloadBytes
+	}
+
+	if r.localBufferSize < 0 {
+		r.localBufferSize = 0
+	}
+
+	if r.bufferDropHook == nil {
+		r.bufferDropHook = func(ctx context.Context, task *RTaskElement, err error) {}
+	}
+
+	if r.sliceRetention <= 0 {
+		r.sliceRetention = DefaultSliceRetention
+	}
+	// 保留时长需要覆盖补偿扫描、启动回填、租约接管以及预取需要访问的历史时间片
+	if minRetention := r.lookback + r.startupLookback + r.leaseDuration + r.prefetchWindow + deleteSetSlack; r.sliceRetention < minRetention {
+		r.sliceRetention = minRetention
+	}
+
+	if r.janitorInterval < 0 {
+		r.janitorInterval = 0
+	}
+
+	if r.promotionHorizon < 0 {
+		r.promotionHorizon = 0
+	}
+	// 晋升需要在任务进入扫描以及预取的范围之前完成
+	if minHorizon := minPromotionHorizon + r.prefetchWindow; r.promotionHorizon > 0 && r.promotionHorizon < minHorizon {
+		r.promotionHorizon = minHorizon
+	}
+
+	if r.redisVersion != "" {
+		r.legacyZrange = isLegacyRedis(r.redisVersion)
+	}
+}
+
+// 速率不大于 0 时不做限制，令牌桶至少能够容纳一个令牌
+func repairRate(rate Rate) Rate {
+	if rate.RPS <= 0 {
+		return Rate{}
+	}
+	if rate.Burst < 1 {
+		rate.Burst = 1
+	}
+	return rate
+}
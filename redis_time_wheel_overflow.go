@@ -0,0 +1,173 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// 长期任务存储.
+// 开启 WithPromotionHorizon 时，执行时刻晚于 horizon 的任务写入以执行时刻为 score 的单个 zset，晋升协程定期将执行时刻进入 horizon 的任务迁移到对应的时间片.
+//
+// 长期 zset 与时间片可能分布在 redis cluster 的不同节点上，晋升拆分为两步：先在时间片所在的节点上写入晋升标识并添加任务，再从长期 zset 中移除.
+// 晋升标识 <prefix>_promoted_{时间片}<分片>:<唯一键>:<score> 与时间片 zset 带有相同的 {hash_tag}，与添加任务在同一个 lua 脚本中完成，
+// 标识存在即说明任务已经写入时间片：重复晋升不会重复添加任务，晋升中途崩溃之后重新执行即可完成迁移.
+// 迁移、更新长期 zset 中的任务之前同样先写入晋升标识，与晋升协程互斥.
+
+const (
+	// 晋升的最短 horizon
+	minPromotionHorizon = 5 * time.Minute
+	// 每次从长期 zset 中检索的任务数量
+	promotionBatchSize = 500
+)
+
+// 是否开启长期任务存储. 自定义 TaskStore 不支持
+func (r *RTimeWheel) overflowMode() bool {
+	return r.opts.promotionHorizon > 0 && r.opts.taskStore == nil
+}
+
+// 执行时刻晚于 horizon 的任务写入长期 zset
+func (r *RTimeWheel) isOverflow(executeAt time.Time) bool {
+	return r.overflowMode() && time.Until(executeAt) > r.opts.promotionHorizon
+}
+
+func (r *RTimeWheel) promotionInterval() time.Duration {
+	return r.opts.promotionHorizon / 4
+}
+
+func (r *RTimeWheel) getOverflowKey() string {
+	return r.opts.keyPrefix + "_overflow"
+}
+
+// 任务的晋升标识 <prefix>_promoted_{时间片}<分片>:<唯一键>:<score>
+func (r *RTimeWheel) getPromotedKey(key string, score int64) string {
+	slice, shard := splitSliceShard(r.getTaskSliceStr(key, time.Unix(score, 0)))
+	return fmt.Sprintf("%s_promoted_{%s}%s:%s:%d", r.opts.keyPrefix, slice, shard, escapeKeyPart(key), score)
+}
+
+// 将任务写入长期 zset. clearDeleted 为 true 时与写入时间片相同，清除任务所在时间片中该唯一键已有的删除标识
+func (r *RTimeWheel) addOverflowTask(ctx context.Context, key string, taskBody []byte, executeAt time.Time, clearDeleted bool) error {
+	if clearDeleted {
+		if _, err := r.redisClient.SRem(ctx, r.getTaskDeleteSetKey(key, executeAt), key); err != nil {
+			return err
+		}
+	}
+	_, err := r.redisClient.ZAdd(ctx, r.getOverflowKey(), float64(executeAt.Unix()), string(taskBody))
+	return err
+}
+
+// 查询长期 zset 中的任务，take 为 true 时将其移除. 任务不存在时返回 nil
+func (r *RTimeWheel) getOverflowTask(ctx context.Context, key string, score int64, take bool) ([]byte, error) {
+	args := []interface{}{r.getOverflowKey(), score, key, 0}
+	if take {
+		args[3] = 1
+	}
+	reply, err := r.redisClient.Eval(ctx, LuaOverflowTask, 1, args)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	return []byte(gocast.ToString(reply)), nil
+}
+
+// 写入任务的晋升标识. 返回 false 说明任务已经被晋升到时间片中
+func (r *RTimeWheel) claimPromotion(ctx context.Context, key string, score int64) (bool, error) {
+	reply, err := r.redisClient.Eval(ctx, LuaClaimPromotion, 1, []interface{}{
+		r.getPromotedKey(key, score),
+		r.getDeleteSetExpireSeconds(time.Now(), time.Unix(score, 0)),
+	})
+	return reply != nil, err
+}
+
+// 晋升协程. 开启 leader 选举时只有 leader 执行，每次取出执行时刻进入 horizon 的全部任务
+func (r *RTimeWheel) promoteTasks() {
+	defer r.recoverPanic(nil)
+
+	if !r.IsLeader() || !r.overflowMode() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, r.promotionInterval())
+	defer cancel()
+
+	until := time.Now().Add(r.opts.promotionHorizon)
+	for {
+		n, err := r.promoteBatch(ctx, until)
+		if err != nil {
+			r.opts.logger.Warn(ctx, "promote tasks failed", "err", err)
+			return
+		}
+		if n < promotionBatchSize {
+			return
+		}
+	}
+}
+
+// 晋升一批任务，返回检索到的任务数量. 按照时间片分组，每个时间片执行一次 lua 脚本，全部写入之后再从长期 zset 中移除
+func (r *RTimeWheel) promoteBatch(ctx context.Context, until time.Time) (int, error) {
+	reply, err := r.redisClient.Eval(ctx, LuaPeekOverflow, 1, []interface{}{
+		r.getOverflowKey(),
+		until.Unix(),
+		promotionBatchSize,
+	})
+	if err != nil {
+		return 0, err
+	}
+	values := gocast.ToStringSlice(reply) // 依次为任务明细及其 score
+	if len(values)%2 != 0 {
+		return 0, fmt.Errorf("invalid peek reply %v", reply)
+	}
+
+	type promotion struct {
+		keys, args []interface{}
+		members    []string
+	}
+	now := time.Now()
+	sliceToPromotion := make(map[string]*promotion)
+	// 无法解析的任务无法晋升，直接从长期 zset 中移除
+	var promoted []string
+	for i := 0; i+1 < len(values); i += 2 {
+		body, score := values[i], gocast.ToInt64(values[i+1])
+		key, err := decodeTaskKey([]byte(body))
+		if err != nil {
+			r.handleMalformedTask(ctx, r.getOverflowKey(), []byte(body), err)
+			promoted = append(promoted, body)
+			continue
+		}
+
+		executeAt := time.Unix(score, 0)
+		sliceStr := r.getTaskSliceStr(key, executeAt)
+		p, ok := sliceToPromotion[sliceStr]
+		if !ok {
+			p = &promotion{
+				keys: []interface{}{sliceTaskKey(r.opts.keyPrefix, sliceStr)},
+				args: []interface{}{r.getSliceExpireSeconds(now, executeAt), r.getDeleteSetExpireSeconds(now, executeAt)},
+			}
+			sliceToPromotion[sliceStr] = p
+		}
+		p.keys = append(p.keys, r.getPromotedKey(key, score))
+		p.args = append(p.args, score, body)
+		p.members = append(p.members, body)
+	}
+
+	var firstErr error
+	for sliceStr, p := range sliceToPromotion {
+		reply, err := r.redisClient.Eval(ctx, LuaPromoteTasks, len(p.keys), append(p.keys, p.args...))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("promote tasks to slice %s, err: %w", sliceStr, err)
+			}
+			continue
+		}
+		r.opts.metrics.IncTasksPromoted(gocast.ToInt(reply))
+		promoted = append(promoted, p.members...)
+	}
+
+	if len(promoted) > 0 {
+		if _, err := r.redisClient.ZRem(ctx, r.getOverflowKey(), promoted...); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return len(values) / 2, firstErr
+}
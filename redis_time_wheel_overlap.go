@@ -0,0 +1,78 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// 重叠窗口模式.
+// 每次扫描的窗口向前延伸 windowOverlap，相邻两次扫描以及不同实例的扫描会检索到相同的任务. 检索时不再移除任务，
+// 而是逐个通过 zrem 认领任务的执行，只有成功将任务移出 zset 的实例才会执行任务.
+// 认领的结果只取决于 zrem，因此认领之后以相同的唯一键以及执行时刻重新写入的任务能够再次被认领，
+// 已经被其他实例移出的任务无论间隔多久都不会被重复认领.
+
+// 是否通过先检索、再认领的方式取出任务. 未开启重叠窗口时同样先检索，以便周期任务在移出 zset 之前写入下一次执行.
+// 租约模式以及自定义 TaskStore 按照各自的方式取出任务
+func (r *RTimeWheel) claimMode() bool {
+	return r.opts.leaseDuration == 0 && r.opts.taskStore == nil
+}
+
+// 扫描窗口的起点，开启重叠窗口模式时向前延伸 windowOverlap
+func (r *RTimeWheel) overlapStart(start time.Time) time.Time {
+	return start.Add(-r.opts.windowOverlap)
+}
+
+// 检索 [from, to) 范围内至多 limit 个任务并逐个认领，只返回认领成功的任务. 检索到 limit 个任务时第二个返回值为 true，需要继续检索下一页
+func (r *RTimeWheel) claimTasks(ctx context.Context, sliceStr string, from, to int64, limit int) ([]StoredTask, bool, error) {
+	zsetKey := sliceTaskKey(r.opts.keyPrefix, sliceStr)
+	score1, score2 := formatScoreRange(from, to)
+	script := peekTasksScript
+	if r.opts.legacyZrange {
+		script = peekTasksLegacyScript
+	}
+	reply, err := r.redisClient.EvalScript(ctx, script, 2, []interface{}{
+		zsetKey,
+		sliceDeleteSetKey(r.opts.keyPrefix, sliceStr),
+		score1,
+		score2,
+		limit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	peeked, err := parseFetchReply(reply)
+	if err != nil || len(peeked) == 0 {
+		return nil, false, err
+	}
+	// 认领失败的任务已经由认领成功的实例移出 zset，下一页不会再次检索到
+	more := len(peeked) >= limit
+
+	// 周期任务在移出 zset 之前写入下一次执行，认领之后宕机也不会丢失后续的周期
+	r.scheduleNextOccurrences(ctx, peeked)
+
+	// 已经标记删除以及无法解析的任务同样需要认领，由认领成功的实例将其移出 zset
+	args := make([]interface{}, 0, 1+len(peeked))
+	args = append(args, zsetKey)
+	for _, st := range peeked {
+		args = append(args, string(st.Body))
+	}
+	reply, err = r.redisClient.EvalScript(ctx, claimTasksScript, 1, args)
+	if err != nil {
+		return nil, false, err
+	}
+
+	claimed := gocast.ToIntSlice(reply)
+	if len(claimed) != len(peeked) {
+		return nil, false, fmt.Errorf("invalid claim reply %v", reply)
+	}
+	tasks := make([]StoredTask, 0, len(peeked))
+	for i, st := range peeked {
+		if claimed[i] == 1 {
+			tasks = append(tasks, st)
+		}
+	}
+	return tasks, more, nil
+}
@@ -0,0 +1,85 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// 任务执行的协程池.
+// 设置 WithMaxConcurrency 时，时间轮在 Start 时创建 maxConcurrency 个常驻的 worker，批次通过 channel 将任务交给空闲的 worker 执行，
+// 不再为每个任务创建新的协程. worker 在空闲时阻塞在 channel 上，不消耗 CPU. run 协程退出并且正在进行的批次全部完成之后销毁协程池.
+// 未启动的时间轮以及未设置并发上限时，仍然为每个任务创建新的协程.
+
+var errPoolStopped = errors.New("worker pool stopped")
+
+type workerPool struct {
+	jobs chan func()
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newWorkerPool(size int) *workerPool {
+	p := &workerPool{jobs: make(chan func()), quit: make(chan struct{})}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// job 需要自行处理 panic，避免 worker 退出
+func (p *workerPool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case job := <-p.jobs:
+			job()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// 等待空闲的 worker 执行 job. ctx 到期时返回 ctx 的错误，协程池已经销毁时返回 errPoolStopped
+func (p *workerPool) submit(ctx context.Context, job func()) error {
+	select {
+	case p.jobs <- job:
+		return nil
+	case <-p.quit:
+		return errPoolStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 销毁协程池，等待 worker 执行完手头的任务之后退出
+func (p *workerPool) stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+// 创建协程池，返回销毁协程池的函数. 未设置并发上限时不创建
+func (r *RTimeWheel) startWorkerPool() func() {
+	if r.opts.maxConcurrency <= 0 {
+		return func() {}
+	}
+	pool := newWorkerPool(r.opts.maxConcurrency)
+	r.poolMu.Lock()
+	r.pool = pool
+	r.poolMu.Unlock()
+	return func() {
+		r.poolMu.Lock()
+		if r.pool == pool {
+			r.pool = nil
+		}
+		r.poolMu.Unlock()
+		pool.stop()
+	}
+}
+
+func (r *RTimeWheel) workerPool() *workerPool {
+	r.poolMu.Lock()
+	defer r.poolMu.Unlock()
+	return r.pool
+}
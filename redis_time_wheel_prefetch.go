@@ -0,0 +1,154 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// 预取模式.
+// 默认模式下，任务在扫描窗口到来时才被取出执行，执行时刻的精度受限于扫描间隔.
+// 预取模式基于租约模式实现：每次 tick 提前取出未来 prefetchWindow 范围内的任务，转移到当前实例的 in-flight zset 中，
+// 再通过本地定时器在任务的执行时刻触发执行，执行完成后确认租约. 实例宕机时，租约到期后由其他实例接管.
+//
+// 任务被预取之后，RemoveTask 写入的删除标识无法再随扫描一并过滤，因此在本地触发执行之前，需要再次检查删除标识.
+
+// 预取 [start, end) 范围内的任务，按照执行时刻分组设置本地定时器
+func (r *RTimeWheel) prefetchTasks(start, end time.Time) {
+	defer r.recoverPanic(nil)
+
+	if r.IsPaused() {
+		return
+	}
+
+	release := r.acquireBatch()
+	defer release()
+
+	tctx, cancel := r.newBatchContext()
+	defer cancel()
+
+	groups := make(map[int64][]*RTaskElement)
+	scanStart, scanned := time.Now(), true
+	for _, slice := range r.getSlices(start, end) {
+		sliceTasks, _, err := r.getExecutableTasks(tctx, slice, ceilSeconds(start), ceilSeconds(end))
+		if err != nil {
+			r.opts.logger.Error(tctx, "prefetch tasks failed", "slice", r.getMinuteSlice(slice), "start", start, "end", end, "err", err)
+			r.onScanError(tctx, err)
+			scanned = false
+		}
+		for _, task := range sliceTasks {
+			score := task.scheduledAt.Unix()
+			groups[score] = append(groups[score], task)
+		}
+	}
+	r.opts.metrics.ObserveScanDuration(time.Since(scanStart))
+	if scanned {
+		r.markScanned(time.Now())
+	}
+
+	for score, tasks := range groups {
+		r.schedulePrefetched(time.Unix(score, 0), tasks)
+	}
+}
+
+// 在 executeAt 时刻触发执行预取的任务. 时间轮停止后不再触发，任务的租约到期后由其他实例接管
+func (r *RTimeWheel) schedulePrefetched(executeAt time.Time, tasks []*RTaskElement) {
+	r.prefetchMu.Lock()
+	defer r.prefetchMu.Unlock()
+	if r.prefetchStopped {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(executeAt), func() {
+		r.prefetchMu.Lock()
+		defer r.prefetchMu.Unlock()
+		if _, ok := r.prefetchTimers[timer]; !ok {
+			return
+		}
+		delete(r.prefetchTimers, timer)
+		r.goTracked(func() { r.firePrefetched(tasks) })
+	})
+	r.prefetchTimers[timer] = struct{}{}
+}
+
+func (r *RTimeWheel) firePrefetched(tasks []*RTaskElement) {
+	defer r.recoverPanic(nil)
+
+	// 暂停期间不执行，任务的租约到期后会在恢复运行时被重新接管
+	if r.IsPaused() {
+		return
+	}
+
+	tctx, cancel := r.newBatchContext()
+	defer cancel()
+
+	tasks, err := r.filterDeleted(tctx, tasks)
+	if err != nil {
+		// 无法确认任务是否已被删除时不执行，等待租约到期后重新检查
+		r.opts.logger.Error(tctx, "check deleted tasks failed", "slice", r.getMinuteSlice(tasks[0].scheduledAt), "err", err)
+		r.onScanError(tctx, err)
+		return
+	}
+	r.executeBatch(tctx, tasks)
+}
+
+// 过滤存在删除标识的任务，并确认这部分任务的租约. 开启分桶时按照任务所属的删除集合分组检查
+func (r *RTimeWheel) filterDeleted(ctx context.Context, tasks []*RTaskElement) ([]*RTaskElement, error) {
+	groups := make(map[string][]*RTaskElement)
+	for _, task := range tasks {
+		deleteSetKey := r.getTaskDeleteSetKey(task.Key, task.scheduledAt)
+		groups[deleteSetKey] = append(groups[deleteSetKey], task)
+	}
+
+	remaining := make([]*RTaskElement, 0, len(tasks))
+	for deleteSetKey, group := range groups {
+		args := make([]interface{}, 0, 1+len(group))
+		args = append(args, deleteSetKey)
+		for _, task := range group {
+			args = append(args, task.Key)
+		}
+		reply, err := r.redisClient.EvalRetryable(ctx, LuaCheckDeletedTasks, 1, args)
+		if err != nil {
+			return tasks, err
+		}
+
+		deleted := gocast.ToInterfaceSlice(reply)
+		for i, task := range group {
+			if i < len(deleted) && gocast.ToInt(deleted[i]) == 1 {
+				r.ackTask(task)
+				continue
+			}
+			remaining = append(remaining, task)
+		}
+	}
+	return remaining, nil
+}
+
+// executeAt 位于预取范围之内的任务，可能已经被取出并等待本地触发
+func (r *RTimeWheel) isPrefetchable(executeAt, now time.Time) bool {
+	if r.opts.prefetchWindow <= 0 {
+		return false
+	}
+	_, end := r.getScanWindow(now.Add(r.opts.prefetchWindow))
+	return !executeAt.Before(now) && executeAt.Before(end)
+}
+
+// 停止尚未触发的本地定时器
+func (r *RTimeWheel) stopPrefetch() {
+	r.prefetchMu.Lock()
+	defer r.prefetchMu.Unlock()
+	r.prefetchStopped = true
+	for timer := range r.prefetchTimers {
+		timer.Stop()
+	}
+	r.prefetchTimers = nil
+}
+
+func (r *RTimeWheel) resetPrefetch() {
+	r.prefetchMu.Lock()
+	defer r.prefetchMu.Unlock()
+	r.prefetchStopped = false
+	r.prefetchTimers = make(map[*time.Timer]struct{})
+}
@@ -0,0 +1,151 @@
+package timewheel
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 限流.
+// 同一时刻到期的任务数量可能远超下游服务能够承受的请求速率. 开启限流后，任务在发起回调之前需要从令牌桶中获取令牌，
+// 令牌不足时延后派发而不是丢弃. 在批次超时之前无法获取令牌的任务重新写入时间轮，在令牌恢复的时刻再次执行，
+// 延后的任务保留原本的幂等键，并且不会重复调度周期任务的下一次执行.
+//
+// 限流仅在单个实例内生效，多个实例时需要按照实例数量分摊速率.
+
+// Rate 令牌桶的速率，每秒生成 RPS 个令牌，最多积累 Burst 个令牌.
+type Rate struct {
+	RPS   float64
+	Burst int
+}
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate Rate) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: float64(rate.Burst), last: time.Now()}
+}
+
+// 预占一个令牌，返回需要等待的时长. 等待结束的时刻晚于 deadline 时不预占令牌，返回 false
+func (b *tokenBucket) reserve(now, deadline time.Time) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * b.rate.RPS
+		if b.tokens > float64(b.rate.Burst) {
+			b.tokens = float64(b.rate.Burst)
+		}
+		b.last = now
+	}
+
+	var wait time.Duration
+	if tokens := b.tokens - 1; tokens < 0 {
+		wait = time.Duration(-tokens / b.rate.RPS * float64(time.Second))
+	}
+	if !deadline.IsZero() && now.Add(wait).After(deadline) {
+		return wait, false
+	}
+	b.tokens--
+	return wait, true
+}
+
+// 归还预占的令牌
+func (b *tokenBucket) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens++
+}
+
+// 速率不大于 0 的主机不做限制，否则等待时长为无穷大；令牌桶至少能够容纳一个令牌
+func newHostLimiters(rates map[string]Rate) map[string]*tokenBucket {
+	limiters := make(map[string]*tokenBucket, len(rates))
+	for host, rate := range rates {
+		if rate = repairRate(rate); rate.RPS > 0 {
+			limiters[host] = newTokenBucket(rate)
+		}
+	}
+	return limiters
+}
+
+// 任务适用的令牌桶：全局令牌桶以及回调地址主机名对应的令牌桶
+func (r *RTimeWheel) limitersFor(task *RTaskElement) []*tokenBucket {
+	var limiters []*tokenBucket
+	if r.limiter != nil {
+		limiters = append(limiters, r.limiter)
+	}
+	if len(r.hostLimiters) > 0 && task.CallbackURL != "" {
+		if u, err := url.Parse(task.CallbackURL); err == nil {
+			if limiter, ok := r.hostLimiters[u.Hostname()]; ok {
+				limiters = append(limiters, limiter)
+			}
+		}
+	}
+	return limiters
+}
+
+// 等待令牌. 无法在 ctx 到期之前获取令牌时返回 false 以及预计需要等待的时长
+func (r *RTimeWheel) waitRateLimit(ctx context.Context, task *RTaskElement) (time.Duration, bool) {
+	limiters := r.limitersFor(task)
+	if len(limiters) == 0 {
+		return 0, true
+	}
+
+	now := time.Now()
+	deadline, _ := ctx.Deadline()
+	var wait time.Duration
+	for i, limiter := range limiters {
+		limiterWait, ok := limiter.reserve(now, deadline)
+		if limiterWait > wait {
+			wait = limiterWait
+		}
+		if !ok {
+			for _, reserved := range limiters[:i] {
+				reserved.cancel()
+			}
+			return wait, false
+		}
+	}
+	if wait <= 0 {
+		return 0, true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return wait, true
+	case <-ctx.Done():
+		for _, limiter := range limiters {
+			limiter.cancel()
+		}
+		return wait, false
+	}
+}
+
+// 将暂时无法派发的任务延后到 wait 之后重新执行. 延后的任务保留首次执行时刻，从而沿用相同的幂等键
+func (r *RTimeWheel) deferTask(task *RTaskElement, wait time.Duration, reason string) {
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+
+	deferred := *task
+	deferred.JitterOffset = 0
+	if deferred.FirstScheduledAt.IsZero() {
+		deferred.FirstScheduledAt = task.scheduledAt
+	}
+	now := time.Now()
+	executeAt := r.notBeforeNextWindow(now, now.Add(wait))
+	if err := r.addTask(ctx, &deferred, executeAt); err != nil {
+		r.opts.logger.Warn(ctx, "defer task failed", "key", task.Key, "callback_url", task.CallbackURL, "reason", reason, "err", err)
+		r.opts.metrics.IncTasksFailed()
+		r.onFailure(ctx, task, err)
+		r.handleFailure(task, err)
+		return
+	}
+	r.opts.logger.Info(ctx, "task deferred", "key", task.Key, "callback_url", task.CallbackURL, "reason", reason, "execute_at", executeAt)
+}
@@ -0,0 +1,76 @@
+package timewheel
+
+import (
+	"context"
+	"time"
+
+	thttp "github.com/xiaoxuxiansheng/timewheel/pkg/http"
+)
+
+const (
+	// 单次投递执行结果的超时时间
+	resultTimeout = 3 * time.Second
+	// 投递执行结果失败后的重试次数
+	resultRetries = 2
+	// 投递执行结果的重试间隔基数
+	resultBackoff = 500 * time.Millisecond
+	// 执行结果中保留的错误信息长度上限
+	maxResultErrorSize = 512
+)
+
+// TaskResult 任务的最终执行结果. 任务设置了 ResultURL 时，执行成功或者最终失败（不再重试）后以 json 格式 POST 到 ResultURL.
+type TaskResult struct {
+	Key         string    `json:"key"`
+	Status      string    `json:"status"`       // 执行结果，HistoryStatusOK 或者 HistoryStatusFail
+	ScheduledAt time.Time `json:"scheduled_at"` // 任务首次的执行时刻，重试不会改变该值
+	FiredAt     time.Time `json:"fired_at"`     // 最后一次发起执行的时刻
+	Attempts    int       `json:"attempts"`     // 发起执行的次数
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"` // 截断后的错误信息
+}
+
+// 任务最终失败，交给失败回调处理并投递执行结果
+func (r *RTimeWheel) onTerminalFailure(ctx context.Context, task *RTaskElement, err error) {
+	r.opts.failureHook(ctx, task, err)
+	r.reportResult(task, err)
+}
+
+// 异步投递执行结果，失败时按照退避重试. 投递的结果只输出日志以及计入监控指标，不影响任务自身的状态
+func (r *RTimeWheel) reportResult(task *RTaskElement, execErr error) {
+	if task.ResultURL == "" || r.opts.dryRunSink != nil {
+		return
+	}
+
+	result := TaskResult{
+		Key:         task.Key,
+		Status:      HistoryStatusOK,
+		ScheduledAt: task.firstScheduledAt(),
+		FiredAt:     task.firedAt,
+		Attempts:    task.Attempt + 1,
+	}
+	if execErr != nil {
+		result.Status = HistoryStatusFail
+		result.StatusCode = thttp.StatusCode(execErr)
+		if result.Error = execErr.Error(); len(result.Error) > maxResultErrorSize {
+			result.Error = result.Error[:maxResultErrorSize]
+		}
+	}
+
+	resultURL := task.ResultURL
+	r.goTracked(func() {
+		var err error
+		for attempt := 0; attempt <= resultRetries; attempt++ {
+			if attempt > 0 {
+				time.Sleep(resultBackoff << (attempt - 1))
+			}
+			ctx, cancel := context.WithTimeout(r.ctx, resultTimeout)
+			err = r.httpClient.JSONPost(ctx, resultURL, nil, &result, nil)
+			cancel()
+			if err == nil {
+				return
+			}
+		}
+		r.opts.metrics.IncResultDeliveryFailures()
+		r.opts.logger.Warn(context.Background(), "deliver task result failed", "key", task.Key, "result_url", resultURL, "err", err)
+	})
+}
@@ -0,0 +1,337 @@
+package timewheel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	thttp "github.com/xiaoxuxiansheng/timewheel/pkg/http"
+)
+
+const (
+	// 重新入队失败时，内存中最多缓存的任务数量
+	maxRetryBufferSize = 10000
+	// 死信队列保留的最大记录数
+	maxDeadLetterSize = 100000
+)
+
+// DeadLetter 重试次数耗尽后写入死信队列的记录.
+type DeadLetter struct {
+	Task        *RTaskElement `json:"task"`
+	Error       string        `json:"error"`
+	FailedAt    time.Time     `json:"failed_at"`
+	ScheduledAt time.Time     `json:"scheduled_at"` // 任务首次的执行时刻，重试不会改变该值
+	Attempts    int           `json:"attempts"`     // 发起执行的次数
+
+	raw string // 死信队列中的原始记录，用于重新入队时定位记录
+}
+
+// RetryAfterError 回调响应携带了 Retry-After，任务按照 Delay 延后重试而不是采用退避策略. Delay 已经按照 WithMaxRetryAfter 截断，
+// 通过 ExecutionHooks.OnFailure 可以观察到实际采用的延迟.
+type RetryAfterError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%v, retry after: %v", e.Err, e.Delay)
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// 回调响应携带 Retry-After 时，将其截断后附加到错误中
+func (r *RTimeWheel) withRetryAfter(err error) error {
+	retryAfter := r.retryAfter(err)
+	if retryAfter <= 0 {
+		return err
+	}
+	return &RetryAfterError{Err: err, Delay: retryAfter}
+}
+
+// 回调响应要求的等待时长，不超过 WithMaxRetryAfter 设置的上限
+func (r *RTimeWheel) retryAfter(err error) time.Duration {
+	retryAfter := thttp.RetryAfter(err)
+	if retryAfter > r.opts.maxRetryAfter {
+		retryAfter = r.opts.maxRetryAfter
+	}
+	return retryAfter
+}
+
+type retryEntry struct {
+	task      *RTaskElement
+	executeAt time.Time
+	err       error
+}
+
+// 任务执行失败时，倘若仍有重试次数，则按照指数退避重新添加到时间轮中，否则交给失败回调处理.
+// 开启 at-least-once 投递模式后，未设置重试次数的任务同样会按照时间轮的默认策略重试，重试次数耗尽后写入死信队列.
+func (r *RTimeWheel) handleFailure(task *RTaskElement, err error) {
+	// 批次的 context 可能已经超时，重试需要使用独立的 context
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+
+	retryable := r.isRetryable(task, err)
+	if !retryable || task.Attempt >= r.maxRetries(task) {
+		r.opts.logger.Error(ctx, "task failed, no more retries", "key", task.Key, "callback_url", task.CallbackURL, "attempt", task.Attempt+1,
+			"retryable", retryable, "err", err)
+		if r.opts.atLeastOnce {
+			if dlErr := r.pushDeadLetter(ctx, task, err); dlErr != nil {
+				err = fmt.Errorf("execute err: %w, dead letter err: %v", err, dlErr)
+			}
+		}
+		r.onTerminalFailure(ctx, task, err)
+		return
+	}
+
+	retry := *task
+	retry.Attempt++
+	retry.JitterOffset = 0
+	if retry.FirstScheduledAt.IsZero() {
+		retry.FirstScheduledAt = task.scheduledAt
+	}
+	// 回调响应携带 Retry-After 时以其为准，否则按照退避策略计算延迟
+	delay := r.backoff(&retry)
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		delay = retryAfterErr.Delay
+	}
+	now := time.Now()
+	executeAt := r.notBeforeNextWindow(now, now.Add(delay))
+	retryErr := r.addTask(ctx, &retry, executeAt)
+	if retryErr == nil {
+		return
+	}
+
+	r.opts.logger.Warn(ctx, "retry task failed", "key", task.Key, "callback_url", task.CallbackURL, "err", retryErr)
+	// at-least-once 模式下，重新入队失败的任务先缓存在内存中，等待 redis 恢复后再次写入
+	if r.opts.atLeastOnce && r.bufferRetry(&retryEntry{task: &retry, executeAt: executeAt, err: err}) {
+		return
+	}
+	r.onTerminalFailure(ctx, task, fmt.Errorf("execute err: %w, retry err: %v", err, retryErr))
+}
+
+// 执行失败的任务是否允许重试. 网络错误、超时等未拿到响应的失败总是允许重试，
+// 非 2xx 响应按照任务自身设置的状态码判断，未设置时使用时间轮的默认值
+func (r *RTimeWheel) isRetryable(task *RTaskElement, err error) bool {
+	statusCode := thttp.StatusCode(err)
+	if statusCode == 0 {
+		return true
+	}
+	codes := task.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = r.opts.retryableStatusCodes
+	}
+	// 默认仅重试请求超时、限流以及服务端错误，其余 4xx 说明请求本身有误，重试也无法成功
+	if len(codes) == 0 {
+		return statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500
+	}
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// 任务是否已经过期. 任务自身设置的最大延迟优先，未设置时使用时间轮的默认值，均为 0 时任务永不过期
+func (r *RTimeWheel) isStale(task *RTaskElement, now time.Time) bool {
+	maxStaleness := task.MaxStaleness
+	if maxStaleness <= 0 {
+		maxStaleness = r.opts.maxStaleness
+	}
+	return maxStaleness > 0 && now.Sub(task.scheduledAt) > maxStaleness
+}
+
+// 过期的任务交给过期回调处理，at-least-once 模式下同时写入死信队列
+func (r *RTimeWheel) handleExpired(task *RTaskElement) {
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+
+	if r.opts.atLeastOnce {
+		if err := r.pushDeadLetter(ctx, task, ErrTaskExpired); err != nil {
+			r.opts.logger.Error(ctx, "push dead letter failed", "key", task.Key, "callback_url", task.CallbackURL, "err", err)
+		}
+	}
+	r.opts.expiredHook(ctx, task)
+}
+
+// 任务的最大重试次数. 任务自身设置的重试次数优先，at-least-once 模式下未设置时使用时间轮的默认值
+func (r *RTimeWheel) maxRetries(task *RTaskElement) int {
+	if task.MaxRetries > 0 || !r.opts.atLeastOnce {
+		return task.MaxRetries
+	}
+	return r.opts.maxRetries
+}
+
+// 计算第 task.Attempt 次重试的退避时长
+func (r *RTimeWheel) backoff(task *RTaskElement) time.Duration {
+	base := task.BackoffBase
+	if base <= 0 && r.opts.atLeastOnce {
+		base = r.opts.retryDelay
+	}
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+
+	backoff := base
+	for i := 1; i < task.Attempt && backoff < r.opts.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.opts.maxBackoff {
+		backoff = r.opts.maxBackoff
+	}
+	return backoff
+}
+
+// 从本次执行开始，全部重试耗尽所需的最长时长. 每次重试按照退避时长与 Retry-After 上限中较大者计算，并计入批次超时时间
+func (r *RTimeWheel) retryHorizon(task *RTaskElement) time.Duration {
+	retry := *task
+	var horizon time.Duration
+	for retry.Attempt < r.maxRetries(task) {
+		retry.Attempt++
+		delay := r.backoff(&retry)
+		if delay < r.opts.maxRetryAfter {
+			delay = r.opts.maxRetryAfter
+		}
+		horizon += delay + r.opts.batchTimeout
+	}
+	return horizon
+}
+
+func (r *RTimeWheel) bufferRetry(entry *retryEntry) bool {
+	r.retryMu.Lock()
+	defer r.retryMu.Unlock()
+	if len(r.retryBuffer) >= maxRetryBufferSize {
+		return false
+	}
+	r.retryBuffer = append(r.retryBuffer, entry)
+	return true
+}
+
+// 将缓存的重试任务重新写入 redis. 已经过期的任务调整到下一个扫描窗口执行
+func (r *RTimeWheel) flushRetryBuffer() {
+	r.retryMu.Lock()
+	entries := r.retryBuffer
+	r.retryBuffer = nil
+	r.retryMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.ctx, 3*time.Second)
+	defer cancel()
+
+	for i, entry := range entries {
+		executeAt := r.notBeforeNextWindow(time.Now(), entry.executeAt)
+		if err := r.addTask(ctx, entry.task, executeAt); err != nil {
+			// redis 仍不可用，剩余的任务放回缓存，等待下一次写入
+			r.opts.logger.Warn(ctx, "flush retry buffer failed", "key", entry.task.Key, "left", len(entries)-i, "err", err)
+			for _, left := range entries[i:] {
+				if !r.bufferRetry(left) {
+					r.onTerminalFailure(ctx, left.task, fmt.Errorf("execute err: %w, retry err: %v", left.err, err))
+				}
+			}
+			return
+		}
+	}
+}
+
+func (r *RTimeWheel) pushDeadLetter(ctx context.Context, task *RTaskElement, err error) error {
+	record, _ := json.Marshal(newDeadLetter(task, err, time.Now()))
+	_, evalErr := r.redisClient.Eval(ctx, LuaPushDeadLetter, 1, []interface{}{
+		r.getDeadLetterKey(),
+		string(record),
+		maxDeadLetterSize,
+	})
+	return evalErr
+}
+
+func newDeadLetter(task *RTaskElement, err error, failedAt time.Time) *DeadLetter {
+	return &DeadLetter{
+		Task:        task,
+		Error:       err.Error(),
+		FailedAt:    failedAt,
+		ScheduledAt: task.firstScheduledAt(),
+		Attempts:    task.Attempt + 1,
+	}
+}
+
+// ListDeadLetters 从新到旧查询死信队列中 [offset, offset+limit) 范围内的记录.
+func (r *RTimeWheel) ListDeadLetters(ctx context.Context, offset, limit int) ([]*DeadLetter, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("invalid offset: %d, limit: %d", offset, limit)
+	}
+
+	reply, err := r.redisClient.EvalRetryable(ctx, LuaRangeDeadLetters, 1, []interface{}{
+		r.getDeadLetterKey(),
+		offset,
+		offset + limit - 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := gocast.ToStringSlice(reply)
+	deadLetters := make([]*DeadLetter, 0, len(records))
+	for _, record := range records {
+		var deadLetter DeadLetter
+		if err := json.Unmarshal([]byte(record), &deadLetter); err != nil || deadLetter.Task == nil {
+			r.opts.metrics.IncPayloadDecodeErrors()
+			r.opts.logger.Warn(ctx, "decode dead letter failed", "record", record, "err", err)
+			continue
+		}
+		deadLetter.raw = record
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+	return deadLetters, nil
+}
+
+// RequeueDeadLetter 将死信队列中的记录移出，并以 executeAt 为执行时刻重新添加到时间轮中，重试次数从零开始计算.
+// 记录已经不在死信队列中（已被重新入队或者被淘汰）时返回 ErrTaskNotFound.
+func (r *RTimeWheel) RequeueDeadLetter(ctx context.Context, deadLetter *DeadLetter, executeAt time.Time) error {
+	if deadLetter.raw == "" {
+		return fmt.Errorf("dead letter not from ListDeadLetters")
+	}
+	executeAt, err := r.resolveExecuteAt(time.Now(), executeAt)
+	if err != nil {
+		return err
+	}
+
+	reply, err := r.redisClient.Eval(ctx, LuaRemoveDeadLetter, 1, []interface{}{
+		r.getDeadLetterKey(),
+		deadLetter.raw,
+	})
+	if err != nil {
+		return err
+	}
+	if gocast.ToInt(reply) == 0 {
+		return ErrTaskNotFound
+	}
+
+	task := *deadLetter.Task
+	task.Attempt = 0
+	task.FirstScheduledAt = time.Time{}
+	if err := r.addTask(ctx, &task, r.applyJitter(&task, executeAt)); err != nil {
+		// 添加失败，将记录放回死信队列
+		if _, pushErr := r.redisClient.Eval(ctx, LuaPushDeadLetter, 1, []interface{}{
+			r.getDeadLetterKey(),
+			deadLetter.raw,
+			maxDeadLetterSize,
+		}); pushErr != nil {
+			return fmt.Errorf("requeue err: %w, restore err: %v", err, pushErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *RTimeWheel) getDeadLetterKey() string {
+	return r.opts.keyPrefix + "_deadletter"
+}
@@ -0,0 +1,237 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/demdxx/gocast"
+)
+
+// 分片模式.
+// 开启分片后，每个时间片的任务根据唯一键的哈希值分散写入 shardCount 个 zset 中，key 为 <prefix>_task_{时间片}_shard_<n>，
+// 每个实例只扫描分配给自己的分片，扫描的压力随实例数量水平扩展. 同一时间片的删除集合以及 in-flight zset 仍然由全部分片共享.
+//
+// 分片的分配通过 redis 中的成员 hash 完成：每个实例定期写入心跳以及通过 WithOwnedShards 静态指定的分片，
+// 存活实例静态指定的分片归属于指定的实例，其余分片按照实例标识排序后轮流分配. 实例的心跳超时后，其名下的分片由存活的实例接管.
+// 分配结果发生变化的短暂期间，同一个分片可能被多个实例扫描，任务的原子取出保证了不会重复执行.
+//
+// 时间片的标识中以 _shard_<n> 后缀区分分片，后缀位于 {hash_tag} 之外，TaskStore 的实现无需感知分片.
+
+const shardSep = "_shard_"
+
+// 唯一键所属的分片
+func (r *RTimeWheel) shardOf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(r.opts.shardCount))
+}
+
+// 唯一键为 key、执行时刻为 t 的任务所在时间片的标识，开启分桶、分片时依次带有分桶以及分片后缀
+func (r *RTimeWheel) getTaskSliceStr(key string, t time.Time) string {
+	slice := r.getSliceStr(t)
+	if r.opts.bucketCount > 0 {
+		slice = bucketSliceStr(slice, r.bucketOf(key))
+	}
+	if r.opts.shardCount > 0 {
+		slice = shardSliceStr(slice, r.shardOf(key))
+	}
+	return slice
+}
+
+// 唯一键为 key、执行时刻为 t 的任务所在的 zset
+func (r *RTimeWheel) getTaskZsetKey(key string, t time.Time) string {
+	return sliceTaskKey(r.opts.keyPrefix, r.getTaskSliceStr(key, t))
+}
+
+// 唯一键为 key、执行时刻为 t 的任务对应的删除集合
+func (r *RTimeWheel) getTaskDeleteSetKey(key string, t time.Time) string {
+	return sliceDeleteSetKey(r.opts.keyPrefix, r.getTaskSliceStr(key, t))
+}
+
+func shardSliceStr(slice string, shard int) string {
+	return slice + shardSep + strconv.Itoa(shard)
+}
+
+// 拆分时间片标识中的分片后缀，未开启分片时后缀为空
+func splitSliceShard(slice string) (string, string) {
+	if i := strings.Index(slice, shardSep); i >= 0 {
+		return slice[:i], slice[i:]
+	}
+	return slice, ""
+}
+
+// 时间片中当前实例需要扫描的全部分桶、分片的标识
+func (r *RTimeWheel) getScanSliceStrs(slice time.Time) []string {
+	if r.opts.shardCount == 0 {
+		return r.getBucketSliceStrs(slice)
+	}
+	return shardSliceStrs(r.getBucketSliceStrs(slice), r.OwnedShards())
+}
+
+// 时间片中全部分桶、分片的标识
+func (r *RTimeWheel) getAllSliceStrs(slice time.Time) []string {
+	if r.opts.shardCount == 0 {
+		return r.getBucketSliceStrs(slice)
+	}
+	shards := make([]int, r.opts.shardCount)
+	for i := range shards {
+		shards[i] = i
+	}
+	return shardSliceStrs(r.getBucketSliceStrs(slice), shards)
+}
+
+func shardSliceStrs(slices []string, shards []int) []string {
+	sliceStrs := make([]string, 0, len(slices)*len(shards))
+	for _, slice := range slices {
+		for _, shard := range shards {
+			sliceStrs = append(sliceStrs, shardSliceStr(slice, shard))
+		}
+	}
+	return sliceStrs
+}
+
+// OwnedShards 返回当前实例负责扫描的分片，未开启分片时返回 nil.
+// 首次心跳完成之前只包含通过 WithOwnedShards 静态指定的分片.
+func (r *RTimeWheel) OwnedShards() []int {
+	if r.opts.shardCount == 0 {
+		return nil
+	}
+	r.shardMu.Lock()
+	defer r.shardMu.Unlock()
+	if r.ownedShards == nil {
+		return r.opts.staticShards
+	}
+	return r.ownedShards
+}
+
+func (r *RTimeWheel) getShardMembersKey() string {
+	return r.opts.keyPrefix + "_shard_members"
+}
+
+func (r *RTimeWheel) shardHeartbeatInterval() time.Duration {
+	return r.opts.shardHeartbeatTimeout / 3
+}
+
+// 写入心跳，并根据存活实例重新计算当前实例负责的分片. 心跳失败时沿用之前的分配结果
+func (r *RTimeWheel) heartbeatShards() {
+	ctx, cancel := context.WithTimeout(r.ctx, r.shardHeartbeatInterval())
+	defer cancel()
+
+	now := time.Now()
+	reply, err := r.redisClient.Eval(ctx, LuaShardHeartbeat, 1, []interface{}{
+		r.getShardMembersKey(),
+		r.opts.instanceID,
+		now.UnixMilli(),
+		now.Add(-r.opts.shardHeartbeatTimeout).UnixMilli(),
+		formatShards(r.opts.staticShards),
+	})
+	if err != nil {
+		r.opts.logger.Warn(ctx, "shard heartbeat failed", "instance_id", r.opts.instanceID, "err", err)
+		return
+	}
+
+	replies := gocast.ToStringSlice(reply) // 依次为存活实例的标识以及静态指定的分片
+	members := make(map[string][]int, len(replies)/2)
+	for i := 0; i+1 < len(replies); i += 2 {
+		members[replies[i]] = parseShards(replies[i+1])
+	}
+	owned := assignShards(r.opts.shardCount, r.opts.instanceID, members)
+
+	r.shardMu.Lock()
+	changed := fmt.Sprint(r.ownedShards) != fmt.Sprint(owned)
+	r.ownedShards = owned
+	r.shardMu.Unlock()
+	if changed {
+		r.opts.logger.Info(ctx, "owned shards changed", "instance_id", r.opts.instanceID, "shards", owned)
+	}
+}
+
+// 退出成员 hash，其他实例在下一次心跳时即可接管当前实例的分片
+func (r *RTimeWheel) leaveShards() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if _, err := r.redisClient.Eval(ctx, LuaShardLeave, 1, []interface{}{r.getShardMembersKey(), r.opts.instanceID}); err != nil {
+		r.opts.logger.Warn(ctx, "leave shard members failed", "instance_id", r.opts.instanceID, "err", err)
+	}
+
+	r.shardMu.Lock()
+	r.ownedShards = nil
+	r.shardMu.Unlock()
+}
+
+// 计算 me 负责的分片. 存活实例静态指定的分片归属于指定的实例，其余分片按照实例标识排序后轮流分配
+func assignShards(shardCount int, me string, members map[string][]int) []int {
+	claimed := make(map[int]bool, shardCount)
+	for _, shards := range members {
+		for _, shard := range shards {
+			claimed[shard] = true
+		}
+	}
+
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	idx := sort.SearchStrings(ids, me)
+
+	owned := append([]int{}, members[me]...)
+	if idx < len(ids) && ids[idx] == me {
+		var unclaimed int
+		for shard := 0; shard < shardCount; shard++ {
+			if claimed[shard] {
+				continue
+			}
+			if unclaimed%len(ids) == idx {
+				owned = append(owned, shard)
+			}
+			unclaimed++
+		}
+	}
+	sort.Ints(owned)
+	return owned
+}
+
+func formatShards(shards []int) string {
+	strs := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		strs = append(strs, strconv.Itoa(shard))
+	}
+	return strings.Join(strs, ",")
+}
+
+func parseShards(s string) []int {
+	var shards []int
+	for _, str := range strings.Split(s, ",") {
+		if shard, err := strconv.Atoi(str); err == nil {
+			shards = append(shards, shard)
+		}
+	}
+	return shards
+}
+
+// 登记分片数量. 使用相同前缀的时间轮必须采用相同的分片数量，否则彼此无法检索到对方添加的任务
+func (r *RTimeWheel) registerShardCount(ctx context.Context) error {
+	return r.registerMeta(ctx, "shard_count", r.opts.shardCount)
+}
+
+// 在元信息 hash 中登记 field，已经登记的值与 value 不一致时返回错误
+func (r *RTimeWheel) registerMeta(ctx context.Context, field string, value int) error {
+	reply, err := r.redisClient.Eval(ctx, LuaRegisterMeta, 1, []interface{}{
+		r.getMetaKey(),
+		field,
+		value,
+	})
+	if err != nil {
+		return err
+	}
+	if registered := gocast.ToInt(reply); registered != value {
+		return fmt.Errorf("%s mismatch, prefix: %s, registered: %d, got: %d", field, r.opts.keyPrefix, registered, value)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package timewheel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认的回调签名 header
+const DefaultSignatureHeader = "X-Timewheel-Signature"
+
+var (
+	// ErrInvalidSignature 签名 header 格式有误，或者没有任何一个签名与密钥匹配
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrSignatureExpired 签名的时间戳超出了允许的误差范围，可能是重放的请求
+	ErrSignatureExpired = errors.New("signature expired")
+)
+
+// 对回调请求体进行签名，返回 t=<unix>,v1=<hex>[,v1=<hex>...] 格式的签名 header，每个密钥对应一个 v1 签名.
+// 签名的内容为 "<unix>." 与请求体拼接而成的字节序列
+func signWebhook(body []byte, timestamp time.Time, secrets [][]byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	parts := make([]string, 0, len(secrets)+1)
+	parts = append(parts, "t="+ts)
+	for _, secret := range secrets {
+		parts = append(parts, "v1="+computeSignature(ts, body, secret))
+	}
+	return strings.Join(parts, ",")
+}
+
+func computeSignature(ts string, body, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature 供回调的接收方校验请求是否由时间轮发出. header 为签名 header 的值，body 为原始请求体.
+// tolerance 为签名时间戳与当前时间允许的最大误差，用于防止重放，为 0 时不校验时间戳.
+func VerifySignature(header string, body []byte, secret []byte, tolerance time.Duration) error {
+	return VerifySignatureWithSecrets(header, body, [][]byte{secret}, tolerance)
+}
+
+// VerifySignatureWithSecrets 与 VerifySignature 相同，任意一个密钥校验通过即可，用于密钥轮换期间同时接受新旧密钥.
+func VerifySignatureWithSecrets(header string, body []byte, secrets [][]byte, tolerance time.Duration) error {
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%w: malformed header %q", ErrInvalidSignature, header)
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%w: malformed header %q", ErrInvalidSignature, header)
+	}
+
+	if tolerance > 0 {
+		if diff := time.Since(time.Unix(unix, 0)); diff > tolerance || diff < -tolerance {
+			return fmt.Errorf("%w: timestamp %d", ErrSignatureExpired, unix)
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := computeSignature(ts, body, secret)
+		for _, signature := range signatures {
+			if hmac.Equal([]byte(expected), []byte(signature)) {
+				return nil
+			}
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,96 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// SliceStats 单个时间片的统计信息.
+type SliceStats struct {
+	Slice   time.Time // 时间片的起始时刻
+	Pending int64     // zset 中的任务数量，包含已被标识删除但尚未被扫描的任务
+	Deleted int64     // 删除集合中的删除标识数量
+}
+
+// WheelStats 时间轮的统计信息.
+type WheelStats struct {
+	Slices       []SliceStats // 存在任务或者删除标识的时间片，按照时间升序排列
+	TotalPending int64        // 全部时间片的任务数量之和
+	NearestAt    time.Time    // 最近一个任务的执行时刻，不存在任务时为零值
+}
+
+// Stats 统计当前时间片起 horizon 范围内各个时间片的任务数量、删除集合大小以及最近一个任务的执行时刻.
+// 全部时间片的查询通过一次流水线完成，耗时不随 horizon 增长而增加网络往返.
+func (r *RTimeWheel) Stats(ctx context.Context, horizon time.Duration) (WheelStats, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return WheelStats{}, err
+	}
+	if horizon < 0 {
+		return WheelStats{}, fmt.Errorf("invalid horizon: %v", horizon)
+	}
+
+	now := time.Now()
+	slices := r.getSlices(now, now.Add(horizon).Add(time.Nanosecond))
+	// 每个时间片依次查询各个分桶删除集合的大小，以及各个分桶、分片 zset 的大小和 score 最小的任务
+	buckets, zsets := len(r.getBucketSliceStrs(now)), len(r.getAllSliceStrs(now))
+	stride := buckets + 2*zsets
+	replies, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		for _, slice := range slices {
+			for _, sliceStr := range r.getBucketSliceStrs(slice) {
+				p.Send("SCARD", sliceDeleteSetKey(r.opts.keyPrefix, sliceStr))
+			}
+			for _, sliceStr := range r.getAllSliceStrs(slice) {
+				zsetKey := sliceTaskKey(r.opts.keyPrefix, sliceStr)
+				p.Send("ZCARD", zsetKey)
+				p.Send("ZRANGE", zsetKey, 0, 0, "WITHSCORES")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return WheelStats{}, err
+	}
+	if len(replies) != stride*len(slices) {
+		return WheelStats{}, fmt.Errorf("invalid replies: %v", replies)
+	}
+
+	var stats WheelStats
+	for i, slice := range slices {
+		sliceReplies := replies[stride*i : stride*(i+1)]
+		for _, reply := range sliceReplies {
+			if err, ok := reply.(error); ok {
+				return WheelStats{}, err
+			}
+		}
+
+		sliceStats := SliceStats{Slice: slice}
+		for j := 0; j < buckets; j++ {
+			sliceStats.Deleted += gocast.ToInt64(sliceReplies[j])
+		}
+		var nearestAt time.Time
+		for j := 0; j < zsets; j++ {
+			sliceStats.Pending += gocast.ToInt64(sliceReplies[buckets+2*j])
+			if nearest := gocast.ToInterfaceSlice(sliceReplies[buckets+2*j+1]); len(nearest) == 2 {
+				if at := time.Unix(gocast.ToInt64(gocast.ToString(nearest[1])), 0); nearestAt.IsZero() || at.Before(nearestAt) {
+					nearestAt = at
+				}
+			}
+		}
+		if sliceStats.Pending == 0 && sliceStats.Deleted == 0 {
+			continue
+		}
+		stats.Slices = append(stats.Slices, sliceStats)
+		stats.TotalPending += sliceStats.Pending
+
+		// 时间片按照时间升序排列，首个非空时间片中 score 最小的任务即为最近的任务
+		if stats.NearestAt.IsZero() {
+			stats.NearestAt = nearestAt
+		}
+	}
+	return stats, nil
+}
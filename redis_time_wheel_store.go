@@ -0,0 +1,179 @@
+package timewheel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// ErrTaskStoreUnsupported 设置了自定义的 TaskStore 时，依赖 redis 存储结构的能力不可用
+var ErrTaskStoreUnsupported = errors.New("operation unsupported by custom task store")
+
+// StoredTask TaskStore 中存储的一条任务.
+type StoredTask struct {
+	Key     string
+	Score   int64  // 执行时刻的秒级时间戳
+	Body    []byte // 序列化之后的任务明细
+	Deleted bool   // 任务已经被 MarkDeleted 标记删除，时间轮不会执行该任务
+
+	task *RTaskElement // 认领之前已经解码的任务
+}
+
+// TaskStore 等待执行的任务的存储. 默认使用基于 redis zset 以及 lua 脚本的实现，可以通过 WithTaskStore 替换为其他存储.
+// 任务按照时间片分组存储，slice 为时间片的标识，由时间轮根据执行时刻以及时间片粒度计算. 实现需要满足以下约定，
+// 可以使用 storetest 子包中的一致性测试进行验证：
+//
+//  1. Add 将任务写入时间片，并清除同一时间片中该唯一键已有的删除标记.
+//  2. MarkDeleted 在时间片中为唯一键写入删除标记，即便任务尚未写入也需要保留标记，标记至少保留到该时间片的任务全部被取出之后.
+//     score 为任务在时间片中的 score，实现可以只检查该 score 下的任务，无论是否命中删除标记都会写入. 任务仍然处于等待状态时返回 nil；此前已经标记删除时返回 ErrTaskNotFound；
+//     时间片中不存在该任务（已经被取出或者从未写入）时返回 ErrTaskAlreadyExecuted.
+//  3. FetchDue 原子地取出并认领时间片中 score 位于 [from, to) 范围内的全部任务，结果按照 score 升序排列.
+//     被取出的任务即从存储中移除，同一个任务在多个实例的并发调用中只能被其中一次调用返回，这是分布式场景下任务不被重复执行的前提.
+//     已经标记删除的任务同样会被取出，并以 Deleted 标识返回.
+type TaskStore interface {
+	Add(ctx context.Context, slice string, score int64, body []byte, key string) error
+	MarkDeleted(ctx context.Context, slice, key string, score int64) error
+	FetchDue(ctx context.Context, slice string, from, to int64) ([]StoredTask, error)
+}
+
+// NewRedisTaskStore 创建基于 redis 的 TaskStore，也是时间轮默认采用的存储. opts 中的 key 前缀等设置需要与时间轮保持一致.
+// 通常无需手动创建，可以用于包装默认实现，或者运行一致性测试.
+func NewRedisTaskStore(client *redis.Client, opts ...RTimeWheelOption) TaskStore {
+	o := RTimeWheelOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	repairRTimeWheel(&o)
+	return &redisTaskStore{client: client, opts: &o}
+}
+
+type redisTaskStore struct {
+	client *redis.Client
+	opts   *RTimeWheelOptions
+}
+
+func (s *redisTaskStore) Add(ctx context.Context, slice string, score int64, body []byte, key string) error {
+	_, err := s.client.EvalScript(ctx, addTasksScript, 2, []interface{}{
+		sliceTaskKey(s.opts.keyPrefix, slice),
+		sliceDeleteSetKey(s.opts.keyPrefix, slice),
+		score,
+		string(body),
+		key,
+		getSliceExpireSeconds(s.opts, time.Now(), time.Unix(score, 0)),
+	})
+	return err
+}
+
+func (s *redisTaskStore) MarkDeleted(ctx context.Context, slice, key string, score int64) error {
+	reply, err := s.client.EvalScript(ctx, deleteTaskScript, 2, []interface{}{
+		sliceTaskKey(s.opts.keyPrefix, slice),
+		sliceDeleteSetKey(s.opts.keyPrefix, slice),
+		key,
+		getDeleteSetExpireSeconds(s.opts, time.Now(), time.Unix(score, 0)),
+		score,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch gocast.ToInt(reply) {
+	case 1:
+		return nil
+	case 0:
+		return ErrTaskNotFound
+	default:
+		return ErrTaskAlreadyExecuted
+	}
+}
+
+// 逐页取出全部任务，每次 lua 调用至多取出 fetchBatchSize 个
+func (s *redisTaskStore) FetchDue(ctx context.Context, slice string, from, to int64) ([]StoredTask, error) {
+	var tasks []StoredTask
+	for {
+		page, err := s.fetchPage(ctx, slice, from, to, s.opts.fetchBatchSize)
+		tasks = append(tasks, page...)
+		if err != nil || len(page) < s.opts.fetchBatchSize {
+			return tasks, err
+		}
+	}
+}
+
+// 取出至多 limit 个任务，只从 zset 中移除本页取出的任务
+func (s *redisTaskStore) fetchPage(ctx context.Context, slice string, from, to int64, limit int) ([]StoredTask, error) {
+	score1, score2 := formatScoreRange(from, to)
+	script := zrangeTasksScript
+	if s.opts.legacyZrange {
+		script = zrangeTasksLegacyScript
+	}
+	reply, err := s.client.EvalScript(ctx, script, 2, []interface{}{
+		sliceTaskKey(s.opts.keyPrefix, slice),
+		sliceDeleteSetKey(s.opts.keyPrefix, slice),
+		score1,
+		score2,
+		limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parseFetchReply(reply)
+}
+
+// 将 [from, to) 转换为 zrange byscore 的边界，from 为 math.MinInt64 时表示不设下界
+func formatScoreRange(from, to int64) (string, string) {
+	score1 := strconv.FormatInt(from, 10)
+	if from == math.MinInt64 {
+		score1 = "-inf"
+	}
+	return score1, "(" + strconv.FormatInt(to, 10)
+}
+
+// 解析检索任务的 lua 脚本的返回结果. 0: 已删除任务集合，之后依次为任务明细及其 score
+func parseFetchReply(rawReply interface{}) ([]StoredTask, error) {
+	replies := gocast.ToInterfaceSlice(rawReply)
+	if len(replies) == 0 || len(replies)%2 == 0 {
+		return nil, fmt.Errorf("invalid replies: %v", replies)
+	}
+
+	deleteds := gocast.ToStringSlice(replies[0])
+	deletedSet := make(map[string]struct{}, len(deleteds))
+	for _, deleted := range deleteds {
+		deletedSet[deleted] = struct{}{}
+	}
+
+	tasks := make([]StoredTask, 0, (len(replies)-1)/2)
+	for i := 1; i+1 < len(replies); i += 2 {
+		body := gocast.ToString(replies[i])
+		task := StoredTask{Score: gocast.ToInt64(replies[i+1]), Body: []byte(body)}
+		// 删除标记以唯一键记录，无法读取唯一键的任务交给时间轮处理
+		if key, err := decodeTaskKey(task.Body); err == nil {
+			task.Key = key
+			_, task.Deleted = deletedSet[task.Key]
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// 向上取整到秒，score 为整秒，因此 [from, to) 与 [ceil(from), ceil(to)) 包含的 score 完全相同
+func ceilSeconds(t time.Time) int64 {
+	sec := t.Unix()
+	if t.Nanosecond() > 0 {
+		sec++
+	}
+	return sec
+}
+
+// 依赖 redis 存储结构的操作，在设置了自定义 TaskStore 时不可用
+func (r *RTimeWheel) checkRedisStore() error {
+	if r.opts.taskStore != nil {
+		return ErrTaskStoreUnsupported
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package timewheel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/demdxx/gocast"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 任务标签.
+// 任务设置了 Tags 时，添加任务的同时将唯一键写入每个标签对应的 set 中，set 的过期时间不早于其中最晚一个任务的执行时刻，
+// 从而可以通过 RemoveByTag 按照标签批量删除任务. 任务从 zset 中取出时从标签集合中移除，周期任务调度下一次执行、失败任务重试时重新写入.
+//
+// 与唯一键索引一样，标签集合只作为定位任务的线索，任务是否存在始终以 zset 为准.
+
+// 每次从标签集合中扫描的唯一键数量
+const tagScanCount = 500
+
+// 标签可以是任意字符串，写入 key 名称之前需要转义 {}，避免标签改变标签集合所属的 cluster 节点
+func (r *RTimeWheel) getTagKey(tag string) string {
+	return r.opts.keyPrefix + "_tag_" + escapeKeyPart(tag)
+}
+
+// 将任务唯一键写入各个标签集合
+func (r *RTimeWheel) tagTask(ctx context.Context, task *RTaskElement, executeAt time.Time) error {
+	expireSeconds := r.getDeleteSetExpireSeconds(time.Now(), executeAt)
+	for _, tag := range task.Tags {
+		if _, err := r.redisClient.Eval(ctx, LuaTagTask, 1, []interface{}{
+			r.getTagKey(tag),
+			task.Key,
+			expireSeconds,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 任务已从 zset 中取出，从标签集合中移除
+func (r *RTimeWheel) untagTasks(ctx context.Context, tasks []*RTaskElement) error {
+	replies, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		for _, task := range tasks {
+			for _, tag := range task.Tags {
+				p.Send("SREM", r.getTagKey(tag), task.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveByTag 删除带有 tag 标签、处于等待状态的全部任务，返回新增的删除标识数量.
+// 标签集合通过 SSCAN 分批遍历，每一批任务根据唯一键索引定位所在的时间片后批量写入删除标识，已经执行或者删除的任务同时从标签集合中移除.
+// 遍历期间新添加的任务可能不会被删除.
+func (r *RTimeWheel) RemoveByTag(ctx context.Context, tag string) (int, error) {
+	if err := r.checkRedisStore(); err != nil {
+		return 0, err
+	}
+	if tag == "" {
+		return 0, fmt.Errorf("empty tag")
+	}
+
+	tagKey := r.getTagKey(tag)
+	var (
+		removed int
+		cursor  = "0"
+	)
+	for {
+		replies, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+			p.Send("SSCAN", tagKey, cursor, "COUNT", tagScanCount)
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		if err, ok := replies[0].(error); ok {
+			return removed, err
+		}
+		scan := gocast.ToInterfaceSlice(replies[0]) // 0: 下一次扫描的游标，1: 本批次的唯一键
+		if len(scan) != 2 {
+			return removed, fmt.Errorf("invalid sscan reply: %v", scan)
+		}
+		cursor = gocast.ToString(scan[0])
+
+		n, err := r.removeTagged(ctx, tagKey, gocast.ToStringSlice(scan[1]))
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+		if cursor == "0" {
+			return removed, nil
+		}
+	}
+}
+
+// 删除一批带有标签的任务
+func (r *RTimeWheel) removeTagged(ctx context.Context, tagKey string, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, 0, 1+len(keys))
+	args = append(args, r.getIndexKey())
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	reply, err := r.redisClient.EvalRetryable(ctx, LuaGetIndexes, 1, args)
+	if err != nil {
+		return 0, err
+	}
+
+	scores := gocast.ToInterfaceSlice(reply)
+	items := make([]KeyWithTime, 0, len(keys))
+	keyToScore := make(map[string]int64, len(keys))
+	for i, key := range keys {
+		if i >= len(scores) || scores[i] == nil {
+			continue
+		}
+		if score := gocast.ToInt64(gocast.ToString(scores[i])); score > 0 {
+			items = append(items, KeyWithTime{Key: key, ExecuteAt: time.Unix(score, 0)})
+			keyToScore[key] = score
+		}
+	}
+
+	removed, err := r.RemoveTasks(ctx, items)
+	if err != nil {
+		return 0, err
+	}
+	if err := r.cleanIndex(ctx, keyToScore); err != nil {
+		r.opts.logger.Warn(ctx, "clean index failed", "tag_key", tagKey, "err", err)
+	}
+
+	srem := make([]interface{}, 0, 1+len(keys))
+	srem = append(srem, tagKey)
+	for _, key := range keys {
+		srem = append(srem, key)
+	}
+	if _, err := r.redisClient.Pipeline(ctx, func(p redis.Pipeliner) error {
+		p.Send("SREM", srem...)
+		return nil
+	}); err != nil {
+		r.opts.logger.Warn(ctx, "untag removed tasks failed", "tag_key", tagKey, "err", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,20 @@
+package timewheel
+
+import "context"
+
+// Tracing 链路追踪的扩展点. 未设置时不产生任何开销，OpenTelemetry 的实现参见 tracing/otel 子模块.
+type Tracing interface {
+	// Inject 将 ctx 中的链路信息写入 carrier. 添加任务时写入 RTaskElement.TraceCarrier，执行任务时写入回调请求的 header
+	Inject(ctx context.Context, carrier map[string]string)
+	// StartExecute 根据 task.TraceCarrier 还原添加任务时的链路，并开启任务执行的 span. 返回的函数用于结束 span
+	StartExecute(ctx context.Context, task *RTaskElement) (context.Context, func(err error))
+}
+
+// 添加任务时记录当前的链路信息
+func (r *RTimeWheel) injectTrace(ctx context.Context, task *RTaskElement) {
+	if r.opts.tracing == nil {
+		return
+	}
+	task.TraceCarrier = make(map[string]string)
+	r.opts.tracing.Inject(ctx, task.TraceCarrier)
+}
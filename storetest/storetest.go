@@ -0,0 +1,197 @@
+// Package storetest 提供 timewheel.TaskStore 的一致性测试，自定义存储的实现可以在自身的单测中运行：
+//
+//	func TestMySQLStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) timewheel.TaskStore {
+//			return newMySQLStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+// Run 针对 newStore 创建的存储运行全部一致性测试. 每个子测试都会创建新的存储，并使用互不相同的时间片.
+func Run(t *testing.T, newStore func(t *testing.T) timewheel.TaskStore) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, store timewheel.TaskStore, slice string)
+	}{
+		{"FetchDue", testFetchDue},
+		{"SliceIsolation", testSliceIsolation},
+		{"MarkDeleted", testMarkDeleted},
+		{"AddClearsDeleted", testAddClearsDeleted},
+		{"ConcurrentFetch", testConcurrentFetch},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, newStore(t), newSlice())
+		})
+	}
+}
+
+// 一致性测试可能运行在共享的存储上，每个子测试使用不同的时间片避免相互影响
+func newSlice() string {
+	return fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+}
+
+// 测试使用的 score 位于未来，与存储中真实的任务互不干扰
+var baseScore = time.Now().Add(24 * time.Hour).Unix()
+
+func body(key string) []byte {
+	return []byte(fmt.Sprintf(`{"key":%q}`, key))
+}
+
+func mustAdd(t *testing.T, store timewheel.TaskStore, slice string, score int64, key string) {
+	t.Helper()
+	if err := store.Add(context.Background(), slice, score, body(key), key); err != nil {
+		t.Fatalf("add %s: %v", key, err)
+	}
+}
+
+func mustFetch(t *testing.T, store timewheel.TaskStore, slice string, from, to int64) []timewheel.StoredTask {
+	t.Helper()
+	tasks, err := store.FetchDue(context.Background(), slice, from, to)
+	if err != nil {
+		t.Fatalf("fetch [%d, %d): %v", from, to, err)
+	}
+	return tasks
+}
+
+func keys(tasks []timewheel.StoredTask) []string {
+	keys := make([]string, 0, len(tasks))
+	for _, task := range tasks {
+		keys = append(keys, task.Key)
+	}
+	return keys
+}
+
+func testFetchDue(t *testing.T, store timewheel.TaskStore, slice string) {
+	mustAdd(t, store, slice, baseScore+1, "b")
+	mustAdd(t, store, slice, baseScore, "a")
+	mustAdd(t, store, slice, baseScore+2, "c")
+
+	tasks := mustFetch(t, store, slice, baseScore, baseScore+2)
+	if got := fmt.Sprint(keys(tasks)); got != "[a b]" {
+		t.Fatalf("got keys: %s, want [a b] in score order", got)
+	}
+	for i, task := range tasks {
+		if task.Score != baseScore+int64(i) || string(task.Body) != string(body(task.Key)) || task.Deleted {
+			t.Errorf("got task: %+v", task)
+		}
+	}
+
+	// 已经取出的任务不会被再次返回
+	if tasks := mustFetch(t, store, slice, baseScore, baseScore+2); len(tasks) != 0 {
+		t.Errorf("fetch again, got keys: %v, want none", keys(tasks))
+	}
+	if got := fmt.Sprint(keys(mustFetch(t, store, slice, baseScore, baseScore+3))); got != "[c]" {
+		t.Errorf("got keys: %s, want [c]", got)
+	}
+}
+
+func testSliceIsolation(t *testing.T, store timewheel.TaskStore, slice string) {
+	other := slice + "-other"
+	mustAdd(t, store, slice, baseScore, "a")
+	mustAdd(t, store, other, baseScore, "b")
+
+	if got := fmt.Sprint(keys(mustFetch(t, store, slice, baseScore, baseScore+1))); got != "[a]" {
+		t.Errorf("got keys: %s, want [a]", got)
+	}
+	if got := fmt.Sprint(keys(mustFetch(t, store, other, baseScore, baseScore+1))); got != "[b]" {
+		t.Errorf("got keys: %s, want [b]", got)
+	}
+}
+
+func testMarkDeleted(t *testing.T, store timewheel.TaskStore, slice string) {
+	ctx := context.Background()
+	mustAdd(t, store, slice, baseScore, "a")
+	mustAdd(t, store, slice, baseScore, "b")
+
+	if err := store.MarkDeleted(ctx, slice, "a", baseScore); err != nil {
+		t.Fatalf("mark pending task deleted, got err: %v", err)
+	}
+	if err := store.MarkDeleted(ctx, slice, "a", baseScore); !errors.Is(err, timewheel.ErrTaskNotFound) {
+		t.Errorf("mark deleted twice, got err: %v, want: %v", err, timewheel.ErrTaskNotFound)
+	}
+	if err := store.MarkDeleted(ctx, slice, "missing", baseScore); !errors.Is(err, timewheel.ErrTaskAlreadyExecuted) {
+		t.Errorf("mark missing task deleted, got err: %v, want: %v", err, timewheel.ErrTaskAlreadyExecuted)
+	}
+
+	// 已经标记删除的任务同样会被取出，并带有删除标识
+	tasks := mustFetch(t, store, slice, baseScore, baseScore+1)
+	deleted := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		deleted[task.Key] = task.Deleted
+	}
+	if len(tasks) != 2 || !deleted["a"] || deleted["b"] {
+		t.Errorf("got tasks: %+v, want a deleted and b pending", tasks)
+	}
+
+	if err := store.MarkDeleted(ctx, slice, "b", baseScore); !errors.Is(err, timewheel.ErrTaskAlreadyExecuted) {
+		t.Errorf("mark fetched task deleted, got err: %v, want: %v", err, timewheel.ErrTaskAlreadyExecuted)
+	}
+}
+
+func testAddClearsDeleted(t *testing.T, store timewheel.TaskStore, slice string) {
+	// 删除标记先于任务写入时同样生效，再次添加任务会清除标记
+	if err := store.MarkDeleted(context.Background(), slice, "a", baseScore); !errors.Is(err, timewheel.ErrTaskAlreadyExecuted) {
+		t.Fatalf("mark missing task deleted, got err: %v, want: %v", err, timewheel.ErrTaskAlreadyExecuted)
+	}
+	mustAdd(t, store, slice, baseScore, "a")
+
+	tasks := mustFetch(t, store, slice, baseScore, baseScore+1)
+	if len(tasks) != 1 || tasks[0].Deleted {
+		t.Errorf("got tasks: %+v, want a pending", tasks)
+	}
+}
+
+func testConcurrentFetch(t *testing.T, store timewheel.TaskStore, slice string) {
+	const taskCnt, fetcherCnt = 200, 8
+	for i := 0; i < taskCnt; i++ {
+		mustAdd(t, store, slice, baseScore+int64(i%10), fmt.Sprintf("task_%d", i))
+	}
+
+	var (
+		mu      sync.Mutex
+		fetched []string
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < fetcherCnt; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// 所有调用方并发取出相同的窗口
+			for score := baseScore; score < baseScore+10; score += 2 {
+				tasks, err := store.FetchDue(context.Background(), slice, score, score+2)
+				if err != nil {
+					t.Errorf("fetch: %v", err)
+					return
+				}
+				mu.Lock()
+				fetched = append(fetched, keys(tasks)...)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(fetched)
+	if len(fetched) != taskCnt {
+		t.Errorf("got %d tasks fetched, want %d", len(fetched), taskCnt)
+	}
+	for i := 1; i < len(fetched); i++ {
+		if fetched[i] == fetched[i-1] {
+			t.Errorf("task %s fetched more than once", fetched[i])
+		}
+	}
+}
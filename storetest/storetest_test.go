@@ -0,0 +1,91 @@
+package storetest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// memoryStore 基于内存的 TaskStore，用于验证一致性测试本身
+type memoryStore struct {
+	mu      sync.Mutex
+	tasks   map[string][]timewheel.StoredTask
+	deleted map[string]map[string]struct{}
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		tasks:   make(map[string][]timewheel.StoredTask),
+		deleted: make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *memoryStore) Add(ctx context.Context, slice string, score int64, body []byte, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.deleted[slice], key)
+	m.tasks[slice] = append(m.tasks[slice], timewheel.StoredTask{Key: key, Score: score, Body: body})
+	return nil
+}
+
+func (m *memoryStore) MarkDeleted(ctx context.Context, slice, key string, score int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.deleted[slice][key]; ok {
+		return timewheel.ErrTaskNotFound
+	}
+	if m.deleted[slice] == nil {
+		m.deleted[slice] = make(map[string]struct{})
+	}
+	m.deleted[slice][key] = struct{}{}
+	for _, task := range m.tasks[slice] {
+		if task.Key == key {
+			return nil
+		}
+	}
+	return timewheel.ErrTaskAlreadyExecuted
+}
+
+func (m *memoryStore) FetchDue(ctx context.Context, slice string, from, to int64) ([]timewheel.StoredTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var due, remain []timewheel.StoredTask
+	for _, task := range m.tasks[slice] {
+		if task.Score >= from && task.Score < to {
+			_, task.Deleted = m.deleted[slice][task.Key]
+			due = append(due, task)
+		} else {
+			remain = append(remain, task)
+		}
+	}
+	m.tasks[slice] = remain
+	sort.SliceStable(due, func(i, j int) bool { return due[i].Score < due[j].Score })
+	return due, nil
+}
+
+func Test_memoryStore(t *testing.T) {
+	Run(t, func(t *testing.T) timewheel.TaskStore {
+		return newMemoryStore()
+	})
+}
+
+const (
+	// redis 服务器信息
+	network  = "tcp"
+	address  = "请输入 redis 地址"
+	password = "请输入 redis 密码"
+)
+
+func Test_redisStore(t *testing.T) {
+	client, err := redis.NewClient(network, address, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Run(t, func(t *testing.T) timewheel.TaskStore {
+		return timewheel.NewRedisTaskStore(client, timewheel.WithKeyPrefix("storetest"))
+	})
+}
@@ -2,31 +2,47 @@ package timewheel
 
 import (
 	"container/list"
+	"context"
+	"runtime/debug"
 	"sync"
 	"time"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/util"
 )
 
 type taskElement struct {
-	task  func()
-	pos   int
-	cycle int
-	key   string
+	task     func()
+	expireAt int64 // 到期的 tick
+	level    int
+	pos      int
+	key      string
 }
 
+// TimeWheel 单机的分层时间轮，不依赖 redis.
+//
+// 第 0 层每个槽位跨度为一个 tick，第 n 层每个槽位跨度为 slotNum^n 个 tick. 超出现有层级范围的任务会按需创建更高的层级，
+// 高层槽位到期时，其中的任务逐层下沉（cascade）到更精细的层级，最终在第 0 层到期执行.
 type TimeWheel struct {
 	sync.Once
+	drainOnce    sync.Once
 	interval     time.Duration
+	startAt      time.Time
 	ticker       *time.Ticker
 	stopc        chan struct{}
+	donec        chan struct{}
+	drainc       chan struct{} // Drain 时关闭，之后不再接收新任务
+	idlec        chan struct{} // Drain 期间剩余的任务全部到期执行之后关闭
 	addTaskCh    chan *taskElement
 	removeTaskCh chan string
-	slots        []*list.List
-	curSlot      int
+	slotNum      int
+	levels       [][]*list.List
+	curTick      int64
 	keyToETask   map[string]*list.Element
+	running      sync.WaitGroup
 }
 
 func NewTimeWheel(slotNum int, interval time.Duration) *TimeWheel {
-	if slotNum <= 0 {
+	if slotNum <= 1 {
 		slotNum = 10
 	}
 	if interval <= 0 {
@@ -35,110 +51,193 @@ func NewTimeWheel(slotNum int, interval time.Duration) *TimeWheel {
 
 	t := TimeWheel{
 		interval:     interval,
+		startAt:      time.Now(),
 		ticker:       time.NewTicker(interval),
 		stopc:        make(chan struct{}),
+		donec:        make(chan struct{}),
+		drainc:       make(chan struct{}),
+		idlec:        make(chan struct{}),
 		keyToETask:   make(map[string]*list.Element),
-		slots:        make([]*list.List, 0, slotNum),
+		slotNum:      slotNum,
 		addTaskCh:    make(chan *taskElement),
 		removeTaskCh: make(chan string),
 	}
-	for i := 0; i < slotNum; i++ {
-		t.slots = append(t.slots, list.New())
-	}
+	t.addLevel()
 	go t.run()
 	return &t
 }
 
+// Stop 停止时间轮. 执行时刻已经到来而 tick 尚未处理的任务会在停止之前执行，并等待正在执行的任务结束；
+// 执行时刻尚未到来的任务会被丢弃，需要等待全部任务执行完成时使用 Drain. 停止后添加、删除任务不再生效.
 func (t *TimeWheel) Stop() {
 	t.Do(func() {
 		t.ticker.Stop()
 		close(t.stopc)
 	})
+	<-t.donec
+	t.running.Wait()
+}
+
+// Drain 不再接收新任务，等待剩余的任务按照各自的执行时刻全部执行完成之后停止时间轮. 删除任务在 Drain 期间依然生效.
+// ctx 到期时按照 Stop 的方式停止，丢弃仍未到期的任务并返回 ctx 的错误.
+func (t *TimeWheel) Drain(ctx context.Context) error {
+	t.drainOnce.Do(func() { close(t.drainc) })
+	var err error
+	select {
+	case <-t.idlec:
+	case <-t.donec:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	t.Stop()
+	return err
 }
 
+// AddTask 添加任务，executeAt 早于当前时间的任务会被立即执行. 重复添加相同 key 的任务时，新任务会覆盖旧任务.
 func (t *TimeWheel) AddTask(key string, task func(), executeAt time.Time) {
-	pos, cycle := t.getPosAndCircle(executeAt)
-	t.addTaskCh <- &taskElement{
-		pos:   pos,
-		cycle: cycle,
-		task:  task,
-		key:   key,
+	select {
+	case <-t.stopc:
+	case <-t.drainc:
+	case t.addTaskCh <- &taskElement{
+		expireAt: t.getExpireTick(executeAt),
+		task:     task,
+		key:      key,
+	}:
 	}
 }
 
 func (t *TimeWheel) RemoveTask(key string) {
-	t.removeTaskCh <- key
+	select {
+	case <-t.stopc:
+	case t.removeTaskCh <- key:
+	}
 }
 
 func (t *TimeWheel) run() {
+	defer close(t.donec)
 	defer func() {
 		if err := recover(); err != nil {
 			// ...
 		}
 	}()
 
+	drainc, draining, idle := t.drainc, false, false
 	for {
 		select {
 		case <-t.stopc:
+			// 执行时刻已经到来的任务在停止之前执行
+			t.tickUntil(time.Now())
 			return
-		case <-t.ticker.C:
-			t.tick()
+		case now := <-t.ticker.C:
+			t.tickUntil(now)
 		case task := <-t.addTaskCh:
-			t.addTask(task)
+			// Drain 与 AddTask 并发时，Drain 之后到达的任务同样丢弃
+			if !draining {
+				t.addTask(task)
+			}
 		case removeKey := <-t.removeTaskCh:
 			t.removeTask(removeKey)
+		case <-drainc:
+			drainc, draining = nil, true
+		}
+		if draining && !idle && len(t.keyToETask) == 0 {
+			close(t.idlec)
+			idle = true
 		}
 	}
 }
 
-func (t *TimeWheel) tick() {
-	list := t.slots[t.curSlot]
-	defer t.circularIncr()
-	t.execute(list)
+// ticker 可能因为阻塞丢失 tick，根据实际时间补齐
+func (t *TimeWheel) tickUntil(now time.Time) {
+	for target := int64(now.Sub(t.startAt) / t.interval); t.curTick < target; {
+		t.tick()
+	}
 }
 
-func (t *TimeWheel) execute(l *list.List) {
-	// 遍历每个 list
-	for e := l.Front(); e != nil; {
-		taskElement, _ := e.Value.(*taskElement)
-		if taskElement.cycle > 0 {
-			taskElement.cycle--
-			e = e.Next()
+func (t *TimeWheel) tick() {
+	t.curTick++
+	// 由高到低处理到期的槽位，高层的任务下沉后，可能落入本次 tick 需要处理的低层槽位
+	for level := len(t.levels) - 1; level >= 0; level-- {
+		span := t.levelSpan(level)
+		if t.curTick%span != 0 {
 			continue
 		}
+		t.cascade(t.levels[level][(t.curTick/span)%int64(t.slotNum)])
+	}
+}
 
-		// 执行任务
-		go func() {
-			defer func() {
-				if err := recover(); err != nil {
-					// ...
-				}
-			}()
-			taskElement.task()
-		}()
-
-		// 执行任务后，从时间轮中删除
+// 将槽位中的任务重新插入时间轮，到期的任务直接执行，其余任务下沉到更精细的层级
+func (t *TimeWheel) cascade(l *list.List) {
+	for e := l.Front(); e != nil; {
 		next := e.Next()
-		l.Remove(e)
-		delete(t.keyToETask, taskElement.key)
+		task, _ := l.Remove(e).(*taskElement)
+		delete(t.keyToETask, task.key)
+		t.addTask(task)
 		e = next
 	}
 }
 
-func (t *TimeWheel) getPosAndCircle(executeAt time.Time) (int, int) {
-	delay := int(time.Until(executeAt))
-	cycle := delay / (len(t.slots) * int(t.interval))
-	pos := (t.curSlot + delay/int(t.interval)) % len(t.slots)
-	return pos, cycle
+func (t *TimeWheel) execute(task *taskElement) {
+	t.running.Add(1)
+	go func() {
+		defer t.running.Done()
+		defer func() {
+			if err := recover(); err != nil {
+				// ...
+			}
+		}()
+		task.task()
+	}()
+}
+
+// 任务到期的 tick，不足一个 tick 的部分向上取整，保证任务不会提前执行
+func (t *TimeWheel) getExpireTick(executeAt time.Time) int64 {
+	delay := executeAt.Sub(t.startAt)
+	if delay <= 0 {
+		return 0
+	}
+	return int64((delay + t.interval - 1) / t.interval)
+}
+
+// 第 level 层每个槽位跨越的 tick 数
+func (t *TimeWheel) levelSpan(level int) int64 {
+	span := int64(1)
+	for i := 0; i < level; i++ {
+		span *= int64(t.slotNum)
+	}
+	return span
+}
+
+func (t *TimeWheel) addLevel() {
+	slots := make([]*list.List, 0, t.slotNum)
+	for i := 0; i < t.slotNum; i++ {
+		slots = append(slots, list.New())
+	}
+	t.levels = append(t.levels, slots)
 }
 
 func (t *TimeWheel) addTask(task *taskElement) {
-	list := t.slots[task.pos]
 	if _, ok := t.keyToETask[task.key]; ok {
 		t.removeTask(task.key)
 	}
-	eTask := list.PushBack(task)
-	t.keyToETask[task.key] = eTask
+	if task.expireAt <= t.curTick {
+		t.execute(task)
+		return
+	}
+
+	// 选择能够容纳到期时间的最低层级：第 level 层覆盖 [当前槽位起点 + span, 当前槽位起点 + span * slotNum) 的范围
+	for level := 0; ; level++ {
+		if level == len(t.levels) {
+			t.addLevel()
+		}
+		span := t.levelSpan(level)
+		if task.expireAt >= t.curTick-t.curTick%span+span*int64(t.slotNum) {
+			continue
+		}
+		task.level, task.pos = level, int((task.expireAt/span)%int64(t.slotNum))
+		t.keyToETask[task.key] = t.levels[level][task.pos].PushBack(task)
+		return
+	}
 }
 
 func (t *TimeWheel) removeTask(key string) {
@@ -148,9 +247,77 @@ func (t *TimeWheel) removeTask(key string) {
 	}
 	delete(t.keyToETask, key)
 	task, _ := eTask.Value.(*taskElement)
-	_ = t.slots[task.pos].Remove(eTask)
+	_ = t.levels[task.level][task.pos].Remove(eTask)
+}
+
+// Scheduler RTimeWheel 与本地时间轮共同实现的接口，便于在测试或者单机场景中替换实现.
+type Scheduler interface {
+	AddTask(ctx context.Context, key string, task *RTaskElement, executeAt time.Time) (TaskHandle, error)
+	RemoveTask(ctx context.Context, key string, executeAt time.Time) error
+	Stop()
+}
+
+var _ Scheduler = (*RTimeWheel)(nil)
+
+// NewLocalScheduler 基于本地时间轮实现 Scheduler，任务到期时交给 executor 执行. 执行失败时不会重试.
+// opts 中的 WithLogger、WithExecutionHooks、WithFailureHook 以及 WithPanicHandler 与 RTimeWheel 的行为一致：
+// 执行失败时输出日志并依次调用 OnFailure 以及失败回调，执行成功时调用 OnSuccess. 其余选项不生效.
+func NewLocalScheduler(timeWheel *TimeWheel, executor Executor, opts ...RTimeWheelOption) Scheduler {
+	o := RTimeWheelOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	repairRTimeWheel(&o)
+	return &localScheduler{timeWheel: timeWheel, executor: executor, opts: &o}
+}
+
+type localScheduler struct {
+	timeWheel *TimeWheel
+	executor  Executor
+	opts      *RTimeWheelOptions
+}
+
+func (s *localScheduler) AddTask(ctx context.Context, key string, task *RTaskElement, executeAt time.Time) (TaskHandle, error) {
+	if key == "" {
+		key = util.NewUUID()
+	}
+	copy := *task
+	copy.Key = key
+	copy.scheduledAt = executeAt
+	s.timeWheel.AddTask(key, func() {
+		s.execute(&copy)
+	}, executeAt)
+	return TaskHandle{Key: key, ExecuteAt: executeAt}, nil
+}
+
+func (s *localScheduler) execute(task *RTaskElement) {
+	ctx := context.Background()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			s.opts.panicHandler(recovered, debug.Stack(), task)
+		}
+	}()
+
+	start := time.Now()
+	task.firedAt = start
+	if err := s.executor.Execute(ctx, task); err != nil {
+		s.opts.logger.Warn(ctx, "execute task failed", "key", task.Key, "callback_url", task.CallbackURL, "err", err)
+		if s.opts.executionHooks != nil {
+			s.opts.executionHooks.OnFailure(ctx, task, err)
+		}
+		s.opts.failureHook(ctx, task, err)
+		return
+	}
+	if s.opts.executionHooks != nil {
+		s.opts.executionHooks.OnSuccess(ctx, task, time.Since(start))
+	}
+}
+
+func (s *localScheduler) RemoveTask(ctx context.Context, key string, executeAt time.Time) error {
+	s.timeWheel.RemoveTask(key)
+	return nil
 }
 
-func (t *TimeWheel) circularIncr() {
-	t.curSlot = (t.curSlot + 1) % len(t.slots)
+func (s *localScheduler) Stop() {
+	s.timeWheel.Stop()
 }
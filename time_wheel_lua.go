@@ -1,8 +1,31 @@
 package timewheel
 
+import (
+	"strings"
+
+	"github.com/xiaoxuxiansheng/timewheel/pkg/redis"
+)
+
+// 读取任务明细中唯一键的 lua 函数，供需要根据唯一键匹配任务的脚本使用.
+// json 格式的任务明细以 '{' 开头；其余格式的任务明细以 1 字节的格式标识开头，之后依次为 2 字节大端序的唯一键长度以及唯一键，参见 encodeTask
+const luaTaskKeyOf = `
+       local function taskKeyOf(v)
+           if string.byte(v,1) == 123
+           then
+               local ok, task = pcall(cjson.decode, v)
+               if ok then return task['key'] end
+               return nil
+           end
+           if #v < 3 then return nil end
+           local n = string.byte(v,2)*256 + string.byte(v,3)
+           return string.sub(v,4,3+n)
+       end
+`
+
 const (
 	// 1 添加任务时，如果存在删除 key 的标识，则将其删除
 	// 添加任务时，根据时间（所属的 min）决定数据从属于哪个分片{}
+	// zset 的过期时间根据时间片的结束时刻以及保留时长计算，只会延长不会缩短
 	LuaAddTasks = `
        -- 获取的首个 key 为 zset 的 key
        local zsetKey = KEYS[1]
@@ -14,32 +37,76 @@ const (
        local task = ARGV[2]
        -- 获取的第三个 arg 为定时任务唯一键，用于将其从已删除任务 set 中移除
        local taskKey = ARGV[3]
+       -- 获取的第四个 arg 为 zset 需要保留的秒数
+       local expireSeconds = tonumber(ARGV[4])
        -- 每次添加定时任务时，都直接将其从已删除任务 set 中移除，不管之前是否在 set 中
        redis.call('srem',deleteSetKey,taskKey)
        -- 调用 zadd 指令，将定时任务添加到 zset 中
-       return redis.call('zadd',zsetKey,score,task)
+       local cnt = redis.call('zadd',zsetKey,score,task)
+       -- 倘若 zset 剩余的过期时间不足，则延长 zset 的过期时间
+       if redis.call('ttl',zsetKey) < expireSeconds
+       then
+           redis.call('expire',zsetKey,expireSeconds)
+       end
+       return cnt
     `
 
-	// 2 删除任务时，将删除 key 的标识置为 true
-	// !通过为 deleteSetKey 集合设置 120 秒的过期时间，可以确保在没有新的删除任务添加时，这个集合不会永久存在于 Redis 中，避免内存被无用数据占用
-	LuaDeleteTask = `
+	// 2 删除任务时，将删除 key 的标识置为 true，并检查任务是否仍处于等待状态
+	// !删除集合的过期时间根据任务所属时间片的结束时刻计算，只会延长不会缩短，保证删除标识在任务被扫描之前不会过期，
+	// 同时在没有新的删除任务添加时，这个集合不会永久存在于 Redis 中，避免内存被无用数据占用
+	// 返回 1 表示任务被成功删除；0 表示任务此前已经被删除；-1 表示 zset 中该 score 下不存在该任务
+	LuaDeleteTask = luaTaskKeyOf + `
+       -- 第一个 key 为任务所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为标识删除任务的 set 集合的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为定时任务的唯一键
+       local taskKey = ARGV[1]
+       -- 第二个 arg 为删除集合需要保留的秒数
+       local expireSeconds = tonumber(ARGV[2])
+       -- 第三个 arg 为任务在 zset 中的 score
+       local score = ARGV[3]
+       -- 将定时任务唯一键添加到 set 中. 即便任务当前不在 zset 中也会写入，从而支持提前删除周期任务未来的某一次执行
+       local added = redis.call('sadd',deleteSetKey,taskKey)
+       -- 倘若 set 剩余的过期时间不足，则延长 set 的过期时间
+       if redis.call('ttl',deleteSetKey) < expireSeconds
+       then
+           redis.call('expire',deleteSetKey,expireSeconds)
+       end
+       if added == 0
+       then
+           return 0
+       end
+       -- 只根据 score 检索，不遍历整个时间片. 调用方需要通过唯一键索引传入任务实际的 score，未命中时删除标识依然生效
+       local targets = redis.call('zrangebyscore',zsetKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               return 1
+           end
+       end
+       return -1
+    `
+
+	// 2.1 批量删除同一个分钟级时间片内的任务，返回新增的删除标识数量
+	LuaDeleteTasks = `
        -- 获取标识删除任务的 set 集合的 key
        local deleteSetKey = KEYS[1]
-       -- 获取定时任务的唯一键
-       local taskKey = ARGV[1]
-       -- 将定时任务唯一键添加到 set 中
-       redis.call('sadd',deleteSetKey,taskKey)
-       -- 倘若是 set 中的首个元素，则对 set 设置 120 s 的过期时间
-       local scnt = redis.call('scard',deleteSetKey)
-       if (tonumber(scnt) == 1)
+       -- 第一个 arg 为删除集合需要保留的秒数
+       local expireSeconds = tonumber(ARGV[1])
+       -- 之后的 args 为定时任务的唯一键
+       local cnt = redis.call('sadd',deleteSetKey,unpack(ARGV,2))
+       -- 倘若 set 剩余的过期时间不足，则延长 set 的过期时间
+       if redis.call('ttl',deleteSetKey) < expireSeconds
        then
-           redis.call('expire',deleteSetKey,120)
+           redis.call('expire',deleteSetKey,expireSeconds)
        end
-       return scnt
-)    `
+       return cnt
+    `
 
 	// 3 执行任务时，通过 zrange 操作取回所有不存在删除 key 标识的任务
 	// 扫描 redis 时间轮. 获取分钟范围内,已删除任务集合 以及在时间上达到执行条件的定时任务进行返回
+	// 每次至多取出 count 个任务，避免积压的时间片产生过大的回复并长时间阻塞 redis，剩余的任务由下一页取出
 	LuaZrangeTasks = `
        -- 第一个 key 为存储定时任务的 zset key
        local zsetKey = KEYS[1]
@@ -49,20 +116,627 @@ const (
        local score1 = ARGV[1]
        -- 第二个 arg 为 zrange 检索的 score 右边界
        local score2 = ARGV[2]
+       -- 第三个 arg 为一页取出的最大任务数量
+       local count = ARGV[3]
        -- 获取到已删除任务的集合
        local deleteSet = redis.call('smembers',deleteSetKey)
-       -- 根据秒级时间戳对 zset 进行 zrange 检索，获取到满足时间条件的定时任务
-       local targets = redis.call('zrange',zsetKey,score1,score2,'byscore')
-       -- 检索到的定时任务直接从时间轮中移除，保证分布式场景下定时任务不被重复获取
-       redis.call('zremrangebyscore',zsetKey,score1,score2)
+       -- 根据秒级时间戳对 zset 进行 zrange 检索，获取到满足时间条件的定时任务及其 score
+       local targets = redis.call('zrange',zsetKey,score1,score2,'byscore','withscores','limit',0,count)
+       -- 检索到的定时任务直接从时间轮中移除，保证分布式场景下定时任务不被重复获取. 只移除本页取出的任务
+       for i = 1, #targets, 2 do
+           redis.call('zrem',zsetKey,targets[i])
+       end
        -- 返回的结果是一个 table
        local reply = {}
        -- table 的首个元素为已删除任务集合
        reply[1] = deleteSet
-       -- 依次将检索到的定时任务追加到 table 中
+       -- 依次将检索到的定时任务及其 score 追加到 table 中
        for i, v in ipairs(targets) do
            reply[#reply+1]=v
        end
        return reply
     `
+
+	// 4 周期任务重新调度时，将下一次执行添加到对应的分钟级 zset 中
+	// 与 LuaAddTasks 不同，不会清除删除集合中已存在的标识，从而支持提前删除周期任务未来的某一次执行
+	LuaRepeatTask = `
+       -- 第一个 key 为下一次执行所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为下一次执行所属的已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为下一次执行在 zset 中的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为定时任务明细数据
+       local task = ARGV[2]
+       -- 第三个 arg 为定时任务唯一键
+       local taskKey = ARGV[3]
+       -- 第四个 arg 为 zset 需要保留的秒数
+       local expireSeconds = tonumber(ARGV[4])
+       -- 倘若下一次执行已被提前删除，则不再添加
+       if redis.call('sismember',deleteSetKey,taskKey) == 1
+       then
+           return -1
+       end
+       local cnt = redis.call('zadd',zsetKey,score,task)
+       if redis.call('ttl',zsetKey) < expireSeconds
+       then
+           redis.call('expire',zsetKey,expireSeconds)
+       end
+       return cnt
+    `
+
+	// 5 从分钟级 zset 中取出指定 score 以及唯一键对应的任务，用于任务的迁移
+	LuaTakeTask = luaTaskKeyOf + `
+       -- 第一个 key 为任务所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为任务所属的已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为任务在 zset 中的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为任务唯一键
+       local taskKey = ARGV[2]
+       -- 任务已被删除，视同不存在
+       if redis.call('sismember',deleteSetKey,taskKey) == 1
+       then
+           return false
+       end
+       -- 根据 score 检索候选任务，并通过任务明细中的 key 进行匹配
+       local targets = redis.call('zrangebyscore',zsetKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               redis.call('zrem',zsetKey,v)
+               return v
+           end
+       end
+       return false
+    `
+
+	// 5.1 查询指定 score 以及唯一键对应的任务，不会对 zset 进行修改
+	LuaGetTask = luaTaskKeyOf + `
+       -- 第一个 key 为任务所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为任务所属的已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为任务在 zset 中的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为任务唯一键
+       local taskKey = ARGV[2]
+       if redis.call('sismember',deleteSetKey,taskKey) == 1
+       then
+           return false
+       end
+       local targets = redis.call('zrangebyscore',zsetKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               return v
+           end
+       end
+       return false
+    `
+
+	// 5.2 分页查询 score 范围内处于等待状态的任务，过滤已删除的任务，不会对 zset 进行修改
+	LuaListTasks = luaTaskKeyOf + `
+       -- 第一个 key 为存储定时任务的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为检索的 score 左边界
+       local score1 = ARGV[1]
+       -- 第二个 arg 为检索的 score 右边界
+       local score2 = ARGV[2]
+       -- 第三个 arg 为最多返回的任务数量
+       local limit = tonumber(ARGV[3])
+       -- 返回的结果依次为任务明细及其 score
+       local reply = {}
+       local offset = 0
+       local pageSize = 100
+       while #reply < 2*limit do
+           local targets = redis.call('zrangebyscore',zsetKey,score1,score2,'withscores','limit',offset,pageSize)
+           for i = 1, #targets, 2 do
+               local key = taskKeyOf(targets[i])
+               if not key or redis.call('sismember',deleteSetKey,key) == 0
+               then
+                   reply[#reply+1] = targets[i]
+                   reply[#reply+1] = targets[i+1]
+                   if #reply >= 2*limit
+                   then
+                       break
+                   end
+               end
+           end
+           if #targets < 2*pageSize
+           then
+               break
+           end
+           offset = offset + pageSize
+       end
+       return reply
+    `
+
+	// 6 在同一个分钟级时间片内迁移任务，取出与添加在同一个 lua 脚本中原子完成
+	LuaMoveTask = luaTaskKeyOf + `
+       -- 第一个 key 为任务所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为任务所属的已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为任务原本的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为任务唯一键
+       local taskKey = ARGV[2]
+       -- 第三个 arg 为任务新的 score
+       local newScore = ARGV[3]
+       if redis.call('sismember',deleteSetKey,taskKey) == 1
+       then
+           return false
+       end
+       local targets = redis.call('zrangebyscore',zsetKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               redis.call('zadd',zsetKey,newScore,v)
+               return v
+           end
+       end
+       return false
+    `
+
+	// 7 写入任务唯一键到所在位置（score）的索引，并将任务的版本号增加给定的值，返回新的版本号
+	LuaSetIndex = `
+       -- 第一个 key 为索引 hash 的 key
+       local indexKey = KEYS[1]
+       -- 第一个 arg 为任务唯一键
+       local taskKey = ARGV[1]
+       -- 第二个 arg 为任务的 score
+       local score = ARGV[2]
+       -- 第三个 arg 为版本号的增量
+       local incr = ARGV[3]
+       redis.call('hset',indexKey,taskKey,score)
+       return redis.call('hincrby',indexKey,'{v}'..taskKey,incr)
+    `
+
+	// 8 读取任务唯一键的索引
+	LuaGetIndex = `
+       -- 第一个 key 为索引 hash 的 key
+       local indexKey = KEYS[1]
+       -- 第一个 arg 为任务唯一键
+       local taskKey = ARGV[1]
+       return redis.call('hget',indexKey,taskKey)
+    `
+
+	// 9 清理任务唯一键的索引以及版本号. 只有当索引仍指向给定的 score 时才会删除，避免误删同一个 key 新添加任务的索引
+	LuaCleanIndex = `
+       -- 第一个 key 为索引 hash 的 key
+       local indexKey = KEYS[1]
+       -- args 依次为任务唯一键及其 score
+       local cnt = 0
+       for i = 1, #ARGV, 2 do
+           local score = redis.call('hget',indexKey,ARGV[i])
+           if score and tonumber(score) == tonumber(ARGV[i+1])
+           then
+               cnt = cnt + redis.call('hdel',indexKey,ARGV[i])
+               redis.call('hdel',indexKey,'{v}'..ARGV[i])
+           end
+       end
+       return cnt
+    `
+
+	// 10 写入死信队列，并保留最新的 maxLen 条记录
+	LuaPushDeadLetter = `
+       -- 第一个 key 为死信队列 list 的 key
+       local deadLetterKey = KEYS[1]
+       -- 第一个 arg 为死信记录
+       local record = ARGV[1]
+       -- 第二个 arg 为死信队列保留的最大记录数
+       local maxLen = tonumber(ARGV[2])
+       local cnt = redis.call('lpush',deadLetterKey,record)
+       if cnt > maxLen
+       then
+           redis.call('ltrim',deadLetterKey,0,maxLen-1)
+       end
+       return cnt
+    `
+
+	// 10.1 从新到旧读取死信队列中的记录
+	LuaRangeDeadLetters = `
+       -- 第一个 key 为死信队列 list 的 key
+       local deadLetterKey = KEYS[1]
+       -- 第一、二个 arg 为读取范围的起止下标
+       return redis.call('lrange',deadLetterKey,ARGV[1],ARGV[2])
+    `
+
+	// 10.2 从死信队列中移除指定的记录，返回移除的数量
+	LuaRemoveDeadLetter = `
+       -- 第一个 key 为死信队列 list 的 key
+       local deadLetterKey = KEYS[1]
+       -- 第一个 arg 为死信记录
+       return redis.call('lrem',deadLetterKey,1,ARGV[1])
+    `
+
+	// 11 租约模式下检索任务. 与 LuaZrangeTasks 相同，区别在于取出的任务会转移到当前实例的 in-flight zset 中，score 为租约的到期时刻
+	LuaLeaseTasks = `
+       -- 第一个 key 为存储定时任务的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第三个 key 为当前实例的 in-flight zset key
+       local inflightKey = KEYS[3]
+       -- 第四个 key 为 in-flight zset 的登记集合，可选. 集群模式下不传入，由调用方提前登记
+       local registryKey = KEYS[4]
+       -- 第一个 arg 为 zrange 检索的 score 左边界
+       local score1 = ARGV[1]
+       -- 第二个 arg 为 zrange 检索的 score 右边界
+       local score2 = ARGV[2]
+       -- 第三个 arg 为租约的到期时刻
+       local deadline = ARGV[3]
+       -- 第四个 arg 为一页取出的最大任务数量
+       local count = ARGV[4]
+       local deleteSet = redis.call('smembers',deleteSetKey)
+       local targets = redis.call('zrange',zsetKey,score1,score2,'byscore','withscores','limit',0,count)
+       local reply = {}
+       reply[1] = deleteSet
+       for i = 1, #targets, 2 do
+           redis.call('zrem',zsetKey,targets[i])
+           -- in-flight zset 中的成员为 score|任务明细
+           redis.call('zadd',inflightKey,deadline,targets[i+1] .. '|' .. targets[i])
+           reply[#reply+1] = targets[i]
+           reply[#reply+1] = targets[i+1]
+       end
+       -- 租约与登记在同一个脚本中完成，进程在租约之后崩溃时 in-flight zset 依然能够被其他实例发现
+       if registryKey and #targets > 0
+       then
+           redis.call('sadd',registryKey,inflightKey)
+       end
+       return reply
+    `
+
+	// 12 确认租约，将任务从 in-flight zset 中删除
+	LuaAckTasks = `
+       -- 第一个 key 为 in-flight zset 的 key
+       local inflightKey = KEYS[1]
+       -- args 为 in-flight zset 中的成员
+       return redis.call('zrem',inflightKey,unpack(ARGV))
+    `
+
+	// 13 获取已登记的全部 in-flight zset
+	LuaGetInflightKeys = `
+       return redis.call('smembers',KEYS[1])
+    `
+
+	// 14 从登记集合中移除 in-flight zset
+	LuaUnregisterInflightKey = `
+       return redis.call('srem',KEYS[1],ARGV[1])
+    `
+
+	// 15 将租约已经到期的任务转移到当前实例的 in-flight zset 中，并续期租约
+	LuaReclaimLeases = `
+       -- 第一个 key 为待检查的 in-flight zset key
+       local fromKey = KEYS[1]
+       -- 第二个 key 为当前实例的 in-flight zset key
+       local toKey = KEYS[2]
+       -- 第三个 key 为 in-flight zset 的登记集合，可选. 集群模式下不传入，由调用方提前登记
+       local registryKey = KEYS[3]
+       -- 第一个 arg 为当前时刻
+       local now = ARGV[1]
+       -- 第二个 arg 为新租约的到期时刻
+       local deadline = ARGV[2]
+       local expired = redis.call('zrangebyscore',fromKey,'-inf',now)
+       for i, v in ipairs(expired) do
+           redis.call('zrem',fromKey,v)
+           redis.call('zadd',toKey,deadline,v)
+       end
+       if registryKey and #expired > 0
+       then
+           redis.call('sadd',registryKey,toKey)
+       end
+       -- 返回的首个元素为待检查 zset 中剩余的成员数量，之后依次为转移的成员
+       local reply = {}
+       reply[1] = redis.call('zcard',fromKey)
+       for i, v in ipairs(expired) do
+           reply[#reply+1] = v
+       end
+       return reply
+    `
+
+	// 16 登记时间轮的时间片粒度，返回已登记的粒度
+	LuaRegisterSliceGranularity = `
+       -- 第一个 key 为时间轮元信息 hash 的 key
+       local metaKey = KEYS[1]
+       -- 第一个 arg 为时间片粒度的秒数
+       local granularity = ARGV[1]
+       redis.call('hsetnx',metaKey,'slice_granularity',granularity)
+       return redis.call('hget',metaKey,'slice_granularity')
+    `
+
+	// 17 追加任务的执行记录，stream 的长度近似保留在 maxLen 以内
+	LuaAppendHistory = `
+       -- 第一个 key 为执行记录 stream 的 key
+       local streamKey = KEYS[1]
+       -- 第一个 arg 为 stream 保留的最大长度
+       local maxLen = ARGV[1]
+       -- 之后的 args 依次为任务唯一键、执行结果、执行时刻、完成时刻以及错误信息
+       return redis.call('xadd',streamKey,'maxlen','~',maxLen,'*',
+           'key',ARGV[2],'status',ARGV[3],'scheduled',ARGV[4],'executed',ARGV[5],'error',ARGV[6])
+    `
+
+	// 18 从新到旧读取执行记录
+	LuaRangeHistory = `
+       -- 第一个 key 为执行记录 stream 的 key
+       local streamKey = KEYS[1]
+       -- 第一个 arg 为读取的起始 ID，第二个 arg 为读取的记录数
+       return redis.call('xrevrange',streamKey,ARGV[1],'-','count',ARGV[2])
+    `
+
+	// 19 检查任务是否存在删除标识，返回与 args 一一对应的检查结果，1 表示已删除
+	LuaCheckDeletedTasks = `
+       -- 第一个 key 为标识删除任务的 set 集合的 key
+       local deleteSetKey = KEYS[1]
+       -- args 为定时任务的唯一键
+       local reply = {}
+       for i, taskKey in ipairs(ARGV) do
+           reply[i] = redis.call('sismember',deleteSetKey,taskKey)
+       end
+       return reply
+    `
+
+	// 20 竞选或者续约 leader，返回 1 表示当前实例持有 leader 锁
+	LuaAcquireLeader = `
+       -- 第一个 key 为 leader 锁的 key
+       local leaderKey = KEYS[1]
+       -- 第一个 arg 为当前实例的标识，第二个 arg 为锁的有效期毫秒数
+       local instanceID = ARGV[1]
+       local ttl = ARGV[2]
+       if redis.call('set',leaderKey,instanceID,'nx','px',ttl)
+       then
+           return 1
+       end
+       -- 锁已经由当前实例持有时续约
+       if redis.call('get',leaderKey) == instanceID
+       then
+           redis.call('pexpire',leaderKey,ttl)
+           return 1
+       end
+       return 0
+    `
+
+	// 21 释放当前实例持有的 leader 锁
+	LuaReleaseLeader = `
+       if redis.call('get',KEYS[1]) == ARGV[1]
+       then
+           return redis.call('del',KEYS[1])
+       end
+       return 0
+    `
+
+	// 22 分片模式下写入实例的心跳，清理心跳超时的实例，返回存活实例的标识以及静态指定的分片
+	LuaShardHeartbeat = `
+       -- 第一个 key 为成员 hash 的 key，field 为实例标识，value 为 心跳时刻|静态指定的分片
+       local membersKey = KEYS[1]
+       -- 第一个 arg 为当前实例的标识，第二个 arg 为心跳时刻，第三个 arg 为存活的最早心跳时刻，均为毫秒级时间戳
+       local instanceID = ARGV[1]
+       local deadline = tonumber(ARGV[3])
+       -- 第四个 arg 为静态指定的分片，以逗号分隔
+       redis.call('hset',membersKey,instanceID,ARGV[2] .. '|' .. ARGV[4])
+       local members = redis.call('hgetall',membersKey)
+       local reply = {}
+       for i = 1, #members, 2 do
+           local sep = string.find(members[i+1],'|',1,true)
+           if not sep or tonumber(string.sub(members[i+1],1,sep-1)) < deadline
+           then
+               redis.call('hdel',membersKey,members[i])
+           else
+               reply[#reply+1] = members[i]
+               reply[#reply+1] = string.sub(members[i+1],sep+1)
+           end
+       end
+       return reply
+    `
+
+	// 23 分片模式下实例退出成员 hash
+	LuaShardLeave = `
+       return redis.call('hdel',KEYS[1],ARGV[1])
+    `
+
+	// 24 登记时间轮的元信息，如分片数量、分桶数量，返回已登记的值
+	LuaRegisterMeta = `
+       -- 第一个 key 为时间轮元信息 hash 的 key
+       local metaKey = KEYS[1]
+       -- 第一个 arg 为元信息的 field，第二个 arg 为需要登记的值
+       local field = ARGV[1]
+       redis.call('hsetnx',metaKey,field,ARGV[2])
+       return redis.call('hget',metaKey,field)
+    `
+
+	// 25 将任务唯一键加入标签集合，并保证集合的过期时间不早于任务的执行时刻
+	LuaTagTask = `
+       -- 第一个 key 为标签集合的 key
+       local tagKey = KEYS[1]
+       -- 第一个 arg 为任务唯一键，第二个 arg 为集合需要保留的秒数
+       local expireSeconds = tonumber(ARGV[2])
+       redis.call('sadd',tagKey,ARGV[1])
+       if redis.call('ttl',tagKey) < expireSeconds
+       then
+           redis.call('expire',tagKey,expireSeconds)
+       end
+       return 1
+    `
+
+	// 26 批量读取任务唯一键的索引，返回与 args 一一对应的 score，索引不存在时为 false
+	LuaGetIndexes = `
+       -- 第一个 key 为索引 hash 的 key，args 为任务唯一键
+       return redis.call('hmget',KEYS[1],unpack(ARGV))
+    `
+
+	// 27 检索任务，与 LuaZrangeTasks 相同，但不从 zset 中移除，任务在认领成功之后才会移除
+	LuaPeekTasks = `
+       -- 第一个 key 为存储定时任务的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为 zrange 检索的 score 左边界
+       local score1 = ARGV[1]
+       -- 第二个 arg 为 zrange 检索的 score 右边界
+       local score2 = ARGV[2]
+       -- 第三个 arg 为一页检索的最大任务数量
+       local count = ARGV[3]
+       local reply = {}
+       reply[1] = redis.call('smembers',deleteSetKey)
+       local targets = redis.call('zrange',zsetKey,score1,score2,'byscore','withscores','limit',0,count)
+       for i, v in ipairs(targets) do
+           reply[#reply+1]=v
+       end
+       return reply
+    `
+
+	// 28 认领任务的执行，认领成功的任务从 zset 中移除. 返回与任务一一对应的认领结果，1 为认领成功.
+	// 只有 zrem 成功移除成员的实例认领成功，成员已经被其他实例移除时认领失败
+	LuaClaimTasks = `
+       -- 第一个 key 为存储定时任务的 zset key
+       local zsetKey = KEYS[1]
+       -- args 依次为每个任务在 zset 中的成员
+       local reply = {}
+       for i = 1, #ARGV do
+           reply[i] = redis.call('zrem',zsetKey,ARGV[i])
+       end
+       return reply
+    `
+
+	// 29 校验并递增任务的版本号. 期望的版本号大于 0 并且与当前版本号不同时返回 -1，否则返回递增之后的版本号
+	LuaCheckVersion = `
+       -- 第一个 key 为索引 hash 的 key
+       local indexKey = KEYS[1]
+       -- 第一个 arg 为任务唯一键
+       local versionField = '{v}'..ARGV[1]
+       -- 第二个 arg 为期望的版本号
+       local expected = tonumber(ARGV[2])
+       local current = tonumber(redis.call('hget',indexKey,versionField) or '0')
+       if expected > 0 and current ~= expected
+       then
+           return -1
+       end
+       return redis.call('hincrby',indexKey,versionField,1)
+    `
+
+	// 30 唯一键不存在索引时写入索引，占用唯一键. 写入成功返回 1，否则返回 0
+	LuaReserveIndex = `
+       -- 第一个 key 为索引 hash 的 key，第一个 arg 为任务唯一键，第二个 arg 为任务的 score
+       return redis.call('hsetnx',KEYS[1],ARGV[1],ARGV[2])
+    `
+
+	// 31 以新的任务明细替换处于等待状态的任务，score 保持不变. 返回被替换的任务明细
+	LuaReplaceTask = luaTaskKeyOf + `
+       -- 第一个 key 为任务所属的 zset key
+       local zsetKey = KEYS[1]
+       -- 第二个 key 为任务所属的已删除任务 set 的 key
+       local deleteSetKey = KEYS[2]
+       -- 第一个 arg 为任务的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为任务唯一键
+       local taskKey = ARGV[2]
+       -- 第三个 arg 为新的任务明细
+       local newTask = ARGV[3]
+       if redis.call('sismember',deleteSetKey,taskKey) == 1
+       then
+           return false
+       end
+       local targets = redis.call('zrangebyscore',zsetKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               redis.call('zrem',zsetKey,v)
+               redis.call('zadd',zsetKey,score,newTask)
+               return v
+           end
+       end
+       return false
+    `
+
+	// 32 检索长期 zset 中执行时刻不晚于给定时刻的任务，不会对 zset 进行修改. 返回任务明细及其 score
+	LuaPeekOverflow = `
+       -- 第一个 key 为长期 zset 的 key
+       local overflowKey = KEYS[1]
+       -- 第一个 arg 为 score 的右边界（包含）
+       local maxScore = ARGV[1]
+       -- 第二个 arg 为检索的最大数量
+       local limit = ARGV[2]
+       return redis.call('zrangebyscore',overflowKey,'-inf',maxScore,'withscores','limit',0,limit)
+    `
+
+	// 33 将长期 zset 中的任务晋升到时间片. 只有晋升标识写入成功的任务才会添加，重复晋升不会重复添加.
+	// 晋升不会清除时间片中已有的删除标识，任务位于长期 zset 时被删除，晋升之后删除依然生效. 返回添加的任务数量
+	LuaPromoteTasks = `
+       -- 第一个 key 为时间片 zset 的 key，之后的 key 依次为每个任务的晋升标识
+       local zsetKey = KEYS[1]
+       -- 第一个 arg 为 zset 需要保留的秒数
+       local expireSeconds = tonumber(ARGV[1])
+       -- 第二个 arg 为晋升标识保留的秒数，之后的 arg 依次为每个任务的 score 以及任务明细
+       local markerExpireSeconds = ARGV[2]
+       local cnt = 0
+       for i = 2, #KEYS do
+           if redis.call('set',KEYS[i],1,'nx','ex',markerExpireSeconds)
+           then
+               cnt = cnt + redis.call('zadd',zsetKey,ARGV[2*i-1],ARGV[2*i])
+           end
+       end
+       if cnt > 0 and redis.call('ttl',zsetKey) < expireSeconds
+       then
+           redis.call('expire',zsetKey,expireSeconds)
+       end
+       return cnt
+    `
+
+	// 34 查询长期 zset 中指定 score 以及唯一键对应的任务，第三个 arg 为 1 时将其从 zset 中移除. 返回任务明细
+	LuaOverflowTask = luaTaskKeyOf + `
+       -- 第一个 key 为长期 zset 的 key
+       local overflowKey = KEYS[1]
+       -- 第一个 arg 为任务的 score
+       local score = ARGV[1]
+       -- 第二个 arg 为任务唯一键
+       local taskKey = ARGV[2]
+       local targets = redis.call('zrangebyscore',overflowKey,score,score)
+       for i, v in ipairs(targets) do
+           if taskKeyOf(v) == taskKey
+           then
+               if ARGV[3] == '1'
+               then
+                   redis.call('zrem',overflowKey,v)
+               end
+               return v
+           end
+       end
+       return false
+    `
+
+	// 35 写入任务的晋升标识，阻止晋升协程将长期 zset 中的任务添加到时间片. 写入成功返回 OK
+	LuaClaimPromotion = `
+       -- 第一个 key 为晋升标识，第一个 arg 为标识保留的秒数
+       return redis.call('set',KEYS[1],1,'nx','ex',ARGV[1])
+    `
+)
+
+// Redis 6.2 之前的版本不支持 ZRANGE 的 BYSCORE 参数，兼容模式下改写为等价的 ZRANGEBYSCORE，脚本的其余部分保持不变
+var legacyZrangeReplacer = strings.NewReplacer(
+	"redis.call('zrange',zsetKey,score1,score2,'byscore','withscores','limit',0,count)",
+	"redis.call('zrangebyscore',zsetKey,score1,score2,'withscores','limit',0,count)",
+)
+
+var (
+	// LuaZrangeTasksLegacy LuaZrangeTasks 在 Redis 6.2 之前版本的兼容形式
+	LuaZrangeTasksLegacy = legacyZrangeReplacer.Replace(LuaZrangeTasks)
+	// LuaLeaseTasksLegacy LuaLeaseTasks 在 Redis 6.2 之前版本的兼容形式
+	LuaLeaseTasksLegacy = legacyZrangeReplacer.Replace(LuaLeaseTasks)
+	// LuaPeekTasksLegacy LuaPeekTasks 在 Redis 6.2 之前版本的兼容形式
+	LuaPeekTasksLegacy = legacyZrangeReplacer.Replace(LuaPeekTasks)
+)
+
+// 每次添加、删除任务以及每次 tick 都会执行的脚本通过 EVALSHA 执行，避免每次发送完整的脚本
+var (
+	addTasksScript          = redis.NewScript(LuaAddTasks)
+	deleteTaskScript        = redis.NewScript(LuaDeleteTask)
+	zrangeTasksScript       = redis.NewScript(LuaZrangeTasks)
+	zrangeTasksLegacyScript = redis.NewScript(LuaZrangeTasksLegacy)
+	peekTasksScript         = redis.NewScript(LuaPeekTasks)
+	peekTasksLegacyScript   = redis.NewScript(LuaPeekTasksLegacy)
+	claimTasksScript        = redis.NewScript(LuaClaimTasks)
 )
@@ -1,7 +1,25 @@
 package timewheel
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +44,126 @@ func Test_timeWheel(t *testing.T) {
 	<-time.After(6 * time.Second)
 }
 
+func Test_timeWheel_hierarchical(t *testing.T) {
+	// 4 个槽位，每层覆盖 40ms、160ms、640ms，较长的延时需要逐层下沉
+	timeWheel := NewTimeWheel(4, 10*time.Millisecond)
+
+	var (
+		mu       sync.Mutex
+		executed = make(map[string]time.Time)
+	)
+	start := time.Now()
+	delays := map[string]time.Duration{
+		"level0": 30 * time.Millisecond,
+		"level1": 100 * time.Millisecond,
+		"level3": 700 * time.Millisecond,
+		"past":   -time.Second,
+	}
+	for key, delay := range delays {
+		key := key
+		timeWheel.AddTask(key, func() {
+			mu.Lock()
+			defer mu.Unlock()
+			executed[key] = time.Now()
+		}, start.Add(delay))
+	}
+	timeWheel.AddTask("removed", func() { t.Errorf("removed task executed") }, start.Add(200*time.Millisecond))
+	timeWheel.RemoveTask("removed")
+
+	slow := make(chan struct{})
+	timeWheel.AddTask("slow", func() {
+		time.Sleep(100 * time.Millisecond)
+		close(slow)
+	}, start.Add(750*time.Millisecond))
+
+	<-time.After(800 * time.Millisecond)
+	// Stop 等待执行中的任务结束
+	timeWheel.Stop()
+	select {
+	case <-slow:
+	default:
+		t.Errorf("stop returned before running task finished")
+	}
+	// 停止后添加任务不会阻塞
+	timeWheel.AddTask("stopped", func() {}, time.Now())
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, delay := range delays {
+		at, ok := executed[key]
+		if !ok {
+			t.Errorf("task %s not executed", key)
+			continue
+		}
+		if at.Before(start.Add(delay)) || at.After(start.Add(delay).Add(50*time.Millisecond)) && delay > 0 {
+			t.Errorf("task %s executed at %v, want %v", key, at.Sub(start), delay)
+		}
+	}
+}
+
+func Test_timeWheel_localScheduler(t *testing.T) {
+	executor := testExecutor{}
+	var scheduler Scheduler = NewLocalScheduler(NewTimeWheel(10, 10*time.Millisecond), &executor)
+
+	ctx := context.Background()
+	_, _ = scheduler.AddTask(ctx, "test1", &RTaskElement{CallbackURL: callbackURL}, time.Now().Add(20*time.Millisecond))
+	_, _ = scheduler.AddTask(ctx, "test2", &RTaskElement{CallbackURL: callbackURL}, time.Now().Add(50*time.Millisecond))
+	_ = scheduler.RemoveTask(ctx, "test2", time.Now().Add(50*time.Millisecond))
+
+	<-time.After(100 * time.Millisecond)
+	scheduler.Stop()
+	if len(executor.tasks) != 1 || executor.tasks[0].Key != "test1" {
+		t.Errorf("got tasks: %+v", executor.tasks)
+	}
+
+	// 执行失败时与 RTimeWheel 一样交给失败回调处理
+	var failed []string
+	failing := NewLocalScheduler(NewTimeWheel(10, 10*time.Millisecond), &testExecutor{err: errors.New("boom")},
+		WithFailureHook(func(ctx context.Context, task *RTaskElement, err error) {
+			failed = append(failed, task.Key+": "+err.Error())
+		}),
+	)
+	_, _ = failing.AddTask(ctx, "test3", &RTaskElement{CallbackURL: callbackURL}, time.Now())
+	<-time.After(50 * time.Millisecond)
+	failing.Stop()
+	if fmt.Sprint(failed) != "[test3: boom]" {
+		t.Errorf("got failed: %v", failed)
+	}
+}
+
+func Test_timeWheel_drain(t *testing.T) {
+	timeWheel := NewTimeWheel(10, 10*time.Millisecond)
+	var executed int32
+	start := time.Now()
+	for i, delay := range []time.Duration{30 * time.Millisecond, 150 * time.Millisecond} {
+		timeWheel.AddTask(fmt.Sprintf("drain%d", i), func() { atomic.AddInt32(&executed, 1) }, start.Add(delay))
+	}
+	timeWheel.AddTask("removed", func() { t.Errorf("removed task executed") }, start.Add(100*time.Millisecond))
+
+	go func() {
+		<-time.After(50 * time.Millisecond)
+		// Drain 期间删除依然生效，新任务不再接收
+		timeWheel.RemoveTask("removed")
+		timeWheel.AddTask("late", func() { t.Errorf("task added while draining executed") }, time.Now())
+	}()
+	<-time.After(20 * time.Millisecond)
+	if err := timeWheel.Drain(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&executed); n != 2 || time.Since(start) < 150*time.Millisecond {
+		t.Errorf("got executed: %d after %v", n, time.Since(start))
+	}
+
+	// ctx 到期时丢弃仍未到期的任务
+	farWheel := NewTimeWheel(10, 10*time.Millisecond)
+	farWheel.AddTask("far", func() { t.Errorf("far task executed") }, time.Now().Add(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := farWheel.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err: %v, want: %v", err, context.DeadlineExceeded)
+	}
+}
+
 const (
 	// redis 服务器信息
 	network  = "tcp"
@@ -41,15 +179,27 @@ var (
 	callbackHeader map[string]string
 )
 
+func newRedisClient(t *testing.T) *redis.Client {
+	redisClient, err := redis.NewClient(network, address, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return redisClient
+}
+
 func Test_redis_timeWheel(t *testing.T) {
 	rTimeWheel := NewRTimeWheel(
-		redis.NewClient(network, address, password),
+		newRedisClient(t),
 		thttp.NewClient(),
 	)
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
 	defer rTimeWheel.Stop()
 
 	ctx := context.Background()
-	if err := rTimeWheel.AddTask(ctx, "test1", &RTaskElement{
+	if _, err := rTimeWheel.AddTask(ctx, "test1", &RTaskElement{
 		CallbackURL: callbackURL,
 		Method:      callbackMethod,
 		Req:         callbackReq,
@@ -59,7 +209,7 @@ func Test_redis_timeWheel(t *testing.T) {
 		return
 	}
 
-	if err := rTimeWheel.AddTask(ctx, "test2", &RTaskElement{
+	if _, err := rTimeWheel.AddTask(ctx, "test2", &RTaskElement{
 		CallbackURL: callbackURL,
 		Method:      callbackMethod,
 		Req:         callbackReq,
@@ -77,3 +227,3327 @@ func Test_redis_timeWheel(t *testing.T) {
 	<-time.After(5 * time.Second)
 	t.Log("ok")
 }
+
+func Test_redis_timeWheel_restart(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(
+		newRedisClient(t),
+		thttp.NewClient(),
+	)
+	defer rTimeWheel.Stop()
+
+	for i := 0; i < 3; i++ {
+		if err := rTimeWheel.Start(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := rTimeWheel.Start(); err == nil {
+			t.Error("double start, expect error")
+			return
+		}
+		rTimeWheel.Stop()
+	}
+}
+
+func Test_redis_timeWheel_batchTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		batchTimeout time.Duration
+		wantErr      bool
+	}{
+		{batchTimeout: 100 * time.Millisecond, wantErr: true},
+		{batchTimeout: 2 * time.Second, wantErr: false},
+	}
+	for _, c := range cases {
+		var failed error
+		rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(),
+			WithBatchTimeout(c.batchTimeout),
+			WithFailureHook(func(ctx context.Context, task *RTaskElement, err error) {
+				failed = err
+			}),
+		)
+
+		tctx, cancel := rTimeWheel.newBatchContext()
+		rTimeWheel.executeBatch(tctx, []*RTaskElement{{Key: "test", CallbackURL: server.URL, Method: http.MethodPost}})
+		cancel()
+
+		if c.wantErr != (failed != nil) {
+			t.Errorf("batch timeout: %v, got err: %v", c.batchTimeout, failed)
+		}
+		if c.wantErr && !errors.Is(failed, context.DeadlineExceeded) {
+			t.Errorf("batch timeout: %v, got err: %v, want: %v", c.batchTimeout, failed, context.DeadlineExceeded)
+		}
+	}
+}
+
+type testExecutionHooks struct {
+	mu        sync.Mutex
+	successes []string
+	failures  []string
+	reports   []ScanReport
+}
+
+func (h *testExecutionHooks) OnSuccess(ctx context.Context, task *RTaskElement, latency time.Duration) {
+	h.mu.Lock()
+	h.successes = append(h.successes, task.Key)
+	h.mu.Unlock()
+	panic("hook panic")
+}
+
+func (h *testExecutionHooks) OnFailure(ctx context.Context, task *RTaskElement, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = append(h.failures, task.Key)
+}
+
+func (h *testExecutionHooks) OnScanError(ctx context.Context, err error) {}
+
+func (h *testExecutionHooks) OnScanComplete(report ScanReport) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reports = append(h.reports, report)
+}
+
+func Test_redis_timeWheel_executionHooks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	hooks := testExecutionHooks{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithExecutionHooks(&hooks))
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	// OnSuccess 中的 panic 会被恢复，不影响批次的执行
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_success", CallbackURL: server.URL + "/ok", Method: http.MethodPost},
+		{Key: "test_failure", CallbackURL: server.URL + "/fail", Method: http.MethodPost},
+	})
+
+	if len(hooks.successes) != 1 || hooks.successes[0] != "test_success" {
+		t.Errorf("got successes: %v", hooks.successes)
+	}
+	if len(hooks.failures) != 1 || hooks.failures[0] != "test_failure" {
+		t.Errorf("got failures: %v", hooks.failures)
+	}
+}
+
+func Test_redis_timeWheel_logger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(),
+		WithLogger(NewStdLogger(log.New(&buf, "", 0))),
+		WithExecutionHooks(&testExecutionHooks{}),
+	)
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{{Key: "test_logger", CallbackURL: server.URL, Method: http.MethodPost}})
+	rTimeWheel.onSuccess(tctx, &RTaskElement{Key: "test_logger"}, 0)
+
+	for _, want := range []string{"WARN execute task failed", "key=test_logger", "callback_url=" + server.URL, "ERROR panic recovered", "stack="} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log output missing %q, got: %s", want, buf.String())
+		}
+	}
+}
+
+type testMetrics struct {
+	noopMetrics
+	mu                 sync.Mutex
+	executed, failed   int
+	latencies          int
+	inflightExecutions int
+}
+
+func (m *testMetrics) IncTasksExecuted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.executed++
+}
+
+func (m *testMetrics) IncTasksFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+}
+
+func (m *testMetrics) AddInflightExecutions(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inflightExecutions += delta
+}
+
+func (m *testMetrics) ObserveCallbackLatency(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies++
+}
+
+func Test_redis_timeWheel_metrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	metrics := testMetrics{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMetrics(&metrics))
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_ok_1", CallbackURL: server.URL + "/ok", Method: http.MethodPost},
+		{Key: "test_ok_2", CallbackURL: server.URL + "/ok", Method: http.MethodPost},
+		{Key: "test_fail", CallbackURL: server.URL + "/fail", Method: http.MethodPost},
+	})
+
+	if metrics.executed != 2 || metrics.failed != 1 || metrics.latencies != 3 || metrics.inflightExecutions != 0 {
+		t.Errorf("got executed: %d, failed: %d, latencies: %d, inflight: %d",
+			metrics.executed, metrics.failed, metrics.latencies, metrics.inflightExecutions)
+	}
+}
+
+type testTraceKey struct{}
+
+type testTracing struct {
+	started []string
+	ended   []error
+}
+
+func (tr *testTracing) Inject(ctx context.Context, carrier map[string]string) {
+	if span, ok := ctx.Value(testTraceKey{}).(string); ok {
+		carrier["traceparent"] = span
+	}
+}
+
+func (tr *testTracing) StartExecute(ctx context.Context, task *RTaskElement) (context.Context, func(err error)) {
+	tr.started = append(tr.started, task.TraceCarrier["traceparent"])
+	return context.WithValue(ctx, testTraceKey{}, "execute-"+task.Key), func(err error) {
+		tr.ended = append(tr.ended, err)
+	}
+}
+
+func Test_redis_timeWheel_tracing(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	tracing := testTracing{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithTracing(&tracing))
+
+	// 添加任务时记录链路信息
+	task := RTaskElement{Key: "test_tracing", CallbackURL: server.URL, Method: http.MethodPost}
+	rTimeWheel.injectTrace(context.WithValue(context.Background(), testTraceKey{}, "add-test_tracing"), &task)
+	if task.TraceCarrier["traceparent"] != "add-test_tracing" {
+		t.Errorf("got trace carrier: %v", task.TraceCarrier)
+	}
+
+	// 执行任务时以添加任务的链路为父链路，并透传执行 span 的链路信息
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(tracing.started) != 1 || tracing.started[0] != "add-test_tracing" {
+		t.Errorf("got started spans: %v", tracing.started)
+	}
+	if len(tracing.ended) != 1 || tracing.ended[0] != nil {
+		t.Errorf("got ended spans: %v", tracing.ended)
+	}
+	if traceparent != "execute-test_tracing" {
+		t.Errorf("got traceparent: %s, want: execute-test_tracing", traceparent)
+	}
+}
+
+func Test_redis_timeWheel_panicHandler(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		recovered []string
+	)
+	// http client 为 nil，执行任务时会发生 panic. handler 自身的 panic 同样不会影响批次的执行
+	rTimeWheel := NewRTimeWheel(nil, nil, WithPanicHandler(func(r interface{}, stack []byte, task *RTaskElement) {
+		mu.Lock()
+		recovered = append(recovered, task.Key)
+		mu.Unlock()
+		if len(stack) == 0 {
+			t.Error("empty stack")
+		}
+		panic("handler panic")
+	}))
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_panic", CallbackURL: "http://127.0.0.1", Method: http.MethodPost},
+	})
+
+	if len(recovered) != 1 || recovered[0] != "test_panic" {
+		t.Errorf("got recovered: %v", recovered)
+	}
+}
+
+func Test_redis_timeWheel_workerPool(t *testing.T) {
+	var recovered int32
+	// 只有一个 worker，任务的 panic 不会导致 worker 退出
+	rTimeWheel := NewRTimeWheel(nil, nil, WithMaxConcurrency(1), WithPanicHandler(func(r interface{}, stack []byte, task *RTaskElement) {
+		atomic.AddInt32(&recovered, 1)
+	}))
+	stopPool := rTimeWheel.startWorkerPool()
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	outcomes := rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_pool_1", CallbackURL: "http://127.0.0.1", Method: http.MethodPost},
+		{Key: "test_pool_2", CallbackURL: "http://127.0.0.1", Method: http.MethodPost},
+		{Key: "test_pool_3", CallbackURL: "http://127.0.0.1", Method: http.MethodPost},
+	})
+	if got := atomic.LoadInt32(&recovered); got != 3 || outcomes.failed != 3 {
+		t.Errorf("got recovered: %d, outcomes: %+v", got, outcomes)
+	}
+
+	// 协程池销毁之后退化为单独的协程
+	stopPool()
+	if rTimeWheel.workerPool() != nil {
+		t.Error("pool should be released after stop")
+	}
+	outcomes = rTimeWheel.executeBatch(tctx, []*RTaskElement{{Key: "test_pool_4", CallbackURL: "http://127.0.0.1", Method: http.MethodPost}})
+	if got := atomic.LoadInt32(&recovered); got != 4 || outcomes.failed != 1 {
+		t.Errorf("got recovered: %d, outcomes: %+v", got, outcomes)
+	}
+}
+
+// 每个 tick 执行 10k 个空任务，对比为每个任务创建协程与复用协程池的分配以及耗时
+func Benchmark_redis_timeWheel_executeBatch(b *testing.B) {
+	for _, pooled := range []bool{false, true} {
+		name := "goroutine"
+		if pooled {
+			name = "pool"
+		}
+		b.Run(name, func(b *testing.B) {
+			rTimeWheel := NewRTimeWheel(nil, nil, WithMaxConcurrency(runtime.GOMAXPROCS(0)))
+			rTimeWheel.RegisterHandler("noop", func(ctx context.Context, payload json.RawMessage) error {
+				return nil
+			})
+			if pooled {
+				defer rTimeWheel.startWorkerPool()()
+			}
+			tasks := make([]*RTaskElement, 10000)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for j := range tasks {
+					tasks[j] = &RTaskElement{Key: "noop", HandlerName: "noop"}
+				}
+				tctx, cancel := rTimeWheel.newBatchContext()
+				rTimeWheel.executeBatch(tctx, tasks)
+				cancel()
+			}
+		})
+	}
+}
+
+func Test_redis_timeWheel_removeFarFuture(t *testing.T) {
+	redisClient := newRedisClient(t)
+	rTimeWheel := NewRTimeWheel(redisClient, thttp.NewClient())
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	executeAt := time.Now().Add(10 * time.Minute)
+	if _, err := rTimeWheel.AddTask(ctx, "test_far_future", &RTaskElement{
+		CallbackURL: callbackURL,
+		Method:      callbackMethod,
+	}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := rTimeWheel.RemoveTask(ctx, "test_far_future", executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 删除标识需要保留到任务被扫描之后
+	conn, err := redisClient.GetConn(ctx)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	ttl, err := conn.Do("TTL", rTimeWheel.getDeleteSetKey(executeAt))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if remain := time.Duration(ttl.(int64)) * time.Second; remain < time.Until(executeAt) {
+		t.Errorf("delete set expires in %v, before task executes in %v", remain, time.Until(executeAt))
+	}
+	if _, _, err := rTimeWheel.GetTask(ctx, "test_far_future"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+}
+
+func Test_redis_timeWheel_removeResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient())
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	task := RTaskElement{CallbackURL: server.URL, Method: http.MethodPost}
+	removedAt, executedAt := time.Now().Add(time.Minute), time.Now().Add(2*time.Second)
+	for key, executeAt := range map[string]time.Time{"test_remove_removed": removedAt, "test_remove_executed": executedAt} {
+		task := task
+		if _, err := rTimeWheel.AddTask(ctx, key, &task, executeAt); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if err := rTimeWheel.RemoveTask(ctx, "test_remove_removed", removedAt); err != nil {
+		t.Error(err)
+	}
+	if err := rTimeWheel.RemoveTask(ctx, "test_remove_removed", removedAt); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("remove twice, got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+	if err := rTimeWheel.RemoveTask(ctx, "test_remove_unknown", removedAt); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("remove unknown, got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+
+	<-time.After(4 * time.Second)
+	if err := rTimeWheel.RemoveTask(ctx, "test_remove_executed", executedAt); !errors.Is(err, ErrTaskAlreadyExecuted) {
+		t.Errorf("remove executed, got err: %v, want: %v", err, ErrTaskAlreadyExecuted)
+	}
+}
+
+func Test_redis_timeWheel_removeTasks(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix(fmt.Sprintf("test_remove_tasks_%d", time.Now().UnixNano())))
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	executeAt := time.Now().Add(5 * time.Minute)
+	if _, err := rTimeWheel.AddTask(ctx, "test_remove_tasks_5000", &RTaskElement{
+		CallbackURL: callbackURL,
+		Method:      callbackMethod,
+	}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 同一个时间片内的大量唯一键拆分为多次 lua 调用，重复的唯一键只计入一次
+	items := make([]KeyWithTime, 0, 10001)
+	for i := 0; i < 10000; i++ {
+		items = append(items, KeyWithTime{Key: fmt.Sprintf("test_remove_tasks_%d", i), ExecuteAt: executeAt})
+	}
+	items = append(items, items[0])
+	if removed, err := rTimeWheel.RemoveTasks(ctx, items); err != nil || removed != 10000 {
+		t.Errorf("got removed: %d, err: %v", removed, err)
+	}
+	if removed, err := rTimeWheel.RemoveTasks(ctx, items); err != nil || removed != 0 {
+		t.Errorf("remove twice, got removed: %d, err: %v", removed, err)
+	}
+	if _, _, err := rTimeWheel.GetTask(ctx, "test_remove_tasks_5000"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+}
+
+func Test_redis_timeWheel_removeScoreMiss(t *testing.T) {
+	ctx := context.Background()
+	store := NewRedisTaskStore(newRedisClient(t), WithKeyPrefix("remove_scan_timewheel"))
+	slice := fmt.Sprintf("test_remove_scan_%d", time.Now().UnixNano())
+	score := time.Now().Add(time.Minute).Unix()
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("test_remove_scan_%d", i)
+		body, _ := json.Marshal(&RTaskElement{Key: key})
+		if err := store.Add(ctx, slice, score, body, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// score 未命中时不遍历时间片，直接返回，但删除标识依然生效
+	if err := store.MarkDeleted(ctx, slice, "test_remove_scan_2", score+1); !errors.Is(err, ErrTaskAlreadyExecuted) {
+		t.Errorf("score miss, got err: %v, want: %v", err, ErrTaskAlreadyExecuted)
+	}
+	if err := store.MarkDeleted(ctx, slice, "test_remove_scan_1", score); err != nil {
+		t.Errorf("score hit, got err: %v", err)
+	}
+	if err := store.MarkDeleted(ctx, slice, "test_remove_scan_unknown", score); !errors.Is(err, ErrTaskAlreadyExecuted) {
+		t.Errorf("unknown, got err: %v, want: %v", err, ErrTaskAlreadyExecuted)
+	}
+	tasks, err := store.FetchDue(ctx, slice, score, score+1)
+	if err != nil || len(tasks) != 3 {
+		t.Fatalf("got tasks: %+v, err: %v", tasks, err)
+	}
+	for _, task := range tasks {
+		if want := task.Key != "test_remove_scan_0"; task.Deleted != want {
+			t.Errorf("key: %s, got deleted: %v, want: %v", task.Key, task.Deleted, want)
+		}
+	}
+}
+
+// 返回 redis 服务端当前的客户端连接数量，不包括查询使用的连接
+func redisClientCount(t *testing.T, redisClient *redis.Client) int {
+	conn, err := redisClient.GetConn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reply, err := conn.Do("CLIENT", "LIST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(strings.TrimSpace(fmt.Sprintf("%s", reply)), "\n")
+}
+
+func Test_redis_timeWheel_ownedClient(t *testing.T) {
+	monitor := newRedisClient(t)
+	defer monitor.Close()
+	baseline := redisClientCount(t, monitor)
+
+	for _, shutdown := range []bool{false, true} {
+		redisClient := newRedisClient(t)
+		rTimeWheel := NewRTimeWheel(redisClient, thttp.NewClient(), WithOwnedClient())
+		if err := rTimeWheel.Start(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rTimeWheel.AddTask(context.Background(), "test_owned_client", &RTaskElement{
+			CallbackURL: callbackURL,
+			Method:      callbackMethod,
+		}, time.Now().Add(time.Minute)); err != nil {
+			t.Error(err)
+		}
+		if redisClientCount(t, monitor) <= baseline {
+			t.Error("time wheel holds no redis connection")
+		}
+
+		if shutdown {
+			if err := rTimeWheel.Shutdown(context.Background()); err != nil {
+				t.Error(err)
+			}
+		} else {
+			rTimeWheel.Stop()
+			<-rTimeWheel.Done()
+		}
+		// Stop 之后异步关闭客户端，服务端的连接数量需要等待连接关闭之后才能回落
+		deadline := time.Now().Add(3 * time.Second)
+		for redisClientCount(t, monitor) > baseline && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if got := redisClientCount(t, monitor); got != baseline {
+			t.Errorf("shutdown: %v, got client count: %d, want: %d", shutdown, got, baseline)
+		}
+
+		if err := redisClient.Close(); err != nil {
+			t.Errorf("close twice, got err: %v", err)
+		}
+		if err := rTimeWheel.Start(); !errors.Is(err, redis.ErrClosed) {
+			t.Errorf("restart, got err: %v, want: %v", err, redis.ErrClosed)
+		}
+	}
+}
+
+func Test_redis_timeWheel_events(t *testing.T) {
+	const channel = "timewheel_test_events"
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithEventChannel(channel))
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	// 订阅方：解码事件并转交给业务逻辑
+	ctx := context.Background()
+	consumer := newRedisClient(t)
+	defer consumer.Close()
+	events := make(chan TaskEvent, 4)
+	sub, err := consumer.Subscribe(ctx, channel, func(msg *redis.Message) {
+		var event TaskEvent
+		if err := json.Unmarshal(msg.Payload, &event); err != nil {
+			t.Error(err)
+			return
+		}
+		events <- event
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer sub.Close()
+
+	executeAt := time.Now().Add(time.Minute)
+	if _, err := rTimeWheel.AddTask(ctx, "test_events", &RTaskElement{
+		CallbackURL: callbackURL,
+		Method:      callbackMethod,
+	}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := rTimeWheel.RemoveTask(ctx, "test_events", executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, action := range []string{TaskEventAdded, TaskEventRemoved} {
+		select {
+		case event := <-events:
+			if want := (TaskEvent{Key: "test_events", Action: action, ExecuteAt: executeAt.Unix()}); event != want {
+				t.Errorf("got event: %+v, want: %+v", event, want)
+			}
+		case <-time.After(3 * time.Second):
+			t.Errorf("event %s not received", action)
+		}
+	}
+}
+
+func Test_redis_timeWheel_wakeScanner(t *testing.T) {
+	fired := make(chan time.Time, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fired <- time.Now()
+	}))
+	defer server.Close()
+
+	// tick 间隔较长时，执行时刻为当前时刻的任务不需要等待下一次 tick
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithTickInterval(30*time.Second))
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+	// 等待第一次 tick 确定扫描的起点
+	<-time.After(31 * time.Second)
+
+	addedAt := time.Now()
+	if _, err := rTimeWheel.AddTask(context.Background(), "test_wake_scanner", &RTaskElement{
+		CallbackURL: server.URL,
+		Method:      http.MethodPost,
+	}, addedAt); err != nil {
+		t.Error(err)
+		return
+	}
+	select {
+	case firedAt := <-fired:
+		if latency := firedAt.Sub(addedAt); latency > 3*time.Second {
+			t.Errorf("got latency: %v", latency)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("task not fired before next tick")
+	}
+}
+
+func Test_redis_timeWheel_recurring(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	// 每一次执行分别位于不同的时间片中
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(),
+		WithKeyPrefix(fmt.Sprintf("test_recurring_%d", time.Now().UnixNano())), WithSliceGranularity(time.Second))
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	firstExecuteAt := time.Now().Add(time.Second)
+	if _, err := rTimeWheel.AddTask(ctx, "test_recurring", &RTaskElement{
+		CallbackURL: server.URL,
+		Method:      http.MethodPost,
+		Interval:    3 * time.Second,
+	}, firstExecuteAt); err != nil {
+		t.Error(err)
+		return
+	}
+	<-time.After(5500 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("got calls: %d, want 2", n)
+	}
+
+	// 以首次执行时刻删除，同样终止整个周期
+	if err := rTimeWheel.RemoveTask(ctx, "test_recurring", firstExecuteAt); err != nil {
+		t.Error(err)
+	}
+	<-time.After(4 * time.Second)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("removed series, got calls: %d, want 2", n)
+	}
+	if _, _, err := rTimeWheel.GetTask(ctx, "test_recurring"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+}
+
+func Test_redis_timeWheel_recurringCrash(t *testing.T) {
+	redisClient := newRedisClient(t)
+	rTimeWheel := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix(fmt.Sprintf("test_recurring_crash_%d", time.Now().UnixNano())))
+
+	ctx := context.Background()
+	executeAt := time.Now().Add(2 * time.Minute).Truncate(time.Second)
+	if _, err := rTimeWheel.AddTask(ctx, "test_recurring_crash", &RTaskElement{
+		CallbackURL:   callbackURL,
+		Method:        callbackMethod,
+		Interval:      time.Minute,
+		JitterSeconds: 10,
+	}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	_, jitteredAt, err := rTimeWheel.GetTask(ctx, "test_recurring_crash")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 多个实例同时检索到任务时，写入的下一次执行完全相同
+	sliceStr := rTimeWheel.getTaskSliceStr("test_recurring_crash", jitteredAt)
+	from, to := jitteredAt.Unix(), jitteredAt.Unix()+1
+	reply, err := redisClient.Eval(ctx, LuaPeekTasks, 2, []interface{}{
+		sliceTaskKey(rTimeWheel.opts.keyPrefix, sliceStr), sliceDeleteSetKey(rTimeWheel.opts.keyPrefix, sliceStr), from, to, DefaultFetchBatchSize,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	peeked, err := parseFetchReply(reply)
+	if err != nil || len(peeked) != 1 {
+		t.Errorf("got peeked: %+v, err: %v", peeked, err)
+		return
+	}
+	rTimeWheel.scheduleNextOccurrences(ctx, peeked)
+
+	// 认领之后、执行之前宕机，下一次执行已经写入
+	claimed, _, err := rTimeWheel.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize)
+	if err != nil || len(claimed) != 1 || claimed[0].task == nil || !claimed[0].task.nextScheduled {
+		t.Errorf("got claimed: %+v, err: %v", claimed, err)
+		return
+	}
+	_, nextAt, err := rTimeWheel.GetTask(ctx, "test_recurring_crash")
+	if err != nil || nextAt.Before(executeAt.Add(time.Minute)) || nextAt.After(executeAt.Add(time.Minute+10*time.Second)) {
+		t.Errorf("got next execute at: %v, err: %v", nextAt, err)
+	}
+	if n, err := redisClient.ZCard(ctx, rTimeWheel.getMinuteSlice(nextAt)); err != nil || n != 1 {
+		t.Errorf("got next occurrences: %d, err: %v, want 1", n, err)
+	}
+	_ = rTimeWheel.RemoveTask(ctx, "test_recurring_crash", nextAt)
+}
+
+func Test_redis_timeWheel_catchUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	prefix := fmt.Sprintf("test_catch_up_%d", time.Now().UnixNano())
+	redisClient := newRedisClient(t)
+	rTimeWheel := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix(prefix), WithLookback(5*time.Minute))
+	defer rTimeWheel.Stop()
+
+	// 停机期间到期的任务滞留在已经错过的时间片中
+	ctx := context.Background()
+	store := NewRedisTaskStore(redisClient, WithKeyPrefix(prefix))
+	for key, executeAt := range map[string]time.Time{
+		"test_catch_up_missed":  time.Now().Add(-2 * time.Minute),
+		"test_catch_up_expired": time.Now().Add(-10 * time.Minute),
+	} {
+		body, _ := json.Marshal(&RTaskElement{Key: key, CallbackURL: server.URL, Method: http.MethodPost})
+		if err := store.Add(ctx, rTimeWheel.getTaskSliceStr(key, executeAt), executeAt.Unix(), body, key); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	// 只补偿 lookback 范围内的任务，并且同一个任务只会补偿一次
+	rTimeWheel.catchUp()
+	rTimeWheel.catchUp()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got calls: %d, want 1", n)
+	}
+}
+
+func Test_redis_timeWheel_reschedule(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix(fmt.Sprintf("test_reschedule_%d", time.Now().UnixNano())))
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	if _, err := rTimeWheel.AddTask(ctx, "test_reschedule", &RTaskElement{
+		CallbackURL: server.URL,
+		Method:      http.MethodPost,
+	}, time.Now().Add(3*time.Minute)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 迁移到其他时间片中更早的执行时刻
+	newExecuteAt := time.Now().Add(2 * time.Second)
+	if err := rTimeWheel.RescheduleTask(ctx, "test_reschedule", newExecuteAt); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, executeAt, err := rTimeWheel.GetTask(ctx, "test_reschedule"); err != nil || executeAt.Unix() != newExecuteAt.Unix() {
+		t.Errorf("got execute at: %v, err: %v, want: %v", executeAt, err, newExecuteAt)
+	}
+	<-time.After(4 * time.Second)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got calls: %d, want 1", n)
+	}
+
+	for _, key := range []string{"test_reschedule", "test_reschedule_unknown"} {
+		if err := rTimeWheel.RescheduleTask(ctx, key, time.Now().Add(time.Minute)); !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("key: %s, got err: %v, want: %v", key, err, ErrTaskNotFound)
+		}
+	}
+}
+
+func Test_redis_timeWheel_removeByKey(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient())
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	ctx := context.Background()
+	if _, err := rTimeWheel.AddTask(ctx, "test_remove_by_key", &RTaskElement{
+		CallbackURL: callbackURL,
+		Method:      callbackMethod,
+	}, time.Now().Add(3*time.Minute)); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := rTimeWheel.RemoveTaskByKey(ctx, "test_remove_by_key"); err != nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err := rTimeWheel.GetTask(ctx, "test_remove_by_key"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+	if err := rTimeWheel.RemoveTaskByKey(ctx, "test_remove_by_key"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("remove twice, got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+}
+
+func Test_redis_timeWheel_keyPrefix(t *testing.T) {
+	redisClient := newRedisClient(t)
+	staging := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("staging_timewheel"))
+	prod := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("prod_timewheel"))
+
+	ctx := context.Background()
+	executeAt := time.Now().Add(3 * time.Minute)
+	if _, err := staging.AddTask(ctx, "test_key_prefix", &RTaskElement{
+		CallbackURL: callbackURL,
+		Method:      callbackMethod,
+	}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	defer staging.RemoveTaskByKey(ctx, "test_key_prefix")
+
+	// 使用不同前缀的时间轮无法看到、也无法删除对方的任务
+	if _, _, err := prod.GetTask(ctx, "test_key_prefix"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+	if err := prod.RemoveTask(ctx, "test_key_prefix", executeAt); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskNotFound)
+	}
+	if _, _, err := staging.GetTask(ctx, "test_key_prefix"); err != nil {
+		t.Error(err)
+	}
+
+	if err := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("bad_{prefix}")).Start(); err == nil {
+		t.Error("prefix with hash tag, expect error")
+	}
+}
+
+func Test_redis_timeWheel_stats(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(),
+		// 使用独立的前缀，避免统计到其他用例残留的任务
+		WithKeyPrefix(fmt.Sprintf("test_stats_%d", time.Now().UnixNano())),
+	)
+	ctx := context.Background()
+
+	base := time.Now().Add(5 * time.Minute)
+	for i, executeAt := range []time.Time{base, base.Add(time.Second), base.Add(2 * time.Minute)} {
+		if _, err := rTimeWheel.AddTask(ctx, fmt.Sprintf("test_stats_%d", i), &RTaskElement{
+			CallbackURL: callbackURL,
+			Method:      callbackMethod,
+		}, executeAt); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	defer rTimeWheel.RemoveTasks(ctx, []KeyWithTime{
+		{Key: "test_stats_0", ExecuteAt: base},
+		{Key: "test_stats_1", ExecuteAt: base.Add(time.Second)},
+		{Key: "test_stats_2", ExecuteAt: base.Add(2 * time.Minute)},
+	})
+
+	stats, err := rTimeWheel.Stats(ctx, 10*time.Minute)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if stats.TotalPending != 3 || len(stats.Slices) < 2 {
+		t.Errorf("got total pending: %d, slices: %v", stats.TotalPending, stats.Slices)
+	}
+	if stats.NearestAt.Unix() != base.Unix() {
+		t.Errorf("got nearest at: %v, want: %v", stats.NearestAt, base)
+	}
+
+	// 时间片超出 horizon 的任务不会被统计
+	if stats, err = rTimeWheel.Stats(ctx, time.Minute); err != nil || stats.TotalPending != 0 {
+		t.Errorf("got total pending: %d, err: %v", stats.TotalPending, err)
+	}
+}
+
+func Test_redis_timeWheel_history(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(),
+		WithKeyPrefix(fmt.Sprintf("test_history_%d", time.Now().UnixNano())),
+		WithHistory(1000),
+	)
+
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_history", CallbackURL: server.URL + "/ok", Method: http.MethodPost, scheduledAt: time.Now()},
+		{Key: "test_history_other", CallbackURL: server.URL + "/ok", Method: http.MethodPost, scheduledAt: time.Now()},
+	})
+	rTimeWheel.executeBatch(tctx, []*RTaskElement{
+		{Key: "test_history", CallbackURL: server.URL + "/fail", Method: http.MethodPost, scheduledAt: time.Now()},
+	})
+	// 执行记录异步写入
+	rTimeWheel.wg.Wait()
+
+	entries, err := rTimeWheel.QueryHistory(context.Background(), "test_history", 10)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 2 || entries[0].Status != HistoryStatusFail || entries[0].Error == "" || entries[1].Status != HistoryStatusOK {
+		t.Errorf("got entries: %v", entries)
+	}
+}
+
+func Test_redis_timeWheel_deleteSetExpire(t *testing.T) {
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	now := time.Now()
+	for _, delay := range []time.Duration{-time.Minute, 0, 10 * time.Minute, 7 * 24 * time.Hour} {
+		executeAt := now.Add(delay)
+		expire := time.Duration(rTimeWheel.getDeleteSetExpireSeconds(now, executeAt)) * time.Second
+		if now.Add(expire).Before(executeAt.Add(deleteSetSlack)) {
+			t.Errorf("delay: %v, delete set expires in %v", delay, expire)
+		}
+	}
+}
+
+func Test_redis_timeWheel_scanWindow(t *testing.T) {
+	for _, tickInterval := range []time.Duration{500 * time.Millisecond, 5 * time.Second, 15 * time.Second} {
+		opts := RTimeWheelOptions{tickInterval: tickInterval}
+		repairRTimeWheel(&opts)
+		rTimeWheel := RTimeWheel{opts: &opts}
+
+		// 从 12:00:50.3 开始连续 tick，跨越一次分钟级时间片的切换
+		now := time.Date(2023, 1, 1, 12, 0, 50, 300*int(time.Millisecond), time.Local)
+		_, lastEnd := rTimeWheel.getScanWindow(now.Add(-tickInterval))
+		scanned := make(map[int64]int)
+		for tick := 0; tick < int(30*time.Second/tickInterval); tick++ {
+			start, end := rTimeWheel.getScanWindow(now)
+			if !start.Equal(lastEnd) {
+				t.Errorf("tick interval: %v, window [%v, %v) not adjacent to last end %v", tickInterval, start, end, lastEnd)
+			}
+			if rTimeWheel.getMinuteSlice(start) != rTimeWheel.getMinuteSlice(end.Add(-time.Nanosecond)) {
+				t.Errorf("tick interval: %v, window [%v, %v) crosses minute slice", tickInterval, start, end)
+			}
+			for sec := start.Truncate(time.Second); sec.Before(end); sec = sec.Add(time.Second) {
+				if !sec.Before(start) {
+					scanned[sec.Unix()]++
+				}
+			}
+			lastEnd = end
+			now = now.Add(tickInterval)
+		}
+
+		for sec, cnt := range scanned {
+			if cnt != 1 {
+				t.Errorf("tick interval: %v, second %v scanned %d times", tickInterval, time.Unix(sec, 0), cnt)
+			}
+		}
+		if len(scanned) != 30 {
+			t.Errorf("tick interval: %v, got %d seconds scanned, want 30", tickInterval, len(scanned))
+		}
+	}
+}
+
+func Test_redis_timeWheel_sliceGranularity(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 59, 58, 0, time.Local)
+	cases := []struct {
+		granularity time.Duration
+		wantSlice   string
+		wantSlices  int
+	}{
+		{granularity: time.Second, wantSlice: "2023-01-01-12:59:58", wantSlices: 5},
+		{granularity: time.Minute, wantSlice: "2023-01-01-12:59", wantSlices: 2},
+		{granularity: 5 * time.Minute, wantSlice: "2023-01-01-12:55", wantSlices: 2},
+		{granularity: time.Hour, wantSlice: "2023-01-01-12:00", wantSlices: 2},
+	}
+	for _, c := range cases {
+		opts := RTimeWheelOptions{sliceGranularity: c.granularity, tickInterval: 5 * time.Second}
+		repairRTimeWheel(&opts)
+		rTimeWheel := RTimeWheel{opts: &opts}
+
+		if got := rTimeWheel.getSliceStr(now); got != c.wantSlice {
+			t.Errorf("granularity: %v, got slice: %s, want: %s", c.granularity, got, c.wantSlice)
+		}
+		// 扫描窗口 [12:59:58, 13:00:03) 跨越时间片的边界
+		slices := rTimeWheel.getSlices(now, now.Add(opts.tickInterval))
+		if len(slices) != c.wantSlices {
+			t.Errorf("granularity: %v, got %d slices, want: %d", c.granularity, len(slices), c.wantSlices)
+		}
+		for _, slice := range slices {
+			parsed, err := rTimeWheel.parseSliceStr(rTimeWheel.getSliceStr(slice))
+			if err != nil || !parsed.Equal(slice) {
+				t.Errorf("granularity: %v, parse slice %v, got: %v, err: %v", c.granularity, slice, parsed, err)
+			}
+		}
+	}
+
+	// 使用相同前缀的时间轮不允许采用不同的粒度
+	redisClient := newRedisClient(t)
+	minute := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("test_granularity"))
+	if err := minute.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer minute.Stop()
+	hour := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("test_granularity"), WithSliceGranularity(time.Hour))
+	if err := hour.Start(); err == nil {
+		hour.Stop()
+		t.Error("mixed slice granularity, expect error")
+	}
+}
+
+func Test_redis_timeWheel_jitter(t *testing.T) {
+	opts := RTimeWheelOptions{jitter: 9 * time.Second}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	// 12:00:55 叠加 [0, 9] 秒的抖动，一部分任务会落在下一个分钟级时间片中
+	executeAt := time.Date(2023, 1, 1, 12, 0, 55, 0, time.Local)
+	const samples = 10000
+	buckets := make(map[int]int)
+	crossed := 0
+	for i := 0; i < samples; i++ {
+		task := RTaskElement{}
+		jitteredAt := rTimeWheel.applyJitter(&task, executeAt)
+		offset := int(jitteredAt.Sub(executeAt) / time.Second)
+		if offset < 0 || offset > 9 || offset != task.JitterOffset {
+			t.Errorf("got offset: %d, jitter offset: %d", offset, task.JitterOffset)
+			return
+		}
+		task.scheduledAt = jitteredAt
+		if !task.unjitteredAt().Equal(executeAt) {
+			t.Errorf("got unjittered at: %v, want: %v", task.unjitteredAt(), executeAt)
+		}
+		if rTimeWheel.getMinuteSlice(jitteredAt) != rTimeWheel.getMinuteSlice(executeAt) {
+			crossed++
+		}
+		buckets[offset]++
+	}
+
+	// 每个桶的期望数量为 samples/10，允许 15% 的偏差
+	for offset := 0; offset <= 9; offset++ {
+		if cnt := buckets[offset]; cnt < samples/10*85/100 || cnt > samples/10*115/100 {
+			t.Errorf("offset: %d, got %d samples, want about %d", offset, cnt, samples/10)
+		}
+	}
+	if crossed != buckets[5]+buckets[6]+buckets[7]+buckets[8]+buckets[9] {
+		t.Errorf("got %d tasks in next slice", crossed)
+	}
+
+	// 任务自身的设置优先于时间轮的默认值
+	task := RTaskElement{JitterSeconds: 1}
+	for i := 0; i < 100; i++ {
+		if jitteredAt := rTimeWheel.applyJitter(&task, executeAt); jitteredAt.Sub(executeAt) > time.Second {
+			t.Errorf("got jitter: %v, want at most 1s", jitteredAt.Sub(executeAt))
+		}
+	}
+}
+
+func Test_redis_timeWheel_resolveExecuteAt(t *testing.T) {
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	// 距离当前扫描窗口关闭还有 1 ms
+	now := time.Date(2023, 1, 1, 12, 0, 59, 999*int(time.Millisecond), time.Local)
+	_, windowEnd := rTimeWheel.getScanWindow(now)
+
+	executeAt, err := rTimeWheel.resolveExecuteAt(now, now)
+	if err != nil || !executeAt.Equal(windowEnd) {
+		t.Errorf("same second, got: %v, %v, want: %v", executeAt, err, windowEnd)
+	}
+
+	if executeAt, err = rTimeWheel.resolveExecuteAt(now, now.Add(time.Minute)); err != nil || !executeAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("future, got: %v, %v", executeAt, err)
+	}
+
+	if _, err = rTimeWheel.resolveExecuteAt(now, now.Add(-time.Second)); !errors.Is(err, ErrExecuteAtInPast) {
+		t.Errorf("past, got err: %v, want: %v", err, ErrExecuteAtInPast)
+	}
+
+	WithExecutePastImmediately()(&opts)
+	if executeAt, err = rTimeWheel.resolveExecuteAt(now, now.Add(-time.Second)); err != nil || !executeAt.Equal(windowEnd) {
+		t.Errorf("past immediately, got: %v, %v, want: %v", executeAt, err, windowEnd)
+	}
+
+	// 亚秒级的扫描间隔下按照 score 比较，score 已经扫描过的执行时刻调整到下一个整秒
+	subSecond := RTimeWheel{opts: &RTimeWheelOptions{tickInterval: 500 * time.Millisecond}}
+	base := time.Unix(1700000005, 0)
+	cases := []struct {
+		now, executeAt, want time.Time
+	}{
+		// 窗口 [5.0s, 5.5s) 扫描 score 5，5.8s 的 score 同样为 5
+		{base.Add(200 * time.Millisecond), base.Add(800 * time.Millisecond), base.Add(time.Second)},
+		// 窗口 [5.5s, 6.0s) 不包含任何 score
+		{base.Add(700 * time.Millisecond), base.Add(800 * time.Millisecond), base.Add(time.Second)},
+		{base.Add(200 * time.Millisecond), base.Add(1300 * time.Millisecond), base.Add(1300 * time.Millisecond)},
+	}
+	for _, c := range cases {
+		if executeAt, err := subSecond.resolveExecuteAt(c.now, c.executeAt); err != nil || !executeAt.Equal(c.want) {
+			t.Errorf("now: %v, execute at: %v, got: %v, %v, want: %v", c.now, c.executeAt, executeAt, err, c.want)
+		}
+	}
+
+	// 内部重新写入的任务同样不能落在当前窗口内
+	longOpts := RTimeWheelOptions{tickInterval: 30 * time.Second}
+	repairRTimeWheel(&longOpts)
+	long := RTimeWheel{opts: &longOpts}
+	if executeAt := long.notBeforeNextWindow(base, base.Add(time.Second)); !executeAt.Equal(time.Unix(1700000010, 0)) {
+		t.Errorf("retry within window, got: %v", executeAt)
+	}
+	if err := long.addTaskPrecheck(&RTaskElement{Key: "interval", CallbackURL: "http://localhost", Method: http.MethodPost, Interval: 10 * time.Second}); err == nil {
+		t.Error("interval shorter than tick interval should be rejected")
+	}
+	if err := long.addTaskPrecheck(&RTaskElement{Key: "interval", CallbackURL: "http://localhost", Method: http.MethodPost, Interval: 30 * time.Second}); err != nil {
+		t.Errorf("interval equal to tick interval, got err: %v", err)
+	}
+}
+
+func Test_redis_timeWheel_subSecondTick(t *testing.T) {
+	var fired int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fired, 1)
+	}))
+	defer server.Close()
+
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithTickInterval(500*time.Millisecond))
+	defer rTimeWheel.Stop()
+
+	// 窗口 [x.0s, x.5s) 已经扫描过 score x，此时添加执行时刻为 x.8s 的任务，
+	// 之后的窗口 [x.5s, x+1s) 不包含任何 score，任务的 score 需要调整到 x+1
+	base := time.Now().Add(time.Minute).Truncate(time.Minute)
+	if _, err := rTimeWheel.addTaskWithKey(context.Background(), "test_sub_second_tick", &RTaskElement{
+		CallbackURL: server.URL,
+		Method:      http.MethodPost,
+	}, base.Add(200*time.Millisecond), base.Add(800*time.Millisecond), false); err != nil {
+		t.Error(err)
+		return
+	}
+	for start := base.Add(500 * time.Millisecond); start.Before(base.Add(2 * time.Second)); start = start.Add(500 * time.Millisecond) {
+		rTimeWheel.executeTasks(start, start.Add(500*time.Millisecond))
+	}
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Errorf("task added into the current window fired %d times, want 1", n)
+	}
+}
+
+func Test_redis_timeWheel_retry(t *testing.T) {
+	// 退避时长逐次翻倍，不超过上限
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMaxBackoff(10*time.Second))
+	for attempt, want := range []time.Duration{2 * time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if got := rTimeWheel.backoff(&RTaskElement{BackoffBase: 2 * time.Second, Attempt: attempt}); got != want {
+			t.Errorf("attempt: %d, got backoff: %v, want: %v", attempt, got, want)
+		}
+	}
+
+	// 前两次回调失败，之后的重试成功
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	var terminal int32
+	rTimeWheel = NewRTimeWheel(newRedisClient(t), thttp.NewClient(),
+		WithKeyPrefix(fmt.Sprintf("test_retry_%d", time.Now().UnixNano())),
+		WithFailureHook(func(ctx context.Context, task *RTaskElement, err error) {
+			atomic.AddInt32(&terminal, 1)
+		}))
+	if err := rTimeWheel.Start(); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.Stop()
+
+	if _, err := rTimeWheel.AddTask(context.Background(), "test_retry", &RTaskElement{
+		CallbackURL: server.URL,
+		Method:      http.MethodPost,
+		MaxRetries:  3,
+		BackoffBase: time.Second,
+	}, time.Now().Add(time.Second)); err != nil {
+		t.Error(err)
+		return
+	}
+	<-time.After(7 * time.Second)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("got calls: %d, want 3", n)
+	}
+	if n := atomic.LoadInt32(&terminal); n != 0 {
+		t.Errorf("got terminal failures: %d, want 0", n)
+	}
+}
+
+func Test_redis_timeWheel_retryableStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var status int
+		fmt.Sscanf(r.URL.Query().Get("status"), "%d", &status)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer server.Close()
+
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+	httpClient := thttp.NewClient()
+
+	cases := []struct {
+		status    int
+		codes     []int
+		ok        bool
+		retryable bool
+	}{
+		{http.StatusOK, nil, true, false},
+		{http.StatusNoContent, nil, true, false},
+		{http.StatusBadRequest, nil, false, false},
+		{http.StatusTooManyRequests, nil, false, true},
+		{http.StatusInternalServerError, nil, false, true},
+		{http.StatusInternalServerError, []int{http.StatusConflict}, false, false},
+		{http.StatusConflict, []int{http.StatusConflict}, false, true},
+	}
+	for _, c := range cases {
+		err := httpClient.JSONDo(context.Background(), http.MethodPost, fmt.Sprintf("%s?status=%d", server.URL, c.status), nil, nil, nil)
+		if (err == nil) != c.ok {
+			t.Errorf("status: %d, got err: %v", c.status, err)
+			continue
+		}
+		if c.ok {
+			continue
+		}
+		var statusErr *thttp.StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != c.status || len(statusErr.Body) >= 1024 {
+			t.Errorf("status: %d, got err: %v", c.status, err)
+		}
+		task := RTaskElement{RetryableStatusCodes: c.codes}
+		if got := rTimeWheel.isRetryable(&task, fmt.Errorf("wrapped: %w", err)); got != c.retryable {
+			t.Errorf("status: %d, codes: %v, got retryable: %v, want: %v", c.status, c.codes, got, c.retryable)
+		}
+	}
+
+	// 未拿到响应的失败总是允许重试
+	if !rTimeWheel.isRetryable(&RTaskElement{RetryableStatusCodes: []int{http.StatusConflict}}, context.DeadlineExceeded) {
+		t.Errorf("deadline exceeded, got not retryable")
+	}
+
+	WithRetryableStatusCodes(http.StatusBadRequest)(&opts)
+	if !rTimeWheel.isRetryable(&RTaskElement{}, &thttp.StatusError{StatusCode: http.StatusBadRequest}) {
+		t.Errorf("global codes, got not retryable")
+	}
+}
+
+func Test_redis_timeWheel_webhookSigning(t *testing.T) {
+	newSecret, oldSecret := []byte("new-secret"), []byte("old-secret")
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		verified <- VerifySignature(r.Header.Get("X-Signature"), body, oldSecret, time.Minute)
+	}))
+	defer server.Close()
+
+	opts := RTimeWheelOptions{}
+	WithWebhookSigning(newSecret, "X-Signature", oldSecret)(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts, httpClient: thttp.NewClient()}
+
+	task := RTaskElement{Key: "signed", CallbackURL: server.URL, Method: http.MethodPost, Req: map[string]interface{}{"a": 1, "b": "x"}}
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := <-verified; err != nil {
+		t.Errorf("verify with old secret, got err: %v", err)
+	}
+
+	body := []byte(`{"a":1}`)
+	now := time.Now()
+	header := signWebhook(body, now, [][]byte{newSecret})
+	if err := VerifySignatureWithSecrets(header, body, [][]byte{oldSecret, newSecret}, time.Minute); err != nil {
+		t.Errorf("verify with rotated secrets, got err: %v", err)
+	}
+	if err := VerifySignature(header, []byte(`{"a":2}`), newSecret, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("tampered body, got err: %v, want: %v", err, ErrInvalidSignature)
+	}
+	if err := VerifySignature(header, body, oldSecret, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("wrong secret, got err: %v, want: %v", err, ErrInvalidSignature)
+	}
+	if err := VerifySignature(signWebhook(body, now.Add(-time.Hour), [][]byte{newSecret}), body, newSecret, time.Minute); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("replayed, got err: %v, want: %v", err, ErrSignatureExpired)
+	}
+	if err := VerifySignature("garbage", body, newSecret, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("malformed, got err: %v, want: %v", err, ErrInvalidSignature)
+	}
+}
+
+func Test_redis_timeWheel_defaultHeaders(t *testing.T) {
+	received := make(chan http.Header, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer server.Close()
+
+	var refreshed int
+	opts := RTimeWheelOptions{}
+	WithDefaultHeadersFunc(func(ctx context.Context) map[string]string {
+		refreshed++
+		return map[string]string{"authorization": fmt.Sprintf("token-%d", refreshed), "X-Service-Name": "svc"}
+	})(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts, httpClient: thttp.NewClient()}
+
+	task := RTaskElement{CallbackURL: server.URL, Method: http.MethodGet}
+	for i := 1; i <= 2; i++ {
+		if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+			t.Error(err)
+			return
+		}
+		header := <-received
+		if got := header.Values("Authorization"); len(got) != 1 || got[0] != fmt.Sprintf("token-%d", i) {
+			t.Errorf("execution %d, got authorization: %v", i, got)
+		}
+	}
+
+	task.Header = map[string]string{"x-service-name": "override"}
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+	if got := (<-received).Values("X-Service-Name"); len(got) != 1 || got[0] != "override" {
+		t.Errorf("got service name: %v, want [override]", got)
+	}
+}
+
+func Test_redis_timeWheel_metadataHeaders(t *testing.T) {
+	received := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header
+	}))
+	defer server.Close()
+
+	opts := RTimeWheelOptions{}
+	WithMetadataHeaders("X-Task-Key", "", "")(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts, httpClient: thttp.NewClient()}
+
+	scheduledAt := time.Now().Add(-3 * time.Second).Truncate(time.Second)
+	task := RTaskElement{Key: "meta", CallbackURL: server.URL, Method: http.MethodGet, scheduledAt: scheduledAt}
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+
+	header := <-received
+	if got := header.Get("X-Task-Key"); got != "meta" {
+		t.Errorf("got key: %s, want: meta", got)
+	}
+	if got, err := time.Parse(time.RFC3339, header.Get(DefaultScheduledAtHeader)); err != nil || !got.Equal(scheduledAt) {
+		t.Errorf("got scheduled at: %v, %v, want: %v", got, err, scheduledAt)
+	}
+	if got, err := time.Parse(time.RFC3339, header.Get(DefaultFiredAtHeader)); err != nil || got.Before(scheduledAt) {
+		t.Errorf("got fired at: %v, %v", got, err)
+	}
+}
+
+func Test_redis_timeWheel_bodyType(t *testing.T) {
+	type request struct {
+		contentType string
+		body        string
+	}
+	received := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{contentType: r.Header.Get("Content-Type"), body: string(body)}
+	}))
+	defer server.Close()
+
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts, httpClient: thttp.NewClient()}
+
+	cases := []struct {
+		task RTaskElement
+		want request
+	}{
+		{RTaskElement{Req: map[string]int{"a": 1}}, request{"application/json", `{"a":1}`}},
+		{RTaskElement{BodyType: BodyTypeForm, FormValues: map[string]string{"a": "1", "b": "x y"}}, request{"application/x-www-form-urlencoded", "a=1&b=x+y"}},
+		{RTaskElement{BodyType: BodyTypeRaw, RawBody: []byte("<a>1</a>"), Header: map[string]string{"Content-Type": "application/xml"}}, request{"application/xml", "<a>1</a>"}},
+		{RTaskElement{BodyType: BodyTypeRaw, RawBody: []byte("raw")}, request{"application/octet-stream", "raw"}},
+	}
+	for _, c := range cases {
+		c.task.CallbackURL, c.task.Method = server.URL, http.MethodPost
+		if err := checkBody(&c.task); err != nil {
+			t.Errorf("body type: %s, got err: %v", c.task.BodyType, err)
+			continue
+		}
+		if err := rTimeWheel.executeTask(context.Background(), &c.task); err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := <-received; got != c.want {
+			t.Errorf("body type: %s, got: %+v, want: %+v", c.task.BodyType, got, c.want)
+		}
+	}
+
+	for _, task := range []RTaskElement{
+		{Method: http.MethodGet, BodyType: BodyTypeRaw, RawBody: []byte("raw")},
+		{Method: http.MethodPost, BodyType: BodyTypeRaw, RawBody: []byte("raw"), Req: "req"},
+		{Method: http.MethodPost, RawBody: []byte("raw")},
+		{Method: http.MethodPost, BodyType: BodyTypeForm, FormValues: map[string]string{"a": "1"}, RawBody: []byte("raw")},
+		{Method: http.MethodPost, BodyType: "xml"},
+	} {
+		if err := checkBody(&task); err == nil {
+			t.Errorf("task: %+v, expect error", task)
+		}
+	}
+}
+
+func Test_redis_timeWheel_methods(t *testing.T) {
+	type request struct {
+		method      string
+		contentType string
+		body        string
+	}
+	received := make(chan request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{method: r.Method, contentType: r.Header.Get("Content-Type"), body: string(body)}
+	}))
+	defer server.Close()
+
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts, httpClient: thttp.NewClient()}
+
+	cases := []struct {
+		task RTaskElement
+		want request
+	}{
+		{RTaskElement{Method: http.MethodGet}, request{http.MethodGet, "", ""}},
+		{RTaskElement{Method: http.MethodHead}, request{http.MethodHead, "", ""}},
+		{RTaskElement{Method: http.MethodDelete}, request{http.MethodDelete, "", ""}},
+		{RTaskElement{Method: http.MethodDelete, Req: map[string]int{"a": 1}}, request{http.MethodDelete, "application/json", `{"a":1}`}},
+		{RTaskElement{Method: http.MethodPost, Req: map[string]int{"a": 1}}, request{http.MethodPost, "application/json", `{"a":1}`}},
+		{RTaskElement{Method: http.MethodPut, Req: map[string]int{"a": 1}}, request{http.MethodPut, "application/json", `{"a":1}`}},
+		{RTaskElement{Method: http.MethodPatch, BodyType: BodyTypeForm, FormValues: map[string]string{"a": "1"}}, request{http.MethodPatch, "application/x-www-form-urlencoded", "a=1"}},
+	}
+	for _, c := range cases {
+		c.task.CallbackURL = server.URL
+		if err := rTimeWheel.addTaskPrecheck(&c.task); err != nil {
+			t.Errorf("method: %s, got err: %v", c.task.Method, err)
+			continue
+		}
+		if err := rTimeWheel.executeTask(context.Background(), &c.task); err != nil {
+			t.Error(err)
+			continue
+		}
+		if got := <-received; got != c.want {
+			t.Errorf("method: %s, got: %+v, want: %+v", c.task.Method, got, c.want)
+		}
+	}
+
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{Method: http.MethodOptions, CallbackURL: server.URL}); err == nil {
+		t.Errorf("method: %s, expect error", http.MethodOptions)
+	}
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{Method: http.MethodHead, CallbackURL: server.URL, BodyType: BodyTypeRaw, RawBody: []byte("raw")}); err == nil {
+		t.Errorf("raw body with %s, expect error", http.MethodHead)
+	}
+
+	WithAllowedMethods(http.MethodPost)(&opts)
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{Method: http.MethodDelete, CallbackURL: server.URL}); err == nil {
+		t.Errorf("method %s not allowed, expect error", http.MethodDelete)
+	}
+}
+
+type testProducer struct {
+	err      error
+	messages []*KafkaMessage
+}
+
+func (p *testProducer) Produce(ctx context.Context, msg *KafkaMessage) error {
+	p.messages = append(p.messages, msg)
+	return p.err
+}
+
+func Test_redis_timeWheel_kafkaProducer(t *testing.T) {
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	task := RTaskElement{Key: "kafka", Topic: "notify", MessageKey: "user_1", Req: map[string]int{"a": 1}}
+	if err := rTimeWheel.addTaskPrecheck(&task); !errors.Is(err, ErrKafkaProducerNotConfigured) {
+		t.Errorf("got err: %v, want: %v", err, ErrKafkaProducerNotConfigured)
+	}
+
+	producer := testProducer{}
+	WithKafkaProducer(&producer)(&opts)
+	if err := rTimeWheel.addTaskPrecheck(&task); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{Topic: "notify", CallbackURL: "http://localhost", Method: http.MethodPost}); err == nil {
+		t.Errorf("topic with callback url, expect error")
+	}
+
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(producer.messages) != 1 {
+		t.Errorf("got %d messages, want 1", len(producer.messages))
+		return
+	}
+	msg := producer.messages[0]
+	if msg.Topic != "notify" || string(msg.Key) != "user_1" || string(msg.Value) != `{"a":1}` || msg.Headers[DefaultKeyHeader] != "kafka" {
+		t.Errorf("got message: %+v", msg)
+	}
+
+	producer.err = errors.New("broker unavailable")
+	err := rTimeWheel.executeTask(context.Background(), &task)
+	if !errors.Is(err, producer.err) || !rTimeWheel.isRetryable(&task, err) {
+		t.Errorf("got err: %v, want retryable %v", err, producer.err)
+	}
+}
+
+func Test_redis_timeWheel_handler(t *testing.T) {
+	opts := RTimeWheelOptions{}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	var got json.RawMessage
+	rTimeWheel.RegisterHandler("echo", func(ctx context.Context, payload json.RawMessage) error {
+		got = payload
+		return nil
+	})
+
+	task := RTaskElement{Key: "handler", HandlerName: "echo", Req: map[string]int{"a": 1}}
+	if err := rTimeWheel.addTaskPrecheck(&task); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil || string(got) != `{"a":1}` {
+		t.Errorf("got payload: %s, err: %v", got, err)
+	}
+
+	// 处理函数可能注册在其他实例上，添加任务时不做校验，执行时按照失败处理
+	unknown := RTaskElement{Key: "unknown", HandlerName: "unknown"}
+	if err := rTimeWheel.addTaskPrecheck(&unknown); err != nil {
+		t.Error(err)
+	}
+	if err := rTimeWheel.executeTask(context.Background(), &unknown); !errors.Is(err, ErrHandlerNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrHandlerNotFound)
+	}
+
+	for _, task := range []RTaskElement{
+		{HandlerName: "echo", CallbackURL: "http://localhost", Method: http.MethodPost},
+		{HandlerName: "echo", Method: http.MethodPost},
+		{HandlerName: "echo", BodyType: BodyTypeRaw, RawBody: []byte("raw")},
+		{},
+	} {
+		if err := rTimeWheel.addTaskPrecheck(&task); err == nil {
+			t.Errorf("task: %+v, expect error", task)
+		}
+	}
+}
+
+type testExecutor struct {
+	err   error
+	tasks []*RTaskElement
+}
+
+func (e *testExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	e.tasks = append(e.tasks, task)
+	return e.err
+}
+
+func Test_redis_timeWheel_executor(t *testing.T) {
+	defaultExecutor, sqsExecutor := testExecutor{}, testExecutor{err: &thttp.StatusError{StatusCode: http.StatusBadRequest}}
+	opts := RTimeWheelOptions{}
+	WithExecutor(&defaultExecutor)(&opts)
+	WithNamedExecutor("sqs", &sqsExecutor)(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	// 自定义默认执行器时，不再校验回调地址
+	task := RTaskElement{Key: "default", Header: map[string]string{"X-Custom": "1"}}
+	if err := rTimeWheel.addTaskPrecheck(&task); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := rTimeWheel.executeTask(context.Background(), &task); err != nil {
+		t.Error(err)
+		return
+	}
+	if len(defaultExecutor.tasks) != 1 {
+		t.Errorf("default executor got %d tasks, want 1", len(defaultExecutor.tasks))
+		return
+	}
+	if header := defaultExecutor.tasks[0].Header; header["X-Custom"] != "1" || header[DefaultKeyHeader] != "default" {
+		t.Errorf("got header: %v", header)
+	}
+	if len(task.Header) != 1 {
+		t.Errorf("original task header modified: %v", task.Header)
+	}
+
+	named := RTaskElement{Key: "named", ExecutorName: "sqs"}
+	if err := rTimeWheel.addTaskPrecheck(&named); err != nil {
+		t.Error(err)
+		return
+	}
+	err := rTimeWheel.executeTask(context.Background(), &named)
+	if len(sqsExecutor.tasks) != 1 || thttp.StatusCode(err) != http.StatusBadRequest || rTimeWheel.isRetryable(&named, err) {
+		t.Errorf("named executor got %d tasks, err: %v", len(sqsExecutor.tasks), err)
+	}
+
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{ExecutorName: "unknown"}); !errors.Is(err, ErrExecutorNotFound) {
+		t.Errorf("got err: %v, want: %v", err, ErrExecutorNotFound)
+	}
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{ExecutorName: "sqs", HandlerName: "echo"}); err == nil {
+		t.Errorf("executor name with handler name, expect error")
+	}
+}
+
+func Test_redis_timeWheel_taskStore(t *testing.T) {
+	// 亚秒级的扫描窗口转换为整秒区间后，包含的 score 保持不变
+	base := time.Unix(1700000000, 0)
+	cases := []struct {
+		start, end time.Time
+		from, to   int64
+	}{
+		{base, base.Add(500 * time.Millisecond), 1700000000, 1700000001},
+		{base.Add(500 * time.Millisecond), base.Add(time.Second), 1700000001, 1700000001},
+		{base, base.Add(5 * time.Second), 1700000000, 1700000005},
+	}
+	for _, c := range cases {
+		if from, to := ceilSeconds(c.start), ceilSeconds(c.end); from != c.from || to != c.to {
+			t.Errorf("window [%v, %v), got [%d, %d), want [%d, %d)", c.start, c.end, from, to, c.from, c.to)
+		}
+	}
+
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithTaskStore(NewRedisTaskStore(nil)))
+	if _, err := rTimeWheel.ListPendingTasks(context.Background(), time.Now(), time.Now().Add(time.Minute), 10); !errors.Is(err, ErrTaskStoreUnsupported) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskStoreUnsupported)
+	}
+	if _, err := rTimeWheel.RemoveTasks(context.Background(), nil); !errors.Is(err, ErrTaskStoreUnsupported) {
+		t.Errorf("got err: %v, want: %v", err, ErrTaskStoreUnsupported)
+	}
+}
+
+type testCodec struct {
+	JSONCodec
+}
+
+func (testCodec) Format() byte {
+	return 0x7f
+}
+
+func Test_redis_timeWheel_codec(t *testing.T) {
+	var malformed []byte
+	opts := RTimeWheelOptions{}
+	WithCodec(testCodec{}, JSONCodec{})(&opts)
+	WithMalformedTaskHook(func(ctx context.Context, data []byte, err error) { malformed = data })(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	task := RTaskElement{Key: "order_1024", CallbackURL: "http://localhost:8080/callback", Method: http.MethodPost}
+	data, err := rTimeWheel.encodeTask(&task)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if data[0] != 0x7f {
+		t.Errorf("got format: %#x, want: %#x", data[0], 0x7f)
+	}
+	if key, err := decodeTaskKey(data); err != nil || key != task.Key {
+		t.Errorf("got key: %s, err: %v", key, err)
+	}
+	got, err := rTimeWheel.decodeTask(data)
+	if err != nil || got.Key != task.Key || got.CallbackURL != task.CallbackURL {
+		t.Errorf("got task: %+v, err: %v", got, err)
+	}
+
+	// 切换编码方式之前写入的 json 任务仍然可以解码
+	legacy, _ := json.Marshal(&task)
+	if got, err := rTimeWheel.decodeTask(legacy); err != nil || got.Key != task.Key {
+		t.Errorf("got task: %+v, err: %v", got, err)
+	}
+	if key, err := decodeTaskKey(legacy); err != nil || key != task.Key {
+		t.Errorf("got key: %s, err: %v", key, err)
+	}
+
+	// 未知的格式交给 malformedTaskHook 处理
+	unknown := append([]byte{0x02}, data[1:]...)
+	if _, err := rTimeWheel.decodeTask(unknown); err == nil {
+		t.Errorf("unknown format, expect error")
+	} else {
+		rTimeWheel.handleMalformedTask(context.Background(), "slice", unknown, err)
+	}
+	if string(malformed) != string(unknown) {
+		t.Errorf("malformed hook got: %q", malformed)
+	}
+	if _, err := decodeTaskKey([]byte{0x7f, 0x00, 0x10, 'a'}); err == nil {
+		t.Errorf("truncated envelope, expect error")
+	}
+}
+
+func Test_redis_timeWheel_prefetch(t *testing.T) {
+	// 未开启租约模式时，预取模式不生效
+	opts := RTimeWheelOptions{}
+	WithPrefetch(10 * time.Second)(&opts)
+	repairRTimeWheel(&opts)
+	if opts.prefetchWindow != 0 {
+		t.Errorf("prefetch without lease, got window: %v", opts.prefetchWindow)
+	}
+
+	opts = RTimeWheelOptions{}
+	WithLease("instance1", 30*time.Second)(&opts)
+	WithPrefetch(10 * time.Second)(&opts)
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 500000000, time.UTC)
+	cases := []struct {
+		executeAt time.Time
+		want      bool
+	}{
+		{now.Add(-time.Second), false},
+		{now.Add(5 * time.Second), true},
+		{now.Add(10 * time.Second), true},
+		{now.Add(11 * time.Second), false},
+	}
+	for _, c := range cases {
+		if got := rTimeWheel.isPrefetchable(c.executeAt, now); got != c.want {
+			t.Errorf("execute at %v, got: %v, want: %v", c.executeAt.Sub(now), got, c.want)
+		}
+	}
+
+	// 停止之后不再设置本地定时器
+	rTimeWheel.resetPrefetch()
+	rTimeWheel.schedulePrefetched(time.Now().Add(time.Hour), []*RTaskElement{{Key: "test1"}})
+	if len(rTimeWheel.prefetchTimers) != 1 {
+		t.Errorf("got %d timers, want 1", len(rTimeWheel.prefetchTimers))
+	}
+	rTimeWheel.stopPrefetch()
+	rTimeWheel.schedulePrefetched(time.Now().Add(time.Hour), []*RTaskElement{{Key: "test2"}})
+	if len(rTimeWheel.prefetchTimers) != 0 {
+		t.Errorf("got %d timers after stop, want 0", len(rTimeWheel.prefetchTimers))
+	}
+}
+
+func Test_redis_timeWheel_lease(t *testing.T) {
+	var fired int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fired, 1)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	prefix := fmt.Sprintf("test_lease_%d", time.Now().UnixNano())
+	crashed := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix(prefix), WithLease("crashed", time.Second))
+	defer crashed.Stop()
+	executeAt := time.Now().Add(time.Minute)
+	if _, err := crashed.AddTask(ctx, "test_lease", &RTaskElement{CallbackURL: server.URL, Method: http.MethodPost}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 租约脚本执行完成之后进程崩溃，任务既没有执行也没有确认
+	sliceStr := crashed.getTaskSliceStr("test_lease", executeAt)
+	stored, err := crashed.leaseTasks(ctx, crashed.getSliceStart(executeAt), sliceStr, executeAt.Unix(), executeAt.Unix()+1, 10)
+	if err != nil || len(stored) != 1 {
+		t.Errorf("got leased: %d, err: %v", len(stored), err)
+		return
+	}
+	if registered, err := crashed.redisClient.SMembers(ctx, crashed.getInflightRegistryKey()); err != nil || len(registered) != 1 {
+		t.Errorf("got registered: %v, err: %v", registered, err)
+	}
+
+	// 租约到期之后由其他实例接管并执行，执行完成后确认租约
+	survivor := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix(prefix), WithLease("survivor", time.Second))
+	defer survivor.Stop()
+	survivor.reapLeases()
+	if n := atomic.LoadInt32(&fired); n != 0 {
+		t.Errorf("lease not expired, fired %d times", n)
+	}
+	<-time.After(2 * time.Second)
+	survivor.reapLeases()
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Errorf("expired lease, fired %d times, want 1", n)
+	}
+	for _, inflightKey := range []string{crashed.getInflightKey(sliceStr), survivor.getInflightKey(sliceStr)} {
+		if n, err := survivor.redisClient.ZCard(ctx, inflightKey); err != nil || n != 0 {
+			t.Errorf("inflight key: %s, got: %d, err: %v", inflightKey, n, err)
+		}
+	}
+}
+
+func Test_redis_timeWheel_leaderElection(t *testing.T) {
+	// 未开启 leader 选举时，所有实例都会扫描
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient())
+	if !rTimeWheel.IsLeader() {
+		t.Errorf("leader election disabled, expect leader")
+	}
+
+	var changes []bool
+	rTimeWheel = NewRTimeWheel(nil, thttp.NewClient(),
+		WithLeaderElection("instance1", 100*time.Millisecond),
+		WithLeadershipHook(func(ctx context.Context, isLeader bool) { changes = append(changes, isLeader) }),
+	)
+	if rTimeWheel.opts.leaderLeaseTTL != time.Second || rTimeWheel.leaderRenewInterval() != time.Second/3 {
+		t.Errorf("got lease ttl: %v", rTimeWheel.opts.leaderLeaseTTL)
+	}
+	if rTimeWheel.IsLeader() {
+		t.Errorf("not elected yet, expect follower")
+	}
+
+	rTimeWheel.leader = true
+	rTimeWheel.setLeader(context.Background(), false)
+	rTimeWheel.setLeader(context.Background(), false)
+	if rTimeWheel.IsLeader() || len(changes) != 1 || changes[0] {
+		t.Errorf("got leadership changes: %v", changes)
+	}
+}
+
+func Test_redis_timeWheel_sharding(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithSharding("instance1", 4, 0), WithOwnedShards(3, 3, 1, 7))
+	if got := fmt.Sprint(rTimeWheel.opts.staticShards); got != "[1 3]" {
+		t.Errorf("got static shards: %s", got)
+	}
+	if got := fmt.Sprint(rTimeWheel.OwnedShards()); got != "[1 3]" {
+		t.Errorf("before heartbeat, got owned shards: %s", got)
+	}
+
+	executeAt := time.Date(2024, 1, 1, 10, 30, 15, 0, time.Local)
+	shard := rTimeWheel.shardOf("test1")
+	wantKey := fmt.Sprintf("%s_task_{%s}_shard_%d", DefaultKeyPrefix, rTimeWheel.getSliceStr(executeAt), shard)
+	if got := rTimeWheel.getTaskZsetKey("test1", executeAt); got != wantKey {
+		t.Errorf("got zset key: %s, want: %s", got, wantKey)
+	}
+	// 同一时间片的全部分片共享删除集合
+	if got := sliceDeleteSetKey(DefaultKeyPrefix, rTimeWheel.getTaskSliceStr("test1", executeAt)); got != rTimeWheel.getDeleteSetKey(executeAt) {
+		t.Errorf("got delete set key: %s", got)
+	}
+	if got := len(rTimeWheel.getAllSliceStrs(executeAt)); got != 4 {
+		t.Errorf("got %d slices, want 4", got)
+	}
+
+	// 未开启分片时沿用原有的 key
+	plain := NewRTimeWheel(nil, thttp.NewClient(), WithSharding("instance1", 1, 0))
+	if got := plain.getTaskZsetKey("test1", executeAt); got != plain.getMinuteSlice(executeAt) {
+		t.Errorf("sharding disabled, got zset key: %s", got)
+	}
+
+	cases := []struct {
+		members map[string][]int
+		me      string
+		want    string
+	}{
+		// 自动分配：按照实例标识排序后轮流分配
+		{map[string][]int{"a": nil, "b": nil}, "a", "[0 2 4]"},
+		{map[string][]int{"a": nil, "b": nil}, "b", "[1 3]"},
+		// 静态指定的分片归属于指定的实例，其余分片轮流分配
+		{map[string][]int{"a": {0, 1}, "b": nil}, "a", "[0 1 2 4]"},
+		{map[string][]int{"a": {0, 1}, "b": nil}, "b", "[3]"},
+		// 实例 b 心跳超时后，其静态指定的分片由存活的实例接管
+		{map[string][]int{"a": nil}, "a", "[0 1 2 3 4]"},
+	}
+	for _, c := range cases {
+		if got := fmt.Sprint(assignShards(5, c.me, c.members)); got != c.want {
+			t.Errorf("members: %v, me: %s, got: %s, want: %s", c.members, c.me, got, c.want)
+		}
+	}
+}
+
+func Test_redis_timeWheel_windowOverlap(t *testing.T) {
+	redisClient := newRedisClient(t)
+	opts := []RTimeWheelOption{WithKeyPrefix("overlap_timewheel"), WithWindowOverlap(2 * time.Second), WithTickInterval(time.Second)}
+	instance1 := NewRTimeWheel(redisClient, thttp.NewClient(), opts...)
+	instance2 := NewRTimeWheel(redisClient, thttp.NewClient(), opts...)
+
+	ctx := context.Background()
+	executeAt := time.Now().Add(time.Minute).Truncate(time.Second)
+	if _, err := instance1.AddTask(ctx, "test_overlap", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 两个实例的窗口重叠，均检索到任务，只有先认领的实例取出任务
+	sliceStr := instance1.getTaskSliceStr("test_overlap", executeAt)
+	from, to := executeAt.Add(-2*time.Second).Unix(), executeAt.Add(time.Second).Unix()
+	claimed, _, err := instance1.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize)
+	if err != nil || len(claimed) != 1 || claimed[0].Key != "test_overlap" {
+		t.Errorf("got claimed: %+v, err: %v", claimed, err)
+		return
+	}
+	if claimed, _, err := instance2.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize); err != nil || len(claimed) != 0 {
+		t.Errorf("claimed by peer, got: %+v, err: %v", claimed, err)
+	}
+	if n, err := redisClient.ZCard(ctx, instance1.getMinuteSlice(executeAt)); err != nil || n != 0 {
+		t.Errorf("claimed task should be removed, got: %d, err: %v", n, err)
+	}
+	// 任务被认领之后，即便窗口再次重叠也不会被重复认领
+	if claimed, _, err := instance2.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize); err != nil || len(claimed) != 0 {
+		t.Errorf("claimed twice, got: %+v, err: %v", claimed, err)
+	}
+
+	// 以相同的唯一键以及执行时刻重新添加任务，新任务依然能够被认领并移出 zset
+	if _, err := instance1.AddTask(ctx, "test_overlap", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	if claimed, _, err := instance2.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize); err != nil || len(claimed) != 1 {
+		t.Errorf("re-added task, got claimed: %+v, err: %v", claimed, err)
+	}
+	if claimed, _, err := instance1.claimTasks(ctx, sliceStr, from, to, DefaultFetchBatchSize); err != nil || len(claimed) != 0 {
+		t.Errorf("re-added task claimed twice, got: %+v, err: %v", claimed, err)
+	}
+	if n, err := redisClient.ZCard(ctx, instance1.getMinuteSlice(executeAt)); err != nil || n != 0 {
+		t.Errorf("re-added task should be removed, got: %d, err: %v", n, err)
+	}
+}
+
+func Test_redis_timeWheel_exactlyOnce(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	var duplicates int32
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix("tw_exactly_once"),
+		WithDuplicateHook(func(ctx context.Context, task *RTaskElement) {
+			atomic.AddInt32(&duplicates, 1)
+		}))
+	if err := rTimeWheel.redisClient.Ping(context.Background()); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// 同一次执行的两个副本只有一个发起回调
+	scheduledAt := time.Now().Truncate(time.Second)
+	newTask := func() *RTaskElement {
+		return &RTaskElement{Key: fmt.Sprintf("test_exactly_once_%d", scheduledAt.UnixNano()), CallbackURL: server.URL,
+			Method: http.MethodPost, ExactlyOnce: true, scheduledAt: scheduledAt}
+	}
+	tctx, cancel := rTimeWheel.newBatchContext()
+	defer cancel()
+	outcomes := rTimeWheel.executeBatch(tctx, []*RTaskElement{newTask(), newTask()})
+	if atomic.LoadInt32(&calls) != 1 || atomic.LoadInt32(&duplicates) != 1 || outcomes.executed != 1 || outcomes.skipped != 1 {
+		t.Errorf("got calls: %d, duplicates: %d, outcomes: %+v", calls, duplicates, outcomes)
+	}
+
+	// 不同的执行时刻视为新的执行
+	scheduledAt = scheduledAt.Add(time.Second)
+	if outcomes := rTimeWheel.executeBatch(tctx, []*RTaskElement{newTask()}); outcomes.executed != 1 {
+		t.Errorf("got outcomes: %+v", outcomes)
+	}
+}
+
+func Test_redis_timeWheel_exactlyOnceTTL(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, nil, WithExactlyOnceTTL(time.Hour), WithMaxRetryAfter(time.Minute), WithBatchTimeout(time.Second))
+	if got := rTimeWheel.exactlyOnceTTL(&RTaskElement{}); got != time.Hour {
+		t.Errorf("got ttl: %v, want: %v", got, time.Hour)
+	}
+
+	// 每次重试按照 Retry-After 上限以及批次超时时间计算
+	task := &RTaskElement{MaxRetries: 3}
+	if got, want := rTimeWheel.retryHorizon(task), 3*(time.Minute+time.Second); got != want {
+		t.Errorf("got retry horizon: %v, want: %v", got, want)
+	}
+	// 退避时长受 DefaultMaxBackoff 限制
+	task.BackoffBase = time.Hour
+	if got, want := rTimeWheel.exactlyOnceTTL(task), 2*(3*DefaultMaxBackoff+3*time.Second); got != want {
+		t.Errorf("got ttl: %v, want: %v", got, want)
+	}
+
+	if got, want := rTimeWheel.getExecutionClaimKey(&RTaskElement{Key: "pay:1", scheduledAt: time.Unix(100, 0)}), DefaultKeyPrefix+"_claim_{pay:1}:100"; got != want {
+		t.Errorf("got claim key: %s, want: %s", got, want)
+	}
+}
+
+func Test_redis_timeWheel_versioning(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix("version_timewheel"))
+	ctx := context.Background()
+	executeAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	task := &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}
+	if _, err := rTimeWheel.AddTaskNX(ctx, "test_version", task, executeAt); err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.RemoveTaskByKey(ctx, "test_version")
+	if _, err := rTimeWheel.AddTaskNX(ctx, "test_version", task, executeAt); !errors.Is(err, ErrTaskExists) {
+		t.Errorf("got err: %v, want ErrTaskExists", err)
+	}
+
+	got, _, err := rTimeWheel.GetTask(ctx, "test_version")
+	if err != nil || got.Version() != 1 {
+		t.Errorf("got version: %v, err: %v", got, err)
+		return
+	}
+
+	// 读取-修改-写入，持有旧版本号的写入被拒绝
+	got.Req = map[string]string{"a": "b"}
+	version, err := rTimeWheel.UpdateTask(ctx, "test_version", got, got.Version())
+	if err != nil || version != 2 {
+		t.Errorf("got version: %d, err: %v", version, err)
+		return
+	}
+	if _, err := rTimeWheel.UpdateTask(ctx, "test_version", got, got.Version()); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("got err: %v, want ErrVersionConflict", err)
+	}
+	if _, err := rTimeWheel.RescheduleTaskIfVersion(ctx, "test_version", executeAt.Add(time.Hour), 1); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("got err: %v, want ErrVersionConflict", err)
+	}
+	if version, err = rTimeWheel.RescheduleTaskIfVersion(ctx, "test_version", executeAt.Add(time.Hour), 2); err != nil || version != 3 {
+		t.Errorf("got version: %d, err: %v", version, err)
+		return
+	}
+
+	got, at, err := rTimeWheel.GetTask(ctx, "test_version")
+	if err != nil || got.Version() != 3 || !at.Equal(executeAt.Add(time.Hour)) || fmt.Sprint(got.Req) != "map[a:b]" {
+		t.Errorf("got task: %+v, execute at: %v, err: %v", got, at, err)
+	}
+}
+
+func Test_redis_timeWheel_addTaskAfter(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient())
+	ctx := context.Background()
+	before := time.Now()
+	executeAt, err := rTimeWheel.AddTaskAfter(ctx, "test_add_after", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, time.Hour)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer rTimeWheel.RemoveTask(ctx, "test_add_after", executeAt)
+	if executeAt.Before(before.Add(time.Hour)) || executeAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("got execute at: %v, want about an hour later", executeAt)
+	}
+	if _, at, err := rTimeWheel.GetTask(ctx, "test_add_after"); err != nil || at.Unix() != executeAt.Unix() {
+		t.Errorf("got execute at: %v, err: %v", at, err)
+	}
+
+	// 执行时刻早于当前扫描窗口时，与 AddTask 的处理方式相同
+	if _, err := rTimeWheel.AddTaskAfter(ctx, "test_add_after_past", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, -time.Hour); !errors.Is(err, ErrExecuteAtInPast) {
+		t.Errorf("got err: %v, want ErrExecuteAtInPast", err)
+	}
+}
+
+func Test_redis_timeWheel_promotion(t *testing.T) {
+	redisClient := newRedisClient(t)
+	rTimeWheel := NewRTimeWheel(redisClient, thttp.NewClient(), WithKeyPrefix("overflow_timewheel"), WithPromotionHorizon(time.Hour))
+	ctx := context.Background()
+	executeAt := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	for _, key := range []string{"test_overflow", "test_overflow_removed"} {
+		if _, err := rTimeWheel.AddTask(ctx, key, &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, executeAt); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	defer rTimeWheel.RemoveTask(ctx, "test_overflow", executeAt)
+	if n, err := redisClient.ZCard(ctx, rTimeWheel.getOverflowKey()); err != nil || n != 2 {
+		t.Errorf("got overflow tasks: %d, err: %v", n, err)
+	}
+	if task, at, err := rTimeWheel.GetTask(ctx, "test_overflow"); err != nil || !task.overflow || !at.Equal(executeAt) {
+		t.Errorf("got task: %+v, execute at: %v, err: %v", task, at, err)
+	}
+
+	// 位于长期 zset 中的任务同样可以删除
+	if err := rTimeWheel.RemoveTask(ctx, "test_overflow_removed", executeAt); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := rTimeWheel.GetTask(ctx, "test_overflow_removed"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v, want ErrTaskNotFound", err)
+	}
+
+	// 晋升之后任务位于时间片中，模拟晋升中途崩溃，重复晋升不会重复添加任务
+	task, _, _ := rTimeWheel.GetTask(ctx, "test_overflow")
+	body, _ := rTimeWheel.encodeTask(task)
+	for i := 0; i < 2; i++ {
+		if _, err := redisClient.ZAdd(ctx, rTimeWheel.getOverflowKey(), float64(executeAt.Unix()), string(body)); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := rTimeWheel.promoteBatch(ctx, executeAt); err != nil {
+			t.Error(err)
+			return
+		}
+		if n, err := redisClient.ZCard(ctx, rTimeWheel.getOverflowKey()); err != nil || n != 0 {
+			t.Errorf("got overflow tasks: %d, err: %v", n, err)
+		}
+		if n, err := redisClient.ZCard(ctx, rTimeWheel.getTaskZsetKey("test_overflow", executeAt)); err != nil || n != 1 {
+			t.Errorf("got slice tasks: %d, err: %v", n, err)
+		}
+	}
+	if task, _, err := rTimeWheel.GetTask(ctx, "test_overflow"); err != nil || task.overflow {
+		t.Errorf("got task: %+v, err: %v", task, err)
+	}
+}
+
+func Test_redis_timeWheel_redisTime(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithRedisTime())
+	// 本地时钟严重偏离时，扫描仍然以 redis 的时刻为准
+	skewed := time.Now().Add(-time.Hour)
+	if now := rTimeWheel.clockNow(skewed); now.Sub(time.Now()).Abs() > 5*time.Second {
+		t.Errorf("got clock: %v, want redis time", now)
+	}
+	if now := NewRTimeWheel(newRedisClient(t), thttp.NewClient()).clockNow(skewed); !now.Equal(skewed) {
+		t.Errorf("got clock: %v, want local time", now)
+	}
+}
+
+// 以 TZ=America/New_York 与 TZ=UTC 启动的实例共享同一个 redis 时，同一时刻落在相同的时间片中
+// 在设置了 TZ 环境变量的子进程中运行 Test_redis_timeWheel_locationInstance，模拟部署在不同时区的实例
+func runInstanceInZone(t *testing.T, tz, mode string, executeAt time.Time) string {
+	cmd := exec.Command(os.Args[0], "-test.run=^Test_redis_timeWheel_locationInstance$", "-test.v")
+	cmd.Env = append(os.Environ(),
+		"TZ="+tz,
+		"TIMEWHEEL_LOCATION_MODE="+mode,
+		"TIMEWHEEL_LOCATION_EXECUTE_AT="+strconv.FormatInt(executeAt.Unix(), 10),
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Errorf("instance in %s, mode: %s, err: %v, output: %s", tz, mode, err, out)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if slice, ok := strings.CutPrefix(strings.TrimSpace(line), "slice: "); ok {
+			return slice
+		}
+	}
+	return ""
+}
+
+func Test_redis_timeWheel_locationInstance(t *testing.T) {
+	mode := os.Getenv("TIMEWHEEL_LOCATION_MODE")
+	if mode == "" {
+		t.Skip("only runs as a subprocess of Test_redis_timeWheel_location")
+	}
+	unix, err := strconv.ParseInt(os.Getenv("TIMEWHEEL_LOCATION_EXECUTE_AT"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	executeAt := time.Unix(unix, 0)
+
+	ctx := context.Background()
+	rTimeWheel := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix("location_timewheel"))
+	fmt.Printf("slice: %s\n", rTimeWheel.getMinuteSlice(executeAt))
+	switch mode {
+	case "add":
+		if _, err := rTimeWheel.AddTask(ctx, "test_location", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, executeAt); err != nil {
+			t.Error(err)
+		}
+	case "get":
+		if _, at, err := rTimeWheel.GetTask(ctx, "test_location"); err != nil || at.Unix() != executeAt.Unix() {
+			t.Errorf("got: %v, err: %v, want: %v", at, err, executeAt)
+		}
+		if err := rTimeWheel.RemoveTask(ctx, "test_location", executeAt); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func Test_redis_timeWheel_location(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// 分别部署在纽约与 UTC 时区的实例，默认按照 UTC 生成相同的时间片 key，纽约实例写入的任务可以被 UTC 实例检索到
+	executeAt := time.Now().Add(time.Hour)
+	nySlice := runInstanceInZone(t, "America/New_York", "add", executeAt)
+	utcSlice := runInstanceInZone(t, "UTC", "get", executeAt)
+	if nySlice == "" || nySlice != utcSlice {
+		t.Errorf("got slices: %q, %q", nySlice, utcSlice)
+	}
+
+	// 旧版本按照本地时区生成的 key 迁移之后，可以被默认按照 UTC 生成 key 的实例检索到
+	ctx := context.Background()
+	legacy := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix("location_legacy_timewheel"), WithLocation(newYork))
+	upgraded := NewRTimeWheel(newRedisClient(t), thttp.NewClient(), WithKeyPrefix("location_legacy_timewheel"))
+	legacyAt := executeAt.Add(time.Hour)
+	if legacy.getMinuteSlice(legacyAt) == upgraded.getMinuteSlice(legacyAt) {
+		t.Error("legacy slice should differ from utc slice")
+	}
+	if _, err := legacy.AddTask(ctx, "test_location_legacy", &RTaskElement{CallbackURL: callbackURL, Method: callbackMethod}, legacyAt); err != nil {
+		t.Error(err)
+		return
+	}
+	defer upgraded.RemoveTask(ctx, "test_location_legacy", legacyAt)
+	if _, _, err := upgraded.GetTask(ctx, "test_location_legacy"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("got err: %v before migration, want ErrTaskNotFound", err)
+	}
+	if n, err := upgraded.MigrateSliceKeys(ctx, newYork); err != nil || n == 0 {
+		t.Errorf("migrated: %d, err: %v", n, err)
+	}
+	if _, at, err := upgraded.GetTask(ctx, "test_location_legacy"); err != nil || at.Unix() != legacyAt.Unix() {
+		t.Errorf("got: %v, err: %v after migration", at, err)
+	}
+}
+
+func Test_redis_timeWheel_buckets(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithBuckets(4), WithSharding("instance1", 2, 0))
+	executeAt := time.Date(2024, 1, 1, 10, 30, 15, 0, time.Local)
+	sliceStr := rTimeWheel.getSliceStr(executeAt)
+
+	// 分桶后缀位于 {hash_tag} 之内，分片后缀位于 {hash_tag} 之外
+	bucket, shard := rTimeWheel.bucketOf("test1"), rTimeWheel.shardOf("test1")
+	wantKey := fmt.Sprintf("%s_task_{%s_bucket_%d}_shard_%d", DefaultKeyPrefix, sliceStr, bucket, shard)
+	if got := rTimeWheel.getTaskZsetKey("test1", executeAt); got != wantKey {
+		t.Errorf("got zset key: %s, want: %s", got, wantKey)
+	}
+	wantKey = fmt.Sprintf("%s_delset_{%s_bucket_%d}", DefaultKeyPrefix, sliceStr, bucket)
+	if got := rTimeWheel.getTaskDeleteSetKey("test1", executeAt); got != wantKey {
+		t.Errorf("got delete set key: %s, want: %s", got, wantKey)
+	}
+	wantKey = fmt.Sprintf("%s_inflight_{%s_bucket_%d}_instance1", DefaultKeyPrefix, sliceStr, bucket)
+	if got := rTimeWheel.getInflightKey(rTimeWheel.getTaskSliceStr("test1", executeAt)); got != wantKey {
+		t.Errorf("got inflight key: %s, want: %s", got, wantKey)
+	}
+
+	if got := len(rTimeWheel.getAllSliceStrs(executeAt)); got != 8 {
+		t.Errorf("got %d slices, want 8", got)
+	}
+	for _, s := range rTimeWheel.getAllSliceStrs(executeAt) {
+		parsed, err := rTimeWheel.parseSliceStr(s)
+		if err != nil || !parsed.Equal(rTimeWheel.getSliceStart(executeAt)) {
+			t.Errorf("parse slice %s, got: %v, err: %v", s, parsed, err)
+		}
+	}
+
+	// 分桶数量小于 2 时沿用原有的 key
+	plain := NewRTimeWheel(nil, thttp.NewClient(), WithBuckets(1))
+	if got := plain.getTaskZsetKey("test1", executeAt); got != plain.getMinuteSlice(executeAt) {
+		t.Errorf("buckets disabled, got zset key: %s", got)
+	}
+}
+
+func Test_redis_timeWheel_rateLimit(t *testing.T) {
+	now := time.Now()
+	bucket := newTokenBucket(Rate{RPS: 10, Burst: 2})
+	bucket.last = now
+	for i := 0; i < 2; i++ {
+		if wait, ok := bucket.reserve(now, time.Time{}); !ok || wait != 0 {
+			t.Errorf("burst reserve %d, got wait: %v, ok: %v", i, wait, ok)
+		}
+	}
+	// 令牌耗尽后需要等待令牌恢复
+	if wait, ok := bucket.reserve(now, time.Time{}); !ok || wait != 100*time.Millisecond {
+		t.Errorf("got wait: %v, ok: %v", wait, ok)
+	}
+	// 等待结束的时刻晚于 deadline 时不预占令牌
+	if wait, ok := bucket.reserve(now, now.Add(150*time.Millisecond)); ok || wait != 200*time.Millisecond {
+		t.Errorf("got wait: %v, ok: %v", wait, ok)
+	}
+	if wait, ok := bucket.reserve(now.Add(300*time.Millisecond), time.Time{}); !ok || wait != 0 {
+		t.Errorf("after refill, got wait: %v, ok: %v", wait, ok)
+	}
+
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(),
+		WithRateLimit(100, 0),
+		WithPerHostRateLimit(map[string]Rate{"a.example.com": {RPS: 1}, "b.example.com": {RPS: 0}}),
+	)
+	if rTimeWheel.opts.rateLimit.Burst != 1 || len(rTimeWheel.hostLimiters) != 1 {
+		t.Errorf("got rate limit: %+v, host limiters: %d", rTimeWheel.opts.rateLimit, len(rTimeWheel.hostLimiters))
+	}
+	limiters := newHostLimiters(map[string]Rate{"a.example.com": {RPS: 1, Burst: -1}, "b.example.com": {RPS: -1, Burst: 5}})
+	if len(limiters) != 1 || limiters["a.example.com"].rate.Burst != 1 {
+		t.Errorf("got host limiters: %+v", limiters)
+	}
+	if got := len(rTimeWheel.limitersFor(&RTaskElement{CallbackURL: "http://a.example.com:8080/cb"})); got != 2 {
+		t.Errorf("got %d limiters, want 2", got)
+	}
+	if got := len(rTimeWheel.limitersFor(&RTaskElement{CallbackURL: "http://b.example.com/cb"})); got != 1 {
+		t.Errorf("got %d limiters, want 1", got)
+	}
+
+	// 批次即将超时，无法获取令牌的任务需要延后，并且归还已经预占的令牌
+	task := &RTaskElement{CallbackURL: "http://a.example.com/cb"}
+	if _, ok := rTimeWheel.waitRateLimit(context.Background(), task); !ok {
+		t.Errorf("first task should not be limited")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if wait, ok := rTimeWheel.waitRateLimit(ctx, task); ok || wait <= 0 {
+		t.Errorf("got wait: %v, ok: %v", wait, ok)
+	}
+	if tokens := rTimeWheel.limiter.tokens; tokens < 0 {
+		t.Errorf("global token not returned, got: %v", tokens)
+	}
+}
+
+func Test_redis_timeWheel_circuitBreaker(t *testing.T) {
+	var transitions []string
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(),
+		WithCircuitBreaker(2, time.Minute, 50*time.Millisecond),
+		WithCircuitBreakerHook(func(ctx context.Context, host string, from, to CircuitState) {
+			transitions = append(transitions, host+":"+to.String())
+		}),
+	)
+	task := &RTaskElement{CallbackURL: "http://a.example.com/cb"}
+	failure := &thttp.StatusError{StatusCode: http.StatusServiceUnavailable}
+
+	// 请求本身有误的 4xx 不计为失败
+	for i := 0; i < 3; i++ {
+		call, _, ok := rTimeWheel.allowCircuit(task)
+		if !ok {
+			t.Fatalf("circuit should be closed")
+		}
+		call.done(&thttp.StatusError{StatusCode: http.StatusBadRequest})
+	}
+
+	for i := 0; i < 2; i++ {
+		call, _, _ := rTimeWheel.allowCircuit(task)
+		call.done(failure)
+	}
+	if _, wait, ok := rTimeWheel.allowCircuit(task); ok || wait <= 0 {
+		t.Errorf("circuit should be open, got wait: %v", wait)
+	}
+	// 其他主机不受影响
+	if _, _, ok := rTimeWheel.allowCircuit(&RTaskElement{CallbackURL: "http://b.example.com/cb"}); !ok {
+		t.Errorf("circuit of other host should be closed")
+	}
+
+	// 冷却结束后只放行一个探测请求，探测失败重新打开
+	time.Sleep(60 * time.Millisecond)
+	probe, _, ok := rTimeWheel.allowCircuit(task)
+	if !ok {
+		t.Fatalf("probe should be allowed")
+	}
+	if _, _, ok := rTimeWheel.allowCircuit(task); ok {
+		t.Errorf("only one probe allowed")
+	}
+	probe.done(failure)
+
+	time.Sleep(60 * time.Millisecond)
+	probe, _, _ = rTimeWheel.allowCircuit(task)
+	probe.done(nil)
+	if _, _, ok := rTimeWheel.allowCircuit(task); !ok {
+		t.Errorf("circuit should be closed after successful probe")
+	}
+
+	want := "[a.example.com:open a.example.com:half_open a.example.com:open a.example.com:half_open a.example.com:closed]"
+	if got := fmt.Sprint(transitions); got != want {
+		t.Errorf("got transitions: %s, want: %s", got, want)
+	}
+}
+
+func Test_redis_timeWheel_sweepHostState(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithPerHostConcurrency(1), WithCircuitBreaker(2, time.Minute, time.Minute))
+	failure := &thttp.StatusError{StatusCode: http.StatusServiceUnavailable}
+
+	// a 主机持有并发名额并且有失败记录，b 主机的名额已经归还并且请求成功
+	holdA, _ := rTimeWheel.tryAcquireHost("a.example.com")
+	releaseB, _ := rTimeWheel.tryAcquireHost("b.example.com")
+	releaseB()
+	callA, _, _ := rTimeWheel.allowCircuit(&RTaskElement{CallbackURL: "http://a.example.com/cb"})
+	callA.done(failure)
+	callB, _, _ := rTimeWheel.allowCircuit(&RTaskElement{CallbackURL: "http://b.example.com/cb"})
+	callB.done(nil)
+
+	rTimeWheel.sweepHostState()
+	if _, ok := rTimeWheel.hostSlots["a.example.com"]; !ok || len(rTimeWheel.hostSlots) != 1 {
+		t.Errorf("got host slots: %v", rTimeWheel.hostSlots)
+	}
+	if _, ok := rTimeWheel.breakers["a.example.com"]; !ok || len(rTimeWheel.breakers) != 1 {
+		t.Errorf("got breakers: %v", rTimeWheel.breakers)
+	}
+
+	// 持有名额期间不会被清理，其他任务仍然无法获取 a 主机的名额
+	if _, ok := rTimeWheel.tryAcquireHost("a.example.com"); ok {
+		t.Errorf("host slot should be full")
+	}
+	holdA()
+	rTimeWheel.sweepHostState()
+	if len(rTimeWheel.hostSlots) != 0 {
+		t.Errorf("got host slots: %v", rTimeWheel.hostSlots)
+	}
+
+	// 关闭状态的熔断器存在进行中的请求时不会被清理，请求失败计入同一个熔断器，连续失败达到阈值后打开
+	task := &RTaskElement{CallbackURL: "http://c.example.com/cb"}
+	inflight, _, _ := rTimeWheel.allowCircuit(task)
+	rTimeWheel.sweepHostState()
+	if _, ok := rTimeWheel.breakers["c.example.com"]; !ok {
+		t.Fatalf("breaker with call in flight evicted")
+	}
+	inflight.done(failure)
+	next, _, _ := rTimeWheel.allowCircuit(task)
+	next.done(failure)
+	if _, _, ok := rTimeWheel.allowCircuit(task); ok {
+		t.Errorf("circuit should be open after consecutive failures")
+	}
+}
+
+func Test_redis_timeWheel_perHostConcurrency(t *testing.T) {
+	var slowInflight, slowMaxInflight int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&slowInflight, 1)
+		defer atomic.AddInt32(&slowInflight, -1)
+		for {
+			max := atomic.LoadInt32(&slowMaxInflight)
+			if n <= max || atomic.CompareAndSwapInt32(&slowMaxInflight, max, n) {
+				break
+			}
+		}
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer slow.Close()
+
+	var fastDone []time.Duration
+	var mu sync.Mutex
+	begin := time.Now()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fastDone = append(fastDone, time.Since(begin))
+	}))
+	defer fast.Close()
+
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMaxConcurrency(2), WithPerHostConcurrency(1))
+	// 慢主机的任务排在批次的前部
+	var tasks []*RTaskElement
+	for i := 0; i < 4; i++ {
+		tasks = append(tasks, &RTaskElement{Key: fmt.Sprintf("slow%d", i), CallbackURL: slow.URL, Method: http.MethodGet})
+	}
+	for i := 0; i < 2; i++ {
+		tasks = append(tasks, &RTaskElement{Key: fmt.Sprintf("fast%d", i), CallbackURL: fast.URL, Method: http.MethodGet})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rTimeWheel.executeBatch(ctx, tasks)
+
+	if max := atomic.LoadInt32(&slowMaxInflight); max != 1 {
+		t.Errorf("got max inflight requests to slow host: %d, want 1", max)
+	}
+	// 慢主机只占用一个并发名额，快主机的任务无需等待慢主机的请求完成
+	if len(fastDone) != 2 || fastDone[1] > 200*time.Millisecond {
+		t.Errorf("fast host starved, got: %v", fastDone)
+	}
+
+	// 相同优先级的任务按照主机轮流排列，高优先级的任务仍然排在前面
+	hostOf := func(task *RTaskElement) string { return task.CallbackURL }
+	got := interleaveByHost([]*RTaskElement{
+		{Key: "p1", Priority: 1, CallbackURL: "c"},
+		{Key: "a1", CallbackURL: "a"}, {Key: "a2", CallbackURL: "a"}, {Key: "a3", CallbackURL: "a"},
+		{Key: "b1", CallbackURL: "b"},
+	}, hostOf)
+	var keys []string
+	for _, task := range got {
+		keys = append(keys, task.Key)
+	}
+	if fmt.Sprint(keys) != "[p1 a1 b1 a2 a3]" {
+		t.Errorf("got interleaved: %v", keys)
+	}
+}
+
+func Test_redis_timeWheel_retryAfter(t *testing.T) {
+	retryAfter := "30"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", retryAfter)
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	executor := NewHTTPExecutor(thttp.NewClient())
+	task := &RTaskElement{CallbackURL: server.URL, Method: http.MethodGet}
+	err := executor.Execute(context.Background(), task)
+	if got := thttp.RetryAfter(err); got != 30*time.Second {
+		t.Errorf("delta seconds, got retry after: %v", got)
+	}
+	retryAfter = time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	err = executor.Execute(context.Background(), task)
+	if got := thttp.RetryAfter(err); got < 58*time.Second || got > time.Minute {
+		t.Errorf("http date, got retry after: %v", got)
+	}
+
+	// Retry-After 按照上限截断，并且不影响状态码的判断
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMaxRetryAfter(20*time.Second), WithCircuitBreaker(1, time.Minute, time.Second))
+	wrapped := rTimeWheel.withRetryAfter(err)
+	var retryAfterErr *RetryAfterError
+	if !errors.As(wrapped, &retryAfterErr) || retryAfterErr.Delay != 20*time.Second {
+		t.Errorf("got wrapped err: %v", wrapped)
+	}
+	if thttp.StatusCode(wrapped) != http.StatusTooManyRequests || !rTimeWheel.isRetryable(task, wrapped) {
+		t.Errorf("wrapped err should keep status code")
+	}
+	if got := rTimeWheel.withRetryAfter(errors.New("network")); got.Error() != "network" {
+		t.Errorf("got err without retry after: %v", got)
+	}
+
+	// 熔断器的冷却时间至少为 Retry-After 要求的时长
+	call, _, _ := rTimeWheel.allowCircuit(task)
+	call.done(wrapped)
+	if _, wait, ok := rTimeWheel.allowCircuit(task); ok || wait < 19*time.Second {
+		t.Errorf("got circuit wait: %v, ok: %v", wait, ok)
+	}
+}
+
+func Test_redis_timeWheel_resultURL(t *testing.T) {
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer callback.Close()
+
+	var (
+		mu      sync.Mutex
+		results []TaskResult
+	)
+	resultServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result TaskResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			t.Errorf("decode result: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, result)
+	}))
+	defer resultServer.Close()
+
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient())
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{CallbackURL: callback.URL, Method: http.MethodGet, ResultURL: "ftp://x"}); err == nil {
+		t.Errorf("invalid result url should be rejected")
+	}
+
+	scheduledAt := time.Now().Truncate(time.Second)
+	ok := &RTaskElement{Key: "ok", CallbackURL: callback.URL, Method: http.MethodGet, ResultURL: resultServer.URL, scheduledAt: scheduledAt}
+	failed := &RTaskElement{Key: "failed", CallbackURL: callback.URL + "?fail=1", Method: http.MethodGet, ResultURL: resultServer.URL, scheduledAt: scheduledAt}
+	rTimeWheel.runTask(context.Background(), ok)
+	rTimeWheel.runTask(context.Background(), failed)
+	rTimeWheel.wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Key < results[j].Key })
+	if len(results) != 2 {
+		t.Fatalf("got results: %+v", results)
+	}
+	if got := results[0]; got.Key != "failed" || got.Status != HistoryStatusFail || got.StatusCode != http.StatusBadRequest || got.Attempts != 1 || got.Error == "" {
+		t.Errorf("got failed result: %+v", got)
+	}
+	if got := results[1]; got.Key != "ok" || got.Status != HistoryStatusOK || !got.ScheduledAt.Equal(scheduledAt) || got.FiredAt.IsZero() {
+		t.Errorf("got ok result: %+v", got)
+	}
+}
+
+func Test_redis_timeWheel_tags(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithKeyPrefix("tw"))
+	defer rTimeWheel.Stop()
+
+	if key := rTimeWheel.getTagKey("tenant-1"); key != "tw_tag_tenant-1" {
+		t.Errorf("tag key: %s", key)
+	}
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{CallbackURL: "http://localhost", Method: http.MethodGet, Tags: []string{"a", ""}}); err == nil {
+		t.Errorf("empty tag should be rejected")
+	}
+	if err := rTimeWheel.addTaskPrecheck(&RTaskElement{CallbackURL: "http://localhost", Method: http.MethodGet, Tags: []string{"a", "b"}}); err != nil {
+		t.Errorf("precheck: %v", err)
+	}
+	if _, err := rTimeWheel.RemoveByTag(context.Background(), ""); err == nil {
+		t.Errorf("empty tag should be rejected")
+	}
+	// 没有任务带有标签时无需访问 redis
+	if err := rTimeWheel.untagTasks(context.Background(), []*RTaskElement{{Key: "k"}}); err != nil {
+		t.Errorf("untag: %v", err)
+	}
+
+	body, _ := json.Marshal(&RTaskElement{Key: "k", Tags: []string{"a", "b"}})
+	var task RTaskElement
+	if err := json.Unmarshal(body, &task); err != nil || len(task.Tags) != 2 || task.Tags[1] != "b" {
+		t.Errorf("tags round trip: %v, %v", task.Tags, err)
+	}
+}
+
+func Test_redis_timeWheel_context(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	rTimeWheel := NewRTimeWheelWithContext(ctx, nil, thttp.NewClient())
+
+	batchCtx, batchCancel := rTimeWheel.newBatchContext()
+	defer batchCancel()
+	cancel()
+	select {
+	case <-batchCtx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("batch context should be cancelled with root context")
+	}
+	// 根 context 取消之后无法启动，也不会访问 redis
+	if err := rTimeWheel.Start(); !errors.Is(err, context.Canceled) {
+		t.Errorf("start after cancel: %v", err)
+	}
+	if err := rTimeWheel.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+func Test_redis_timeWheel_scanWindowDrift(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient())
+	base := time.Unix(1700000000, 0)
+
+	// tick 在 x.999 以及 (x+2).003 触发时，第 x+1 秒同样需要被扫描
+	start, end, ok := rTimeWheel.nextScanWindow(time.Time{}, base.Add(999*time.Millisecond))
+	if !ok || !start.Equal(base) || !end.Equal(base.Add(time.Second)) {
+		t.Fatalf("first window: [%v, %v), %v", start, end, ok)
+	}
+	start, end, ok = rTimeWheel.nextScanWindow(end, base.Add(2003*time.Millisecond))
+	if !ok || !start.Equal(base.Add(time.Second)) || !end.Equal(base.Add(3*time.Second)) {
+		t.Errorf("window after gap: [%v, %v), %v", start, end, ok)
+	}
+	// 同一秒内的第二次 tick 不再重复扫描
+	if _, _, ok = rTimeWheel.nextScanWindow(end, base.Add(2500*time.Millisecond)); ok {
+		t.Errorf("window should not be scanned twice")
+	}
+
+	// 真实的 ticker 叠加随机的处理延迟，扫描窗口之间首尾相接，既不遗漏也不重叠
+	tickWheel := NewRTimeWheel(nil, thttp.NewClient(), WithTickInterval(100*time.Millisecond))
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var (
+		first, scannedUntil time.Time
+		windows             int
+	)
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); {
+		now := <-ticker.C
+		start, end, ok := tickWheel.nextScanWindow(scannedUntil, now)
+		if !ok {
+			continue
+		}
+		if first.IsZero() {
+			first = start
+		} else if !start.Equal(scannedUntil) {
+			t.Fatalf("window [%v, %v) does not continue from %v", start, end, scannedUntil)
+		}
+		if !start.Before(end) || end.After(now.Add(100*time.Millisecond)) {
+			t.Fatalf("invalid window [%v, %v) at %v", start, end, now)
+		}
+		scannedUntil = end
+		windows++
+		time.Sleep(time.Duration(rand.Intn(250)) * time.Millisecond)
+	}
+	if covered := scannedUntil.Sub(first); covered < 2500*time.Millisecond || windows == 0 {
+		t.Errorf("covered: %v, windows: %d", covered, windows)
+	}
+}
+
+type skipCountMetrics struct {
+	noopMetrics
+	skipped int32
+}
+
+func (m *skipCountMetrics) IncScansSkipped() { atomic.AddInt32(&m.skipped, 1) }
+
+func Test_redis_timeWheel_scanOverlapSkip(t *testing.T) {
+	queued := NewRTimeWheel(nil, thttp.NewClient())
+	first, ok1 := queued.tryAcquireScan()
+	second, ok2 := queued.tryAcquireScan()
+	if !ok1 || !ok2 {
+		t.Fatalf("ScanOverlapQueue should never skip")
+	}
+	first()
+	second()
+
+	metrics := &skipCountMetrics{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithScanOverlapPolicy(ScanOverlapSkip), WithMetrics(metrics))
+	// 暂停状态下扫描立即返回，不会访问 redis
+	rTimeWheel.Pause()
+	base := time.Unix(1700000000, 0)
+
+	// 模拟一次迟迟没有完成的扫描
+	rTimeWheel.scanSem <- struct{}{}
+	scannedUntil := base.Add(time.Second)
+	if got := rTimeWheel.scan(scannedUntil, base.Add(time.Second)); !got.Equal(scannedUntil) {
+		t.Errorf("skipped tick should not advance, got: %v", got)
+	}
+	if got := rTimeWheel.scan(scannedUntil, base.Add(2*time.Second)); !got.Equal(scannedUntil) {
+		t.Errorf("skipped tick should not advance, got: %v", got)
+	}
+	<-rTimeWheel.scanSem
+
+	// 被跳过的窗口合并到下一次扫描中
+	if start, _, _ := rTimeWheel.nextScanWindow(scannedUntil, base.Add(3*time.Second)); !start.Equal(scannedUntil) {
+		t.Errorf("carried forward window start: %v", start)
+	}
+	if got := rTimeWheel.scan(scannedUntil, base.Add(3*time.Second)); !got.Equal(base.Add(4 * time.Second)) {
+		t.Errorf("scanned until: %v", got)
+	}
+	if skipped := atomic.LoadInt32(&metrics.skipped); skipped != 2 {
+		t.Errorf("skipped: %d", skipped)
+	}
+	if err := rTimeWheel.Shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
+
+type backfillExecutor struct {
+	mu      sync.Mutex
+	firedAt []time.Time
+	late    int
+}
+
+func (e *backfillExecutor) Execute(ctx context.Context, task *RTaskElement) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.firedAt = append(e.firedAt, time.Now())
+	if task.Late() {
+		e.late++
+	}
+	return nil
+}
+
+func Test_redis_timeWheel_startupBackfill(t *testing.T) {
+	executor := &backfillExecutor{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithExecutor(executor),
+		WithStartupLookback(time.Hour), WithStartupBackfillRate(2))
+
+	tasks := make([]*RTaskElement, 5)
+	for i := range tasks {
+		tasks[i] = &RTaskElement{Key: fmt.Sprintf("late_%d", i), scheduledAt: time.Now().Add(-time.Minute), late: true}
+	}
+	start := time.Now()
+	rTimeWheel.backfillTasks(tasks)
+
+	executor.mu.Lock()
+	defer executor.mu.Unlock()
+	if len(executor.firedAt) != 5 || executor.late != 5 {
+		t.Fatalf("fired: %d, late: %d", len(executor.firedAt), executor.late)
+	}
+	// 每秒至多派发 2 个任务，5 个任务分 3 批派发
+	sort.Slice(executor.firedAt, func(i, j int) bool { return executor.firedAt[i].Before(executor.firedAt[j]) })
+	if elapsed := executor.firedAt[4].Sub(start); elapsed < 2*time.Second {
+		t.Errorf("backfill not rate limited, elapsed: %v", elapsed)
+	}
+	if elapsed := executor.firedAt[1].Sub(start); elapsed > time.Second {
+		t.Errorf("first chunk delayed: %v", elapsed)
+	}
+}
+
+func Test_redis_timeWheel_localBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var dropped []string
+	rTimeWheel := NewRTimeWheelWithContext(ctx, nil, thttp.NewClient(), WithLocalBuffer(2),
+		WithBufferDropHook(func(ctx context.Context, task *RTaskElement, err error) {
+			dropped = append(dropped, task.Key)
+		}))
+
+	connErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	executeAt := time.Now().Add(time.Minute)
+	if rTimeWheel.bufferAdd(ctx, &RTaskElement{Key: "reply_err"}, executeAt, errors.New("ERR wrong type")) {
+		t.Errorf("redis reply error should not be buffered")
+	}
+	for _, key := range []string{"a", "b"} {
+		if !rTimeWheel.bufferAdd(ctx, &RTaskElement{Key: key}, executeAt, connErr) {
+			t.Errorf("task %s should be buffered", key)
+		}
+	}
+	// 缓冲已满，返回原本的错误并通过回调通知
+	if rTimeWheel.bufferAdd(ctx, &RTaskElement{Key: "c"}, executeAt, connErr) {
+		t.Errorf("task should not be buffered when buffer is full")
+	}
+	if len(dropped) != 1 || dropped[0] != "c" {
+		t.Errorf("dropped: %v", dropped)
+	}
+
+	rTimeWheel.addBufferMu.Lock()
+	defer rTimeWheel.addBufferMu.Unlock()
+	if len(rTimeWheel.addBuffer) != 2 || !rTimeWheel.flushing || !rTimeWheel.addBuffer[0].executeAt.Equal(executeAt) {
+		t.Errorf("buffer: %d, flushing: %v", len(rTimeWheel.addBuffer), rTimeWheel.flushing)
+	}
+}
+
+func Test_redis_timeWheel_taskHandle(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMaxKeyLength(8))
+	task := &RTaskElement{CallbackURL: "http://localhost", Method: http.MethodGet}
+	for _, key := range []string{"too_long_key", "bad\xff"} {
+		if _, err := rTimeWheel.AddTask(context.Background(), key, task, time.Now().Add(time.Minute)); !errors.Is(err, ErrInvalidTaskKey) {
+			t.Errorf("key %q: %v", key, err)
+		}
+	}
+	if err := rTimeWheel.checkTaskKey("short"); err != nil {
+		t.Errorf("valid key rejected: %v", err)
+	}
+	if err := NewRTimeWheel(nil, thttp.NewClient()).checkTaskKey(strings.Repeat("k", DefaultMaxKeyLength+1)); !errors.Is(err, ErrInvalidTaskKey) {
+		t.Errorf("default max key length not applied: %v", err)
+	}
+
+	// 未指定唯一键时自动生成，并且每次生成的唯一键都不相同
+	scheduler := NewLocalScheduler(NewTimeWheel(10, 100*time.Millisecond), &testExecutor{})
+	defer scheduler.Stop()
+	executeAt := time.Now().Add(time.Minute)
+	first, err := scheduler.AddTask(context.Background(), "", task, executeAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := scheduler.AddTask(context.Background(), "", task, executeAt)
+	if len(first.Key) != 36 || first.Key == second.Key || !first.ExecuteAt.Equal(executeAt) {
+		t.Errorf("handles: %+v, %+v", first, second)
+	}
+	if err := scheduler.RemoveTask(context.Background(), first.Key, first.ExecuteAt); err != nil {
+		t.Errorf("remove by handle: %v", err)
+	}
+}
+
+// redis cluster 计算 key 所属 slot 的方式：存在非空的 hash tag 时只对 hash tag 计算 CRC16
+func clusterKeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+// 模拟 cluster 模式下执行 lua 脚本，访问的 key 不在同一个 slot 时返回 CROSSSLOT 错误
+func clusterEval(keys ...string) error {
+	for _, key := range keys[1:] {
+		if clusterKeySlot(key) != clusterKeySlot(keys[0]) {
+			return fmt.Errorf("CROSSSLOT Keys in request don't hash to the same slot: %v", keys)
+		}
+	}
+	return nil
+}
+
+func Test_redis_timeWheel_taskKeyValidation(t *testing.T) {
+	if clusterKeySlot("123456789") != 12739 || clusterKeySlot("a{123456789}b") != 12739 {
+		t.Fatalf("cluster slot mock is broken")
+	}
+
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithBuckets(4))
+	for _, key := range []string{"{order}_1", "order}", "a\nb", "a\rb", "bad\xff"} {
+		task := &RTaskElement{Key: key, CallbackURL: "http://localhost", Method: http.MethodGet}
+		if err := rTimeWheel.addTaskPrecheck(task); !errors.Is(err, ErrInvalidTaskKey) {
+			t.Errorf("precheck key %q: %v", key, err)
+		}
+		if err := rTimeWheel.RemoveTask(context.Background(), key, time.Now()); !errors.Is(err, ErrInvalidTaskKey) {
+			t.Errorf("remove key %q: %v", key, err)
+		}
+	}
+
+	// 合法的唯一键可以包含任意其他字符，同一个脚本访问的 zset 与删除集合始终位于同一个 slot
+	executeAt := time.Now().Add(time.Minute)
+	for _, key := range []string{"order:1", "订单 1", "100%", "a_task_b", "key|with|pipes"} {
+		if err := rTimeWheel.checkTaskKey(key); err != nil {
+			t.Errorf("key %q rejected: %v", key, err)
+		}
+		if err := clusterEval(rTimeWheel.getTaskZsetKey(key, executeAt), rTimeWheel.getTaskDeleteSetKey(key, executeAt)); err != nil {
+			t.Errorf("key %q: %v", key, err)
+		}
+	}
+
+	// 标签写入 key 名称之前转义，标签中的 {} 不再决定标签集合所属的 slot
+	for _, tag := range []string{"{tenant}", "a}b{c", "100%"} {
+		tagKey := rTimeWheel.getTagKey(tag)
+		if strings.ContainsAny(tagKey, "{}") {
+			t.Errorf("tag key %q not escaped", tagKey)
+		}
+	}
+	if rTimeWheel.getTagKey("%7B") == rTimeWheel.getTagKey("{") {
+		t.Errorf("escaped tags collide")
+	}
+}
+
+type payloadSizeMetrics struct {
+	noopMetrics
+	sizes []int
+}
+
+func (m *payloadSizeMetrics) ObservePayloadSize(bytes int) { m.sizes = append(m.sizes, bytes) }
+
+func Test_redis_timeWheel_maxPayload(t *testing.T) {
+	metrics := &payloadSizeMetrics{}
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(), WithMetrics(metrics))
+	task := &RTaskElement{CallbackURL: "http://localhost", Method: http.MethodPost, Req: strings.Repeat("x", DefaultMaxPayloadBytes)}
+	_, err := rTimeWheel.AddTask(context.Background(), "big", task, time.Now().Add(time.Minute))
+	if len(metrics.sizes) != 1 || metrics.sizes[0] <= DefaultMaxPayloadBytes {
+		t.Fatalf("payload sizes: %v", metrics.sizes)
+	}
+	// 错误中携带实际的大小
+	if !errors.Is(err, ErrPayloadTooLarge) || !strings.Contains(err.Error(), strconv.Itoa(metrics.sizes[0])) {
+		t.Errorf("big payload: %v", err)
+	}
+
+	small := NewRTimeWheel(nil, thttp.NewClient(), WithMaxPayloadBytes(16))
+	task = &RTaskElement{CallbackURL: "http://localhost", Method: http.MethodGet}
+	if _, err := small.AddTask(context.Background(), "small", task, time.Now().Add(time.Minute)); !errors.Is(err, ErrPayloadTooLarge) {
+		t.Errorf("configured max payload not applied: %v", err)
+	}
+}
+
+func Test_redis_timeWheel_dryRun(t *testing.T) {
+	var called int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&called, 1)
+	}))
+	defer server.Close()
+
+	var (
+		resolved    *RTaskElement
+		scheduledAt time.Time
+	)
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient(),
+		WithDefaultHeaders(map[string]string{"x-tenant": "t1"}),
+		WithDryRun(func(ctx context.Context, task *RTaskElement, at time.Time) {
+			resolved, scheduledAt = task, at
+		}))
+
+	task := &RTaskElement{Key: "dry", CallbackURL: server.URL, Method: http.MethodPost, Req: map[string]int{"a": 1}, ResultURL: server.URL}
+	task.scheduledAt = time.Unix(1700000000, 0)
+	if err := rTimeWheel.executeTask(context.Background(), task); err != nil {
+		t.Fatal(err)
+	}
+	rTimeWheel.reportResult(task, nil)
+	if err := rTimeWheel.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&called) != 0 {
+		t.Errorf("dry run should not send requests")
+	}
+	if resolved == nil || !scheduledAt.Equal(task.scheduledAt) {
+		t.Fatalf("sink not called, scheduled at: %v", scheduledAt)
+	}
+	if string(resolved.RawBody) != `{"a":1}` || resolved.BodyType != BodyTypeRaw || resolved.Header["Content-Type"] != "application/json" {
+		t.Errorf("body: %s, body type: %s, header: %v", resolved.RawBody, resolved.BodyType, resolved.Header)
+	}
+	if resolved.Header["X-Tenant"] != "t1" || resolved.Header[DefaultIdempotencyKeyHeader] != task.IdempotencyKey() {
+		t.Errorf("header not merged: %v", resolved.Header)
+	}
+	if task.BodyType != "" || task.RawBody != nil {
+		t.Errorf("original task modified: %+v", task)
+	}
+}
+
+func Test_redis_timeWheel_done(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rTimeWheel := NewRTimeWheelWithContext(ctx, nil, thttp.NewClient())
+	if rTimeWheel.Running() || rTimeWheel.Err() != nil {
+		t.Errorf("wheel not started")
+	}
+	select {
+	case <-rTimeWheel.Done():
+	default:
+		t.Errorf("done should be closed before start")
+	}
+
+	// 跳过 Start 中的 redis 检查，直接启动 run 协程
+	rTimeWheel.mu.Lock()
+	rTimeWheel.launch()
+	rTimeWheel.mu.Unlock()
+	done := rTimeWheel.Done()
+	if !rTimeWheel.Running() {
+		t.Errorf("wheel should be running")
+	}
+
+	// 模拟一个正在执行的任务，Done 需要等待其完成
+	release := make(chan struct{})
+	rTimeWheel.goTracked(func() { <-release })
+	rTimeWheel.Stop()
+	select {
+	case <-done:
+		t.Errorf("done closed before in-flight executions drained")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("done not closed")
+	}
+	if rTimeWheel.Running() || rTimeWheel.Err() != nil {
+		t.Errorf("stopped wheel, running: %v, err: %v", rTimeWheel.Running(), rTimeWheel.Err())
+	}
+
+	// 根 context 取消属于异常退出，通过 Err 返回原因
+	rTimeWheel.mu.Lock()
+	rTimeWheel.launch()
+	rTimeWheel.mu.Unlock()
+	done = rTimeWheel.Done()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("done not closed after context cancelled")
+	}
+	if rTimeWheel.Running() || !errors.Is(rTimeWheel.Err(), context.Canceled) {
+		t.Errorf("cancelled wheel, running: %v, err: %v", rTimeWheel.Running(), rTimeWheel.Err())
+	}
+}
+
+func Test_redis_timeWheel_healthCheck(t *testing.T) {
+	rTimeWheel := NewRTimeWheel(redis.MustNewClient("tcp", "127.0.0.1:1", ""), thttp.NewClient(), WithTickInterval(100*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rTimeWheel.HealthCheck(ctx); !errors.Is(err, ErrRedisUnreachable) || errors.Is(err, ErrScanStalled) {
+		t.Errorf("got err: %v, want redis unreachable", err)
+	}
+
+	if err := rTimeWheel.checkScan(); !errors.Is(err, ErrWheelStopped) {
+		t.Errorf("got err: %v, want wheel stopped", err)
+	}
+
+	rTimeWheel.mu.Lock()
+	rTimeWheel.started = true
+	rTimeWheel.lastScanAt = time.Now().Add(-time.Second)
+	rTimeWheel.mu.Unlock()
+	err := rTimeWheel.checkScan()
+	var healthErr *HealthError
+	if !errors.Is(err, ErrScanStalled) || !errors.As(err, &healthErr) || healthErr.State != WheelStarted {
+		t.Errorf("got err: %v, want scan stalled", err)
+	}
+
+	rTimeWheel.markScanned(time.Now())
+	if err := rTimeWheel.checkScan(); err != nil {
+		t.Errorf("got err: %v, want nil", err)
+	}
+
+	// 暂停期间不扫描，不视为停滞
+	rTimeWheel.Pause()
+	rTimeWheel.mu.Lock()
+	rTimeWheel.lastScanAt = time.Now().Add(-time.Second)
+	rTimeWheel.mu.Unlock()
+	if err := rTimeWheel.checkScan(); err != nil || rTimeWheel.State() != WheelPaused {
+		t.Errorf("paused wheel, err: %v, state: %s", err, rTimeWheel.State())
+	}
+}
+
+func Test_redis_timeWheel_sliceRetention(t *testing.T) {
+	// 保留时长不会短于补偿扫描、启动回填所需的时长
+	opts := RTimeWheelOptions{sliceRetention: time.Minute, lookback: time.Hour, startupLookback: time.Hour}
+	repairRTimeWheel(&opts)
+	if opts.sliceRetention < 2*time.Hour {
+		t.Errorf("got retention: %v, want at least 2h", opts.sliceRetention)
+	}
+
+	opts = RTimeWheelOptions{janitorInterval: time.Minute}
+	repairRTimeWheel(&opts)
+	rTimeWheel := RTimeWheel{opts: &opts}
+	now := time.Now()
+	for _, delay := range []time.Duration{-48 * time.Hour, 0, 10 * time.Minute} {
+		executeAt := now.Add(delay)
+		expire := time.Duration(rTimeWheel.getSliceExpireSeconds(now, executeAt)) * time.Second
+		// zset 需要在清理之后才过期
+		if now.Add(expire).Before(executeAt.Add(opts.sliceRetention + opts.janitorInterval)) {
+			t.Errorf("delay: %v, slice expires in %v", delay, expire)
+		}
+	}
+
+	opts = RTimeWheelOptions{keyPrefix: "tw*", shardCount: 2, instanceID: "a"}
+	repairRTimeWheel(&opts)
+	rTimeWheel = RTimeWheel{opts: &opts}
+	if pattern := rTimeWheel.getSliceKeyPattern(); pattern != `tw\*_task_{*` {
+		t.Errorf("got pattern: %s", pattern)
+	}
+	for _, sliceStr := range []string{rTimeWheel.getSliceStr(now), shardSliceStrs([]string{rTimeWheel.getSliceStr(now)}, []int{1})[0]} {
+		got, ok := rTimeWheel.parseSliceKey(sliceTaskKey(opts.keyPrefix, sliceStr))
+		if !ok || got != sliceStr {
+			t.Errorf("parse slice key, got: %s, want: %s", got, sliceStr)
+		}
+		if start, err := rTimeWheel.parseSliceStr(got); err != nil || !start.Equal(rTimeWheel.getSliceStart(now)) {
+			t.Errorf("parse slice str %s, got: %v, err: %v", got, start, err)
+		}
+	}
+	if _, ok := rTimeWheel.parseSliceKey("tw*_deadletter"); ok {
+		t.Errorf("dead letter key should not be parsed as slice")
+	}
+}
+
+// 返回固定任务的 TaskStore
+type staticTaskStore struct {
+	stored []StoredTask
+}
+
+func (s *staticTaskStore) Add(ctx context.Context, slice string, score int64, body []byte, key string) error {
+	return nil
+}
+
+func (s *staticTaskStore) MarkDeleted(ctx context.Context, slice, key string, score int64) error {
+	return nil
+}
+
+func (s *staticTaskStore) FetchDue(ctx context.Context, slice string, from, to int64) ([]StoredTask, error) {
+	stored := s.stored
+	s.stored = nil
+	return stored, nil
+}
+
+func Test_redis_timeWheel_scanReport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	encode := func(task *RTaskElement) []byte {
+		body, _ := json.Marshal(task)
+		return body
+	}
+	store := staticTaskStore{stored: []StoredTask{
+		{Key: "ok", Score: now.Unix(), Body: encode(&RTaskElement{Key: "ok", CallbackURL: server.URL + "/ok", Method: http.MethodPost})},
+		{Key: "fail", Score: now.Unix(), Body: encode(&RTaskElement{Key: "fail", CallbackURL: server.URL + "/fail", Method: http.MethodPost})},
+		{Key: "stale", Score: now.Add(-time.Hour).Unix(), Body: encode(&RTaskElement{Key: "stale", CallbackURL: server.URL + "/ok", Method: http.MethodPost})},
+		{Key: "deleted", Score: now.Unix(), Body: encode(&RTaskElement{Key: "deleted", CallbackURL: server.URL + "/ok"}), Deleted: true},
+		{Score: now.Unix(), Body: []byte("malformed")},
+	}}
+	hooks := testExecutionHooks{}
+	rTimeWheel := NewRTimeWheel(redis.MustNewClient("tcp", "127.0.0.1:1", ""), thttp.NewClient(),
+		WithTaskStore(&store), WithExecutionHooks(&hooks), WithMaxStaleness(time.Minute))
+
+	start, end := rTimeWheel.getScanWindow(now)
+	rTimeWheel.executeTasks(start, end)
+
+	if len(hooks.reports) != 1 {
+		t.Fatalf("got %d reports, want 1", len(hooks.reports))
+	}
+	report := hooks.reports[0]
+	if !report.WindowStart.Equal(start) || !report.WindowEnd.Equal(end) || report.Duration < report.ScanDuration {
+		t.Errorf("got report: %+v", report)
+	}
+	if report.Fetched != 5 || report.Deleted != 1 || report.Malformed != 1 ||
+		report.Executed != 1 || report.Failed != 1 || report.Skipped != 1 || report.ScanErrors != 0 {
+		t.Errorf("got report: %+v", report)
+	}
+}
+
+// 每次检索耗时 delay，每个时间片返回一个任务的 TaskStore
+type slowTaskStore struct {
+	staticTaskStore
+	delay       time.Duration
+	callbackURL string
+}
+
+func (s *slowTaskStore) FetchDue(ctx context.Context, slice string, from, to int64) ([]StoredTask, error) {
+	time.Sleep(s.delay)
+	body, _ := json.Marshal(&RTaskElement{Key: "bench_" + slice, CallbackURL: s.callbackURL, Method: http.MethodPost})
+	return []StoredTask{{Key: "bench_" + slice, Score: from, Body: body}}, nil
+}
+
+// 扫描窗口跨越 5 个时间片，取出的任务逐页交给派发方，首个回调无需等待全部时间片检索完毕
+func Benchmark_redis_timeWheel_firstCallback(b *testing.B) {
+	called := make(chan struct{}, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case called <- struct{}{}:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	store := slowTaskStore{delay: 5 * time.Millisecond, callbackURL: server.URL}
+	rTimeWheel := NewRTimeWheel(redis.MustNewClient("tcp", "127.0.0.1:1", ""), thttp.NewClient(),
+		WithTaskStore(&store), WithTickInterval(5*time.Second), WithSliceGranularity(time.Second))
+
+	var firstCallback time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start, end := rTimeWheel.getScanWindow(time.Now())
+		begin := time.Now()
+		done := make(chan struct{})
+		go func() {
+			rTimeWheel.executeTasks(start, end)
+			close(done)
+		}()
+		<-called
+		firstCallback += time.Since(begin)
+		<-done
+		for len(called) > 0 {
+			<-called
+		}
+	}
+	b.ReportMetric(float64(firstCallback.Nanoseconds())/float64(b.N), "ns/first-callback")
+}
+
+func Test_redis_timeWheel_maxPendingTasks(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	store := countingTaskStore{slowTaskStore: slowTaskStore{callbackURL: server.URL}}
+	hooks := testExecutionHooks{}
+	rTimeWheel := NewRTimeWheel(redis.MustNewClient("tcp", "127.0.0.1:1", ""), thttp.NewClient(),
+		WithTaskStore(&store), WithExecutionHooks(&hooks), WithTickInterval(5*time.Second), WithSliceGranularity(time.Second), WithMaxPendingTasks(1))
+
+	start, end := rTimeWheel.getScanWindow(time.Now())
+	done := make(chan struct{})
+	go func() {
+		rTimeWheel.executeTasks(start, end)
+		close(done)
+	}()
+
+	// 第一个任务执行完成之前不会取出下一个时间片
+	time.Sleep(200 * time.Millisecond)
+	if got := atomic.LoadInt32(&store.calls); got != 1 {
+		t.Errorf("got %d fetches while executor is saturated, want 1", got)
+	}
+	close(release)
+	<-done
+
+	if got := atomic.LoadInt32(&store.calls); got != 5 {
+		t.Errorf("got %d fetches, want 5", got)
+	}
+	if len(hooks.reports) != 1 || hooks.reports[0].Executed != 5 {
+		t.Errorf("got reports: %+v", hooks.reports)
+	}
+	if free, _ := rTimeWheel.pending.free(); free != 1 {
+		t.Errorf("got %d free pending slots, want 1", free)
+	}
+}
+
+// 记录检索次数的 slowTaskStore
+type countingTaskStore struct {
+	slowTaskStore
+	calls int32
+}
+
+func (s *countingTaskStore) FetchDue(ctx context.Context, slice string, from, to int64) ([]StoredTask, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.slowTaskStore.FetchDue(ctx, slice, from, to)
+}
+
+func Test_redis_timeWheel_attempt(t *testing.T) {
+	var gotAttempt string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAttempt = r.Header.Get(DefaultAttemptHeader)
+	}))
+	defer server.Close()
+
+	// 重试次数由时间轮内部维护，添加任务时不允许指定
+	rTimeWheel := NewRTimeWheel(nil, thttp.NewClient())
+	for _, task := range []*RTaskElement{
+		{CallbackURL: server.URL, Method: http.MethodPost, Attempt: 1},
+		{CallbackURL: server.URL, Method: http.MethodPost, FirstScheduledAt: time.Now()},
+	} {
+		if err := rTimeWheel.addTaskPrecheck(task); err == nil {
+			t.Errorf("caller supplied attempt should be rejected: %+v", task)
+		}
+	}
+
+	firstScheduledAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	retry := &RTaskElement{Key: "test_attempt", CallbackURL: server.URL, Method: http.MethodPost, Attempt: 6,
+		FirstScheduledAt: firstScheduledAt, scheduledAt: time.Now().Truncate(time.Second)}
+	if err := rTimeWheel.executeTask(context.Background(), retry); err != nil || gotAttempt != "7" {
+		t.Errorf("got attempt header: %s, err: %v", gotAttempt, err)
+	}
+
+	deadLetter := newDeadLetter(retry, errors.New("failed"), time.Now())
+	if deadLetter.Attempts != 7 || !deadLetter.ScheduledAt.Equal(firstScheduledAt) {
+		t.Errorf("got dead letter: %+v", deadLetter)
+	}
+	deadLetter = newDeadLetter(&RTaskElement{scheduledAt: firstScheduledAt}, errors.New("failed"), time.Now())
+	if deadLetter.Attempts != 1 || !deadLetter.ScheduledAt.Equal(firstScheduledAt) {
+		t.Errorf("got dead letter: %+v", deadLetter)
+	}
+}
+
+func Test_redis_timeWheel_redisCompatibility(t *testing.T) {
+	for version, legacy := range map[string]bool{"5.0.14": true, "6.0.16": true, "6.2.0": false, "7.2.4": false, "unknown": false} {
+		if got := isLegacyRedis(version); got != legacy {
+			t.Errorf("version: %s, got legacy: %v, want: %v", version, got, legacy)
+		}
+	}
+
+	// 兼容形式只改写检索指令，脚本的其余部分保持不变
+	for _, scripts := range [][2]string{{LuaZrangeTasks, LuaZrangeTasksLegacy}, {LuaLeaseTasks, LuaLeaseTasksLegacy}} {
+		modern, legacy := scripts[0], scripts[1]
+		if !strings.Contains(modern, "'byscore'") || strings.Contains(legacy, "'byscore'") ||
+			!strings.Contains(legacy, "redis.call('zrangebyscore',zsetKey,score1,score2,'withscores','limit',0,count)") {
+			t.Errorf("got legacy script: %s", legacy)
+		}
+		if strings.Replace(modern, "'zrange',zsetKey,score1,score2,'byscore',", "'zrangebyscore',zsetKey,score1,score2,", 1) != legacy {
+			t.Errorf("legacy script differs beyond the zrange call")
+		}
+	}
+
+	opts := RTimeWheelOptions{}
+	WithRedisCompatibility("6.0")(&opts)
+	repairRTimeWheel(&opts)
+	if !opts.legacyZrange {
+		t.Errorf("redis 6.0 should use legacy zrange")
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/xiaoxuxiansheng/timewheel/tracing/otel
+
+go 1.20
+
+require (
+	github.com/xiaoxuxiansheng/timewheel v0.0.0
+	go.opentelemetry.io/otel v1.19.0
+	go.opentelemetry.io/otel/trace v1.19.0
+)
+
+require (
+	github.com/demdxx/gocast v1.2.0 // indirect
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/gomodule/redigo v1.8.9 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	go.opentelemetry.io/otel/metric v1.19.0 // indirect
+)
+
+replace github.com/xiaoxuxiansheng/timewheel => ../..
@@ -0,0 +1,66 @@
+// Package otel 基于 OpenTelemetry 实现时间轮的链路追踪.
+//
+// 添加任务时，当前的链路信息会随任务一起序列化；执行任务时，在 timewheel.execute span 下发起回调请求，并通过 header 透传 traceparent.
+// 独立为子模块，避免未使用 OpenTelemetry 的使用方引入相关依赖. 使用示例：
+//
+//	rTimeWheel := timewheel.NewRTimeWheel(redisClient, httpClient, otel.WithTracerProvider(tracerProvider))
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/xiaoxuxiansheng/timewheel"
+)
+
+const (
+	instrumentationName = "github.com/xiaoxuxiansheng/timewheel"
+	// 任务执行的 span 名称
+	executeSpanName = "timewheel.execute"
+)
+
+// WithTracerProvider 开启基于 OpenTelemetry 的链路追踪. tp 为 nil 时使用全局的 TracerProvider，链路信息的传播使用全局的 TextMapPropagator.
+func WithTracerProvider(tp trace.TracerProvider) timewheel.RTimeWheelOption {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return timewheel.WithTracing(&tracing{
+		tracer:     tp.Tracer(instrumentationName),
+		propagator: otel.GetTextMapPropagator(),
+	})
+}
+
+type tracing struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func (t *tracing) Inject(ctx context.Context, carrier map[string]string) {
+	t.propagator.Inject(ctx, propagation.MapCarrier(carrier))
+}
+
+func (t *tracing) StartExecute(ctx context.Context, task *timewheel.RTaskElement) (context.Context, func(err error)) {
+	if len(task.TraceCarrier) > 0 {
+		ctx = t.propagator.Extract(ctx, propagation.MapCarrier(task.TraceCarrier))
+	}
+	ctx, span := t.tracer.Start(ctx, executeSpanName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("timewheel.task.key", task.Key),
+			attribute.String("timewheel.task.callback_url", task.CallbackURL),
+			attribute.Int("timewheel.task.attempt", task.Attempt+1),
+		),
+	)
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}